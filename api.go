@@ -0,0 +1,539 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cohesion-org/deepseek-go"
+
+	"cli-epub-parser-md-generator/epub"
+	"cli-epub-parser-md-generator/extract"
+	"cli-epub-parser-md-generator/llm"
+	"cli-epub-parser-md-generator/pipeline"
+	"cli-epub-parser-md-generator/render"
+	"cli-epub-parser-md-generator/source"
+)
+
+// maxUploadSize bounds the EPUB files accepted by POST /books.
+const maxUploadSize = 256 << 20
+
+// apiChapter is a chapter of an uploaded book, numbered as by list.
+type apiChapter struct {
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+	Source string `json:"source"`
+	Tokens int    `json:"tokens"`
+}
+
+// apiBook is an uploaded book, kept in <data>/books/<id>/ as book.json and the uploaded file,
+// book.epub, book.fb2, book.mobi, book.azw3, book.pdf, book.md or book.txt.
+type apiBook struct {
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Title     string          `json:"title"`
+	Creators  []string        `json:"creators,omitempty"`
+	Rendition *epub.Rendition `json:"rendition,omitempty"`
+	Uploaded  time.Time       `json:"uploaded"`
+	Chapters  []apiChapter    `json:"chapters"`
+}
+
+// apiJobRequest is the body of POST /books/{id}/jobs. Empty fields take the value of the config.
+type apiJobRequest struct {
+	Chapters string `json:"chapters"` // ex: 3, 1-5,8 or all
+	Prompt   string `json:"prompt,omitempty"`
+	Provider string `json:"provider,omitempty"`
+	Model    string `json:"model,omitempty"`
+	Style    string `json:"style,omitempty"`
+	Profile  string `json:"profile,omitempty"`
+}
+
+// apiJob is a generation queued by the API, kept in <data>/jobs/<id>.json with its output
+// folder <data>/jobs/<id>/.
+type apiJob struct {
+	ID       string                 `json:"id"`
+	Book     string                 `json:"book"`
+	Request  apiJobRequest          `json:"request"`
+	Selected []int                  `json:"selected"`
+	Status   pipeline.ChapterStatus `json:"status"`
+	Error    string                 `json:"error,omitempty"`
+	Done     int                    `json:"done"`
+	Total    int                    `json:"total"`
+	Usage    render.Usage           `json:"usage"`
+	Created  time.Time              `json:"created"`
+	Started  *time.Time             `json:"started,omitempty"`
+	Finished *time.Time             `json:"finished,omitempty"`
+}
+
+// apiServer serves the JSON API: books are uploaded, then jobs generate their chapters in the
+// background with at most workers jobs at the same time. Books, jobs and outputs are stored
+// under dir, so queued and interrupted jobs are run again when serve restarts.
+type apiServer struct {
+	dir        string
+	configFile string
+	convert    extract.Options
+	// client replaces the DeepSeek client of the jobs when set, for tests
+	client *deepseek.Client
+
+	mu    sync.Mutex
+	cond  *sync.Cond
+	jobs  map[string]*apiJob
+	queue []string
+}
+
+// defaultAPIDir returns the folder where serve keeps the books and jobs of the API.
+func defaultAPIDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("error finding the user cache directory: %w", err)
+	}
+	return filepath.Join(dir, "cli-epub-parser-md-generator", "api"), nil
+}
+
+// newAPIServer loads the jobs saved in dir and queues again the ones that did not finish.
+func newAPIServer(dir, configFile string, convert extract.Options) (*apiServer, error) {
+	s := &apiServer{dir: dir, configFile: configFile, convert: convert, jobs: map[string]*apiJob{}}
+	s.cond = sync.NewCond(&s.mu)
+	for _, sub := range []string{"books", "jobs"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, fmt.Errorf("error creating api folder: %w", err)
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "jobs", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var pending []*apiJob
+	for _, file := range files {
+		job := &apiJob{}
+		data, err := os.ReadFile(file)
+		if err == nil {
+			err = json.Unmarshal(data, job)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading job %s: %w", file, err)
+		}
+		s.jobs[job.ID] = job
+		if job.Status == pipeline.StatusPending || job.Status == pipeline.StatusRunning {
+			job.Status = pipeline.StatusPending
+			pending = append(pending, job)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Created.Before(pending[j].Created) })
+	for _, job := range pending {
+		s.queue = append(s.queue, job.ID)
+	}
+	return s, nil
+}
+
+// start runs the queued jobs with workers goroutines until ctx is done.
+func (s *apiServer) start(ctx context.Context, workers int) {
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	}()
+	for range max(workers, 1) {
+		go func() {
+			for {
+				s.mu.Lock()
+				for len(s.queue) == 0 && ctx.Err() == nil {
+					s.cond.Wait()
+				}
+				if ctx.Err() != nil {
+					s.mu.Unlock()
+					return
+				}
+				job := s.jobs[s.queue[0]]
+				s.queue = s.queue[1:]
+				s.mu.Unlock()
+				s.runJob(ctx, job)
+			}
+		}()
+	}
+}
+
+func (s *apiServer) routes(mux *http.ServeMux) {
+	mux.HandleFunc("POST /books", s.handleUpload)
+	mux.HandleFunc("GET /books/{id}/chapters", s.handleChapters)
+	mux.HandleFunc("POST /books/{id}/jobs", s.handleSubmit)
+	mux.HandleFunc("GET /jobs/{id}", s.handleJob)
+	mux.HandleFunc("GET /jobs/{id}/output", s.handleOutput)
+}
+
+func (s *apiServer) bookDir(id string) string { return filepath.Join(s.dir, "books", id) }
+func (s *apiServer) jobDir(id string) string  { return filepath.Join(s.dir, "jobs", id) }
+
+// bookFile is the uploaded file of book, named after its format.
+func (s *apiServer) bookFile(book *apiBook) string {
+	return filepath.Join(s.bookDir(book.ID), "book"+bookExt(book.Name))
+}
+
+// bookExt returns the extension of a supported file format in name, or .epub.
+func bookExt(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if _, ok := source.Formats[ext]; ok {
+		return ext
+	}
+	return ".epub"
+}
+
+// saveJob writes job to disk. s.mu must be held.
+func (s *apiServer) saveJob(job *apiJob) error {
+	return writeJSONFile(s.jobDir(job.ID)+".json", job)
+}
+
+// updateJob changes job under the lock and saves it.
+func (s *apiServer) updateJob(job *apiJob, update func(job *apiJob)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	update(job)
+	if err := s.saveJob(job); err != nil {
+		fmt.Println("error saving job:", err)
+	}
+}
+
+func (s *apiServer) loadBook(id string) (*apiBook, error) {
+	data, err := os.ReadFile(filepath.Join(s.bookDir(filepath.Base(id)), "book.json"))
+	if err != nil {
+		return nil, err
+	}
+	book := &apiBook{}
+	return book, json.Unmarshal(data, book)
+}
+
+// writeJSONFile writes v as indented JSON to a temporary file renamed over path.
+func writeJSONFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// handleUpload stores the book sent as the request body, or as the file field of a multipart
+// form. Its format is told by the extension of its name, EPUB by default. The same file
+// uploaded twice gets the same id.
+func (s *apiServer) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if crossSite(r) {
+		writeJSONError(w, http.StatusForbidden, errors.New("cross-site request refused"))
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	var body io.Reader = r.Body
+	name := r.URL.Query().Get("name")
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("error reading the file field: %w", err))
+			return
+		}
+		defer file.Close()
+		body = file
+		if len(name) == 0 {
+			name = header.Filename
+		}
+	}
+	if len(name) == 0 {
+		name = "book.epub"
+	}
+	ext := bookExt(name)
+	if len(filepath.Ext(name)) > 0 && ext != strings.ToLower(filepath.Ext(name)) {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("unsupported book format %q, expected .epub, .fb2, .mobi, .azw3, .pdf, .md or .txt", filepath.Ext(name)))
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Join(s.dir, "books"), "upload-*"+ext)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), body)
+	tmp.Close()
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("the book is larger than %d bytes", maxUploadSize))
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	id := hex.EncodeToString(hash.Sum(nil))[:16]
+	if book, err := s.loadBook(id); err == nil {
+		writeJSON(w, http.StatusOK, book)
+		return
+	}
+	ebook, err := extractBook(tmp.Name())
+	if errors.Is(err, epub.ErrDRMProtected) {
+		writeJSONError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("not a readable book: %w", err))
+		return
+	}
+	defer removeWorkspace(ebook.Dir)
+	book := &apiBook{ID: id, Name: filepath.Base(name), Title: ebook.Metadata.Title, Creators: ebook.Metadata.Creators, Rendition: ebook.CurrentRendition(), Uploaded: time.Now()}
+	for i, item := range ebook.Chapters(false) {
+		book.Chapters = append(book.Chapters, apiChapter{Number: i + 1, Title: item.Title, Source: item.Href, Tokens: chapterTokens(ebook, item, i+1, s.convert)})
+	}
+	dir := s.bookDir(id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := os.Rename(tmp.Name(), s.bookFile(book)); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := writeJSONFile(filepath.Join(dir, "book.json"), book); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, book)
+}
+
+func (s *apiServer) handleChapters(w http.ResponseWriter, r *http.Request) {
+	book, err := s.loadBook(r.PathValue("id"))
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("unknown book %s", r.PathValue("id")))
+		return
+	}
+	writeJSON(w, http.StatusOK, book.Chapters)
+}
+
+// settings resolves the generate settings of a job the way the generate command would, from the
+// config, the profile and the fields of the request.
+func (s *apiServer) settings(book *apiBook, req apiJobRequest) (*generateSettings, error) {
+	if len(req.Provider) > 0 && req.Provider != llm.ProviderDeepSeek {
+		return nil, fmt.Errorf("unsupported provider %q, expected %s", req.Provider, llm.ProviderDeepSeek)
+	}
+	if len(req.Style) > 0 && req.Style != render.StyleBlog && req.Style != render.StyleYouTubeScript {
+		return nil, fmt.Errorf("unknown style %q, expected %s or %s", req.Style, render.StyleBlog, render.StyleYouTubeScript)
+	}
+	if _, err := loadConfig(s.configFile, req.Profile); err != nil {
+		return nil, err
+	}
+	args := []string{"-book", s.bookFile(book), "-config", s.configFile}
+	if len(req.Profile) > 0 {
+		args = append(args, "-profile", req.Profile)
+	}
+	if len(req.Model) > 0 {
+		args = append(args, "-model", req.Model)
+	}
+	settings, err := requestSettings(args)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Style) > 0 {
+		settings.generate.Style = req.Style
+	}
+	if len(req.Prompt) > 0 {
+		settings.generate.Prompt = req.Prompt
+	}
+	return settings, nil
+}
+
+// handleSubmit queues a job generating chapters of a book.
+func (s *apiServer) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	book, err := s.loadBook(r.PathValue("id"))
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("unknown book %s", r.PathValue("id")))
+		return
+	}
+	// a JSON body can't be sent across sites without the consent of CORS, unlike text/plain
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeJSONError(w, http.StatusUnsupportedMediaType, errors.New("the job must be sent as application/json"))
+		return
+	}
+	if crossSite(r) {
+		writeJSONError(w, http.StatusForbidden, errors.New("cross-site request refused"))
+		return
+	}
+	var req apiJobRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("error parsing job: %w", err))
+		return
+	}
+	selected, err := parseChapterSelection(req.Chapters, len(book.Chapters))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	if _, err := s.settings(book, req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	if s.client == nil && len(os.Getenv("DEEPSEEK_API_KEY")) == 0 {
+		writeJSONError(w, http.StatusServiceUnavailable, fmt.Errorf("DEEPSEEK_API_KEY is not set"))
+		return
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	job := &apiJob{ID: hex.EncodeToString(id), Book: book.ID, Request: req, Selected: selected, Status: pipeline.StatusPending, Total: len(selected), Created: time.Now()}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.saveJob(job); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.jobs[job.ID] = job
+	s.queue = append(s.queue, job.ID)
+	s.cond.Signal()
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *apiServer) job(id string) (apiJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return apiJob{}, false
+	}
+	return *job, true
+}
+
+func (s *apiServer) handleJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.job(r.PathValue("id"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("unknown job %s", r.PathValue("id")))
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// apiOutput is a generated chapter as returned by GET /jobs/{id}/output.
+type apiOutput struct {
+	Number    int    `json:"number"`
+	Title     string `json:"title"`
+	Content   string `json:"content"`
+	Narration string `json:"narration,omitempty"`
+	// Warnings are the divergences of the rewrite from the glossary of the book.
+	Warnings []string `json:"warnings,omitempty"`
+	// Unsupported are the claims of the rewrite not found in the source chapter.
+	Unsupported []string `json:"unsupported,omitempty"`
+}
+
+// handleOutput returns the chapters generated so far by a job, as JSON or, with ?format=md,
+// as one Markdown document.
+func (s *apiServer) handleOutput(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.job(r.PathValue("id"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("unknown job %s", r.PathValue("id")))
+		return
+	}
+	manifest, err := pipeline.LoadManifest(s.jobDir(job.ID))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	outputs := []apiOutput{}
+	for _, ch := range manifest.Chapters {
+		if ch.Status == pipeline.StatusDone && ch.Result != nil {
+			outputs = append(outputs, apiOutput{Number: ch.Number, Title: ch.Result.Title, Content: ch.Result.Content, Narration: ch.Result.Narration, Warnings: ch.Warnings, Unsupported: ch.Unsupported})
+		}
+	}
+	if r.URL.Query().Get("format") == render.FormatMarkdown {
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		for i, out := range outputs {
+			if i > 0 {
+				fmt.Fprint(w, "\n\n")
+			}
+			fmt.Fprintf(w, "# %s\n\n%s\n", out.Title, strings.TrimSpace(out.Content))
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"job": job.ID, "status": job.Status, "chapters": outputs})
+}
+
+// runJob generates the chapters of job into its output folder. The run resumes from the manifest,
+// so a job interrupted by a restart does not ask the model again for the chapters it finished.
+// A job stopped by the shutdown of serve is left pending, to run again when it restarts.
+func (s *apiServer) runJob(ctx context.Context, job *apiJob) {
+	now := time.Now()
+	s.updateJob(job, func(job *apiJob) { job.Status, job.Started, job.Done, job.Error = pipeline.StatusRunning, &now, 0, "" })
+	err := s.generate(ctx, job)
+	finished := time.Now()
+	s.updateJob(job, func(job *apiJob) {
+		switch {
+		case ctx.Err() != nil:
+			job.Status = pipeline.StatusPending
+		case err != nil:
+			job.Status, job.Error, job.Finished = pipeline.StatusFailed, err.Error(), &finished
+		default:
+			job.Status, job.Finished = pipeline.StatusDone, &finished
+		}
+		if manifest, err := pipeline.LoadManifest(s.jobDir(job.ID)); err == nil {
+			job.Usage = render.Usage{}
+			for _, ch := range manifest.Chapters {
+				job.Usage.PromptTokens += ch.Usage.PromptTokens
+				job.Usage.CompletionTokens += ch.Usage.CompletionTokens
+				job.Usage.TotalTokens += ch.Usage.TotalTokens
+			}
+		}
+	})
+}
+
+func (s *apiServer) generate(ctx context.Context, job *apiJob) error {
+	book, err := s.loadBook(job.Book)
+	if err != nil {
+		return fmt.Errorf("error reading book %s: %w", job.Book, err)
+	}
+	settings, err := s.settings(book, job.Request)
+	if err != nil {
+		return err
+	}
+	settings.book = book.Name
+	settings.outputDir = s.jobDir(job.ID)
+	settings.convert.AssetsDir = filepath.Join(settings.outputDir, "assets")
+	settings.resume = true
+	ebook, err := extractBook(s.bookFile(book))
+	if err != nil {
+		return err
+	}
+	defer removeWorkspace(ebook.Dir)
+	run := settings.newRun(ebook, ebook.Chapters(false), job.Selected)
+	if s.client != nil {
+		run.Client = s.client
+	}
+	run.Progress = func(e pipeline.ProgressEvent) {
+		s.mu.Lock()
+		job.Done = e.Done
+		s.mu.Unlock()
+	}
+	return run.Run(ctx)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cli-epub-parser-md-generator/extract"
+	"cli-epub-parser-md-generator/internal/epubtest"
+	"cli-epub-parser-md-generator/internal/llmtest"
+	"cli-epub-parser-md-generator/pipeline"
+)
+
+func TestAPIServer(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("TMPDIR", t.TempDir())
+	t.Cleanup(cleanupWorkspace)
+	dataDir := t.TempDir()
+	configFile := filepath.Join(t.TempDir(), "epubmd.yaml")
+	os.WriteFile(configFile, []byte("format: blog,md\n"), 0644)
+	client, calls := llmtest.NewFakeModel(t)
+	newServer := func() (*apiServer, *httptest.Server) {
+		api, err := newAPIServer(dataDir, configFile, extract.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		api.client = client
+		mux := http.NewServeMux()
+		api.routes(mux)
+		server := httptest.NewServer(mux)
+		t.Cleanup(server.Close)
+		return api, server
+	}
+	do := func(server *httptest.Server, method, path, contentType string, body io.Reader, v any) int {
+		req, _ := http.NewRequest(method, server.URL+path, body)
+		if len(contentType) > 0 {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if v != nil {
+			json.NewDecoder(resp.Body).Decode(v)
+		}
+		return resp.StatusCode
+	}
+	epubData, err := os.ReadFile(epubtest.Write(t, epubtest.Files))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first server only queues the job, the second one runs it after a restart
+	_, first := newServer()
+	var book apiBook
+	if status := do(first, http.MethodPost, "/books?name=test-book.epub", "application/epub+zip", bytes.NewReader(epubData), &book); status != http.StatusCreated {
+		t.Fatalf("expected the book to be created, got %d", status)
+	}
+	if book.Title != "Test Book" || len(book.ID) == 0 {
+		t.Errorf("unexpected book %+v", book)
+	}
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	part, _ := writer.CreateFormFile("file", "other.epub")
+	part.Write(epubData)
+	writer.Close()
+	var again apiBook
+	if status := do(first, http.MethodPost, "/books", writer.FormDataContentType(), &form, &again); status != http.StatusOK || again.ID != book.ID {
+		t.Errorf("expected the same book from the same file, got %d %+v", status, again)
+	}
+	if status := do(first, http.MethodPost, "/books", "application/epub+zip", strings.NewReader("not a zip"), nil); status != http.StatusBadRequest {
+		t.Errorf("expected an invalid book to be rejected, got %d", status)
+	}
+	protected := map[string]string{"META-INF/rights.xml": "<rights/>"}
+	for name, content := range epubtest.Files {
+		protected[name] = content
+	}
+	protectedData, _ := os.ReadFile(epubtest.Write(t, protected))
+	if status := do(first, http.MethodPost, "/books", "application/epub+zip", bytes.NewReader(protectedData), nil); status != http.StatusUnprocessableEntity {
+		t.Errorf("expected a DRM protected book to be rejected with 422, got %d", status)
+	}
+	var chapters []apiChapter
+	if status := do(first, http.MethodGet, "/books/"+book.ID+"/chapters", "", nil, &chapters); status != http.StatusOK || len(chapters) != 3 || chapters[1].Title != "One: The Start" || chapters[1].Tokens == 0 {
+		t.Errorf("unexpected chapters %d %+v", status, chapters)
+	}
+	if status := do(first, http.MethodGet, "/books/missing/chapters", "", nil, nil); status != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing book, got %d", status)
+	}
+	for _, body := range []string{`{"chapters": "9"}`, `{"chapters": "2", "provider": "openai"}`, `{"chapters": "2", "profile": "missing"}`, `{`} {
+		if status := do(first, http.MethodPost, "/books/"+book.ID+"/jobs", "application/json", strings.NewReader(body), nil); status != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, status)
+		}
+	}
+	if status := do(first, http.MethodPost, "/books/"+book.ID+"/jobs", "text/plain", strings.NewReader(`{"chapters": "2"}`), nil); status != http.StatusUnsupportedMediaType {
+		t.Errorf("expected 415 for a job sent as text/plain, got %d", status)
+	}
+	for _, path := range []string{"/books", "/books/" + book.ID + "/jobs"} {
+		req, _ := http.NewRequest(http.MethodPost, first.URL+path, strings.NewReader(`{"chapters": "2"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Origin", "https://example.com")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: expected a cross-site request to be refused, got %d", path, resp.StatusCode)
+		}
+	}
+	var job apiJob
+	if status := do(first, http.MethodPost, "/books/"+book.ID+"/jobs", "application/json", strings.NewReader(`{"chapters": "2-3", "prompt": "Rewrite as json {\"title\", \"content\"}"}`), &job); status != http.StatusAccepted {
+		t.Fatalf("expected the job to be queued, got %d", status)
+	}
+	if job.Status != pipeline.StatusPending || job.Total != 2 {
+		t.Errorf("unexpected job %+v", job)
+	}
+
+	api, second := newServer()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api.start(ctx, 2)
+	deadline := time.Now().Add(10 * time.Second)
+	for job.Status != pipeline.StatusDone && job.Status != pipeline.StatusFailed && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+		do(second, http.MethodGet, "/jobs/"+job.ID, "", nil, &job)
+	}
+	if job.Status != pipeline.StatusDone || job.Done != 2 || job.Usage.TotalTokens != 30 || job.Finished == nil {
+		t.Fatalf("unexpected job %+v", job)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected 2 requests, got %d", calls.Load())
+	}
+	var output struct {
+		Chapters []apiOutput `json:"chapters"`
+	}
+	if status := do(second, http.MethodGet, "/jobs/"+job.ID+"/output", "", nil, &output); status != http.StatusOK || len(output.Chapters) != 2 || output.Chapters[0].Number != 2 {
+		t.Errorf("unexpected output %d %+v", status, output)
+	}
+	resp, err := http.Get(second.URL + "/jobs/" + job.ID + "/output?format=md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	markdown, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.HasPrefix(string(markdown), "# Post") || strings.Count(string(markdown), "Rewritten text.") != 2 {
+		t.Errorf("unexpected markdown output %q", markdown)
+	}
+	if status := do(second, http.MethodGet, "/jobs/missing", "", nil, nil); status != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing job, got %d", status)
+	}
+}
+
+func TestAPIJobInterrupted(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("TMPDIR", t.TempDir())
+	t.Cleanup(cleanupWorkspace)
+	dataDir := t.TempDir()
+	configFile := filepath.Join(t.TempDir(), "epubmd.yaml")
+	os.WriteFile(configFile, []byte("format: blog,md\n"), 0644)
+	api, err := newAPIServer(dataDir, configFile, extract.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.client, _ = llmtest.NewFakeModel(t)
+	mux := http.NewServeMux()
+	api.routes(mux)
+	epubData, err := os.ReadFile(epubtest.Write(t, epubtest.Files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var book apiBook
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/books?name=test-book.epub", bytes.NewReader(epubData)))
+	json.NewDecoder(rec.Body).Decode(&book)
+	var job apiJob
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/books/"+book.ID+"/jobs", strings.NewReader(`{"chapters": "2"}`))
+	req.Header.Set("Content-Type", "application/json")
+	mux.ServeHTTP(rec, req)
+	if err := json.NewDecoder(rec.Body).Decode(&job); err != nil || rec.Code != http.StatusAccepted {
+		t.Fatalf("expected the job to be queued, got %d %v", rec.Code, err)
+	}
+
+	// the shutdown of serve cancels the context of the running jobs
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	api.runJob(ctx, api.jobs[job.ID])
+	if got := api.jobs[job.ID]; got.Status != pipeline.StatusPending || got.Finished != nil || len(got.Error) > 0 {
+		t.Errorf("expected the interrupted job to stay pending, got %+v", got)
+	}
+	restarted, err := newAPIServer(dataDir, configFile, extract.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restarted.queue) != 1 || restarted.queue[0] != job.ID {
+		t.Errorf("expected the interrupted job to be queued again, got %q", restarted.queue)
+	}
+}
+
+func TestRequestSettings(t *testing.T) {
+	_, err := requestSettings([]string{"-book", "book.epub", "-unknown"})
+	if err == nil || err.Error() != "invalid generate settings: flag provided but not defined: -unknown" {
+		t.Errorf("expected the flag error, got %v", err)
+	}
+	_, err = requestSettings([]string{"-book", "book.epub", "-verify", "maybe"})
+	if err == nil || !strings.Contains(err.Error(), `unknown verification "maybe"`) {
+		t.Errorf("expected the verification error, got %v", err)
+	}
+}
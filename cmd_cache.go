@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"cli-epub-parser-md-generator/llm"
+)
+
+// runCache manages the cache of model responses: cache path, cache stats or cache clear [-older-than 720h].
+func runCache(args []string) int {
+	fs := newFlagSet("cache", "path|stats|clear [-older-than 720h]")
+	olderThan := fs.Duration("older-than", 0, "with clear, only remove responses older than this, ex: 720h")
+	action := "stats"
+	if len(args) > 0 && args[0] != "-h" && args[0] != "-help" && args[0] != "--help" {
+		action, args = args[0], args[1:]
+	}
+	if code := parseFlags(fs, args, nil); code >= 0 {
+		return code
+	}
+	dir, err := llm.DefaultCacheDir()
+	if err != nil {
+		fmt.Println(err)
+		return exitError
+	}
+	cache := &llm.Cache{Dir: dir}
+	switch action {
+	case "path":
+		fmt.Println(cache.Dir)
+	case "stats":
+		stats, err := cache.Stats()
+		if err != nil {
+			fmt.Println(err)
+			return exitError
+		}
+		fmt.Printf("%d cached responses, %.1f KiB in %s\n", stats.Entries, float64(stats.Bytes)/1024, cache.Dir)
+		if stats.Entries > 0 {
+			fmt.Printf("oldest %s, newest %s\n", stats.Oldest.Format(time.DateTime), stats.Newest.Format(time.DateTime))
+		}
+	case "clear":
+		removed, err := cache.Clear(*olderThan)
+		if err != nil {
+			fmt.Println(err)
+			return exitError
+		}
+		fmt.Printf("removed %d cached responses\n", removed)
+	default:
+		fmt.Printf("unknown cache action %q\n", action)
+		fs.Usage()
+		return exitUsage
+	}
+	return exitOK
+}
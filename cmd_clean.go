@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// runClean removes the temp folders left behind by runs that crashed or were killed.
+func runClean(args []string) int {
+	fs := newFlagSet("clean", "[-dry-run] [-legacy-dir .]")
+	dryRun := fs.Bool("dry-run", false, "only list the folders that would be removed")
+	legacyDir := fs.String("legacy-dir", ".", "folder searched for the .tmpNNNN folders of earlier versions, empty to skip")
+	if code := parseFlags(fs, args, nil); code >= 0 {
+		return code
+	}
+	stale, err := findStaleWorkspaces(os.TempDir(), *legacyDir)
+	if err != nil {
+		fmt.Println(err)
+		return exitError
+	}
+	if len(stale) == 0 {
+		fmt.Println("no stale temp folder")
+		return exitOK
+	}
+	if *dryRun {
+		for _, dir := range stale {
+			fmt.Println(dir)
+		}
+		return exitOK
+	}
+	deleteTempFolders(stale)
+	return exitOK
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// runConfig prints the effective configuration: config show [-config file] [-profile name].
+func runConfig(args []string) int {
+	fs := newFlagSet("config", "show [-config <file>] [-profile <name>]")
+	config := addConfigFlags(fs)
+	action := "show"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+	if code := parseFlags(fs, args, nil); code >= 0 {
+		return code
+	}
+	if action != "show" {
+		fmt.Printf("unknown config action %q\n", action)
+		fs.Usage()
+		return exitUsage
+	}
+	loaded, err := loadConfig(*config.file, *config.profile)
+	if err != nil {
+		fmt.Println(err)
+		return exitUsage
+	}
+	if len(loaded.Files) == 0 {
+		fmt.Println("# no config file, using the defaults")
+	}
+	for _, filename := range loaded.Files {
+		fmt.Println("# from", filename)
+	}
+	if len(loaded.Profile) > 0 {
+		fmt.Println("# profile", loaded.Profile)
+	}
+	if names := loaded.profileNames(); len(names) > 0 {
+		fmt.Println("# profiles:", strings.Join(names, ", "))
+	}
+	effective := loaded.Config
+	effective.Profiles = nil
+	data, err := yaml.Marshal(effective)
+	if err != nil {
+		fmt.Println(err)
+		return exitError
+	}
+	fmt.Print(string(data))
+	return exitOK
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"cli-epub-parser-md-generator/extract"
+	"cli-epub-parser-md-generator/render"
+)
+
+// runExtract converts every document of the reading order to Markdown without calling the model.
+func runExtract(args []string) int {
+	fs := newFlagSet("extract", "-book <book_name> [-out <dir>] [-combined]")
+	book := fs.String("book", "", "the book: an .epub, .fb2, .mobi, .azw3, .pdf, .md or .txt file or a folder of HTML files")
+	out := fs.String("out", "", "folder the book is written to, default <output>/<book>")
+	output := fs.String("output", outputPath, "output folder, books are written to <output>/<book>")
+	combined := fs.Bool("combined", false, "also write the whole book as a single Markdown file")
+	all := fs.Bool("all", false, "include every document of the spine: non-linear ones such as notes or answers, front matter, cover and index")
+	conversion := addConversionFlags(fs)
+	config := addConfigFlags(fs)
+	if code := parseFlags(fs, args, book); code >= 0 {
+		return code
+	}
+	if err := config.apply(fs); err != nil {
+		fmt.Println(err)
+		return exitUsage
+	}
+	opts, err := conversion.options()
+	if err != nil {
+		fmt.Println(err)
+		return exitUsage
+	}
+	dir := *out
+	if len(dir) == 0 {
+		dir = render.BookDir(*output, *book)
+	}
+	opts.AssetsDir = filepath.Join(dir, "assets")
+
+	ebook, err := extractBook(*book)
+	if err != nil {
+		fmt.Println(err)
+		return exitError
+	}
+	defer removeWorkspace(ebook.Dir)
+	opts.RootDir = ebook.Dir
+	title := ebook.Metadata.Title
+	if len(title) == 0 {
+		title = strings.TrimSuffix(filepath.Base(*book), filepath.Ext(*book))
+	}
+	var whole strings.Builder
+	fmt.Fprintf(&whole, "# %s\n\n", title)
+	if len(ebook.Metadata.Creators) > 0 {
+		fmt.Fprintf(&whole, "_%s_\n\n", strings.Join(ebook.Metadata.Creators, ", "))
+	}
+	for i, item := range ebook.Chapters(*all) {
+		chapterOpts := opts
+		chapterOpts.Chapter = i + 1
+		converted, err := extract.ConvertFile(ebook.Path(item.Href), chapterOpts)
+		if err != nil {
+			fmt.Println(err)
+			return exitError
+		}
+		for _, warning := range converted.Warnings {
+			fmt.Printf("chapter %d: %s\n", i+1, warning)
+		}
+		// empty documents keep their number so files match the numbering of list
+		if len(strings.TrimSpace(converted.Text)) == 0 && len(converted.Figures) == 0 {
+			continue
+		}
+		markdown := withTitle(converted.Markdown, item.Title)
+		filename := fmt.Sprintf("%02d-%s.md", i+1, render.Slugify(item.Title))
+		if err := render.SaveFile(dir, filename, markdown); err != nil {
+			fmt.Println(err)
+			return exitError
+		}
+		fmt.Println("saved at: ", filepath.Join(dir, filename))
+		whole.WriteString(shiftHeadings(markdown, 1) + "\n")
+	}
+	if *combined {
+		filename := render.Slugify(title) + ".md"
+		if err := render.SaveFile(dir, filename, whole.String()); err != nil {
+			fmt.Println(err)
+			return exitError
+		}
+		fmt.Println("saved at: ", filepath.Join(dir, filename))
+	}
+	return exitOK
+}
+
+// withTitle starts the chapter with its table of contents title unless it already opens with a heading.
+func withTitle(markdown, title string) string {
+	blocks := render.ParseMarkdown(markdown)
+	if len(blocks) > 0 && blocks[0].Kind == render.BlockHeading {
+		return markdown
+	}
+	return "# " + title + "\n\n" + markdown
+}
+
+var headingLineRe = regexp.MustCompile(`^#{1,6}\s`)
+
+// shiftHeadings moves every heading down by levels, up to level 6, so chapters nest under the book title.
+func shiftHeadings(markdown string, levels int) string {
+	lines := strings.Split(markdown, "\n")
+	inCode := false
+	for i, line := range lines {
+		if strings.HasPrefix(line, "```") {
+			inCode = !inCode
+		}
+		if inCode || !headingLineRe.MatchString(line) {
+			continue
+		}
+		level := len(line) - len(strings.TrimLeft(line, "#"))
+		lines[i] = strings.Repeat("#", min(level+levels, 6)) + line[level:]
+	}
+	return strings.Join(lines, "\n")
+}
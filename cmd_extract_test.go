@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cli-epub-parser-md-generator/internal/epubtest"
+)
+
+func TestRunExtract(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	t.Cleanup(cleanupWorkspace)
+	out := t.TempDir()
+	book := epubtest.Write(t, epubtest.Files)
+	if code := runExtract([]string{"-book", book, "-out", out, "-combined"}); code != 0 {
+		t.Fatalf("extract exited with %d", code)
+	}
+	chapter, err := os.ReadFile(filepath.Join(out, "02-one-the-start.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(chapter), "# One: The Start\n\nFirst chapter text.[^1]") || !strings.Contains(string(chapter), "[^1]: The note.") {
+		t.Errorf("unexpected chapter:\n%s", chapter)
+	}
+	if _, err := os.Stat(filepath.Join(out, "assets", "images-fig.png")); err != nil {
+		t.Error(err)
+	}
+	whole, err := os.ReadFile(filepath.Join(out, "test-book.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(whole), "# Test Book\n\n_Jane Doe_") || !strings.Contains(string(whole), "## Two") || strings.Contains(string(whole), "The note.\n\n# notes") {
+		t.Errorf("unexpected combined file:\n%s", whole)
+	}
+	if code := runExtract([]string{}); code != 2 {
+		t.Errorf("expected usage error, got %d", code)
+	}
+}
+
+func TestShiftHeadings(t *testing.T) {
+	got := shiftHeadings("# A\n\n```\n# not a heading\n```\n\n###### F", 1)
+	if got != "## A\n\n```\n# not a heading\n```\n\n###### F" {
+		t.Errorf("got %q", got)
+	}
+}
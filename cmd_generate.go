@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cohesion-org/deepseek-go"
+
+	"cli-epub-parser-md-generator/epub"
+	"cli-epub-parser-md-generator/extract"
+	"cli-epub-parser-md-generator/llm"
+	"cli-epub-parser-md-generator/pipeline"
+	"cli-epub-parser-md-generator/render"
+)
+
+// generateSettings are the flags of generate once merged with the config, checked and resolved.
+type generateSettings struct {
+	book        string
+	chapters    string // selection, asked interactively when empty
+	all         bool
+	formats     []string
+	convert     extract.Options
+	generate    llm.Options
+	outputDir   string
+	concurrency int
+	resume      bool
+	glossary    bool
+	previously  int
+	verify      string
+	noCache     bool
+}
+
+// parseGenerateArgs parses the flags of generate. It returns the exit code to stop with, or -1
+// with the settings to run.
+func parseGenerateArgs(args []string) (*generateSettings, int) {
+	settings, code, err := readGenerateArgs(args, os.Stderr)
+	if err != nil {
+		fmt.Println(err)
+		return nil, exitUsage
+	}
+	return settings, code
+}
+
+// requestSettings resolves the generate settings of a request of serve from args, without
+// writing anything on its console. The error is the first line the flags would print.
+func requestSettings(args []string) (*generateSettings, error) {
+	var output strings.Builder
+	settings, code, err := readGenerateArgs(args, &output)
+	if err != nil {
+		return nil, err
+	}
+	if code >= 0 {
+		message, _, _ := strings.Cut(output.String(), "\n")
+		return nil, fmt.Errorf("invalid generate settings: %s", message)
+	}
+	return settings, nil
+}
+
+// readGenerateArgs parses the flags of generate, writing their errors and the usage to flagOutput.
+// It returns the exit code to stop with when the flags can't be parsed, or the settings to run
+// and the error of their values.
+func readGenerateArgs(args []string, flagOutput io.Writer) (*generateSettings, int, error) {
+	fs := newFlagSet("generate", "-book <book_name> [-chapters 1-5,8|all] [-format blog|youtube-script,md,html,json,epub,ssml]")
+	book := fs.String("book", "", "the book: an .epub, .fb2, .mobi, .azw3, .pdf, .md or .txt file or a folder of HTML files")
+	fs.String("port", "8000", "ignored, kept for existing scripts; use the serve command to preview a book")
+	formatFlag := fs.String("format", render.StyleBlog, "comma separated writing style (blog or youtube-script) and output formats (md, html, json, epub, ssml)")
+	chaptersFlag := fs.String("chapters", "", "chapters to generate, ex: 3, 1-5,8 or all, as numbered by list; asked interactively when empty")
+	all := fs.Bool("all", false, "include every document of the spine in the chapter numbering: non-linear ones, front matter, cover and index")
+	figures := fs.Bool("figures", false, "list the figures of a chapter in the prompt as [Figure 3.2: caption] placeholders")
+	wpm := fs.Int("wpm", render.DefaultWordsPerMinute, "speaking rate in words per minute, used for script and caption timings")
+	exportFlag := fs.String("export", "", "comma separated extra exports of the generated text: teleprompter, srt, vtt")
+	noCache := fs.Bool("no-cache", false, "always ask the model, without reading or writing the response cache")
+	provider := fs.String("provider", llm.ProviderDeepSeek, "model provider, only deepseek for now")
+	model := fs.String("model", deepseek.DeepSeekChat, "model name")
+	promptFile := fs.String("prompt", "", "file with a system prompt replacing the built-in one; it must still ask for {\"title\", \"content\"} json")
+	output := fs.String("output", outputPath, "output folder, books are written to <output>/<book>")
+	concurrency := fs.Int("concurrency", 1, "number of chapters generated at the same time")
+	resume := fs.Bool("resume", false, "skip the chapters done by a previous run from the same input and retry the failed ones")
+	glossary := fs.Bool("glossary", false, "give the key terms of glossary.yaml in the output folder to the model with every chapter, extracting it on the first run, and flag the rewrites that rename them")
+	previously := fs.Int("previously", 0, "token budget of the summaries of the chapters before each chapter added to its prompt, so it can refer to them without repeating them; chapters are then generated one at a time; 0 leaves them out")
+	verify := fs.String("verify", "", "check the numbers, names and quotes of every rewrite against its source chapter and write a NN-title.verify.md report: local matches them in the text, judge also asks the model about the ones not found")
+	conversion := addConversionFlags(fs)
+	config := addConfigFlags(fs)
+	fs.SetOutput(flagOutput)
+	if code := parseFlags(fs, args, book); code >= 0 {
+		return nil, code, nil
+	}
+	if err := config.apply(fs); err != nil {
+		return nil, -1, err
+	}
+	if *provider != llm.ProviderDeepSeek {
+		return nil, -1, fmt.Errorf("unsupported provider %q, expected %s", *provider, llm.ProviderDeepSeek)
+	}
+	if len(*verify) > 0 && *verify != llm.VerifyLocal && *verify != llm.VerifyJudge {
+		return nil, -1, fmt.Errorf("unknown verification %q, expected %s or %s", *verify, llm.VerifyLocal, llm.VerifyJudge)
+	}
+	format, formats, err := render.ParseFormat(*formatFlag)
+	if err != nil {
+		return nil, -1, err
+	}
+	exports, err := render.ParseExports(*exportFlag)
+	if err != nil {
+		return nil, -1, err
+	}
+	opts, err := conversion.options()
+	if err != nil {
+		return nil, -1, err
+	}
+	settings := &generateSettings{
+		book:        *book,
+		chapters:    *chaptersFlag,
+		all:         *all,
+		formats:     append(formats, exports...),
+		convert:     opts,
+		generate:    llm.Options{Model: *model, Style: format, WPM: *wpm},
+		outputDir:   render.BookDir(*output, *book),
+		concurrency: *concurrency,
+		resume:      *resume,
+		glossary:    *glossary,
+		previously:  *previously,
+		verify:      *verify,
+		noCache:     *noCache,
+	}
+	if len(*promptFile) > 0 {
+		prompt, err := os.ReadFile(*promptFile)
+		if err != nil {
+			return nil, -1, fmt.Errorf("error reading prompt: %w", err)
+		}
+		settings.generate.Prompt = string(prompt)
+	}
+	settings.convert.AssetsDir = filepath.Join(settings.outputDir, "assets")
+	settings.convert.FigurePlaceholders = *figures
+	return settings, -1, nil
+}
+
+// newRun prepares the run of the settings on an extracted book, for the selected chapters.
+func (s *generateSettings) newRun(ebook *epub.Book, chapters []epub.SpineItem, selected []int) *pipeline.Generator {
+	run := &pipeline.Generator{
+		Book:        ebook,
+		BookName:    s.book,
+		Chapters:    chapters,
+		Selected:    selected,
+		Convert:     s.convert,
+		Generate:    s.generate,
+		Formats:     s.formats,
+		OutputDir:   s.outputDir,
+		Concurrency: s.concurrency,
+		Resume:      s.resume,
+		Glossary:    s.glossary,
+		Previously:  s.previously,
+		Verify:      s.verify,
+		Client:      deepseek.NewClient(os.Getenv("DEEPSEEK_API_KEY")),
+	}
+	if !s.noCache {
+		if dir, err := llm.DefaultCacheDir(); err == nil {
+			run.Cache = &llm.Cache{Dir: dir}
+		}
+	}
+	return run
+}
+
+// runGenerate rewrites the selected chapters with the model and writes them in every requested format.
+func runGenerate(args []string) int {
+	settings, code := parseGenerateArgs(args)
+	if code >= 0 {
+		return code
+	}
+	if len(os.Getenv("DEEPSEEK_API_KEY")) == 0 {
+		fmt.Println("DEEPSEEK_API_KEY is not set, add it to the environment or to a .env file")
+		return exitError
+	}
+	ebook, err := extractBook(settings.book)
+	if err != nil {
+		fmt.Println(err)
+		return exitError
+	}
+	defer removeWorkspace(ebook.Dir)
+	chapters := ebook.Chapters(settings.all)
+	for i, item := range chapters {
+		fmt.Printf("%d: %s\n", i+1, item.Title)
+	}
+	userInput := settings.chapters
+	if len(userInput) == 0 {
+		fmt.Println("choose a chapter based on number, ex: 3, 1-5,8 or all")
+		fmt.Scanln(&userInput)
+	}
+	chapterNumbers, err := parseChapterSelection(userInput, len(chapters))
+	if err != nil {
+		fmt.Println(err)
+		return exitUsage
+	}
+	run := settings.newRun(ebook, chapters, chapterNumbers)
+	run.Progress = func(e pipeline.ProgressEvent) { fmt.Println(e.Message) }
+	if err := run.Run(context.Background()); err != nil {
+		fmt.Println(err)
+		return exitError
+	}
+	return exitOK
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"cli-epub-parser-md-generator/epub"
+)
+
+// bookInfo is what info prints about a book.
+type bookInfo struct {
+	epub.Metadata
+	Package    string          `json:"package"`
+	Rendition  *epub.Rendition `json:"rendition,omitempty"`
+	Renditions int             `json:"renditions"`
+	Chapters   int             `json:"chapters"`
+	Images     int             `json:"images"`
+	TOC        []epub.TOCItem  `json:"toc"`
+	Landmarks  []epub.Landmark `json:"landmarks,omitempty"`
+}
+
+// runInfo prints the metadata of a book and a summary of its content.
+func runInfo(args []string) int {
+	fs := newFlagSet("info", "-book <book_name> [-json]")
+	book := fs.String("book", "", "the book: an .epub, .fb2, .mobi, .azw3, .pdf, .md or .txt file or a folder of HTML files")
+	asJSON := fs.Bool("json", false, "print the information as JSON")
+	if code := parseFlags(fs, args, book); code >= 0 {
+		return code
+	}
+	ebook, err := extractBook(*book)
+	if err != nil {
+		fmt.Println(err)
+		return exitError
+	}
+	defer removeWorkspace(ebook.Dir)
+	info := bookInfo{
+		Metadata:   ebook.Metadata,
+		Package:    ebook.OPFPath,
+		Rendition:  ebook.CurrentRendition(),
+		Renditions: len(ebook.Renditions),
+		Chapters:   len(ebook.Chapters(false)),
+		TOC:        ebook.TOC,
+		Landmarks:  ebook.Landmarks,
+	}
+	for _, item := range ebook.Manifest {
+		if strings.HasPrefix(item.MediaType, "image/") {
+			info.Images++
+		}
+	}
+	if *asJSON {
+		data, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			fmt.Println(err)
+			return exitError
+		}
+		fmt.Println(string(data))
+		return exitOK
+	}
+	fields := []struct{ name, value string }{
+		{"Title", info.Title},
+		{"Author", strings.Join(info.Creators, ", ")},
+		{"Language", info.Language},
+		{"Publisher", info.Publisher},
+		{"Date", info.Date},
+		{"Identifier", info.Identifier},
+		{"Package", info.Package},
+		{"Rendition", renditionSummary(info.Rendition, info.Renditions)},
+		{"Chapters", fmt.Sprint(info.Chapters)},
+		{"Images", fmt.Sprint(info.Images)},
+	}
+	for _, field := range fields {
+		if len(field.value) > 0 {
+			fmt.Printf("%-11s %s\n", field.name+":", field.value)
+		}
+	}
+	if len(info.Description) > 0 {
+		fmt.Printf("\n%s\n", info.Description)
+	}
+	if len(info.Landmarks) > 0 {
+		fmt.Println("\nLandmarks:")
+		for _, landmark := range info.Landmarks {
+			fmt.Printf("  %-15s %s\n", landmark.Type, landmark.Title)
+		}
+	}
+	if len(info.TOC) > 0 {
+		fmt.Println("\nContents:")
+		for _, item := range info.TOC {
+			fmt.Printf("%s%s\n", strings.Repeat("  ", max(item.Level, 1)), item.Title)
+		}
+	}
+	return exitOK
+}
+
+// renditionSummary describes the rendition read when the book has more than one, or "".
+func renditionSummary(rendition *epub.Rendition, count int) string {
+	if rendition == nil || count < 2 {
+		return ""
+	}
+	var details []string
+	for _, value := range []string{rendition.Label, rendition.Layout, rendition.Language, rendition.AccessMode} {
+		if len(value) > 0 {
+			details = append(details, value)
+		}
+	}
+	return fmt.Sprintf("%s of %d (%s)", rendition.Path, count, strings.Join(details, ", "))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"path"
+)
+
+// runList prints the chapters of a book, numbered as generate and extract number them,
+// with the number of tokens each one would send to the model.
+func runList(args []string) int {
+	fs := newFlagSet("list", "-book <book_name> [-all]")
+	book := fs.String("book", "", "the book: an .epub, .fb2, .mobi, .azw3, .pdf, .md or .txt file or a folder of HTML files")
+	all := fs.Bool("all", false, "include every document of the spine: non-linear ones such as notes or answers, front matter, cover and index")
+	conversion := addConversionFlags(fs)
+	config := addConfigFlags(fs)
+	if code := parseFlags(fs, args, book); code >= 0 {
+		return code
+	}
+	if err := config.apply(fs); err != nil {
+		fmt.Println(err)
+		return exitUsage
+	}
+	opts, err := conversion.options()
+	if err != nil {
+		fmt.Println(err)
+		return exitUsage
+	}
+	ebook, err := extractBook(*book)
+	if err != nil {
+		fmt.Println(err)
+		return exitError
+	}
+	defer removeWorkspace(ebook.Dir)
+	fmt.Printf("%3s  %7s  %s\n", "#", "tokens", "title")
+	total := 0
+	for i, item := range ebook.Chapters(*all) {
+		tokens := chapterTokens(ebook, item, i+1, opts)
+		total += tokens
+		title := item.Title
+		if !item.Linear {
+			title += " (non-linear)"
+		}
+		if len(item.Landmark) > 0 {
+			title += " (" + item.Landmark + ")"
+		}
+		fmt.Printf("%3d  %7d  %s [%s]\n", i+1, tokens, title, path.Base(item.Href))
+	}
+	fmt.Printf("%3s  %7d  total\n", "", total)
+	return exitOK
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// runServe serves the JSON API and, when a book is given, a web UI to browse its chapters and
+// generate them.
+func runServe(args []string) int {
+	fs := newFlagSet("serve", "[-book <book_name>] [-listen 127.0.0.1] [-port 8000] [-data dir] [-jobs 1]")
+	book := fs.String("book", "", "the book to browse in the web UI, an .epub, .fb2, .mobi, .azw3, .pdf, .md or .txt file or a folder of HTML files; the API alone is served without it")
+	host := fs.String("listen", "127.0.0.1", "address to listen on; 0.0.0.0 serves the whole network, with no authentication")
+	port := fs.Int("port", 8000, "port number, a free one is picked when 0 or already in use")
+	dataDir := fs.String("data", "", "folder keeping the books and jobs of the API (default: the user cache directory)")
+	workers := fs.Int("jobs", 1, "number of API jobs run at the same time")
+	conversion := addConversionFlags(fs)
+	config := addConfigFlags(fs)
+	if code := parseFlags(fs, args, nil); code >= 0 {
+		return code
+	}
+	if len(*book) == 0 && fs.NArg() > 0 {
+		*book = fs.Arg(0)
+	}
+	if err := config.apply(fs); err != nil {
+		fmt.Println(err)
+		return exitUsage
+	}
+	opts, err := conversion.options()
+	if err != nil {
+		fmt.Println(err)
+		return exitUsage
+	}
+	if len(*dataDir) == 0 {
+		if *dataDir, err = defaultAPIDir(); err != nil {
+			fmt.Println(err)
+			return exitError
+		}
+	}
+	api, err := newAPIServer(*dataDir, *config.file, opts)
+	if err != nil {
+		fmt.Println(err)
+		return exitError
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api.start(ctx, *workers)
+	mux := http.NewServeMux()
+	api.routes(mux)
+	if len(*book) > 0 {
+		ebook, err := extractBook(*book)
+		if err != nil {
+			fmt.Println(err)
+			return exitError
+		}
+		defer removeWorkspace(ebook.Dir)
+		newBookUI(ebook, *book, *config.file, opts).routes(mux)
+	}
+	listener, err := listen(*host, *port)
+	if err != nil {
+		fmt.Println(err)
+		return exitError
+	}
+	if !isLoopback(*host) {
+		log.Printf("warning: listening on %s, anyone who can reach it can read the books and spend the API key", *host)
+	}
+	log.Printf("Starting server on http://%s ...", listener.Addr())
+	if err := http.Serve(listener, mux); err != nil {
+		fmt.Println(err)
+		return exitError
+	}
+	return exitOK
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"cli-epub-parser-md-generator/epub"
+	"cli-epub-parser-md-generator/extract"
+	"cli-epub-parser-md-generator/source"
+	"cli-epub-parser-md-generator/tokens"
+)
+
+// Exit codes shared by every command.
+const (
+	exitOK    int = 0
+	exitError int = 1 // the command ran and failed
+	exitUsage int = 2 // invalid flags or arguments
+)
+
+// command is a subcommand of the cli, run with the arguments that follow its name.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"list", "list the chapters of a book with their titles and token counts", runList},
+		{"info", "print the metadata of a book", runInfo},
+		{"extract", "export a book to Markdown without calling the model", runExtract},
+		{"generate", "rewrite chapters with the model as blog posts or video scripts", runGenerate},
+		{"serve", "preview the extracted book in the browser", runServe},
+		{"cache", "inspect or clear the cache of model responses", runCache},
+		{"config", "print the effective configuration", runConfig},
+		{"clean", "remove the temp folders left behind by crashed runs", runClean},
+	}
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: cli-epub-parser-md-generator <command> [flags]")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Run 'cli-epub-parser-md-generator <command> -h' for the flags of a command.")
+	fmt.Fprintln(w, "'cli-epub-parser-md-generator -book <book_name> ...' is kept as a shortcut for generate.")
+}
+
+// runCommand dispatches args, without the program name, to the matching command and returns its exit code.
+func runCommand(args []string) int {
+	if len(args) == 0 {
+		printUsage(os.Stderr)
+		return exitUsage
+	}
+	name := args[0]
+	switch {
+	case name == "help" || name == "-h" || name == "-help" || name == "--help":
+		if len(args) > 1 {
+			return runCommand([]string{args[1], "-h"})
+		}
+		printUsage(os.Stdout)
+		return exitOK
+	case strings.HasPrefix(name, "-"):
+		// scripts written before the subcommands call the binary with -book directly
+		return runGenerate(args)
+	}
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd.run(args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	printUsage(os.Stderr)
+	return exitUsage
+}
+
+// newFlagSet creates the flag set of a command, with a usage line printed before the flags.
+func newFlagSet(name, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: cli-epub-parser-md-generator "+name+" "+usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses args and returns the exit code to stop with, or -1 to carry on.
+// The book may be given with -book or as the first argument.
+func parseFlags(fs *flag.FlagSet, args []string, book *string) int {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if book == nil {
+		return -1
+	}
+	if len(*book) == 0 && fs.NArg() > 0 {
+		*book = fs.Arg(0)
+	}
+	if len(*book) == 0 {
+		fmt.Fprintln(fs.Output(), "missing book")
+		fs.Usage()
+		return exitUsage
+	}
+	return -1
+}
+
+// conversionFlags are the flags controlling how chapters are turned into text, shared by
+// every command that reads chapters.
+type conversionFlags struct {
+	clean     *bool
+	rules     *string
+	footnotes *string
+}
+
+func addConversionFlags(fs *flag.FlagSet) conversionFlags {
+	return conversionFlags{
+		clean:     fs.Bool("clean", true, "strip page breaks, running headers, copyright notices and other noise"),
+		rules:     fs.String("rules", "", "JSON file with extra cleaning rules: {\"selectors\": [...], \"regex\": [{\"pattern\": ..., \"replace\": ...}]}"),
+		footnotes: fs.String("footnotes", string(extract.FootnotesMarkdown), "how note references are rendered: inline, markdown or drop"),
+	}
+}
+
+// options returns the conversion options set by the flags, without the per chapter fields.
+func (f conversionFlags) options() (extract.Options, error) {
+	footnotes, err := extract.ParseFootnoteMode(*f.footnotes)
+	if err != nil {
+		return extract.Options{}, err
+	}
+	opts := extract.Options{AssetsHref: "assets", Footnotes: footnotes}
+	if !*f.clean {
+		return opts, nil
+	}
+	var rules extract.CleanRules
+	if len(*f.rules) > 0 {
+		if rules, err = extract.LoadCleanRules(*f.rules); err != nil {
+			return opts, err
+		}
+	}
+	opts.Cleaner, err = extract.NewCleaner(rules)
+	return opts, err
+}
+
+// extractBook reads the book, in any format of the source package, into a new workspace.
+// The caller removes the workspace with removeWorkspace(book.Dir).
+func extractBook(filename string) (book *epub.Book, err error) {
+	dir, err := newWorkspace()
+	if err != nil {
+		return nil, err
+	}
+	// The workspace is removed on errors, and on panics recovered by the caller.
+	defer func() {
+		if book == nil {
+			removeWorkspace(dir)
+		}
+	}()
+	book, err = source.Open(filename, dir)
+	if err != nil {
+		return nil, err
+	}
+	return book, nil
+}
+
+// chapterTokens returns the number of tokens chapter number would send to the model, or 0 when
+// it can't be converted. Its images are not copied.
+func chapterTokens(book *epub.Book, item epub.SpineItem, number int, opts extract.Options) int {
+	opts.Chapter = number
+	opts.AssetsDir = ""
+	converted, err := extract.ConvertFile(book.Path(item.Href), opts)
+	if err != nil {
+		return 0
+	}
+	count, err := tokens.Count(converted.Text)
+	if err != nil {
+		return 0
+	}
+	return count
+}
+
+// parseChapterSelection parses chapter numbers such as "3", "1-5,8" or "all" into
+// sorted, 1-based chapter numbers no greater than max.
+func parseChapterSelection(value string, max int) ([]int, error) {
+	value = strings.TrimSpace(strings.ToLower(value))
+	if value == "all" {
+		chapters := make([]int, max)
+		for i := range chapters {
+			chapters[i] = i + 1
+		}
+		return chapters, nil
+	}
+	seen := map[int]bool{}
+	var chapters []int
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		from, to, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(strings.TrimSpace(from))
+		if err != nil {
+			return nil, fmt.Errorf("invalid chapter %q", part)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(strings.TrimSpace(to)); err != nil {
+				return nil, fmt.Errorf("invalid chapter range %q", part)
+			}
+		}
+		if start < 1 || end > max || start > end {
+			return nil, fmt.Errorf("chapter %q out of range 1-%d", part, max)
+		}
+		for n := start; n <= end; n++ {
+			if !seen[n] {
+				seen[n] = true
+				chapters = append(chapters, n)
+			}
+		}
+	}
+	if len(chapters) == 0 {
+		return nil, fmt.Errorf("no chapter selected")
+	}
+	sort.Ints(chapters)
+	return chapters, nil
+}
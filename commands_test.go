@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cli-epub-parser-md-generator/epub"
+	"cli-epub-parser-md-generator/internal/epubtest"
+)
+
+func TestRunCommand(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("TMPDIR", t.TempDir())
+	t.Cleanup(cleanupWorkspace)
+	book := epubtest.Write(t, epubtest.Files)
+	manuscript := filepath.Join(t.TempDir(), "manuscript.md")
+	os.WriteFile(manuscript, []byte("# One\n\nText.\n\n# Two\n\nMore text.\n"), 0644)
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{nil, exitUsage},
+		{[]string{"help"}, exitOK},
+		{[]string{"help", "list"}, exitOK},
+		{[]string{"unknown"}, exitUsage},
+		{[]string{"list"}, exitUsage},
+		{[]string{"list", "-nope", book}, exitUsage},
+		{[]string{"list", book}, exitOK},
+		{[]string{"list", "-book", book, "-all"}, exitOK},
+		{[]string{"info", "-json", book}, exitOK},
+		{[]string{"info", "-book", "missing.epub"}, exitError},
+		{[]string{"list", manuscript}, exitOK},
+		{[]string{"info", "-book", "book.pdf"}, exitError},
+		{[]string{"generate", "-book", book, "-format", "pdf"}, exitUsage},
+		{[]string{"generate", "-book", book, "-verify", "maybe"}, exitUsage},
+		{[]string{"-book", book, "-format", "pdf"}, exitUsage},
+		{[]string{"cache", "stats"}, exitOK},
+		{[]string{"cache", "clear", "-older-than", "1h"}, exitOK},
+		{[]string{"cache", "purge"}, exitUsage},
+		{[]string{"config", "show"}, exitOK},
+		{[]string{"config", "show", "-profile", "missing"}, exitUsage},
+		{[]string{"config", "edit"}, exitUsage},
+		{[]string{"clean", "-dry-run", "-legacy-dir", t.TempDir()}, exitOK},
+		{[]string{"generate", "-book", book, "-provider", "openai"}, exitUsage},
+	}
+	for _, test := range tests {
+		if code := runCommand(test.args); code != test.code {
+			t.Errorf("%v: expected exit code %d, got %d", test.args, test.code, code)
+		}
+	}
+}
+
+func TestBookChapters(t *testing.T) {
+	dir := t.TempDir()
+	epub.Extract(epubtest.Write(t, epubtest.Files), dir)
+	book, err := epub.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chapters := book.Chapters(false); len(chapters) != 3 || chapters[1].Title != "One: The Start" {
+		t.Errorf("unexpected chapters %+v", chapters)
+	}
+	if chapters := book.Chapters(true); len(chapters) != 4 {
+		t.Errorf("expected the non-linear notes too, got %+v", chapters)
+	}
+}
+
+func TestParseChapterSelection(t *testing.T) {
+	chapters, err := parseChapterSelection("5, 1-3,2", 10)
+	if err != nil || len(chapters) != 4 || chapters[0] != 1 || chapters[3] != 5 {
+		t.Errorf("unexpected result %v %v", chapters, err)
+	}
+	if chapters, _ := parseChapterSelection("all", 3); len(chapters) != 3 {
+		t.Errorf("expected every chapter, got %v", chapters)
+	}
+	for _, bad := range []string{"", "0", "11", "3-1", "x"} {
+		if _, err := parseChapterSelection(bad, 10); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
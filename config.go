@@ -0,0 +1,357 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/cohesion-org/deepseek-go"
+	"gopkg.in/yaml.v3"
+
+	"cli-epub-parser-md-generator/extract"
+	"cli-epub-parser-md-generator/llm"
+	"cli-epub-parser-md-generator/render"
+)
+
+// Config holds the settings that would otherwise be passed as flags on every run. It is read
+// from epubmd.yaml or epubmd.toml, with named profiles selected with -profile, ex:
+//
+//	model: deepseek-chat
+//	concurrency: 4
+//	profiles:
+//	  youtube:
+//	    format: youtube-script,md,ssml
+//	    wpm: 160
+//
+// Every setting matches the flag of the same name, except output which sets -output.
+type Config struct {
+	Provider    string `yaml:"provider,omitempty" toml:"provider,omitempty"`
+	Model       string `yaml:"model,omitempty" toml:"model,omitempty"`
+	Prompt      string `yaml:"prompt,omitempty" toml:"prompt,omitempty"`
+	Output      string `yaml:"output,omitempty" toml:"output,omitempty"`
+	Concurrency int    `yaml:"concurrency,omitempty" toml:"concurrency,omitempty"`
+	Format      string `yaml:"format,omitempty" toml:"format,omitempty"`
+	Export      string `yaml:"export,omitempty" toml:"export,omitempty"`
+	WPM         int    `yaml:"wpm,omitempty" toml:"wpm,omitempty"`
+	Figures     *bool  `yaml:"figures,omitempty" toml:"figures,omitempty"`
+	Clean       *bool  `yaml:"clean,omitempty" toml:"clean,omitempty"`
+	Glossary    *bool  `yaml:"glossary,omitempty" toml:"glossary,omitempty"`
+	Previously  int    `yaml:"previously,omitempty" toml:"previously,omitempty"`
+	Verify      string `yaml:"verify,omitempty" toml:"verify,omitempty"`
+	Rules       string `yaml:"rules,omitempty" toml:"rules,omitempty"`
+	Footnotes   string `yaml:"footnotes,omitempty" toml:"footnotes,omitempty"`
+
+	Profiles map[string]Config `yaml:"profiles,omitempty" toml:"profiles,omitempty"`
+}
+
+// configNames are the file names looked up, in order, in every config folder.
+var configNames = []string{"epubmd.yaml", "epubmd.yml", "epubmd.toml"}
+
+// envPrefix starts the environment variables overriding the config, ex: EPUBMD_MODEL.
+const envPrefix string = "EPUBMD_"
+
+// LoadedConfig is the effective configuration of a run and where it comes from.
+type LoadedConfig struct {
+	Config
+	Profile string
+	Files   []string
+}
+
+// readConfigFile decodes a YAML or TOML config file, depending on its extension.
+func readConfigFile(filename string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return cfg, err
+	}
+	if strings.EqualFold(filepath.Ext(filename), ".toml") {
+		err = toml.Unmarshal(data, &cfg)
+	} else {
+		err = yaml.Unmarshal(data, &cfg)
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("error parsing config %s: %w", filename, err)
+	}
+	cfg.resolvePaths(filepath.Dir(filename))
+	return cfg, nil
+}
+
+// resolvePaths makes the relative prompt, rules and output paths of the config and its
+// profiles relative to dir, the folder of the config file, rather than to the current folder.
+func (cfg *Config) resolvePaths(dir string) {
+	for _, p := range []*string{&cfg.Prompt, &cfg.Rules, &cfg.Output} {
+		if len(*p) > 0 && !filepath.IsAbs(*p) {
+			*p = filepath.Join(dir, *p)
+		}
+	}
+	for name, profile := range cfg.Profiles {
+		profile.resolvePaths(dir)
+		cfg.Profiles[name] = profile
+	}
+}
+
+// configFiles returns the config files to load, lowest precedence first: the user config in
+// ~/.config/epubmd, then the project config in the current folder. explicit, set with -config,
+// replaces both.
+func configFiles(explicit string) []string {
+	if len(explicit) > 0 {
+		return []string{explicit}
+	}
+	var dirs []string
+	if dir, err := os.UserConfigDir(); err == nil {
+		dirs = append(dirs, filepath.Join(dir, "epubmd"))
+	}
+	dirs = append(dirs, ".")
+	var files []string
+	for _, dir := range dirs {
+		for _, name := range configNames {
+			filename := filepath.Join(dir, name)
+			if _, err := os.Stat(filename); err == nil {
+				files = append(files, filename)
+				break
+			}
+		}
+	}
+	return files
+}
+
+// merge sets every setting of over that is not empty on cfg.
+func (cfg *Config) merge(over Config) {
+	if len(over.Provider) > 0 {
+		cfg.Provider = over.Provider
+	}
+	if len(over.Model) > 0 {
+		cfg.Model = over.Model
+	}
+	if len(over.Prompt) > 0 {
+		cfg.Prompt = over.Prompt
+	}
+	if len(over.Output) > 0 {
+		cfg.Output = over.Output
+	}
+	if over.Concurrency > 0 {
+		cfg.Concurrency = over.Concurrency
+	}
+	if len(over.Format) > 0 {
+		cfg.Format = over.Format
+	}
+	if len(over.Export) > 0 {
+		cfg.Export = over.Export
+	}
+	if over.WPM > 0 {
+		cfg.WPM = over.WPM
+	}
+	if over.Figures != nil {
+		cfg.Figures = over.Figures
+	}
+	if over.Clean != nil {
+		cfg.Clean = over.Clean
+	}
+	if over.Glossary != nil {
+		cfg.Glossary = over.Glossary
+	}
+	if over.Previously > 0 {
+		cfg.Previously = over.Previously
+	}
+	if len(over.Verify) > 0 {
+		cfg.Verify = over.Verify
+	}
+	if len(over.Rules) > 0 {
+		cfg.Rules = over.Rules
+	}
+	if len(over.Footnotes) > 0 {
+		cfg.Footnotes = over.Footnotes
+	}
+}
+
+// flagValues returns the settings by flag name, as flag.Set expects them.
+func (cfg Config) flagValues() map[string]string {
+	values := map[string]string{
+		"provider":  cfg.Provider,
+		"model":     cfg.Model,
+		"prompt":    cfg.Prompt,
+		"output":    cfg.Output,
+		"format":    cfg.Format,
+		"export":    cfg.Export,
+		"rules":     cfg.Rules,
+		"footnotes": cfg.Footnotes,
+		"verify":    cfg.Verify,
+	}
+	if cfg.Concurrency > 0 {
+		values["concurrency"] = strconv.Itoa(cfg.Concurrency)
+	}
+	if cfg.WPM > 0 {
+		values["wpm"] = strconv.Itoa(cfg.WPM)
+	}
+	if cfg.Previously > 0 {
+		values["previously"] = strconv.Itoa(cfg.Previously)
+	}
+	if cfg.Figures != nil {
+		values["figures"] = strconv.FormatBool(*cfg.Figures)
+	}
+	if cfg.Clean != nil {
+		values["clean"] = strconv.FormatBool(*cfg.Clean)
+	}
+	if cfg.Glossary != nil {
+		values["glossary"] = strconv.FormatBool(*cfg.Glossary)
+	}
+	for name, value := range values {
+		if len(value) == 0 {
+			delete(values, name)
+		}
+	}
+	return values
+}
+
+// envConfig reads the EPUBMD_* environment variables.
+func envConfig() (Config, error) {
+	var cfg Config
+	cfg.Provider = os.Getenv(envPrefix + "PROVIDER")
+	cfg.Model = os.Getenv(envPrefix + "MODEL")
+	cfg.Prompt = os.Getenv(envPrefix + "PROMPT")
+	cfg.Output = os.Getenv(envPrefix + "OUTPUT")
+	cfg.Format = os.Getenv(envPrefix + "FORMAT")
+	cfg.Export = os.Getenv(envPrefix + "EXPORT")
+	cfg.Rules = os.Getenv(envPrefix + "RULES")
+	cfg.Footnotes = os.Getenv(envPrefix + "FOOTNOTES")
+	cfg.Verify = os.Getenv(envPrefix + "VERIFY")
+	var err error
+	if value := os.Getenv(envPrefix + "CONCURRENCY"); len(value) > 0 {
+		if cfg.Concurrency, err = strconv.Atoi(value); err != nil {
+			return cfg, fmt.Errorf("invalid %sCONCURRENCY %q", envPrefix, value)
+		}
+	}
+	if value := os.Getenv(envPrefix + "WPM"); len(value) > 0 {
+		if cfg.WPM, err = strconv.Atoi(value); err != nil {
+			return cfg, fmt.Errorf("invalid %sWPM %q", envPrefix, value)
+		}
+	}
+	if value := os.Getenv(envPrefix + "PREVIOUSLY"); len(value) > 0 {
+		if cfg.Previously, err = strconv.Atoi(value); err != nil {
+			return cfg, fmt.Errorf("invalid %sPREVIOUSLY %q", envPrefix, value)
+		}
+	}
+	for name, target := range map[string]**bool{"FIGURES": &cfg.Figures, "CLEAN": &cfg.Clean, "GLOSSARY": &cfg.Glossary} {
+		if value := os.Getenv(envPrefix + name); len(value) > 0 {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return cfg, fmt.Errorf("invalid %s%s %q", envPrefix, name, value)
+			}
+			*target = &b
+		}
+	}
+	return cfg, nil
+}
+
+// defaultConfig holds the defaults of the flags, the lowest precedence of all.
+func defaultConfig() Config {
+	clean, figures, glossary := true, false, false
+	return Config{
+		Provider:    llm.ProviderDeepSeek,
+		Model:       deepseek.DeepSeekChat,
+		Output:      outputPath,
+		Concurrency: 1,
+		Format:      render.StyleBlog,
+		WPM:         render.DefaultWordsPerMinute,
+		Figures:     &figures,
+		Clean:       &clean,
+		Glossary:    &glossary,
+		Footnotes:   string(extract.FootnotesMarkdown),
+		Profiles:    map[string]Config{},
+	}
+}
+
+// loadConfig builds the effective configuration, lowest precedence first: the defaults, the user config,
+// the project config, the selected profile, then the environment and the .env file.
+// Flags set on the command line override all of them, see applyConfig.
+func loadConfig(explicit, profile string) (LoadedConfig, error) {
+	loaded := LoadedConfig{Profile: profile, Config: defaultConfig()}
+	if len(loaded.Profile) == 0 {
+		loaded.Profile = os.Getenv(envPrefix + "PROFILE")
+	}
+	files := configFiles(explicit)
+	if len(explicit) > 0 {
+		if _, err := os.Stat(explicit); errors.Is(err, os.ErrNotExist) {
+			return loaded, fmt.Errorf("config file %s not found", explicit)
+		}
+	}
+	for _, filename := range files {
+		cfg, err := readConfigFile(filename)
+		if err != nil {
+			return loaded, err
+		}
+		loaded.merge(cfg)
+		for name, p := range cfg.Profiles {
+			merged := loaded.Profiles[name]
+			merged.merge(p)
+			loaded.Profiles[name] = merged
+		}
+		loaded.Files = append(loaded.Files, filename)
+	}
+	if len(loaded.Profile) > 0 {
+		p, ok := loaded.Profiles[loaded.Profile]
+		if !ok {
+			return loaded, fmt.Errorf("unknown profile %q, expected one of: %s", loaded.Profile, strings.Join(loaded.profileNames(), ", "))
+		}
+		loaded.merge(p)
+	}
+	env, err := envConfig()
+	if err != nil {
+		return loaded, err
+	}
+	loaded.merge(env)
+	return loaded, nil
+}
+
+func (loaded LoadedConfig) profileNames() []string {
+	var names []string
+	for name := range loaded.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// configFlags are the -config and -profile flags of the commands reading the config.
+type configFlags struct {
+	file    *string
+	profile *string
+}
+
+func addConfigFlags(fs *flag.FlagSet) configFlags {
+	return configFlags{
+		file:    fs.String("config", "", "config file, default epubmd.yaml or epubmd.toml in the current folder and ~/.config/epubmd"),
+		profile: fs.String("profile", "", "named profile of the config file, ex: youtube"),
+	}
+}
+
+// apply loads the configuration and sets it on every flag of fs that was not given on
+// the command line.
+func (f configFlags) apply(fs *flag.FlagSet) error {
+	loaded, err := loadConfig(*f.file, *f.profile)
+	if err != nil {
+		return err
+	}
+	return applyConfig(fs, loaded.Config)
+}
+
+// applyConfig sets the config values on the flags of fs that were not set explicitly.
+func applyConfig(fs *flag.FlagSet, cfg Config) error {
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	for name, value := range cfg.flagValues() {
+		if explicit[name] || fs.Lookup(name) == nil {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("invalid config value for %s: %w", name, err)
+		}
+	}
+	return nil
+}
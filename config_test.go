@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cli-epub-parser-md-generator/render"
+)
+
+func TestLoadConfig(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", home)
+	os.MkdirAll(filepath.Join(home, "epubmd"), 0755)
+	os.WriteFile(filepath.Join(home, "epubmd", "epubmd.toml"), []byte(`
+model = "deepseek-reasoner"
+concurrency = 2
+
+[profiles.youtube]
+format = "youtube-script,md"
+wpm = 140
+`), 0644)
+	loaded, err := loadConfig("", "youtube")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Model != "deepseek-reasoner" || loaded.Concurrency != 2 || loaded.Format != "youtube-script,md" || loaded.WPM != 140 || loaded.Output != outputPath {
+		t.Errorf("unexpected config %+v", loaded.Config)
+	}
+	if len(loaded.Files) != 1 {
+		t.Errorf("expected the user config, got %v", loaded.Files)
+	}
+
+	project := filepath.Join(t.TempDir(), "epubmd.yaml")
+	os.WriteFile(project, []byte("clean: false\nprofiles:\n  blog:\n    format: blog,html\n"), 0644)
+	t.Setenv("EPUBMD_CONCURRENCY", "8")
+	loaded, err = loadConfig(project, "blog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Format != "blog,html" || *loaded.Clean || loaded.Concurrency != 8 || loaded.Model != "deepseek-chat" {
+		t.Errorf("unexpected config %+v", loaded.Config)
+	}
+	if _, err := loadConfig(project, "youtube"); err == nil {
+		t.Error("expected an error for an unknown profile")
+	}
+	t.Setenv("EPUBMD_CONCURRENCY", "many")
+	if _, err := loadConfig(project, ""); err == nil {
+		t.Error("expected an error for an invalid environment variable")
+	}
+}
+
+func TestLoadConfigPaths(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	dir := t.TempDir()
+	project := filepath.Join(dir, "epubmd.yaml")
+	absolute := filepath.Join(t.TempDir(), "books")
+	os.WriteFile(project, []byte("prompt: prompts/blog.txt\noutput: "+absolute+"\nprofiles:\n  clean:\n    rules: rules.json\n"), 0644)
+	loaded, err := loadConfig(project, "clean")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Prompt != filepath.Join(dir, "prompts", "blog.txt") || loaded.Rules != filepath.Join(dir, "rules.json") || loaded.Output != absolute {
+		t.Errorf("expected the paths relative to the config file, got %+v", loaded.Config)
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	model := fs.String("model", "deepseek-chat", "")
+	wpm := fs.Int("wpm", render.DefaultWordsPerMinute, "")
+	clean := fs.Bool("clean", true, "")
+	fs.Parse([]string{"-wpm", "170"})
+	clean2 := false
+	if err := applyConfig(fs, Config{Model: "other", WPM: 120, Clean: &clean2, Output: "out"}); err != nil {
+		t.Fatal(err)
+	}
+	if *model != "other" || *wpm != 170 || *clean {
+		t.Errorf("expected explicit flags to win over the config, got %s %d %v", *model, *wpm, *clean)
+	}
+}
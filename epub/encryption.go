@@ -0,0 +1,147 @@
+package epub
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrDRMProtected is returned by Open for books whose content is encrypted with DRM: it can't be
+// read without the key of the reader they were bought for.
+var ErrDRMProtected = errors.New("the book is DRM protected")
+
+const (
+	idpfObfuscation  = "http://www.idpf.org/2008/embedding"
+	adobeObfuscation = "http://ns.adobe.com/pdf/enc#RC"
+)
+
+// rightsFiles are the META-INF files of the DRM schemes, by scheme name.
+var rightsFiles = []struct{ name, scheme string }{
+	{"rights.xml", "Adobe ADEPT"},
+	{"license.lcpl", "Readium LCP"},
+	{"sinf.xml", "Apple FairPlay"},
+}
+
+type encryptionXML struct {
+	EncryptedData []struct {
+		Method struct {
+			Algorithm string `xml:"Algorithm,attr"`
+		} `xml:"EncryptionMethod"`
+		Reference struct {
+			URI string `xml:"URI,attr"`
+		} `xml:"CipherData>CipherReference"`
+	} `xml:"EncryptedData"`
+}
+
+// encryptedResource is a resource listed in META-INF/encryption.xml.
+type encryptedResource struct {
+	path      string // relative to the root of the extracted book
+	algorithm string
+}
+
+// readEncryption lists the resources of META-INF/encryption.xml and returns ErrDRMProtected when
+// the book has a rights file or any resource is encrypted with something else than the font
+// obfuscation of the IDPF or Adobe, which is not DRM.
+func readEncryption(dir string) ([]encryptedResource, error) {
+	var encryption encryptionXML
+	err := readXML(filepath.Join(dir, "META-INF", "encryption.xml"), &encryption)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("error reading encryption.xml: %w", err)
+	}
+	var resources []encryptedResource
+	var encrypted []string
+	for _, data := range encryption.EncryptedData {
+		if len(data.Reference.URI) == 0 {
+			continue
+		}
+		resource := encryptedResource{path: resolveHref("", data.Reference.URI), algorithm: data.Method.Algorithm}
+		if !filepath.IsLocal(filepath.FromSlash(resource.path)) {
+			return nil, fmt.Errorf("unsafe path %q in encryption.xml", data.Reference.URI)
+		}
+		resources = append(resources, resource)
+		if resource.algorithm != idpfObfuscation && resource.algorithm != adobeObfuscation {
+			encrypted = append(encrypted, resource.path)
+		}
+	}
+	scheme := ""
+	for _, rights := range rightsFiles {
+		if _, err := os.Stat(filepath.Join(dir, "META-INF", rights.name)); err == nil {
+			scheme = rights.scheme
+			break
+		}
+	}
+	if len(encrypted) == 0 && len(scheme) == 0 {
+		return resources, nil
+	}
+	if len(scheme) == 0 {
+		scheme = "unknown scheme"
+	}
+	if len(encrypted) == 0 {
+		return nil, fmt.Errorf("%w (%s)", ErrDRMProtected, scheme)
+	}
+	listed := encrypted[:min(len(encrypted), 5)]
+	more := ""
+	if len(encrypted) > len(listed) {
+		more = fmt.Sprintf(" and %d more", len(encrypted)-len(listed))
+	}
+	return nil, fmt.Errorf("%w (%s), %d encrypted resources: %s%s", ErrDRMProtected, scheme, len(encrypted), strings.Join(listed, ", "), more)
+}
+
+// deobfuscateFonts restores the fonts obfuscated with the key derived from the identifiers of
+// the book, the unique one first, and removes encryption.xml so that opening the book again
+// leaves them alone.
+func deobfuscateFonts(dir string, identifiers []string, resources []encryptedResource) error {
+	if len(resources) == 0 {
+		return nil
+	}
+	for _, resource := range resources {
+		key, length := obfuscationKey(resource.algorithm, identifiers)
+		if len(key) == 0 {
+			return fmt.Errorf("can't derive the font obfuscation key of %s from the identifiers %q", resource.path, identifiers)
+		}
+		filename := filepath.Join(dir, filepath.FromSlash(resource.path))
+		data, err := os.ReadFile(filename)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for i := range min(length, len(data)) {
+			data[i] ^= key[i%len(key)]
+		}
+		if err := os.WriteFile(filename, data, 0644); err != nil {
+			return err
+		}
+	}
+	return os.Remove(filepath.Join(dir, "META-INF", "encryption.xml"))
+}
+
+// obfuscationKey returns the key of an obfuscation algorithm and the number of leading bytes
+// it applies to: the SHA-1 of the unique identifier without whitespace for the IDPF, the bytes
+// of the first urn:uuid identifier for Adobe.
+func obfuscationKey(algorithm string, identifiers []string) ([]byte, int) {
+	switch algorithm {
+	case idpfObfuscation:
+		if len(identifiers) == 0 {
+			return nil, 0
+		}
+		sum := sha1.Sum([]byte(strings.Join(strings.Fields(identifiers[0]), "")))
+		return sum[:], 1040
+	case adobeObfuscation:
+		for _, identifier := range identifiers {
+			uuid, ok := strings.CutPrefix(strings.TrimSpace(identifier), "urn:uuid:")
+			if !ok {
+				continue
+			}
+			if key, err := hex.DecodeString(strings.ReplaceAll(uuid, "-", "")); err == nil && len(key) == 16 {
+				return key, 1024
+			}
+		}
+	}
+	return nil, 0
+}
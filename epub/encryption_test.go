@@ -0,0 +1,121 @@
+package epub
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cli-epub-parser-md-generator/internal/epubtest"
+)
+
+func encryptionFile(resources ...encryptedResource) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0"?><encryption xmlns="urn:oasis:names:tc:opendocument:xmlns:container" xmlns:enc="http://www.w3.org/2001/04/xmlenc#">`)
+	for _, resource := range resources {
+		b.WriteString(`<enc:EncryptedData><enc:EncryptionMethod Algorithm="` + resource.algorithm + `"/><enc:CipherData><enc:CipherReference URI="` + resource.path + `"/></enc:CipherData></enc:EncryptedData>`)
+	}
+	b.WriteString(`</encryption>`)
+	return b.String()
+}
+
+func TestOpenDRMProtected(t *testing.T) {
+	files := map[string]string{
+		"META-INF/encryption.xml": encryptionFile(
+			encryptedResource{"OEBPS/text/ch01.xhtml", "http://www.w3.org/2001/04/xmlenc#aes128-cbc"},
+			encryptedResource{"OEBPS/text/ch02.xhtml", "http://www.w3.org/2001/04/xmlenc#aes128-cbc"},
+		),
+		"META-INF/rights.xml": `<adept:rights xmlns:adept="http://ns.adobe.com/adept"/>`,
+	}
+	for name, content := range epubtest.Files {
+		files[name] = content
+	}
+	dir := t.TempDir()
+	Extract(epubtest.Write(t, files), dir)
+	_, err := Open(dir)
+	if !errors.Is(err, ErrDRMProtected) {
+		t.Fatalf("expected ErrDRMProtected, got %v", err)
+	}
+	for _, want := range []string{"Adobe ADEPT", "2 encrypted resources", "OEBPS/text/ch01.xhtml, OEBPS/text/ch02.xhtml"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in %q", want, err)
+		}
+	}
+}
+
+func TestOpenRightsOnly(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{"META-INF/license.lcpl": "{}"}
+	for name, content := range epubtest.Files {
+		files[name] = content
+	}
+	Extract(epubtest.Write(t, files), dir)
+	if _, err := Open(dir); !errors.Is(err, ErrDRMProtected) || !strings.Contains(err.Error(), "Readium LCP") {
+		t.Errorf("expected ErrDRMProtected, got %v", err)
+	}
+}
+
+func TestOpenObfuscatedFonts(t *testing.T) {
+	font := bytes.Repeat([]byte("OTTO font data "), 100)
+	identifiers := []string{"urn:isbn:123", "urn:uuid:0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0"}
+	obfuscate := func(algorithm string) string {
+		key, length := obfuscationKey(algorithm, identifiers)
+		data := bytes.Clone(font)
+		for i := range min(length, len(data)) {
+			data[i] ^= key[i%len(key)]
+		}
+		return string(data)
+	}
+	files := map[string]string{
+		"META-INF/encryption.xml": encryptionFile(
+			encryptedResource{"OEBPS/fonts/idpf.otf", idpfObfuscation},
+			encryptedResource{"OEBPS/fonts/adobe.otf", adobeObfuscation},
+		),
+		"OEBPS/fonts/idpf.otf":  obfuscate(idpfObfuscation),
+		"OEBPS/fonts/adobe.otf": obfuscate(adobeObfuscation),
+	}
+	for name, content := range epubtest.Files {
+		files[name] = content
+	}
+	files["OEBPS/content.opf"] = strings.Replace(files["OEBPS/content.opf"], "<dc:title>", "<dc:identifier>"+identifiers[1]+"</dc:identifier><dc:title>", 1)
+	dir := t.TempDir()
+	Extract(epubtest.Write(t, files), dir)
+	for range 2 {
+		if _, err := Open(dir); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"idpf.otf", "adobe.otf"} {
+			data, err := os.ReadFile(filepath.Join(dir, "OEBPS", "fonts", name))
+			if err != nil || !bytes.Equal(data, font) {
+				t.Errorf("expected %s to be restored, got %q %v", name, data[:20], err)
+			}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "META-INF", "encryption.xml")); err == nil {
+		t.Error("expected encryption.xml to be removed")
+	}
+}
+
+func TestOpenObfuscatedOutsideBook(t *testing.T) {
+	files := map[string]string{
+		"META-INF/encryption.xml": encryptionFile(encryptedResource{"../victim.txt", idpfObfuscation}),
+	}
+	for name, content := range epubtest.Files {
+		files[name] = content
+	}
+	base := t.TempDir()
+	victim := filepath.Join(base, "victim.txt")
+	if err := os.WriteFile(victim, []byte("not a font"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(base, "book")
+	Extract(epubtest.Write(t, files), dir)
+	if _, err := Open(dir); err == nil || !strings.Contains(err.Error(), "unsafe path") {
+		t.Errorf("expected an unsafe path error, got %v", err)
+	}
+	if data, _ := os.ReadFile(victim); string(data) != "not a font" {
+		t.Errorf("expected the file outside the book to be left alone, got %q", data)
+	}
+}
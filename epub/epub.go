@@ -0,0 +1,484 @@
+// Package epub reads EPUB 2 and EPUB 3 books: the package document with its metadata,
+// manifest and spine, the table of contents from the EPUB 3 nav or the EPUB 2 NCX, and the
+// landmarks or guide naming the cover, the start of the body matter and the back matter.
+package epub
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"cli-epub-parser-md-generator/internal/htmlutil"
+)
+
+// Metadata is the Dublin Core metadata of a book.
+type Metadata struct {
+	Title       string   `json:"title"`
+	Creators    []string `json:"creators"`
+	Language    string   `json:"language"`
+	Identifier  string   `json:"identifier"`
+	Publisher   string   `json:"publisher"`
+	Date        string   `json:"date"`
+	Description string   `json:"description"`
+}
+
+// ManifestItem is a resource listed in the package manifest.
+type ManifestItem struct {
+	ID         string
+	Href       string // relative to the root of the extracted book, with forward slashes
+	MediaType  string
+	Properties string
+	Fallback   string // id of the item to read instead when the media type is not supported
+}
+
+// SpineItem is a document of the reading order, with its title from the table of contents.
+type SpineItem struct {
+	ManifestItem
+	Linear   bool
+	Title    string
+	Landmark string // type of the first landmark pointing into the document, ex: cover or bodymatter
+}
+
+// TOCItem is an entry of the table of contents.
+type TOCItem struct {
+	Title string `json:"title"`
+	Href  string `json:"href"` // relative to the root of the extracted book, may have a #fragment
+	Level int    `json:"level"`
+}
+
+// Rendition is a package document listed in container.xml. Books with multiple renditions have
+// one per layout, language or access mode, ex: a reflowable and a fixed layout version.
+type Rendition struct {
+	Path       string `json:"path"`
+	MediaType  string `json:"media_type,omitempty"`
+	Layout     string `json:"layout,omitempty"` // reflowable or pre-paginated
+	Language   string `json:"language,omitempty"`
+	AccessMode string `json:"access_mode,omitempty"` // textual, visual, auditory or tactile
+	Label      string `json:"label,omitempty"`
+}
+
+// Book is an extracted EPUB, described by its package document.
+type Book struct {
+	Dir        string
+	OPFPath    string
+	Renditions []Rendition
+	Rendition  int // index in Renditions of the package document read
+	Metadata   Metadata
+	Manifest   map[string]ManifestItem
+	Spine      []SpineItem
+	TOC        []TOCItem
+	Landmarks  []Landmark
+}
+
+// CurrentRendition returns the rendition read, or nil for a book built from another format.
+func (b *Book) CurrentRendition() *Rendition {
+	if b.Rendition < 0 || b.Rendition >= len(b.Renditions) {
+		return nil
+	}
+	return &b.Renditions[b.Rendition]
+}
+
+// Path is where a manifest href lives on disk.
+func (b *Book) Path(href string) string {
+	href, _, _ = strings.Cut(href, "#")
+	return filepath.Join(b.Dir, filepath.FromSlash(href))
+}
+
+var spaceRe = regexp.MustCompile(`\s+`)
+
+type containerXML struct {
+	Rootfiles []struct {
+		FullPath   string `xml:"full-path,attr"`
+		MediaType  string `xml:"media-type,attr"`
+		Layout     string `xml:"layout,attr"`
+		Language   string `xml:"language,attr"`
+		AccessMode string `xml:"accessMode,attr"`
+		Label      string `xml:"label,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+type opfXML struct {
+	UniqueIdentifier string `xml:"unique-identifier,attr"`
+	Metadata         struct {
+		Titles      []string `xml:"title"`
+		Creators    []string `xml:"creator"`
+		Languages   []string `xml:"language"`
+		Identifiers []struct {
+			ID    string `xml:"id,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"identifier"`
+		Publishers  []string `xml:"publisher"`
+		Dates       []string `xml:"date"`
+		Description string   `xml:"description"`
+		Metas       []struct {
+			Property string `xml:"property,attr"`
+			Value    string `xml:",chardata"`
+		} `xml:"meta"`
+	} `xml:"metadata"`
+	Manifest []struct {
+		ID         string `xml:"id,attr"`
+		Href       string `xml:"href,attr"`
+		MediaType  string `xml:"media-type,attr"`
+		Properties string `xml:"properties,attr"`
+		Fallback   string `xml:"fallback,attr"`
+	} `xml:"manifest>item"`
+	Spine struct {
+		TOC      string `xml:"toc,attr"`
+		Itemrefs []struct {
+			IDRef  string `xml:"idref,attr"`
+			Linear string `xml:"linear,attr"`
+		} `xml:"itemref"`
+	} `xml:"spine"`
+	Guide []struct {
+		Type  string `xml:"type,attr"`
+		Title string `xml:"title,attr"`
+		Href  string `xml:"href,attr"`
+	} `xml:"guide>reference"`
+}
+
+// identifier is the unique identifier of the package, or its first identifier.
+func (opf *opfXML) identifier() string {
+	for _, identifier := range opf.Metadata.Identifiers {
+		if identifier.ID == opf.UniqueIdentifier {
+			return strings.TrimSpace(identifier.Value)
+		}
+	}
+	if len(opf.Metadata.Identifiers) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(opf.Metadata.Identifiers[0].Value)
+}
+
+// layout is the rendition:layout of the package, reflowable unless set.
+func (opf *opfXML) layout() string {
+	for _, meta := range opf.Metadata.Metas {
+		if meta.Property == "rendition:layout" {
+			return strings.TrimSpace(meta.Value)
+		}
+	}
+	return "reflowable"
+}
+
+type ncxNavPoint struct {
+	Label   string `xml:"navLabel>text"`
+	Content struct {
+		Src string `xml:"src,attr"`
+	} `xml:"content"`
+	NavPoints []ncxNavPoint `xml:"navPoint"`
+}
+
+type ncxXML struct {
+	NavPoints []ncxNavPoint `xml:"navMap>navPoint"`
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(values[0])
+}
+
+// resolveHref resolves href, relative to the document at base, to a path from the book root.
+func resolveHref(base, href string) string {
+	if unescaped, err := url.PathUnescape(href); err == nil {
+		href = unescaped
+	}
+	file, fragment, hasFragment := strings.Cut(href, "#")
+	resolved := path.Join(path.Dir(base), file)
+	if len(file) == 0 {
+		resolved = base
+	}
+	if hasFragment {
+		resolved += "#" + fragment
+	}
+	return resolved
+}
+
+// readXML decodes an XML file in its encoding, see htmlutil.Decode, with its text normalized.
+func readXML(filename string, v any) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	data, _, err = htmlutil.Decode(data)
+	if err != nil {
+		return err
+	}
+	decoder := xml.NewDecoder(strings.NewReader(htmlutil.Normalize(string(data))))
+	decoder.Strict = false
+	// the data is UTF-8 now, whatever the XML declaration says
+	decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	return decoder.Decode(v)
+}
+
+// Open reads the package document of a book extracted to dir: metadata, manifest,
+// spine and table of contents, from the EPUB 3 nav document or the EPUB 2 NCX, and
+// landmarks, from the nav document or the EPUB 2 guide. It returns ErrDRMProtected for
+// books encrypted with DRM and restores the fonts obfuscated by the IDPF or Adobe schemes.
+func Open(dir string) (*Book, error) {
+	var container containerXML
+	if err := readXML(filepath.Join(dir, "META-INF", "container.xml"), &container); err != nil {
+		return nil, fmt.Errorf("error reading container.xml: %w", err)
+	}
+	obfuscated, err := readEncryption(dir)
+	if err != nil {
+		return nil, err
+	}
+	book := &Book{Dir: dir, Manifest: map[string]ManifestItem{}}
+	for _, rootfile := range container.Rootfiles {
+		book.Renditions = append(book.Renditions, Rendition{
+			Path:       rootfile.FullPath,
+			MediaType:  rootfile.MediaType,
+			Layout:     rootfile.Layout,
+			Language:   rootfile.Language,
+			AccessMode: rootfile.AccessMode,
+			Label:      rootfile.Label,
+		})
+	}
+	opf, err := book.readRendition()
+	if err != nil {
+		return nil, err
+	}
+	book.Metadata = Metadata{
+		Title:       first(opf.Metadata.Titles),
+		Creators:    opf.Metadata.Creators,
+		Language:    first(opf.Metadata.Languages),
+		Identifier:  opf.identifier(),
+		Publisher:   first(opf.Metadata.Publishers),
+		Date:        first(opf.Metadata.Dates),
+		Description: strings.TrimSpace(opf.Metadata.Description),
+	}
+	identifiers := []string{book.Metadata.Identifier}
+	for _, identifier := range opf.Metadata.Identifiers {
+		identifiers = append(identifiers, identifier.Value)
+	}
+	if err := deobfuscateFonts(dir, identifiers, obfuscated); err != nil {
+		return nil, fmt.Errorf("error restoring obfuscated fonts: %w", err)
+	}
+	var navHref string
+	for _, item := range opf.Manifest {
+		m := ManifestItem{ID: item.ID, Href: resolveHref(book.OPFPath, item.Href), MediaType: item.MediaType, Properties: item.Properties, Fallback: item.Fallback}
+		book.Manifest[item.ID] = m
+		if strings.Contains(" "+item.Properties+" ", " nav ") {
+			navHref = m.Href
+		}
+	}
+	for _, ref := range opf.Spine.Itemrefs {
+		item, ok := book.Manifest[ref.IDRef]
+		if !ok {
+			continue
+		}
+		book.Spine = append(book.Spine, SpineItem{ManifestItem: book.readable(item), Linear: ref.Linear != "no"})
+	}
+	if len(navHref) > 0 {
+		if doc := readNav(book, navHref); doc != nil {
+			book.TOC = readNavTOC(doc, navHref)
+			book.Landmarks = readNavLandmarks(doc, navHref)
+		}
+	}
+	if ncx, ok := book.Manifest[opf.Spine.TOC]; len(book.TOC) == 0 && ok {
+		book.TOC = readNCXTOC(book, ncx.Href)
+	}
+	if len(book.Landmarks) == 0 {
+		book.Landmarks = guideLandmarks(book.OPFPath, opf)
+	}
+	book.AssignTitles()
+	book.assignLandmarks()
+	return book, nil
+}
+
+// readRendition picks the rendition to read, the first reflowable and textual package document
+// or else the first one that can be read, and reads its package document.
+func (b *Book) readRendition() (*opfXML, error) {
+	var chosen *opfXML
+	var firstErr error
+	for i, rendition := range b.Renditions {
+		if len(rendition.MediaType) > 0 && rendition.MediaType != "application/oebps-package+xml" {
+			continue
+		}
+		var opf opfXML
+		if err := readXML(b.Path(rendition.Path), &opf); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("error reading %s: %w", rendition.Path, err)
+			}
+			continue
+		}
+		if len(rendition.Layout) == 0 {
+			b.Renditions[i].Layout = opf.layout()
+		}
+		preferred := b.Renditions[i].Layout != "pre-paginated" && (len(rendition.AccessMode) == 0 || rendition.AccessMode == "textual")
+		if chosen == nil || preferred {
+			chosen, b.Rendition, b.OPFPath = &opf, i, rendition.Path
+		}
+		if preferred {
+			break
+		}
+	}
+	if chosen == nil && firstErr != nil {
+		return nil, firstErr
+	}
+	if chosen == nil {
+		return nil, fmt.Errorf("no package document in container.xml")
+	}
+	return chosen, nil
+}
+
+// readable follows the fallback chain of a spine item whose media type can't be converted,
+// such as an SVG page or a foreign resource, to a document that can.
+func (b *Book) readable(item ManifestItem) ManifestItem {
+	seen := map[string]bool{}
+	for current := item; !seen[current.ID]; {
+		if isDocument(current.MediaType) {
+			return current
+		}
+		seen[current.ID] = true
+		next, ok := b.Manifest[current.Fallback]
+		if !ok {
+			break
+		}
+		current = next
+	}
+	return item
+}
+
+func readNav(book *Book, navHref string) *html.Node {
+	doc, err := htmlutil.ParseFile(book.Path(navHref))
+	if err != nil {
+		return nil
+	}
+	return doc
+}
+
+// findNav returns the first <nav> of the navigation document with the given epub:type.
+func findNav(n *html.Node, navType string) *html.Node {
+	if n.Type == html.ElementNode && n.Data == "nav" && htmlutil.HasSemantic(n, navType) {
+		return n
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if nav := findNav(child, navType); nav != nil {
+			return nav
+		}
+	}
+	return nil
+}
+
+func readNavTOC(doc *html.Node, navHref string) []TOCItem {
+	nav := findNav(doc, "toc")
+	if nav == nil {
+		return nil
+	}
+	var items []TOCItem
+	var walk func(n *html.Node, level int)
+	walk = func(n *html.Node, level int) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			switch child.Data {
+			case "ol", "ul":
+				walk(child, level+1)
+			case "a":
+				title := strings.TrimSpace(spaceRe.ReplaceAllString(htmlutil.TextContent(child), " "))
+				if href := htmlutil.Attr(child, "href"); len(href) > 0 && len(title) > 0 {
+					items = append(items, TOCItem{Title: title, Href: resolveHref(navHref, href), Level: level})
+				}
+			default:
+				walk(child, level)
+			}
+		}
+	}
+	walk(nav, 0)
+	return items
+}
+
+func readNCXTOC(book *Book, href string) []TOCItem {
+	var ncx ncxXML
+	if err := readXML(book.Path(href), &ncx); err != nil {
+		return nil
+	}
+	var items []TOCItem
+	var walk func(points []ncxNavPoint, level int)
+	walk = func(points []ncxNavPoint, level int) {
+		for _, p := range points {
+			if title := strings.TrimSpace(p.Label); len(title) > 0 {
+				items = append(items, TOCItem{Title: title, Href: resolveHref(href, p.Content.Src), Level: level})
+			}
+			walk(p.NavPoints, level+1)
+		}
+	}
+	walk(ncx.NavPoints, 1)
+	return items
+}
+
+// AssignTitles names every spine item after the first table of contents entry pointing into it,
+// falling back to the first heading or <title> of the document or its file name. Open calls it,
+// readers building a Book from other formats call it once the spine and TOC are set.
+func (b *Book) AssignTitles() {
+	titles := map[string]string{}
+	for _, item := range b.TOC {
+		file, _, _ := strings.Cut(item.Href, "#")
+		if _, ok := titles[file]; !ok {
+			titles[file] = item.Title
+		}
+	}
+	for i := range b.Spine {
+		if title, ok := titles[b.Spine[i].Href]; ok {
+			b.Spine[i].Title = title
+			continue
+		}
+		b.Spine[i].Title = documentTitle(b.Path(b.Spine[i].Href))
+		if len(b.Spine[i].Title) == 0 {
+			b.Spine[i].Title = strings.TrimSuffix(path.Base(b.Spine[i].Href), path.Ext(b.Spine[i].Href))
+		}
+	}
+}
+
+func documentTitle(filename string) string {
+	doc, err := htmlutil.ParseFile(filename)
+	if err != nil {
+		return ""
+	}
+	for _, tag := range []string{"h1", "h2", "title"} {
+		if n := findElement(doc, tag); n != nil {
+			if title := strings.TrimSpace(spaceRe.ReplaceAllString(htmlutil.TextContent(n), " ")); len(title) > 0 {
+				return title
+			}
+		}
+	}
+	return ""
+}
+
+func findElement(n *html.Node, tag string) *html.Node {
+	if n.Type == html.ElementNode && n.Data == tag {
+		return n
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if found := findElement(child, tag); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// IsDocument reports whether the spine item is an XHTML or HTML document that can be converted.
+func (item SpineItem) IsDocument() bool {
+	return isDocument(item.MediaType)
+}
+
+func isDocument(mediaType string) bool {
+	switch mediaType {
+	case "application/xhtml+xml", "text/html":
+		return true
+	}
+	return false
+}
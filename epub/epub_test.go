@@ -0,0 +1,140 @@
+package epub
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cli-epub-parser-md-generator/internal/epubtest"
+)
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	if err := Extract(epubtest.Write(t, epubtest.Files), dir); err != nil {
+		t.Fatal(err)
+	}
+	book, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Metadata.Title != "Test Book" || book.Metadata.Creators[0] != "Jane Doe" || book.Metadata.Identifier != "urn:isbn:123" {
+		t.Errorf("unexpected metadata %+v", book.Metadata)
+	}
+	if len(book.Spine) != 4 || book.Spine[3].Linear {
+		t.Fatalf("unexpected spine %+v", book.Spine)
+	}
+	titles := []string{"Cover", "One: The Start", "Two", "notes"}
+	for i, title := range titles {
+		if book.Spine[i].Title != title {
+			t.Errorf("spine %d: expected title %q, got %q", i, title, book.Spine[i].Title)
+		}
+	}
+	if len(book.TOC) != 3 || book.TOC[1].Href != "OEBPS/text/ch01.xhtml#s1" || book.TOC[1].Level != 2 {
+		t.Errorf("unexpected toc %+v", book.TOC)
+	}
+}
+
+func TestOpenNCXFallback(t *testing.T) {
+	files := map[string]string{}
+	for name, content := range epubtest.Files {
+		files[name] = content
+	}
+	files["OEBPS/content.opf"] = strings.Replace(files["OEBPS/content.opf"], ` properties="nav"`, "", 1)
+	dir := t.TempDir()
+	Extract(epubtest.Write(t, files), dir)
+	book, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(book.TOC) != 1 || book.Spine[1].Title != "NCX One" {
+		t.Errorf("expected the NCX table of contents, got %+v", book.TOC)
+	}
+}
+
+func TestExtractUnsafePath(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "book")
+	err := Extract(epubtest.Write(t, map[string]string{"../evil.txt": "x"}), dir)
+	if err == nil || !strings.Contains(err.Error(), "unsafe path") {
+		t.Errorf("expected an unsafe path error, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "evil.txt")); err == nil {
+		t.Error("expected nothing to be written outside of the target folder")
+	}
+}
+
+// openFiles writes files over the test book, removing the ones set to "", and opens it.
+func openFiles(t *testing.T, changes map[string]string) *Book {
+	t.Helper()
+	files := map[string]string{}
+	for name, content := range epubtest.Files {
+		files[name] = content
+	}
+	for name, content := range changes {
+		if len(content) == 0 {
+			delete(files, name)
+			continue
+		}
+		files[name] = content
+	}
+	dir := t.TempDir()
+	if err := Extract(epubtest.Write(t, files), dir); err != nil {
+		t.Fatal(err)
+	}
+	book, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return book
+}
+
+func TestOpenRenditions(t *testing.T) {
+	fixed := strings.Replace(epubtest.Files["OEBPS/content.opf"], "<dc:language>en</dc:language>",
+		`<dc:language>en</dc:language><meta property="rendition:layout">pre-paginated</meta>`, 1)
+	fixed = strings.Replace(fixed, "Test Book", "Fixed Book", 1)
+	book := openFiles(t, map[string]string{
+		"META-INF/container.xml": `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container" xmlns:rendition="http://www.idpf.org/2013/rendition">
+<rootfiles>
+<rootfile full-path="book.pdf" media-type="application/pdf"/>
+<rootfile full-path="OEBPS/fixed.opf" media-type="application/oebps-package+xml" rendition:label="Print replica"/>
+<rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml" rendition:layout="reflowable" rendition:label="Text"/>
+</rootfiles></container>`,
+		"OEBPS/fixed.opf": fixed,
+	})
+	if len(book.Renditions) != 3 || book.Rendition != 2 || book.OPFPath != "OEBPS/content.opf" || book.Metadata.Title != "Test Book" {
+		t.Errorf("expected the reflowable rendition, got %d of %+v", book.Rendition, book.Renditions)
+	}
+	if book.Renditions[1].Layout != "pre-paginated" || book.Renditions[2].Label != "Text" {
+		t.Errorf("unexpected renditions %+v", book.Renditions)
+	}
+}
+
+func TestOpenNoPackage(t *testing.T) {
+	dir := t.TempDir()
+	Extract(epubtest.Write(t, map[string]string{"META-INF/container.xml": `<container><rootfiles>
+<rootfile full-path="book.pdf" media-type="application/pdf"/></rootfiles></container>`}), dir)
+	if _, err := Open(dir); err == nil || !strings.Contains(err.Error(), "no package document") {
+		t.Errorf("expected a missing package error, got %v", err)
+	}
+}
+
+func TestOpenSpineFallback(t *testing.T) {
+	opf := strings.Replace(epubtest.Files["OEBPS/content.opf"],
+		`<item id="ch2" href="text/ch02.xhtml" media-type="application/xhtml+xml"/>`,
+		`<item id="ch2-svg" href="text/ch02.svg" media-type="image/svg+xml" fallback="ch2"/>
+<item id="ch2" href="text/ch02.xhtml" media-type="application/xhtml+xml"/>
+<item id="map" href="images/map.svg" media-type="image/svg+xml"/>`, 1)
+	opf = strings.Replace(opf, `<itemref idref="ch2"/>`, `<itemref idref="ch2-svg"/><itemref idref="map"/>`, 1)
+	book := openFiles(t, map[string]string{
+		"OEBPS/content.opf":    opf,
+		"OEBPS/text/ch02.svg":  `<svg xmlns="http://www.w3.org/2000/svg"><text>Two</text></svg>`,
+		"OEBPS/images/map.svg": `<svg xmlns="http://www.w3.org/2000/svg"/>`,
+	})
+	if len(book.Spine) != 5 || book.Spine[2].Href != "OEBPS/text/ch02.xhtml" || book.Spine[2].Title != "Two" {
+		t.Errorf("expected the XHTML fallback of the SVG page, got %+v", book.Spine)
+	}
+	if chapters := book.Chapters(false); len(chapters) != 3 {
+		t.Errorf("expected the SVG page without fallback to be skipped, got %+v", chapters)
+	}
+}
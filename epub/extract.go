@@ -0,0 +1,61 @@
+package epub
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Extract extracts the contents of an EPUB file (which is a ZIP archive)
+// to the specified target directory
+func Extract(epubPath string, targetDir string) error {
+	// Create extraction directory if it doesn't exist
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		return fmt.Errorf("failed to create extraction directory: %v", err)
+	}
+	// Open the EPUB file
+	reader, err := zip.OpenReader(epubPath)
+	if err != nil {
+		return fmt.Errorf("error opening EPUB file: %v", err)
+	}
+	defer reader.Close()
+	// Extract each file
+	for _, file := range reader.File {
+		// entries such as ../../.env or /etc/passwd would be written outside of targetDir
+		if !filepath.IsLocal(file.Name) {
+			return fmt.Errorf("unsafe path %q in EPUB file", file.Name)
+		}
+		extractPath := filepath.Join(targetDir, file.Name)
+		// Create directories if needed
+		if file.FileInfo().IsDir() {
+			os.MkdirAll(extractPath, 0755)
+			continue
+		}
+		// Make sure the parent directory exists
+		parentDir := filepath.Dir(extractPath)
+		if err := os.MkdirAll(parentDir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %v", parentDir, err)
+		}
+		// Create the file
+		outFile, err := os.Create(extractPath)
+		if err != nil {
+			return fmt.Errorf("failed to create file %s: %v", extractPath, err)
+		}
+		// Open the zipped file
+		zipFile, err := file.Open()
+		if err != nil {
+			outFile.Close()
+			return fmt.Errorf("failed to open zipped file %s: %v", file.Name, err)
+		}
+		// Copy the contents
+		_, err = io.Copy(outFile, zipFile)
+		outFile.Close()
+		zipFile.Close()
+		if err != nil {
+			return fmt.Errorf("failed to extract file %s: %v", file.Name, err)
+		}
+	}
+	return nil
+}
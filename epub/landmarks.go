@@ -0,0 +1,126 @@
+package epub
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"cli-epub-parser-md-generator/internal/htmlutil"
+)
+
+// Landmark is a structural part of the book, from the EPUB 3 landmarks nav or the EPUB 2 guide.
+type Landmark struct {
+	Type  string `json:"type"` // EPUB 3 structural semantics, ex: cover, toc, bodymatter, copyright-page or index
+	Title string `json:"title"`
+	Href  string `json:"href"` // relative to the root of the extracted book, may have a #fragment
+}
+
+// guideTypes maps the EPUB 2 guide reference types that differ from the EPUB 3 structural semantics.
+var guideTypes = map[string]string{
+	"text":       "bodymatter",
+	"title-page": "titlepage",
+	"other.loi":  "loi",
+	"other.lot":  "lot",
+}
+
+// skippedLandmarks are the documents left out of the chapters: they have nothing to rewrite.
+var skippedLandmarks = map[string]bool{
+	"cover":          true,
+	"titlepage":      true,
+	"toc":            true,
+	"copyright-page": true,
+	"index":          true,
+}
+
+func readNavLandmarks(doc *html.Node, navHref string) []Landmark {
+	nav := findNav(doc, "landmarks")
+	if nav == nil {
+		return nil
+	}
+	var landmarks []Landmark
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			if child.Data != "a" {
+				walk(child)
+				continue
+			}
+			types := strings.Fields(htmlutil.Attr(child, "epub:type"))
+			href := htmlutil.Attr(child, "href")
+			if len(types) == 0 || len(href) == 0 {
+				continue
+			}
+			title := strings.TrimSpace(spaceRe.ReplaceAllString(htmlutil.TextContent(child), " "))
+			landmarks = append(landmarks, Landmark{Type: types[0], Title: title, Href: resolveHref(navHref, href)})
+		}
+	}
+	walk(nav)
+	return landmarks
+}
+
+func guideLandmarks(opfPath string, opf *opfXML) []Landmark {
+	var landmarks []Landmark
+	for _, ref := range opf.Guide {
+		if len(ref.Type) == 0 || len(ref.Href) == 0 {
+			continue
+		}
+		landmarkType := strings.ToLower(ref.Type)
+		if mapped, ok := guideTypes[landmarkType]; ok {
+			landmarkType = mapped
+		}
+		landmarks = append(landmarks, Landmark{Type: landmarkType, Title: strings.TrimSpace(ref.Title), Href: resolveHref(opfPath, ref.Href)})
+	}
+	return landmarks
+}
+
+// assignLandmarks tags every spine item with the first landmark pointing into it.
+func (b *Book) assignLandmarks() {
+	types := map[string]string{}
+	for _, landmark := range b.Landmarks {
+		file, _, _ := strings.Cut(landmark.Href, "#")
+		if _, ok := types[file]; !ok {
+			types[file] = landmark.Type
+		}
+	}
+	for i := range b.Spine {
+		b.Spine[i].Landmark = types[b.Spine[i].Href]
+	}
+}
+
+// BodyStart returns the index in the spine of the document where the body matter starts, as
+// named by the bodymatter landmark, or 0 when the book has none.
+func (b *Book) BodyStart() int {
+	for _, landmark := range b.Landmarks {
+		if landmark.Type != "bodymatter" {
+			continue
+		}
+		file, _, _ := strings.Cut(landmark.Href, "#")
+		for i, item := range b.Spine {
+			if item.Href == file {
+				return i
+			}
+		}
+	}
+	return 0
+}
+
+// Chapters returns the documents of the spine numbered as chapters: the linear documents in
+// reading order from the start of the body matter, without the cover, title page, table of
+// contents, copyright page and index named by the landmarks. With all set it returns every
+// document of the spine.
+func (b *Book) Chapters(all bool) []SpineItem {
+	start := 0
+	if !all {
+		start = b.BodyStart()
+	}
+	var chapters []SpineItem
+	for _, item := range b.Spine[start:] {
+		if item.IsDocument() && (all || item.Linear && !skippedLandmarks[item.Landmark]) {
+			chapters = append(chapters, item)
+		}
+	}
+	return chapters
+}
@@ -0,0 +1,59 @@
+package epub
+
+import (
+	"strings"
+	"testing"
+
+	"cli-epub-parser-md-generator/internal/epubtest"
+)
+
+func TestNavLandmarks(t *testing.T) {
+	book := openFiles(t, map[string]string{
+		"OEBPS/nav.xhtml": strings.Replace(epubtest.Files["OEBPS/nav.xhtml"], "</body>", `<nav epub:type="landmarks"><ol>
+<li><a epub:type="cover" href="text/cover.xhtml">Cover</a></li>
+<li><a epub:type="bodymatter" href="text/ch01.xhtml#start">Start of content</a></li>
+</ol></nav></body>`, 1),
+	})
+	if len(book.Landmarks) != 2 || book.Landmarks[1].Href != "OEBPS/text/ch01.xhtml#start" || book.Landmarks[1].Title != "Start of content" {
+		t.Fatalf("unexpected landmarks %+v", book.Landmarks)
+	}
+	if book.Spine[0].Landmark != "cover" || book.Spine[1].Landmark != "bodymatter" || book.BodyStart() != 1 {
+		t.Errorf("unexpected spine landmarks %+v", book.Spine)
+	}
+	if chapters := book.Chapters(false); len(chapters) != 2 || chapters[0].Title != "One: The Start" {
+		t.Errorf("expected the chapters to start at the body matter, got %+v", chapters)
+	}
+	if chapters := book.Chapters(true); len(chapters) != 4 {
+		t.Errorf("expected every document, got %+v", chapters)
+	}
+}
+
+func TestGuideLandmarks(t *testing.T) {
+	opf := strings.Replace(epubtest.Files["OEBPS/content.opf"], `<item id="ch2" href="text/ch02.xhtml" media-type="application/xhtml+xml"/>`,
+		`<item id="ch2" href="text/ch02.xhtml" media-type="application/xhtml+xml"/>
+<item id="copyright" href="text/copyright.xhtml" media-type="application/xhtml+xml"/>
+<item id="index" href="text/index.xhtml" media-type="application/xhtml+xml"/>`, 1)
+	opf = strings.Replace(opf, `<itemref idref="ch1"/>`, `<itemref idref="copyright"/><itemref idref="ch1"/>`, 1)
+	opf = strings.Replace(opf, `<itemref idref="notes" linear="no"/>`, `<itemref idref="notes" linear="no"/><itemref idref="index"/>`, 1)
+	opf = strings.Replace(opf, "</package>", `<guide>
+<reference type="cover" title="Cover" href="text/cover.xhtml"/>
+<reference type="copyright-page" title="Copyright" href="text/copyright.xhtml"/>
+<reference type="text" title="Begin Reading" href="text/ch01.xhtml"/>
+<reference type="index" title="Index" href="text/index.xhtml"/>
+</guide></package>`, 1)
+	book := openFiles(t, map[string]string{
+		"OEBPS/content.opf":          opf,
+		"OEBPS/text/copyright.xhtml": `<html><body><p>All rights reserved.</p></body></html>`,
+		"OEBPS/text/index.xhtml":     `<html><body><h1>Index</h1></body></html>`,
+	})
+	if len(book.Landmarks) != 4 || book.Landmarks[2].Type != "bodymatter" {
+		t.Fatalf("unexpected landmarks %+v", book.Landmarks)
+	}
+	if book.BodyStart() != 2 {
+		t.Errorf("expected the body matter to start at the first chapter, got %d", book.BodyStart())
+	}
+	chapters := book.Chapters(false)
+	if len(chapters) != 2 || chapters[0].Title != "One: The Start" || chapters[1].Title != "Two" {
+		t.Errorf("expected the front matter and index to be skipped, got %+v", chapters)
+	}
+}
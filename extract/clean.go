@@ -0,0 +1,222 @@
+package extract
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+
+	"cli-epub-parser-md-generator/internal/htmlutil"
+)
+
+// RegexRule replaces every match of Pattern in the extracted text with Replace.
+type RegexRule struct {
+	Pattern string `json:"pattern"`
+	Replace string `json:"replace"`
+}
+
+// CleanRules are user defined cleaning rules, loaded from the -rules JSON file, ex:
+//
+//	{"selectors": ["div.running-head", "p.isbn"], "regex": [{"pattern": "(?m)^Page \\d+$", "replace": ""}]}
+type CleanRules struct {
+	Selectors []string    `json:"selectors"`
+	Regex     []RegexRule `json:"regex"`
+}
+
+func LoadCleanRules(filename string) (CleanRules, error) {
+	var rules CleanRules
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return rules, fmt.Errorf("error reading cleaning rules: %w", err)
+	}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return rules, fmt.Errorf("error parsing cleaning rules %s: %w", filename, err)
+	}
+	return rules, nil
+}
+
+type compiledRegexRule struct {
+	re      *regexp.Regexp
+	replace string
+}
+
+// Cleaner strips boilerplate and noise from chapters before they are sent to the model.
+// The built-in rules always run, the user rules run after them.
+type Cleaner struct {
+	selectors []cascadia.SelectorGroup
+	regex     []compiledRegexRule
+}
+
+// NewCleaner compiles the user selectors and patterns.
+func NewCleaner(rules CleanRules) (*Cleaner, error) {
+	cl := &Cleaner{}
+	for _, sel := range rules.Selectors {
+		group, err := cascadia.ParseGroup(sel)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %w", sel, err)
+		}
+		cl.selectors = append(cl.selectors, group)
+	}
+	for _, rule := range rules.Regex {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", rule.Pattern, err)
+		}
+		cl.regex = append(cl.regex, compiledRegexRule{re: re, replace: rule.Replace})
+	}
+	return cl, nil
+}
+
+func removeNode(n *html.Node) {
+	if n.Parent != nil {
+		n.Parent.RemoveChild(n)
+	}
+}
+
+// isNoiseElement reports whether n is a page break marker, an empty anchor such as an
+// index target, or navigation inside a chapter.
+func isNoiseElement(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	if htmlutil.HasSemantic(n, "pagebreak", "index-term", "index-locator") || n.Data == "nav" {
+		return true
+	}
+	if n.Data == "a" && !isNoteref(n) && len(strings.TrimSpace(htmlutil.TextContent(n))) == 0 {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == html.ElementNode {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// CleanDocument removes noise elements and elements matching the user selectors from the document.
+func (cl *Cleaner) CleanDocument(root *html.Node) {
+	var noise []*html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if isNoiseElement(n) {
+			noise = append(noise, n)
+			return
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(root)
+	for _, sel := range cl.selectors {
+		noise = append(noise, cascadia.QueryAll(root, sel)...)
+	}
+	for _, n := range noise {
+		removeNode(n)
+	}
+}
+
+var ligatures = strings.NewReplacer(
+	"\u00ad", "", // soft hyphen
+	"\ufb00", "ff",
+	"\ufb01", "fi",
+	"\ufb02", "fl",
+	"\ufb03", "ffi",
+	"\ufb04", "ffl",
+	"\ufb05", "st",
+	"\ufb06", "st",
+)
+
+var (
+	copyrightRe    = regexp.MustCompile(`(?i)(copyright\s*©|©\s*(19|20)\d\d|all rights reserved)`)
+	inlineSpacesRe = regexp.MustCompile(`[ \t\f\v\x{00a0}]+`)
+	blankLinesRe   = regexp.MustCompile(`\n{3,}`)
+	// thematicBreakRe matches a Markdown horizontal rule
+	thematicBreakRe = regexp.MustCompile(`^\s*(?:(?:-\s*){3,}|(?:\*\s*){3,}|(?:_\s*){3,})$`)
+)
+
+// runningHeaderMax is the longest line considered a running header when repeated.
+const runningHeaderMax int = 80
+
+// cleanLine is a line of text to clean, verbatim lines belong to code blocks or tables and are
+// kept as they are.
+type cleanLine struct {
+	text     string
+	verbatim bool
+}
+
+// CleanText normalizes ligatures, removes soft hyphens, copyright notices and running headers
+// repeated through the chapter, collapses whitespace and applies the user regex rules. The
+// lines of fenced code blocks and tables are left alone.
+func (cl *Cleaner) CleanText(text string) string {
+	var lines []cleanLine
+	fence := ""
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case len(fence) > 0:
+			if strings.HasPrefix(trimmed, fence) && len(strings.Trim(trimmed, fence[:1])) == 0 {
+				fence = ""
+			}
+			lines = append(lines, cleanLine{line, true})
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			fence = trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, trimmed[:1]))]
+			lines = append(lines, cleanLine{line, true})
+		default:
+			lines = append(lines, cleanLine{line, strings.HasPrefix(trimmed, "|")})
+		}
+	}
+	return cl.cleanLines(lines)
+}
+
+// cleanLines cleans the lines of a chapter, see CleanText.
+func (cl *Cleaner) cleanLines(lines []cleanLine) string {
+	counts := map[string]int{}
+	for _, line := range lines {
+		if trimmed := strings.TrimSpace(line.text); !line.verbatim && len(trimmed) > 0 && len(trimmed) <= runningHeaderMax {
+			counts[ligatures.Replace(trimmed)]++
+		}
+	}
+	var kept []string
+	for _, line := range lines {
+		if line.verbatim {
+			kept = append(kept, line.text)
+			continue
+		}
+		text := ligatures.Replace(line.text)
+		// keep the indentation of nested lists, collapse the rest
+		indent := text[:len(text)-len(strings.TrimLeft(text, " \t"))]
+		trimmed := strings.TrimSpace(inlineSpacesRe.ReplaceAllString(text, " "))
+		// a short line repeated three times or more is a running header or footer
+		if counts[strings.TrimSpace(text)] >= 3 && !isStructuralLine(trimmed) {
+			continue
+		}
+		if copyrightRe.MatchString(trimmed) && len(trimmed) < 300 {
+			continue
+		}
+		if len(trimmed) == 0 {
+			if len(kept) > 0 && len(kept[len(kept)-1]) == 0 {
+				continue
+			}
+			indent = ""
+		}
+		kept = append(kept, indent+trimmed)
+	}
+	text := strings.Join(kept, "\n")
+	if len(cl.regex) > 0 {
+		for _, rule := range cl.regex {
+			text = rule.re.ReplaceAllString(text, rule.replace)
+		}
+		// the user rules may leave blank lines where they removed text
+		text = blankLinesRe.ReplaceAllString(text, "\n\n")
+	}
+	return strings.Trim(text, "\n")
+}
+
+// isStructuralLine reports whether a repeated line is Markdown structure rather than a header.
+func isStructuralLine(line string) bool {
+	return thematicBreakRe.MatchString(line) || line == ">"
+}
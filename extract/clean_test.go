@@ -0,0 +1,73 @@
+package extract
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestCleanDocument(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<html><body>
+<nav><a href="toc.xhtml">Contents</a></nav>
+<p>Before<span epub:type="pagebreak" id="p12" title="12">12</span> after<a id="idx-1"></a>.</p>
+<div class="running-head">Deep Work</div>
+<p>Keep <a epub:type="noteref" href="#n1"></a>this.</p>
+</body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleaner, err := NewCleaner(CleanRules{Selectors: []string{"div.running-head"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleaner.CleanDocument(doc)
+	var b strings.Builder
+	html.Render(&b, doc)
+	out := b.String()
+	for _, gone := range []string{"Contents", "pagebreak", "idx-1", "running-head"} {
+		if strings.Contains(out, gone) {
+			t.Errorf("expected %q to be removed:\n%s", gone, out)
+		}
+	}
+	if !strings.Contains(out, "noteref") || !strings.Contains(out, "Before after") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+}
+
+func TestCleanText(t *testing.T) {
+	cleaner, err := NewCleaner(CleanRules{Regex: []RegexRule{{Pattern: `(?m)^Page \d+$`, Replace: ""}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "THE BOOK\n\nThe ﬁrst para­graph   has  spaces.\n\nTHE BOOK\n\nPage 7\n\n\n\nCopyright © 2016 Someone. All rights reserved.\n\nTHE BOOK\n\n  - nested item"
+	got := cleaner.CleanText(text)
+	want := "The first paragraph has spaces.\n\n  - nested item"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCleanTextKeepsCodeAndTables(t *testing.T) {
+	cleaner, err := NewCleaner(CleanRules{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := "```go\nif a {\n}\nif b {\n}\nif c {\n\n\n}\nx  :=  1\n```"
+	table := "| Year | Sales |\n| --- | --- |\n| 2020 | 10 |\n| 2020 | 10 |\n| 2020 | 10 |"
+	text := "Header\n\n" + code + "\n\nHeader\n\n" + table + "\n\nHeader\n\nThe  end."
+	got := cleaner.CleanText(text)
+	want := code + "\n\n" + table + "\n\nThe end."
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestNewCleanerInvalidRules(t *testing.T) {
+	if _, err := NewCleaner(CleanRules{Selectors: []string{"div["}}); err == nil {
+		t.Error("expected an error for an invalid selector")
+	}
+	if _, err := NewCleaner(CleanRules{Regex: []RegexRule{{Pattern: "("}}}); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
@@ -0,0 +1,29 @@
+package extract_test
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"cli-epub-parser-md-generator/extract"
+)
+
+func ExampleConvert() {
+	doc, err := html.Parse(strings.NewReader(`<html><body><h1>Loops</h1><p>A <b>for</b> loop repeats.</p><ul><li>one</li><li>two</li></ul></body></html>`))
+	if err != nil {
+		panic(err)
+	}
+	chapter, err := extract.Convert(doc, extract.Options{})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(chapter.Markdown)
+	// Output:
+	// # Loops
+	//
+	// A **for** loop repeats.
+	//
+	// - one
+	// - two
+}
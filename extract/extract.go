@@ -0,0 +1,674 @@
+// Package extract converts the XHTML documents of a book to Markdown and to the plain text sent
+// to the model: headings, lists, tables, figures and footnotes, with the noise of print layouts
+// cleaned out.
+package extract
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"cli-epub-parser-md-generator/internal/htmlutil"
+)
+
+// Options controls how a chapter document is converted to Markdown.
+type Options struct {
+	// BaseDir is the folder of the chapter file, image sources are resolved against it.
+	BaseDir string
+	// RootDir is the folder of the book, images outside it are not copied. BaseDir when empty.
+	RootDir string
+	// AssetsDir is where images are copied to, nothing is copied when empty.
+	AssetsDir string
+	// AssetsHref is how the Markdown refers to AssetsDir, ex: "assets".
+	AssetsHref string
+	// BookHref, when AssetsDir is empty, links the images where they are in the book instead, by
+	// their path in RootDir under BookHref, ex: "/book" for a preview serving the book there.
+	BookHref string
+	// Chapter numbers the figures, ex: Figure 3.2 is the second figure of chapter 3.
+	Chapter int
+	// FigurePlaceholders lists figures in the plain text as "[Figure 3.2: caption]".
+	FigurePlaceholders bool
+	// Footnotes is how note references are rendered, Markdown footnotes by default.
+	Footnotes FootnoteMode
+	// Cleaner strips noise from the document and from the converted text when set.
+	Cleaner *Cleaner
+}
+
+// Figure is an image of a chapter, copied to the assets folder.
+type Figure struct {
+	Label   string
+	Href    string
+	Alt     string
+	Caption string
+}
+
+// Chapter is a chapter document converted to Markdown, and to the plain text sent to the model.
+type Chapter struct {
+	Markdown string
+	Text     string
+	Figures  []Figure
+	// Warnings are the images that could not be exported and were left out.
+	Warnings []string
+}
+
+type converter struct {
+	opts     Options
+	figures  []Figure
+	warnings []string
+	copied   map[string]string
+	// root is the document being converted, for notes that live in the same file.
+	root        *html.Node
+	notes       []footnote
+	noteNumbers map[string]int
+	noteDocs    map[string]*html.Node
+	// skipNotes leaves out note bodies, which are rendered where they are referenced.
+	skipNotes bool
+}
+
+// mdBlock is one converted block, as Markdown and as plain text.
+type mdBlock struct {
+	md   string
+	text string
+	// verbatim blocks, code and tables, are left alone by the Cleaner.
+	verbatim bool
+}
+
+var spaceRe = regexp.MustCompile(`\s+`)
+
+var skippedElements = map[string]bool{"head": true, "script": true, "style": true, "template": true, "title": true}
+
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "body": true, "dd": true, "details": true,
+	"div": true, "dl": true, "dt": true, "figcaption": true, "figure": true, "footer": true, "h1": true, "h2": true,
+	"h3": true, "h4": true, "h5": true, "h6": true, "header": true, "hr": true, "html": true, "li": true, "main": true,
+	"nav": true, "ol": true, "p": true, "pre": true, "section": true, "svg": true, "table": true, "ul": true,
+}
+
+// Convert converts a chapter document, or its body element, to Markdown.
+func Convert(n *html.Node, opts Options) (Chapter, error) {
+	if len(opts.Footnotes) == 0 {
+		opts.Footnotes = FootnotesMarkdown
+	}
+	if opts.Cleaner != nil {
+		opts.Cleaner.CleanDocument(n)
+	}
+	c := &converter{opts: opts, copied: map[string]string{}, noteNumbers: map[string]int{}, noteDocs: map[string]*html.Node{}}
+	for c.root = n; c.root.Parent != nil; c.root = c.root.Parent {
+	}
+	c.skipNotes = opts.Footnotes == FootnotesDrop || containsNoteref(n)
+	blocks := c.blocks(n)
+	if len(c.notes) > 0 {
+		blocks = append(blocks, c.footnoteDefinitions())
+	}
+	var md, text []string
+	var lines []cleanLine
+	for _, b := range blocks {
+		if len(strings.TrimSpace(b.md)) > 0 {
+			md = append(md, b.md)
+		}
+		if len(strings.TrimSpace(b.text)) > 0 {
+			text = append(text, b.text)
+			// the plain text of code and tables has no fences to tell them apart, so the
+			// cleaner gets them marked line by line
+			if len(lines) > 0 {
+				lines = append(lines, cleanLine{})
+			}
+			for _, line := range strings.Split(b.text, "\n") {
+				lines = append(lines, cleanLine{line, b.verbatim})
+			}
+		}
+	}
+	converted := Chapter{
+		Markdown: strings.Join(md, "\n\n") + "\n",
+		Text:     strings.Join(text, "\n\n"),
+		Figures:  c.figures,
+		Warnings: c.warnings,
+	}
+	if opts.Cleaner != nil {
+		converted.Markdown = opts.Cleaner.CleanText(converted.Markdown) + "\n"
+		converted.Text = opts.Cleaner.cleanLines(lines)
+	}
+	return converted, nil
+}
+
+// ConvertFile parses and converts a chapter file, resolving images against its folder.
+func ConvertFile(filename string, opts Options) (Chapter, error) {
+	doc, err := htmlutil.ParseFile(filename)
+	if err != nil {
+		return Chapter{}, fmt.Errorf("error parsing %s: %w", filename, err)
+	}
+	if opts.BaseDir == "" {
+		opts.BaseDir = filepath.Dir(filename)
+	}
+	return Convert(doc, opts)
+}
+
+// blocks converts the children of n, grouping runs of inline content into paragraphs.
+func (c *converter) blocks(n *html.Node) []mdBlock {
+	var out []mdBlock
+	var md, text strings.Builder
+	flush := func() {
+		m, t := strings.TrimSpace(spaceRe.ReplaceAllString(md.String(), " ")), strings.TrimSpace(spaceRe.ReplaceAllString(text.String(), " "))
+		if len(m) > 0 || len(t) > 0 {
+			out = append(out, mdBlock{md: m, text: t})
+		}
+		md.Reset()
+		text.Reset()
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if c.skipNotes && isNoteBody(child) {
+			continue
+		}
+		if child.Type == html.ElementNode && (blockElements[child.Data] || child.Data == "img" || child.Data == "image") {
+			flush()
+			out = append(out, c.block(child)...)
+			continue
+		}
+		m, t := c.inline(child)
+		md.WriteString(m)
+		text.WriteString(t)
+	}
+	flush()
+	return out
+}
+
+// block converts a block level element.
+func (c *converter) block(n *html.Node) []mdBlock {
+	if skippedElements[n.Data] {
+		return nil
+	}
+	switch n.Data {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		m, t := c.inlineChildren(n)
+		if len(m) == 0 {
+			return nil
+		}
+		return []mdBlock{{md: strings.Repeat("#", int(n.Data[1]-'0')) + " " + m, text: t}}
+	case "hr":
+		return []mdBlock{{md: "---"}}
+	case "pre":
+		code := strings.Trim(htmlutil.TextContent(n), "\n")
+		return []mdBlock{{md: "```\n" + code + "\n```", text: code, verbatim: true}}
+	case "ul", "ol":
+		return []mdBlock{c.list(n, 0)}
+	case "blockquote":
+		var md, text []string
+		for _, b := range c.blocks(n) {
+			md = append(md, "> "+strings.ReplaceAll(b.md, "\n", "\n> "))
+			text = append(text, b.text)
+		}
+		return []mdBlock{{md: strings.Join(md, "\n>\n"), text: strings.Join(text, "\n\n")}}
+	case "table":
+		return []mdBlock{c.table(n)}
+	case "figure":
+		return c.figure(n)
+	case "img", "image":
+		if f, ok := c.image(n, ""); ok {
+			return []mdBlock{c.figureBlock(f)}
+		}
+		return nil
+	case "svg":
+		if f, ok := c.svg(n, ""); ok {
+			return []mdBlock{c.figureBlock(f)}
+		}
+		return nil
+	}
+	return c.blocks(n)
+}
+
+func (c *converter) inlineChildren(n *html.Node) (string, string) {
+	var md, text strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		m, t := c.inline(child)
+		md.WriteString(m)
+		text.WriteString(t)
+	}
+	return strings.TrimSpace(spaceRe.ReplaceAllString(md.String(), " ")), strings.TrimSpace(spaceRe.ReplaceAllString(text.String(), " "))
+}
+
+// inline converts inline content to Markdown and plain text.
+func (c *converter) inline(n *html.Node) (string, string) {
+	switch n.Type {
+	case html.TextNode:
+		return escapeMarkdown(n.Data), n.Data
+	case html.ElementNode:
+	default:
+		return "", ""
+	}
+	if skippedElements[n.Data] || (c.skipNotes && isNoteBody(n)) {
+		return "", ""
+	}
+	if isNoteref(n) {
+		return c.noteref(n)
+	}
+	// wrap keeps the whitespace around the content outside of the markers,
+	// so "a<b> bold </b>word" becomes "a **bold** word"
+	wrap := func(marker string) (string, string) {
+		var md, text strings.Builder
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			m, t := c.inline(child)
+			md.WriteString(m)
+			text.WriteString(t)
+		}
+		m := spaceRe.ReplaceAllString(md.String(), " ")
+		trimmed := strings.TrimSpace(m)
+		if len(trimmed) == 0 {
+			return m, text.String()
+		}
+		lead, trail := "", ""
+		if strings.HasPrefix(m, " ") {
+			lead = " "
+		}
+		if strings.HasSuffix(m, " ") {
+			trail = " "
+		}
+		return lead + marker + trimmed + marker + trail, text.String()
+	}
+	switch n.Data {
+	case "br":
+		return "\n", "\n"
+	case "strong", "b":
+		return wrap("**")
+	case "em", "i", "cite":
+		return wrap("*")
+	case "code", "kbd", "samp":
+		t := htmlutil.TextContent(n)
+		return "`" + t + "`", t
+	case "a":
+		m, t := c.inlineChildren(n)
+		href := htmlutil.Attr(n, "href")
+		if strings.HasPrefix(href, "http://") || strings.HasPrefix(href, "https://") {
+			return "[" + m + "](" + href + ")", t
+		}
+		return m, t
+	case "img", "image":
+		if f, ok := c.image(n, ""); ok {
+			return c.figureInline(f)
+		}
+		return "", ""
+	case "svg":
+		if f, ok := c.svg(n, ""); ok {
+			return c.figureInline(f)
+		}
+		return "", ""
+	}
+	var md, text strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		m, t := c.inline(child)
+		md.WriteString(m)
+		text.WriteString(t)
+	}
+	if blockElements[n.Data] {
+		return " " + md.String() + " ", " " + text.String() + " "
+	}
+	return md.String(), text.String()
+}
+
+func (c *converter) list(n *html.Node, depth int) mdBlock {
+	var md, text []string
+	number := 0
+	for li := n.FirstChild; li != nil; li = li.NextSibling {
+		if li.Type != html.ElementNode || li.Data != "li" {
+			continue
+		}
+		number++
+		marker := "-"
+		if n.Data == "ol" {
+			marker = fmt.Sprintf("%d.", number)
+		}
+		var nested []mdBlock
+		var itemMD, itemText strings.Builder
+		for child := li.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == html.ElementNode && (child.Data == "ul" || child.Data == "ol") {
+				nested = append(nested, c.list(child, depth+1))
+				continue
+			}
+			if child.Type == html.ElementNode && blockElements[child.Data] {
+				m, t := c.inlineChildren(child)
+				itemMD.WriteString(" " + m + " ")
+				itemText.WriteString(" " + t + " ")
+				continue
+			}
+			m, t := c.inline(child)
+			itemMD.WriteString(m)
+			itemText.WriteString(t)
+		}
+		indent := strings.Repeat("  ", depth)
+		md = append(md, indent+marker+" "+strings.TrimSpace(spaceRe.ReplaceAllString(itemMD.String(), " ")))
+		text = append(text, strings.TrimSpace(spaceRe.ReplaceAllString(itemText.String(), " ")))
+		for _, b := range nested {
+			md = append(md, b.md)
+			text = append(text, b.text)
+		}
+	}
+	return mdBlock{md: strings.Join(md, "\n"), text: strings.Join(text, "\n")}
+}
+
+func (c *converter) table(n *html.Node) mdBlock {
+	var rows [][]string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			if child.Data == "tr" {
+				var row []string
+				for cell := child.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.Type == html.ElementNode && (cell.Data == "td" || cell.Data == "th") {
+						m, _ := c.inlineChildren(cell)
+						row = append(row, strings.ReplaceAll(m, "|", `\|`))
+					}
+				}
+				rows = append(rows, row)
+				continue
+			}
+			walk(child)
+		}
+	}
+	walk(n)
+	if len(rows) == 0 {
+		return mdBlock{}
+	}
+	var md, text []string
+	for i, row := range rows {
+		md = append(md, "| "+strings.Join(row, " | ")+" |")
+		text = append(text, strings.Join(row, " | "))
+		if i == 0 {
+			md = append(md, "|"+strings.Repeat(" --- |", len(row)))
+		}
+	}
+	return mdBlock{md: strings.Join(md, "\n"), text: strings.Join(text, "\n"), verbatim: true}
+}
+
+// figure converts a <figure> with its images and <figcaption>.
+func (c *converter) figure(n *html.Node) []mdBlock {
+	var caption string
+	var images []*html.Node
+	var others []*html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				if child.Type == html.TextNode && len(strings.TrimSpace(child.Data)) > 0 {
+					others = append(others, child)
+				}
+				continue
+			}
+			switch child.Data {
+			case "figcaption":
+				caption = strings.TrimSpace(spaceRe.ReplaceAllString(htmlutil.TextContent(child), " "))
+			case "img", "image", "svg":
+				images = append(images, child)
+			case "div", "span", "p", "a", "picture":
+				walk(child)
+			default:
+				others = append(others, child)
+			}
+		}
+	}
+	walk(n)
+	var out []mdBlock
+	for _, img := range images {
+		var f Figure
+		var ok bool
+		if img.Data == "svg" {
+			f, ok = c.svg(img, caption)
+		} else {
+			f, ok = c.image(img, caption)
+		}
+		if ok {
+			out = append(out, c.figureBlock(f))
+		}
+	}
+	for _, other := range others {
+		if other.Type == html.TextNode {
+			out = append(out, mdBlock{md: escapeMarkdown(strings.TrimSpace(other.Data)), text: strings.TrimSpace(other.Data)})
+			continue
+		}
+		out = append(out, c.block(other)...)
+	}
+	if len(images) == 0 && len(caption) > 0 {
+		out = append(out, mdBlock{md: "_" + caption + "_", text: caption})
+	}
+	return out
+}
+
+func (c *converter) figureBlock(f Figure) mdBlock {
+	md := fmt.Sprintf("![%s](%s)", escapeMarkdown(f.Alt), f.Href)
+	if len(f.Caption) > 0 {
+		md += "\n\n_" + escapeMarkdown(f.Label+": "+f.Caption) + "_"
+	}
+	return mdBlock{md: md, text: c.placeholder(f)}
+}
+
+func (c *converter) figureInline(f Figure) (string, string) {
+	return fmt.Sprintf("![%s](%s)", escapeMarkdown(f.Alt), f.Href), c.placeholder(f)
+}
+
+func (c *converter) placeholder(f Figure) string {
+	if !c.opts.FigurePlaceholders {
+		return ""
+	}
+	description := f.Caption
+	if len(description) == 0 {
+		description = f.Alt
+	}
+	if len(description) == 0 {
+		return "[" + f.Label + "]"
+	}
+	return "[" + f.Label + ": " + description + "]"
+}
+
+var captionLabelRe = regexp.MustCompile(`^((?:Figure|Fig\.|Image|Illustration|Table)\s*[0-9IVXivx]+(?:[.\-–][0-9]+)*)[.:\s–—-]*`)
+
+func (c *converter) newFigure(href, alt, caption string) Figure {
+	f := Figure{Label: fmt.Sprintf("Figure %d", len(c.figures)+1), Href: href, Alt: alt, Caption: caption}
+	if c.opts.Chapter > 0 {
+		f.Label = fmt.Sprintf("Figure %d.%d", c.opts.Chapter, len(c.figures)+1)
+	}
+	// keep the numbering of the book when the caption has one, ex: "Figure 3-2. The loop"
+	if m := captionLabelRe.FindStringSubmatch(caption); m != nil {
+		f.Label = m[1]
+		f.Caption = strings.TrimSpace(caption[len(m[0]):])
+	}
+	if len(f.Alt) == 0 {
+		f.Alt = caption
+	}
+	c.figures = append(c.figures, f)
+	return f
+}
+
+// image resolves an <img> or SVG <image> against the chapter folder and copies it to the assets folder.
+func (c *converter) image(n *html.Node, caption string) (Figure, bool) {
+	src := htmlutil.Attr(n, "src")
+	if len(src) == 0 {
+		src = htmlutil.Attr(n, "href")
+	}
+	if len(src) == 0 {
+		src = htmlutil.Attr(n, "xlink:href")
+	}
+	if len(src) == 0 || strings.HasPrefix(src, "data:") {
+		return Figure{}, false
+	}
+	href, err := c.copyAsset(src)
+	if err != nil {
+		c.warnings = append(c.warnings, "skipping image: "+err.Error())
+		return Figure{}, false
+	}
+	return c.newFigure(href, strings.TrimSpace(htmlutil.Attr(n, "alt")), caption), true
+}
+
+// svg exports an inline <svg>. An SVG that only wraps a single <image>, as covers often do,
+// is exported as that image.
+func (c *converter) svg(n *html.Node, caption string) (Figure, bool) {
+	var images []*html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == html.ElementNode && child.Data == "image" {
+				images = append(images, child)
+			}
+			walk(child)
+		}
+	}
+	walk(n)
+	if len(images) == 1 {
+		return c.image(images[0], caption)
+	}
+	title := ""
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && (child.Data == "title" || child.Data == "desc") {
+			title = strings.TrimSpace(htmlutil.TextContent(child))
+			break
+		}
+	}
+	if len(c.opts.AssetsDir) == 0 {
+		return c.newFigure("", title, caption), true
+	}
+	var buf bytes.Buffer
+	if err := html.Render(&buf, n); err != nil {
+		return Figure{}, false
+	}
+	name := fmt.Sprintf("chapter-%d-svg-%d.svg", c.opts.Chapter, len(c.figures)+1)
+	if err := writeAsset(filepath.Join(c.opts.AssetsDir, name), &buf); err != nil {
+		c.warnings = append(c.warnings, "skipping svg: "+err.Error())
+		return Figure{}, false
+	}
+	return c.newFigure(path.Join(c.opts.AssetsHref, name), title, caption), true
+}
+
+// copyAsset copies the image at src, relative to the chapter folder, to the assets folder
+// and returns how the Markdown refers to it. Images outside the book are refused.
+func (c *converter) copyAsset(src string) (string, error) {
+	if u, err := url.Parse(src); err == nil && u.Scheme != "" {
+		return src, nil
+	}
+	if unescaped, err := url.PathUnescape(src); err == nil {
+		src = unescaped
+	}
+	src, _, _ = strings.Cut(src, "#")
+	source := filepath.Join(c.opts.BaseDir, filepath.FromSlash(src))
+	if href, ok := c.copied[source]; ok {
+		return href, nil
+	}
+	name := assetName(src)
+	if len(c.opts.AssetsDir) > 0 || len(c.opts.BookHref) > 0 {
+		root := c.opts.RootDir
+		if len(root) == 0 {
+			root = c.opts.BaseDir
+		}
+		rel, err := filepath.Rel(root, source)
+		if err != nil || !filepath.IsLocal(rel) {
+			return "", fmt.Errorf("image %s is outside the book", src)
+		}
+		if len(c.opts.AssetsDir) == 0 {
+			u := url.URL{Path: path.Join(c.opts.BookHref, filepath.ToSlash(rel))}
+			// parentheses would end the Markdown link
+			href := strings.NewReplacer("(", "%28", ")", "%29").Replace(u.EscapedPath())
+			c.copied[source] = href
+			return href, nil
+		}
+		// OpenInRoot also refuses symbolic links leading out of the book
+		f, err := os.OpenInRoot(cmp.Or(root, "."), rel)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return "", err
+		}
+		if name, err = writeUniqueAsset(c.opts.AssetsDir, name, data); err != nil {
+			return "", err
+		}
+	}
+	href := path.Join(c.opts.AssetsHref, name)
+	c.copied[source] = href
+	return href, nil
+}
+
+// writeUniqueAsset writes data to name in the assets folder and returns the name written. When
+// another image already has the name, a number is added to it, ex: images-fig-2.png, so that
+// images with the same flattened path do not overwrite each other.
+func writeUniqueAsset(dir, name string, data []byte) (string, error) {
+	ext := path.Ext(name)
+	for i := 1; ; i++ {
+		candidate := name
+		if i > 1 {
+			candidate = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), i, ext)
+		}
+		existing, err := os.ReadFile(filepath.Join(dir, candidate))
+		if errors.Is(err, os.ErrNotExist) {
+			return candidate, writeAsset(filepath.Join(dir, candidate), bytes.NewReader(data))
+		}
+		if err != nil {
+			return "", err
+		}
+		if bytes.Equal(existing, data) {
+			return candidate, nil
+		}
+	}
+}
+
+// assetName flattens an image path into a file name, ex: ../images/ch03/fig2.png -> images-ch03-fig2.png
+func assetName(src string) string {
+	var parts []string
+	for _, part := range strings.Split(path.Clean("/"+filepath.ToSlash(src)), "/") {
+		if part != "" && part != ".." && part != "." {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+func writeAsset(filename string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, r)
+	return err
+}
+
+var markdownSpecialRe = regexp.MustCompile("([\\\\`*_\\[\\]])")
+
+func escapeMarkdown(text string) string {
+	return markdownSpecialRe.ReplaceAllString(text, `\$1`)
+}
+
+// FiguresMarkdown lists the figures of a chapter, appended to the generated Markdown
+// so the narration can refer to them.
+func FiguresMarkdown(figures []Figure) string {
+	if len(figures) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("\n\n## Figures\n\n")
+	for _, f := range figures {
+		if len(f.Href) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "![%s](%s)\n\n", escapeMarkdown(f.Alt), f.Href)
+		if len(f.Caption) > 0 {
+			fmt.Fprintf(&b, "_%s_\n\n", escapeMarkdown(f.Label+": "+f.Caption))
+		} else {
+			fmt.Fprintf(&b, "_%s_\n\n", f.Label)
+		}
+	}
+	return b.String()
+}
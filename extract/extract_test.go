@@ -0,0 +1,179 @@
+package extract
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+
+	"cli-epub-parser-md-generator/render"
+)
+
+const testChapter = `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml"><head><title>Ch 3</title><style>p{}</style></head>
+<body>
+<h1>Chapter 3: Loops</h1>
+<p>Some <b>bold</b> and <i>italic</i> text with a_b and <a href="https://go.dev">a link</a>.</p>
+<figure><img src="../images/loop.png" alt="A loop"/><figcaption>Figure 3-2. The event loop</figcaption></figure>
+<ul><li>one</li><li>two<ol><li>nested</li></ol></li></ul>
+<pre>for {
+}</pre>
+<table><tr><th>a</th><th>b</th></tr><tr><td>1</td><td>2</td></tr></table>
+<svg><image xlink:href="../images/cover.jpg"/></svg>
+</body></html>`
+
+func writeTestChapter(t *testing.T) (string, string) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "OEBPS", "text"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join(root, "OEBPS", "images"), 0755)
+	os.WriteFile(filepath.Join(root, "OEBPS", "images", "loop.png"), []byte("png"), 0644)
+	os.WriteFile(filepath.Join(root, "OEBPS", "images", "cover.jpg"), []byte("jpg"), 0644)
+	chapter := filepath.Join(root, "OEBPS", "text", "ch03.xhtml")
+	os.WriteFile(chapter, []byte(testChapter), 0644)
+	return root, chapter
+}
+
+func TestConvertHTMLFile(t *testing.T) {
+	root, chapter := writeTestChapter(t)
+	assets := filepath.Join(root, "out", "assets")
+	converted, err := ConvertFile(chapter, Options{RootDir: root, AssetsDir: assets, AssetsHref: "assets", Chapter: 3, FigurePlaceholders: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# Chapter 3: Loops",
+		"Some **bold** and *italic* text with a\\_b and [a link](https://go.dev).",
+		"![A loop](assets/images-loop.png)\n\n_Figure 3-2: The event loop_",
+		"- one\n- two\n  1. nested",
+		"```\nfor {\n}\n```",
+		"| a | b |\n| --- | --- |\n| 1 | 2 |",
+		"![](assets/images-cover.jpg)",
+	} {
+		if !strings.Contains(converted.Markdown, want) {
+			t.Errorf("expected %q in markdown:\n%s", want, converted.Markdown)
+		}
+	}
+	if strings.Contains(converted.Markdown, "p{}") || strings.Contains(converted.Text, "Ch 3") {
+		t.Errorf("head content leaked into the output")
+	}
+	if !strings.Contains(converted.Text, "[Figure 3-2: The event loop]") || !strings.Contains(converted.Text, "[Figure 3.2]") {
+		t.Errorf("expected figure placeholders in text:\n%s", converted.Text)
+	}
+	if len(converted.Figures) != 2 {
+		t.Errorf("expected 2 figures, got %+v", converted.Figures)
+	}
+	for _, name := range []string{"images-loop.png", "images-cover.jpg"} {
+		if _, err := os.Stat(filepath.Join(assets, name)); err != nil {
+			t.Error(err)
+		}
+	}
+	// the plain text renders the same inline content without markup
+	blocks := render.ParseMarkdown(converted.Markdown)
+	if got := blocks[1].PlainText(); got != "Some bold and italic text with a_b and a link." {
+		t.Errorf("unexpected plain text %q", got)
+	}
+}
+
+func TestConvertAssetsInsideBook(t *testing.T) {
+	root, _ := writeTestChapter(t)
+	book := filepath.Join(root, "OEBPS")
+	os.WriteFile(filepath.Join(root, "secret.txt"), []byte("secret"), 0644)
+	os.MkdirAll(filepath.Join(book, "text", "images"), 0755)
+	os.WriteFile(filepath.Join(book, "text", "images", "loop.png"), []byte("other png"), 0644)
+	chapter := filepath.Join(book, "text", "ch04.xhtml")
+	os.WriteFile(chapter, []byte(`<p><img src="../../secret.txt"/><img src="../images/loop.png"/><img src="images/loop.png"/><img src="../images/loop.png"/></p>`), 0644)
+	assets := filepath.Join(root, "out", "assets")
+	converted, err := ConvertFile(chapter, Options{RootDir: book, AssetsDir: assets, AssetsHref: "assets"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hrefs []string
+	for _, f := range converted.Figures {
+		hrefs = append(hrefs, f.Href)
+	}
+	if want := []string{"assets/images-loop.png", "assets/images-loop-2.png", "assets/images-loop.png"}; !slices.Equal(hrefs, want) {
+		t.Errorf("expected the figures %q, got %q", want, hrefs)
+	}
+	if _, err := os.Stat(filepath.Join(assets, "secret.txt")); err == nil {
+		t.Error("expected the file outside the book not to be copied")
+	}
+	if len(converted.Warnings) != 1 || !strings.HasPrefix(converted.Warnings[0], "skipping image: ") {
+		t.Errorf("expected a warning for the image outside the book, got %q", converted.Warnings)
+	}
+	for name, want := range map[string]string{"images-loop.png": "png", "images-loop-2.png": "other png"} {
+		if data, _ := os.ReadFile(filepath.Join(assets, name)); string(data) != want {
+			t.Errorf("expected %q in %s, got %q", want, name, data)
+		}
+	}
+	// without an assets folder, BookHref links the images in place
+	converted, err = ConvertFile(chapter, Options{RootDir: book, BookHref: "/book"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hrefs = nil
+	for _, f := range converted.Figures {
+		hrefs = append(hrefs, f.Href)
+	}
+	if want := []string{"/book/images/loop.png", "/book/text/images/loop.png", "/book/images/loop.png"}; !slices.Equal(hrefs, want) || len(converted.Warnings) != 1 {
+		t.Errorf("expected the figures %q, got %q %q", want, hrefs, converted.Warnings)
+	}
+}
+
+func TestConvertWithoutPlaceholders(t *testing.T) {
+	_, chapter := writeTestChapter(t)
+	converted, err := ConvertFile(chapter, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(converted.Text, "[Figure") {
+		t.Errorf("unexpected placeholders in text:\n%s", converted.Text)
+	}
+}
+
+func TestConvertCleaned(t *testing.T) {
+	cleaner, err := NewCleaner(CleanRules{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := html.Parse(strings.NewReader(`<p>Running head</p><pre>}
+}
+}
+x  :=  1</pre><p>Running head</p><table><tr><td>a</td></tr><tr><td>a</td></tr><tr><td>a</td></tr></table><p>Running head</p><p>The   end.</p>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	converted, err := Convert(doc, Options{Cleaner: cleaner})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "```\n}\n}\n}\nx  :=  1\n```\n\n| a |\n| --- |\n| a |\n| a |\n\nThe end.\n"; converted.Markdown != want {
+		t.Errorf("got markdown %q, want %q", converted.Markdown, want)
+	}
+	if want := "}\n}\n}\nx  :=  1\n\na\na\na\n\nThe end."; converted.Text != want {
+		t.Errorf("got text %q, want %q", converted.Text, want)
+	}
+}
+
+func TestFiguresMarkdown(t *testing.T) {
+	md := FiguresMarkdown([]Figure{{Label: "Figure 1.1", Href: "assets/a.png", Alt: "A", Caption: "Cap"}})
+	if !strings.Contains(md, "## Figures") || !strings.Contains(md, "![A](assets/a.png)") || !strings.Contains(md, "_Figure 1.1: Cap_") {
+		t.Errorf("unexpected markdown %q", md)
+	}
+}
+
+func TestConvertFileLegacyEncoding(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "ch01.xhtml")
+	os.WriteFile(filename, []byte("<?xml version=\"1.0\" encoding=\"windows-1252\"?>\n<html><body><h1>Caf\xe9</h1><p>\x93Cr\xe8me&nbsp;br\xfbl\xe9e\x94 for\xa0two</p></body></html>"), 0644)
+	converted, err := ConvertFile(filename, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(converted.Markdown, "# Café") || !strings.Contains(converted.Text, "“Crème brûlée” for two") {
+		t.Errorf("expected the text decoded and normalized:\n%s", converted.Markdown)
+	}
+}
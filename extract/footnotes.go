@@ -0,0 +1,183 @@
+package extract
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"cli-epub-parser-md-generator/internal/htmlutil"
+)
+
+// FootnoteMode is how note references are rendered, set with -footnotes.
+type FootnoteMode string
+
+const (
+	FootnotesMarkdown FootnoteMode = "markdown"
+	FootnotesInline   FootnoteMode = "inline"
+	FootnotesDrop     FootnoteMode = "drop"
+)
+
+func ParseFootnoteMode(value string) (FootnoteMode, error) {
+	switch mode := FootnoteMode(strings.ToLower(strings.TrimSpace(value))); mode {
+	case "":
+		return FootnotesMarkdown, nil
+	case FootnotesMarkdown, FootnotesInline, FootnotesDrop:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown footnotes mode %q, expected %s, %s or %s", value, FootnotesInline, FootnotesMarkdown, FootnotesDrop)
+}
+
+type footnote struct {
+	number int
+	text   string
+}
+
+// isNoteBody reports whether n holds note text, which is rendered where it is referenced instead.
+func isNoteBody(n *html.Node) bool {
+	return htmlutil.HasSemantic(n, "footnote", "footnotes", "endnote", "endnotes", "rearnote", "rearnotes", "note")
+}
+
+func isNoteref(n *html.Node) bool {
+	return n.Type == html.ElementNode && n.Data == "a" && htmlutil.HasSemantic(n, "noteref")
+}
+
+func containsNoteref(n *html.Node) bool {
+	if isNoteref(n) {
+		return true
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if containsNoteref(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// noteref renders a note reference according to the footnote mode.
+func (c *converter) noteref(n *html.Node) (string, string) {
+	if c.opts.Footnotes == FootnotesDrop {
+		return "", ""
+	}
+	href := htmlutil.Attr(n, "href")
+	text, ok := c.resolveNote(href, strings.Trim(htmlutil.TextContent(n), "[]() \t\n"))
+	if !ok {
+		return "", ""
+	}
+	if c.opts.Footnotes == FootnotesInline {
+		return " (" + escapeMarkdown(text) + ")", " (" + text + ")"
+	}
+	key := c.noteKey(href)
+	number, seen := c.noteNumbers[key]
+	if !seen {
+		number = len(c.notes) + 1
+		c.noteNumbers[key] = number
+		c.notes = append(c.notes, footnote{number: number, text: text})
+	}
+	ref := fmt.Sprintf("[^%d]", number)
+	return ref, ref
+}
+
+func (c *converter) noteKey(href string) string {
+	file, id, _ := strings.Cut(href, "#")
+	if len(file) == 0 {
+		return "#" + id
+	}
+	return filepath.Join(c.opts.BaseDir, filepath.FromSlash(file)) + "#" + id
+}
+
+// resolveNote finds the note an href points to, in this document or in a separate notes file,
+// and returns its text without the backlink and the leading marker, the number or symbol of the
+// reference. Numbers that start the text of the note, ex: "1984 edition", are kept.
+func (c *converter) resolveNote(href, marker string) (string, bool) {
+	file, id, _ := strings.Cut(href, "#")
+	if unescaped, err := url.PathUnescape(file); err == nil {
+		file = unescaped
+	}
+	if len(id) == 0 {
+		return "", false
+	}
+	doc := c.root
+	if len(file) > 0 {
+		filename := filepath.Join(c.opts.BaseDir, filepath.FromSlash(file))
+		var ok bool
+		if doc, ok = c.noteDocs[filename]; !ok {
+			var err error
+			if doc, err = htmlutil.ParseFile(filename); err != nil {
+				return "", false
+			}
+			c.noteDocs[filename] = doc
+		}
+	}
+	target := findByID(doc, id)
+	if target == nil {
+		return "", false
+	}
+	// the id is often on the backlink or the number inside the note, use the enclosing note
+	for node := target; node != nil && node.Type == html.ElementNode; node = node.Parent {
+		if node.Data == "a" || node.Data == "span" || node.Data == "sup" {
+			continue
+		}
+		target = node
+		break
+	}
+	text := strings.TrimSpace(spaceRe.ReplaceAllString(noteText(target), " "))
+	if len(marker) > 0 {
+		markerRe := regexp.MustCompile(`^[\[(]?` + regexp.QuoteMeta(marker) + `[\])]?[.:]?(?:\s+|$)`)
+		text = markerRe.ReplaceAllString(text, "")
+	}
+	return text, len(text) > 0
+}
+
+// noteText is the text content of a note, without the links back to the reference.
+func noteText(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		if n.Type == html.ElementNode && n.Data == "a" && (htmlutil.HasSemantic(n, "backlink", "referrer") || isBacklinkText(n)) {
+			return
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	return b.String()
+}
+
+// isBacklinkText reports whether an internal link only holds a note number or a return arrow.
+func isBacklinkText(n *html.Node) bool {
+	if strings.Contains(htmlutil.Attr(n, "href"), "://") {
+		return false
+	}
+	text := strings.TrimSpace(htmlutil.TextContent(n))
+	return len(strings.Trim(text, "0123456789[].*↩︎↑^ ")) == 0
+}
+
+func findByID(n *html.Node, id string) *html.Node {
+	if n.Type == html.ElementNode && htmlutil.Attr(n, "id") == id {
+		return n
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if found := findByID(child, id); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// footnoteDefinitions renders the collected notes as Markdown footnote definitions.
+func (c *converter) footnoteDefinitions() mdBlock {
+	var md, text []string
+	for _, note := range c.notes {
+		md = append(md, fmt.Sprintf("[^%d]: %s", note.number, escapeMarkdown(note.text)))
+		text = append(text, fmt.Sprintf("[^%d]: %s", note.number, note.text))
+	}
+	return mdBlock{md: strings.Join(md, "\n"), text: strings.Join(text, "\n")}
+}
@@ -0,0 +1,77 @@
+package extract
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeNotesBook(t *testing.T) string {
+	dir := t.TempDir()
+	chapter := `<html xmlns:epub="http://www.idpf.org/2007/ops"><body>
+<p>Deep work matters.<a epub:type="noteref" href="notes.xhtml#n1" id="r1">1</a> It is rare.<sup><a role="doc-noteref" href="#fn2">2</a></sup></p>
+<p>Again.<a epub:type="noteref" href="notes.xhtml#n1">1</a></p>
+<aside epub:type="footnote" id="fn2"><p><a href="#r2">2</a> Same file note.</p></aside>
+</body></html>`
+	notes := `<html><body><section epub:type="endnotes">
+<p id="n1"><a epub:type="backlink" href="ch01.xhtml#r1">1.</a> Newport, 2016.</p>
+</section></body></html>`
+	os.WriteFile(filepath.Join(dir, "ch01.xhtml"), []byte(chapter), 0644)
+	os.WriteFile(filepath.Join(dir, "notes.xhtml"), []byte(notes), 0644)
+	return filepath.Join(dir, "ch01.xhtml")
+}
+
+func TestFootnotesMarkdown(t *testing.T) {
+	converted, err := ConvertFile(writeNotesBook(t), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Deep work matters.[^1] It is rare.[^2]", "Again.[^1]", "[^1]: Newport, 2016.\n[^2]: Same file note."} {
+		if !strings.Contains(converted.Markdown, want) {
+			t.Errorf("expected %q in:\n%s", want, converted.Markdown)
+		}
+	}
+	if strings.Count(converted.Markdown, "Same file note") != 1 {
+		t.Errorf("the note body should only appear as a definition:\n%s", converted.Markdown)
+	}
+}
+
+func TestFootnotesInlineAndDrop(t *testing.T) {
+	chapter := writeNotesBook(t)
+	inline, _ := ConvertFile(chapter, Options{Footnotes: FootnotesInline})
+	if !strings.Contains(inline.Text, "Deep work matters. (Newport, 2016.) It is rare. (Same file note.)") {
+		t.Errorf("unexpected inline text:\n%s", inline.Text)
+	}
+	dropped, _ := ConvertFile(chapter, Options{Footnotes: FootnotesDrop})
+	if strings.ContainsAny(dropped.Text, "12") || strings.Contains(dropped.Text, "Newport") || strings.Contains(dropped.Text, "Same file") {
+		t.Errorf("notes should be dropped:\n%s", dropped.Text)
+	}
+}
+
+func TestFootnoteMarkers(t *testing.T) {
+	dir := t.TempDir()
+	chapter := `<html xmlns:epub="http://www.idpf.org/2007/ops"><body>
+<p>Orwell.<a epub:type="noteref" href="#n3">3</a> Again.<a epub:type="noteref" href="#n4">[4]</a> Star.<a epub:type="noteref" href="#n5">*</a></p>
+<aside epub:type="footnote" id="n3"><p>1984 edition, page 12.</p></aside>
+<aside epub:type="footnote" id="n4"><p>4. 12 people came.</p></aside>
+<aside epub:type="footnote" id="n5"><p>* See above.</p></aside>
+</body></html>`
+	os.WriteFile(filepath.Join(dir, "ch01.xhtml"), []byte(chapter), 0644)
+	converted, err := ConvertFile(filepath.Join(dir, "ch01.xhtml"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[^1]: 1984 edition, page 12.\n[^2]: 12 people came.\n[^3]: See above."; !strings.Contains(converted.Markdown, want) {
+		t.Errorf("expected %q in:\n%s", want, converted.Markdown)
+	}
+}
+
+func TestParseFootnoteMode(t *testing.T) {
+	if mode, err := ParseFootnoteMode("Inline"); err != nil || mode != FootnotesInline {
+		t.Errorf("unexpected result %s %v", mode, err)
+	}
+	if _, err := ParseFootnoteMode("endnotes"); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,77 @@
+// Package epubtest builds EPUB files for tests.
+package epubtest
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Write builds an EPUB file from files, named by their path in the archive, with the mimetype
+// entry first, and returns its path.
+func Write(t *testing.T, files map[string]string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "test-book.epub")
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	w, _ := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	w.Write([]byte("application/epub+zip"))
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// Files is a small EPUB 3 book with a nav document, an NCX, one image and a non-linear notes
+// document: its spine is a cover, "One: The Start", "Two" and the notes.
+var Files = map[string]string{
+	"META-INF/container.xml": `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`,
+	"OEBPS/content.opf": `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:identifier id="id">urn:isbn:123</dc:identifier><dc:title>Test Book</dc:title>
+<dc:creator>Jane Doe</dc:creator><dc:language>en</dc:language>
+</metadata>
+<manifest>
+<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+<item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+<item id="cover" href="text/cover.xhtml" media-type="application/xhtml+xml"/>
+<item id="ch1" href="text/ch01.xhtml" media-type="application/xhtml+xml"/>
+<item id="ch2" href="text/ch02.xhtml" media-type="application/xhtml+xml"/>
+<item id="notes" href="text/notes.xhtml" media-type="application/xhtml+xml"/>
+<item id="img" href="images/fig.png" media-type="image/png"/>
+</manifest>
+<spine toc="ncx">
+<itemref idref="cover"/><itemref idref="ch1"/><itemref idref="ch2"/><itemref idref="notes" linear="no"/>
+</spine>
+</package>`,
+	"OEBPS/nav.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body>
+<nav epub:type="toc"><ol>
+<li><a href="text/ch01.xhtml">One: The Start</a><ol><li><a href="text/ch01.xhtml#s1">Section</a></li></ol></li>
+<li><a href="text/ch02.xhtml">Two</a></li>
+</ol></nav></body></html>`,
+	"OEBPS/toc.ncx": `<?xml version="1.0"?><ncx xmlns="http://www.daisy.org/z3986/2005/ncx/"><navMap>
+<navPoint><navLabel><text>NCX One</text></navLabel><content src="text/ch01.xhtml"/></navPoint>
+</navMap></ncx>`,
+	"OEBPS/text/cover.xhtml": `<html><head><title>Cover</title></head><body><img src="../images/fig.png" alt="cover"/></body></html>`,
+	"OEBPS/text/ch01.xhtml": `<html><body><p>First chapter text.<a epub:type="noteref" href="notes.xhtml#n1">1</a></p>
+<h2 id="s1">Section</h2><p>More.</p></body></html>`,
+	"OEBPS/text/ch02.xhtml":  `<html><body><h1>Two</h1><figure><img src="../images/fig.png" alt="A figure"/><figcaption>A caption</figcaption></figure></body></html>`,
+	"OEBPS/text/notes.xhtml": `<html><body><p id="n1"><a href="ch01.xhtml">1</a> The note.</p></body></html>`,
+	"OEBPS/images/fig.png":   "png",
+}
@@ -0,0 +1,137 @@
+package htmlutil
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/saintfish/chardet"
+	"golang.org/x/net/html"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	xmlEncodingRe = regexp.MustCompile(`^\s*<\?xml[^>]*\sencoding\s*=\s*["']([\w.:-]+)["']`)
+	// metaCharsetRe matches <meta charset="..."> and <meta http-equiv="Content-Type" content="text/html; charset=...">
+	metaCharsetRe = regexp.MustCompile(`(?i)<meta\s[^>]*charset\s*=\s*["']?([\w.:-]+)`)
+)
+
+// typography replaces the characters that look like spaces or nothing but are not, so that
+// the text collapses, matches and tokenizes as it reads.
+var typography = strings.NewReplacer(
+	"\u00a0", " ", // no-break space
+	"\u202f", " ", // narrow no-break space
+	"\u2007", " ", // figure space
+	"\u200b", "", // zero width space
+	"\u2060", "", // word joiner
+	"\ufeff", "", // zero width no-break space, a byte order mark in the middle of the text
+	"\u00ad", "", // soft hyphen
+)
+
+// Normalize returns s in Unicode NFC with no-break spaces turned to spaces and zero-width
+// characters and soft hyphens removed. Zero-width joiners are kept: some scripts need them.
+func Normalize(s string) string {
+	return typography.Replace(norm.NFC.String(s))
+}
+
+// Decode converts a document to UTF-8 and returns it with the name of its encoding. Valid UTF-8
+// is kept as is, whatever it declares; otherwise the byte order mark, the encoding of the XML
+// declaration or the <meta> charset is used, and when there is none or it is wrong the encoding
+// is sniffed from the content.
+func Decode(data []byte) ([]byte, string, error) {
+	if utf8.Valid(data) {
+		return bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), "utf-8", nil
+	}
+	name := declaredEncoding(data)
+	if len(name) == 0 || strings.EqualFold(name, "utf-8") || strings.EqualFold(name, "utf8") {
+		name = sniffEncoding(data)
+	}
+	enc, err := lookupEncoding(name)
+	if err != nil {
+		return nil, "", err
+	}
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("error decoding %s: %w", name, err)
+	}
+	return bytes.TrimPrefix(decoded, []byte("\xef\xbb\xbf")), name, nil
+}
+
+func declaredEncoding(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xff\xfe")):
+		return "utf-16le"
+	case bytes.HasPrefix(data, []byte("\xfe\xff")):
+		return "utf-16be"
+	}
+	head := data[:min(len(data), 4096)]
+	if m := xmlEncodingRe.FindSubmatch(head); m != nil {
+		return string(m[1])
+	}
+	if m := metaCharsetRe.FindSubmatch(head); m != nil {
+		return string(m[1])
+	}
+	return ""
+}
+
+// sniffEncoding guesses the encoding of data, windows-1252 when it can't tell.
+func sniffEncoding(data []byte) string {
+	result, err := chardet.NewHtmlDetector().DetectBest(data)
+	if err != nil || result.Confidence < 30 || strings.EqualFold(result.Charset, "utf-8") {
+		return "windows-1252"
+	}
+	return result.Charset
+}
+
+func lookupEncoding(name string) (encoding.Encoding, error) {
+	// chardet names GB 18030 with a dash the indexes don't know
+	label := strings.ReplaceAll(strings.ToLower(name), "gb-18030", "gb18030")
+	if enc, err := htmlindex.Get(label); err == nil {
+		return enc, nil
+	}
+	if enc, err := ianaindex.IANA.Encoding(label); err == nil && enc != nil {
+		return enc, nil
+	}
+	return nil, fmt.Errorf("unsupported encoding %q", name)
+}
+
+// ParseFile reads an HTML or XHTML document in its encoding, see Decode, and parses it with its
+// text, alt and title attributes normalized, see Normalize.
+func ParseFile(filename string) (*html.Node, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	data, _, err = Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	NormalizeTree(doc)
+	return doc, nil
+}
+
+// NormalizeTree normalizes the text of n and its descendants and their alt and title
+// attributes. Other attributes, such as links to files, are left alone.
+func NormalizeTree(n *html.Node) {
+	if n.Type == html.TextNode {
+		n.Data = Normalize(n.Data)
+	}
+	for i, a := range n.Attr {
+		if a.Key == "alt" || a.Key == "title" {
+			n.Attr[i].Val = Normalize(a.Val)
+		}
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		NormalizeTree(child)
+	}
+}
@@ -0,0 +1,69 @@
+package htmlutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/html"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+func TestDecode(t *testing.T) {
+	gbk, _ := simplifiedchinese.GBK.NewEncoder().String("<p>第一章 开始了。这是一个很长的中文句子，用来检测编码。</p>")
+	utf16, _ := unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewEncoder().String("<p>café</p>")
+	tests := []struct {
+		name, data, encoding, want string
+	}{
+		{"utf-8", "<p>café</p>", "utf-8", "<p>café</p>"},
+		{"utf-8 bom", "\xef\xbb\xbf<p>café</p>", "utf-8", "<p>café</p>"},
+		{"mislabeled utf-8", `<?xml version="1.0" encoding="iso-8859-1"?><p>café</p>`, "utf-8", `<?xml version="1.0" encoding="iso-8859-1"?><p>café</p>`},
+		{"xml declaration", "<?xml version='1.0' encoding='windows-1252'?><p>caf\xe9 \x93quoted\x94</p>", "windows-1252", "<?xml version='1.0' encoding='windows-1252'?><p>café “quoted”</p>"},
+		{"meta charset", `<html><head><meta charset="gb2312"></head><body>` + gbk + `</body></html>`, "gb2312", `<html><head><meta charset="gb2312"></head><body><p>第一章 开始了。这是一个很长的中文句子，用来检测编码。</p></body></html>`},
+		{"wrong declaration", `<?xml version="1.0" encoding="utf-8"?><p>caf` + "\xe9" + ` cr` + "\xe8" + `me br` + "\xfb" + `l` + "\xe9" + `e, d` + "\xe9" + `j` + "\xe0" + ` vu</p>`, "ISO-8859-1", `<?xml version="1.0" encoding="utf-8"?><p>café crème brûlée, déjà vu</p>`},
+		{"utf-16 bom", utf16, "utf-16le", "<p>café</p>"},
+	}
+	for _, test := range tests {
+		data, name, err := Decode([]byte(test.data))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if name != test.encoding || string(data) != test.want {
+			t.Errorf("%s: expected %q in %s, got %q in %s", test.name, test.want, test.encoding, data, name)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	if got := Normalize("Cafe\u0301 au\u200b lait, co\u00adoperate 10\u00a0%"); got != "Caf\u00e9 au lait, cooperate 10 %" {
+		t.Errorf("unexpected normalized text %q", got)
+	}
+	if got := Normalize("\U0001F469\u200d\U0001F4BB"); got != "\U0001F469\u200d\U0001F4BB" {
+		t.Errorf("expected the zero width joiner to be kept, got %q", got)
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "ch.xhtml")
+	os.WriteFile(filename, []byte("<?xml version=\"1.0\" encoding=\"windows-1252\"?><html><body><p title=\"na\xefve\">Na\xefve&nbsp;caf\xe9</p></body></html>"), 0644)
+	doc, err := ParseFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p *html.Node
+	var find func(*html.Node)
+	find = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "p" {
+			p = n
+		}
+		for child := n.FirstChild; child != nil && p == nil; child = child.NextSibling {
+			find(child)
+		}
+	}
+	find(doc)
+	if p == nil || TextContent(p) != "Naïve café" || Attr(p, "title") != "naïve" {
+		t.Errorf("unexpected paragraph %q %q", TextContent(p), Attr(p, "title"))
+	}
+}
@@ -0,0 +1,55 @@
+// Package htmlutil has the helpers on HTML shared by the epub and extract packages: decoding
+// documents to normalized UTF-8 and reading parsed nodes.
+package htmlutil
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Attr returns the value of the attribute key of n, or "".
+func Attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// TextContent returns the text of n and its descendants.
+func TextContent(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	return b.String()
+}
+
+// HasSemantic reports whether n has one of types in its epub:type or, without the
+// doc- prefix, in its ARIA role.
+func HasSemantic(n *html.Node, types ...string) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	values := strings.Fields(Attr(n, "epub:type"))
+	for _, role := range strings.Fields(Attr(n, "role")) {
+		values = append(values, strings.TrimPrefix(role, "doc-"))
+	}
+	for _, v := range values {
+		for _, t := range types {
+			if v == t {
+				return true
+			}
+		}
+	}
+	return false
+}
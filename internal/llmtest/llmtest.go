@@ -0,0 +1,46 @@
+// Package llmtest fakes the DeepSeek API for tests.
+package llmtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cohesion-org/deepseek-go"
+)
+
+// NewFakeModel serves chat completions answering {"title": ..., "content": ...} and counts the requests.
+func NewFakeModel(t *testing.T) (*deepseek.Client, *atomic.Int32) {
+	t.Helper()
+	return NewFakeModelFunc(t, func(n int, messages []deepseek.ChatCompletionMessage) map[string]any {
+		return map[string]any{"title": fmt.Sprintf("Post %d", n), "content": "# Post\n\nRewritten text."}
+	})
+}
+
+// NewFakeModelFunc serves chat completions answering the JSON object returned by answer for the
+// messages of request n, counted from 1, and counts the requests.
+func NewFakeModelFunc(t *testing.T, answer func(n int, messages []deepseek.ChatCompletionMessage) map[string]any) (*deepseek.Client, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		var request deepseek.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		content, _ := json.Marshal(answer(int(n), request.Messages))
+		json.NewEncoder(w).Encode(map[string]any{
+			"id":      fmt.Sprint(n),
+			"object":  "chat.completion",
+			"choices": []map[string]any{{"index": 0, "message": map[string]string{"role": "assistant", "content": string(content)}, "finish_reason": "stop"}},
+			"usage":   map[string]int{"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15},
+		})
+	}))
+	t.Cleanup(server.Close)
+	client, err := deepseek.NewClientWithOptions("token", deepseek.WithBaseURL(server.URL+"/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, &calls
+}
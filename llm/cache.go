@@ -0,0 +1,139 @@
+package llm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cohesion-org/deepseek-go"
+)
+
+// Cache stores model responses on disk, keyed by the request, so generating the
+// same chapter twice with the same prompt does not pay for a second completion.
+type Cache struct {
+	Dir string
+}
+
+// CacheStats describes the content of the cache.
+type CacheStats struct {
+	Entries int
+	Bytes   int64
+	Oldest  time.Time
+	Newest  time.Time
+}
+
+// DefaultCacheDir is the responses folder in the user cache directory, ex: ~/.cache/cli-epub-parser-md-generator/responses.
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("error finding the user cache directory: %w", err)
+	}
+	return filepath.Join(dir, "cli-epub-parser-md-generator", "responses"), nil
+}
+
+// cacheKey identifies a request by its model, system prompt and chapter text.
+func cacheKey(model, prompt, text string) string {
+	sum := sha256.Sum256([]byte(model + "\x00" + prompt + "\x00" + text))
+	return hex.EncodeToString(sum[:])
+}
+
+func (rc *Cache) path(key string) string {
+	return filepath.Join(rc.Dir, key+".json")
+}
+
+// Get returns the cached response for key, if any.
+func (rc *Cache) Get(key string) (*deepseek.ChatCompletionResponse, bool) {
+	if rc == nil {
+		return nil, false
+	}
+	data, err := os.ReadFile(rc.path(key))
+	if err != nil {
+		return nil, false
+	}
+	var response deepseek.ChatCompletionResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, false
+	}
+	return &response, true
+}
+
+// Put stores the response for key.
+func (rc *Cache) Put(key string, response *deepseek.ChatCompletionResponse) error {
+	if rc == nil {
+		return nil
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		return fmt.Errorf("error marshaling response: %w", err)
+	}
+	if err := os.MkdirAll(rc.Dir, 0755); err != nil {
+		return fmt.Errorf("error creating cache folder: %w", err)
+	}
+	return os.WriteFile(rc.path(key), data, 0644)
+}
+
+func (rc *Cache) entries() ([]os.FileInfo, error) {
+	files, err := os.ReadDir(rc.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var infos []os.FileInfo
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		if info, err := file.Info(); err == nil {
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+
+// Stats counts the cached responses and their size.
+func (rc *Cache) Stats() (CacheStats, error) {
+	var stats CacheStats
+	infos, err := rc.entries()
+	if err != nil {
+		return stats, err
+	}
+	for _, info := range infos {
+		stats.Entries++
+		stats.Bytes += info.Size()
+		if stats.Oldest.IsZero() || info.ModTime().Before(stats.Oldest) {
+			stats.Oldest = info.ModTime()
+		}
+		if info.ModTime().After(stats.Newest) {
+			stats.Newest = info.ModTime()
+		}
+	}
+	return stats, nil
+}
+
+// Clear removes the cached responses older than maxAge, or every response when maxAge is 0,
+// and returns how many were removed.
+func (rc *Cache) Clear(maxAge time.Duration) (int, error) {
+	infos, err := rc.entries()
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, info := range infos {
+		if maxAge > 0 && time.Since(info.ModTime()) < maxAge {
+			continue
+		}
+		if err := os.Remove(filepath.Join(rc.Dir, info.Name())); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
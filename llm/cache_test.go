@@ -0,0 +1,45 @@
+package llm
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cohesion-org/deepseek-go"
+)
+
+func TestResponseCache(t *testing.T) {
+	cache := &Cache{Dir: t.TempDir()}
+	key := cacheKey(deepseek.DeepSeekChat, systemPrompt, "chapter text")
+	if key == cacheKey(deepseek.DeepSeekChat, youtubeScriptPrompt, "chapter text") {
+		t.Error("expected the prompt to be part of the key")
+	}
+	if _, ok := cache.Get(key); ok {
+		t.Fatal("expected an empty cache")
+	}
+	response := &deepseek.ChatCompletionResponse{ID: "1", Usage: deepseek.Usage{TotalTokens: 42}}
+	if err := cache.Put(key, response); err != nil {
+		t.Fatal(err)
+	}
+	cached, ok := cache.Get(key)
+	if !ok || cached.ID != "1" || cached.Usage.TotalTokens != 42 {
+		t.Errorf("unexpected cached response %+v", cached)
+	}
+	old := cacheKey(deepseek.DeepSeekChat, systemPrompt, "old chapter")
+	cache.Put(old, response)
+	past := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(cache.path(old), past, past)
+	if stats, err := cache.Stats(); err != nil || stats.Entries != 2 || !stats.Oldest.Equal(past) {
+		t.Errorf("unexpected stats %+v %v", stats, err)
+	}
+	if removed, err := cache.Clear(24 * time.Hour); err != nil || removed != 1 {
+		t.Errorf("expected to remove the old response, removed %d %v", removed, err)
+	}
+	if removed, _ := cache.Clear(0); removed != 1 {
+		t.Errorf("expected to remove every response, removed %d", removed)
+	}
+	var disabled *Cache
+	if _, ok := disabled.Get(key); ok || disabled.Put(key, response) != nil {
+		t.Error("expected a nil cache to be a no-op")
+	}
+}
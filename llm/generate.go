@@ -0,0 +1,165 @@
+// Package llm rewrites chapter text with a chat model, in the blog or youtube-script style,
+// and caches the responses on disk by the hash of their input.
+package llm
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cohesion-org/deepseek-go"
+
+	"cli-epub-parser-md-generator/render"
+)
+
+type deepseekOutput struct {
+	Title   string `json:"title"`
+	Content string `json:"content"`
+	// Summary is only asked for with Options.Recap.
+	Summary string `json:"summary,omitempty"`
+}
+
+// fallbackSummaryWords is the length of the summaries made from the narration.
+const fallbackSummaryWords int = 60
+
+// Options are the settings of the model requests of a run.
+type Options struct {
+	Model string
+	// Prompt replaces the built-in system prompt of the style when it is not empty.
+	Prompt string
+	Style  string
+	WPM    int
+	// Glossary adds the key terms of the book to the system prompt when it is not nil.
+	Glossary *Glossary
+	// Recap asks the model for a summary of the chapter, to tell the next chapters what it covered.
+	Recap bool
+	// Previously holds the summaries of the chapters before this one, one per line, so the
+	// rewrite can refer to them without repeating them.
+	Previously string
+	// OnMessage, when set, is called with what the requests do besides their result, ex: that
+	// the response came from the cache.
+	OnMessage func(message string)
+}
+
+// message reports text to OnMessage.
+func (opts Options) message(text string) {
+	if opts.OnMessage != nil {
+		opts.OnMessage(text)
+	}
+}
+
+// SystemPrompt is the custom prompt, or the built-in prompt of the style, followed by the
+// glossary and the summaries of the previous chapters.
+func (opts Options) SystemPrompt() string {
+	prompt := systemPrompt
+	if len(opts.Prompt) > 0 {
+		prompt = opts.Prompt
+	} else if opts.Style == render.StyleYouTubeScript {
+		prompt = youtubeScriptPrompt
+	}
+	if glossary := opts.Glossary.Prompt(); len(glossary) > 0 {
+		prompt += "\n\n" + glossary
+	}
+	if opts.Recap {
+		prompt += "\n\n" + recapPrompt
+	}
+	if len(opts.Previously) > 0 {
+		prompt += "\n\n" + previouslyPrompt + opts.Previously
+	}
+	return prompt
+}
+
+func (opts Options) ModelName() string {
+	if len(opts.Model) == 0 {
+		return deepseek.DeepSeekChat
+	}
+	return opts.Model
+}
+
+// InputHash identifies what a chapter is generated from: the model, the prompt, the style,
+// the speaking rate used for timings and the text.
+func (opts Options) InputHash(text string) string {
+	return cacheKey(opts.ModelName(), fmt.Sprintf("%s\x00%d\x00%s", opts.Style, opts.WPM, opts.SystemPrompt()), text)
+}
+
+// ProviderDeepSeek is the only provider supported so far.
+const ProviderDeepSeek string = "deepseek"
+
+// Generate sends the chapter text to DeepSeek and turns the JSON answer into a
+// render.Chapter written in the requested style. Responses are reused from cache when it is not nil.
+func Generate(ctx context.Context, client *deepseek.Client, cache *Cache, opts Options, text string) (render.Chapter, error) {
+	style, wpm := opts.Style, opts.WPM
+	prompt := opts.SystemPrompt()
+	request := &deepseek.ChatCompletionRequest{
+		Model: opts.ModelName(),
+		Messages: []deepseek.ChatCompletionMessage{
+			{Role: deepseek.ChatMessageRoleSystem, Content: prompt},
+			{Role: deepseek.ChatMessageRoleUser, Content: text},
+		},
+		JSONMode: true,
+	}
+	key := cacheKey(request.Model, prompt, text)
+	response, cached := cache.Get(key)
+	if cached {
+		opts.message("using cached response")
+	} else {
+		var err error
+		if response, err = client.CreateChatCompletion(ctx, request); err != nil {
+			return render.Chapter{}, fmt.Errorf("error creating chat completion: %w", err)
+		}
+	}
+	// only responses that parse are cached, a malformed answer is asked again next time
+	store := func() {
+		if !cached {
+			if err := cache.Put(key, response); err != nil {
+				opts.message("error caching response: " + err.Error())
+			}
+		}
+	}
+	extractor := deepseek.NewJSONExtractor(nil)
+	usage := render.Usage{
+		PromptTokens:     response.Usage.PromptTokens,
+		CompletionTokens: response.Usage.CompletionTokens,
+		TotalTokens:      response.Usage.TotalTokens,
+	}
+	if style == render.StyleYouTubeScript {
+		var script render.YouTubeScript
+		if err := extractor.ExtractJSON(response, &script); err != nil {
+			return render.Chapter{}, fmt.Errorf("error extracting script json: %w", err)
+		}
+		store()
+		opts.message("estimated runtime: " + render.FormatTimestamp(script.Runtime(wpm)))
+		chapter := render.Chapter{Title: script.Title, Content: script.Markdown(wpm), Narration: script.Narration(), Usage: usage}
+		if opts.Recap {
+			var recap deepseekOutput
+			extractor.ExtractJSON(response, &recap)
+			chapter.Summary = cmp.Or(recap.Summary, fallbackSummary(chapter.Narration))
+		}
+		return chapter, nil
+	}
+	var output deepseekOutput
+	if err := extractor.ExtractJSON(response, &output); err != nil {
+		return render.Chapter{}, fmt.Errorf("error extracting json: %w", err)
+	}
+	store()
+	chapter := render.Chapter{Title: output.Title, Content: output.Content, Narration: output.Content, Usage: usage}
+	if opts.Recap {
+		chapter.Summary = cmp.Or(output.Summary, fallbackSummary(chapter.Narration))
+	}
+	return chapter, nil
+}
+
+// fallbackSummary is the start of the narration, for the answers without a summary.
+func fallbackSummary(narration string) string {
+	var words []string
+	for _, block := range render.ParseMarkdown(narration) {
+		if block.Kind == render.BlockParagraph {
+			words = append(words, strings.Fields(block.PlainText())...)
+		}
+		if len(words) >= fallbackSummaryWords {
+			return strings.Join(words[:fallbackSummaryWords], " ") + "..."
+		}
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,65 @@
+package llm
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cohesion-org/deepseek-go"
+
+	"cli-epub-parser-md-generator/internal/llmtest"
+	"cli-epub-parser-md-generator/render"
+)
+
+func TestGenerateRecap(t *testing.T) {
+	client, _ := llmtest.NewFakeModelFunc(t, func(n int, messages []deepseek.ChatCompletionMessage) map[string]any {
+		if n == 1 {
+			return map[string]any{"title": "Post", "content": "Rewritten text.", "summary": "What it covered."}
+		}
+		return map[string]any{"title": "Post", "content": "# Post\n\n" + strings.Repeat("word ", 100)}
+	})
+	opts := Options{Style: render.StyleBlog, Recap: true}
+	chapter, err := Generate(context.Background(), client, nil, opts, "text")
+	if err != nil || chapter.Summary != "What it covered." {
+		t.Errorf("expected the summary of the model, got %q %v", chapter.Summary, err)
+	}
+	chapter, err = Generate(context.Background(), client, nil, opts, "text")
+	if want := strings.Repeat("word ", fallbackSummaryWords-1) + "word..."; err != nil || chapter.Summary != want {
+		t.Errorf("expected the start of the narration, got %q %v", chapter.Summary, err)
+	}
+	opts.Recap = false
+	if chapter, _ := Generate(context.Background(), client, nil, opts, "text"); len(chapter.Summary) != 0 {
+		t.Errorf("expected no summary without Recap, got %q", chapter.Summary)
+	}
+}
+
+func TestPreviouslyPrompt(t *testing.T) {
+	opts := Options{Style: render.StyleYouTubeScript, Recap: true, Previously: "- Chapter 1, One: Habits start small.\n"}
+	prompt := opts.SystemPrompt()
+	for _, want := range []string{youtubeScriptPrompt, recapPrompt, previouslyPrompt + opts.Previously} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("expected %q in %q", want, prompt)
+		}
+	}
+	if hash := opts.InputHash("text"); hash == (Options{Style: render.StyleYouTubeScript}).InputHash("text") {
+		t.Error("expected the recap to change the input hash")
+	}
+}
+
+func TestGenerateMessages(t *testing.T) {
+	client, calls := llmtest.NewFakeModelFunc(t, func(n int, messages []deepseek.ChatCompletionMessage) map[string]any {
+		return map[string]any{"title": "Post", "content": "Rewritten text."}
+	})
+	var messages []string
+	opts := Options{Style: render.StyleBlog, OnMessage: func(message string) { messages = append(messages, message) }}
+	cache := &Cache{Dir: t.TempDir()}
+	for range 2 {
+		if _, err := Generate(context.Background(), client, cache, opts, "text"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls.Load() != 1 || !slices.Equal(messages, []string{"using cached response"}) {
+		t.Errorf("expected the second answer from the cache, got %d requests and %q", calls.Load(), messages)
+	}
+}
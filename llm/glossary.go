@@ -0,0 +1,287 @@
+package llm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+
+	"cli-epub-parser-md-generator/render"
+)
+
+// GlossaryName is the file, inside the output folder of a book, holding its glossary.
+const GlossaryName string = "glossary.yaml"
+
+// maxGlossaryTerms bounds the extracted terms, which are all sent with every chapter.
+const maxGlossaryTerms int = 30
+
+// GlossaryTerm is a key term of a book, to be written the same way in every chapter.
+type GlossaryTerm struct {
+	Term string `yaml:"term"`
+	// Note tells the model what the term means, when it is not obvious.
+	Note string `yaml:"note,omitempty"`
+	// Avoid lists renamings of the term that are flagged in the rewrites, ex: focused labor for deep work.
+	Avoid []string `yaml:"avoid,omitempty"`
+}
+
+// Glossary holds the key terms of a book. It is extracted from all its chapters on the first run,
+// then edited by hand: its terms are given to the model with every chapter so the rewrites
+// name the concepts of the book consistently, and Check flags the rewrites that do not.
+type Glossary struct {
+	Terms []GlossaryTerm `yaml:"terms"`
+}
+
+const glossaryHeader string = `# Key terms of the book, given to the model with every chapter and checked in the rewrites.
+# Edit them freely, this file is only extracted when it does not exist, ex:
+#   - term: deep work
+#     note: focused work on cognitively demanding tasks
+#     avoid: [focused labor, concentrated work]
+`
+
+// LoadGlossary reads the glossary at path. The error wraps os.ErrNotExist when there is none.
+func LoadGlossary(path string) (*Glossary, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading glossary: %w", err)
+	}
+	var g Glossary
+	if err := yaml.Unmarshal(data, &g); err != nil {
+		return nil, fmt.Errorf("error parsing glossary %s: %w", path, err)
+	}
+	g.Terms = slices.DeleteFunc(g.Terms, func(term GlossaryTerm) bool { return len(strings.TrimSpace(term.Term)) == 0 })
+	return &g, nil
+}
+
+// Save writes the glossary to path, with a comment explaining how to edit it.
+func (g *Glossary) Save(path string) error {
+	data, err := yaml.Marshal(g)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(glossaryHeader), data...), 0644)
+}
+
+var (
+	// glossaryQuoteRe matches short quoted phrases, often a term being introduced.
+	glossaryQuoteRe = regexp.MustCompile(`[\x{201c}"]([^\x{201d}"]{3,50})[\x{201d}"]`)
+	// glossaryStopWords are words emphasized for stress rather than as terms.
+	glossaryStopWords = map[string]bool{"about": true, "after": true, "again": true, "always": true, "before": true, "cannot": true,
+		"could": true, "every": true, "first": true, "might": true, "never": true, "other": true, "should": true, "still": true,
+		"their": true, "there": true, "these": true, "those": true, "which": true, "while": true, "would": true}
+)
+
+// ExtractGlossary finds the key terms of a book in the Markdown of its chapters: the phrases
+// set in italics or bold, or quoted, of at most four words, that the text uses at least three
+// times. The most frequent come first.
+func ExtractGlossary(chapters []string) *Glossary {
+	type candidate struct {
+		term  string
+		words glossaryText
+		count int
+	}
+	var candidates []*candidate
+	seen := map[string]bool{}
+	add := func(text string) {
+		term := strings.Join(strings.Fields(strings.TrimFunc(text, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})), " ")
+		if key := strings.ToLower(term); isGlossaryTerm(term) && !seen[key] {
+			seen[key] = true
+			candidates = append(candidates, &candidate{term: term})
+		}
+	}
+	var text strings.Builder
+	for _, chapter := range chapters {
+		for _, block := range render.ParseMarkdown(chapter) {
+			if block.Kind == render.BlockCode {
+				continue
+			}
+			plain := block.PlainText()
+			text.WriteString(plain + "\n")
+			if block.Kind == render.BlockHeading {
+				continue
+			}
+			for _, inline := range block.Inlines {
+				if inline.Kind == render.InlineEmphasis || inline.Kind == render.InlineStrong {
+					add(inline.Text)
+				}
+			}
+			for _, m := range glossaryQuoteRe.FindAllStringSubmatch(plain, -1) {
+				add(m[1])
+			}
+		}
+	}
+	// the candidates are counted in a single pass over the words of the book, looked up by
+	// their first word
+	byFirst := map[string][]*candidate{}
+	for _, c := range candidates {
+		if c.words = newGlossaryText(c.term); len(c.words.words) > 0 {
+			byFirst[c.words.words[0]] = append(byFirst[c.words.words[0]], c)
+		}
+	}
+	all := newGlossaryText(text.String())
+	for i, word := range all.words {
+		keys := []string{word}
+		for _, suffix := range []string{"s", "es"} {
+			if stem, ok := strings.CutSuffix(word, suffix); ok && len(stem) > 0 {
+				keys = append(keys, stem)
+			}
+		}
+		for _, key := range keys {
+			for _, c := range byFirst[key] {
+				if all.has(c.words, i) {
+					c.count++
+				}
+			}
+		}
+	}
+	candidates = slices.DeleteFunc(candidates, func(c *candidate) bool { return c.count < 3 })
+	slices.SortStableFunc(candidates, func(a, b *candidate) int { return b.count - a.count })
+	g := &Glossary{Terms: []GlossaryTerm{}}
+	for _, c := range candidates[:min(len(candidates), maxGlossaryTerms)] {
+		g.Terms = append(g.Terms, GlossaryTerm{Term: c.term})
+	}
+	return g
+}
+
+// isGlossaryTerm tells if a phrase may be a term: one to four words with letters, single lower
+// case words being long enough not to be mere stress.
+func isGlossaryTerm(term string) bool {
+	words := strings.Fields(term)
+	if len(words) == 0 || len(words) > 4 || len(term) < 3 || len(term) > 50 || !strings.ContainsFunc(term, unicode.IsLetter) {
+		return false
+	}
+	if len(words) == 1 && strings.ToLower(term) == term {
+		return len(term) >= 5 && !strings.HasSuffix(term, "ly") && !glossaryStopWords[term]
+	}
+	return true
+}
+
+// glossaryText is a text split into lower case words, to find the terms of a glossary in it.
+type glossaryText struct {
+	words []string
+	// joined tells if only spaces and hyphens separate each word from the previous one.
+	joined []bool
+}
+
+func newGlossaryText(text string) glossaryText {
+	var t glossaryText
+	joined := false
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			t.words = append(t.words, strings.ToLower(word.String()))
+			t.joined = append(t.joined, joined)
+			word.Reset()
+			joined = true
+		}
+	}
+	for _, r := range text {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word.WriteRune(r)
+		case unicode.IsSpace(r) || r == '-':
+			flush()
+		default:
+			flush()
+			joined = false
+		}
+	}
+	flush()
+	return t
+}
+
+// has tells if term starts at word i: in any case, with any spacing or hyphens between its
+// words and an optional plural. Words the term separates with other characters, ex: the
+// apostrophe of Newport's law, must be separated by some in the text too.
+func (t glossaryText) has(term glossaryText, i int) bool {
+	if len(term.words) == 0 || i+len(term.words) > len(t.words) {
+		return false
+	}
+	last := len(term.words) - 1
+	for j, want := range term.words {
+		word := t.words[i+j]
+		if j > 0 && t.joined[i+j] != term.joined[j] {
+			return false
+		}
+		if word != want && (j < last || (word != want+"s" && word != want+"es")) {
+			return false
+		}
+	}
+	return true
+}
+
+// count returns how many times term appears, stopping at limit when it is positive.
+func (t glossaryText) count(term string, limit int) int {
+	words := newGlossaryText(term)
+	n := 0
+	for i := range t.words {
+		if t.has(words, i) {
+			if n++; n == limit {
+				break
+			}
+		}
+	}
+	return n
+}
+
+// Prompt returns the instructions added to the system prompt, or "" when there are no terms.
+func (g *Glossary) Prompt() string {
+	if g == nil || len(g.Terms) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("Glossary: this book is rewritten chapter by chapter, so name its key concepts exactly with these terms in every chapter, never with synonyms or paraphrases:\n")
+	for _, term := range g.Terms {
+		fmt.Fprintf(&b, "- %s", term.Term)
+		if len(term.Note) > 0 {
+			fmt.Fprintf(&b, ": %s", term.Note)
+		}
+		if len(term.Avoid) > 0 {
+			fmt.Fprintf(&b, " (not %s)", quoteAll(term.Avoid))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func quoteAll(texts []string) string {
+	var quoted []string
+	for _, text := range texts {
+		quoted = append(quoted, fmt.Sprintf("%q", text))
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// Check returns how output, the rewrite of source, diverges from the glossary: the renamings
+// of its terms it uses, and the terms that source uses at least twice but output leaves out.
+func (g *Glossary) Check(source, output string) []string {
+	if g == nil {
+		return nil
+	}
+	sourceText, outputText := newGlossaryText(source), newGlossaryText(output)
+	var problems []string
+	for _, term := range g.Terms {
+		var renamed []string
+		for _, avoid := range term.Avoid {
+			if outputText.count(avoid, 1) > 0 {
+				renamed = append(renamed, avoid)
+			}
+		}
+		switch {
+		case len(renamed) > 0:
+			problems = append(problems, fmt.Sprintf("uses %s instead of %q", quoteAll(renamed), term.Term))
+		case sourceText.count(term.Term, 2) == 2 && outputText.count(term.Term, 1) == 0:
+			problems = append(problems, fmt.Sprintf("leaves out %q", term.Term))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,108 @@
+package llm
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"cli-epub-parser-md-generator/render"
+)
+
+func TestExtractGlossary(t *testing.T) {
+	chapters := []string{
+		"# Deep Work\n\n*Deep work* is rare. Deep work matters, and **shallow work** is *not* enough.\n\nShallow work fills the day.",
+		"Deep-work sessions beat shallow work. What he called \"the Zeigarnik effect\" came up once, as did *really*.",
+	}
+	g := ExtractGlossary(chapters)
+	var terms []string
+	for _, term := range g.Terms {
+		terms = append(terms, term.Term)
+	}
+	if !slices.Equal(terms, []string{"Deep work", "shallow work"}) {
+		t.Errorf("unexpected terms %q", terms)
+	}
+	// occurrences next to each other are all counted
+	g = ExtractGlossary([]string{"*Flow state* matters: flow state flow states.", "*Newport's law* holds. Newport's law, Newport law."})
+	if len(g.Terms) != 1 || g.Terms[0].Term != "Flow state" {
+		t.Errorf("expected only the term used three times, got %+v", g.Terms)
+	}
+	if g := ExtractGlossary(nil); g.Terms == nil || len(g.Terms) != 0 {
+		t.Errorf("expected an empty glossary, got %+v", g)
+	}
+}
+
+func TestGlossaryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book", GlossaryName)
+	if _, err := LoadGlossary(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected a missing glossary, got %v", err)
+	}
+	g := &Glossary{Terms: []GlossaryTerm{{Term: "deep work", Note: "focused work", Avoid: []string{"focused labor"}}}}
+	if err := g.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.HasPrefix(string(data), "# Key terms") {
+		t.Errorf("expected the explanation comment, got %q", data)
+	}
+	loaded, err := LoadGlossary(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Terms) != 1 || loaded.Terms[0].Note != "focused work" || !slices.Equal(loaded.Terms[0].Avoid, []string{"focused labor"}) {
+		t.Errorf("unexpected glossary %+v", loaded)
+	}
+	os.WriteFile(path, []byte("terms:\n  - term: flow\n  - note: no term\n"), 0644)
+	if loaded, err := LoadGlossary(path); err != nil || len(loaded.Terms) != 1 {
+		t.Errorf("expected the entries without a term to be dropped, got %+v %v", loaded, err)
+	}
+	os.WriteFile(path, []byte("terms: [unclosed"), 0644)
+	if _, err := LoadGlossary(path); err == nil || errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a parse error, got %v", err)
+	}
+}
+
+func TestGlossaryPrompt(t *testing.T) {
+	opts := Options{Style: render.StyleBlog}
+	if opts.SystemPrompt() != systemPrompt {
+		t.Error("expected the built-in prompt without a glossary")
+	}
+	opts.Glossary = &Glossary{Terms: []GlossaryTerm{}}
+	if opts.SystemPrompt() != systemPrompt {
+		t.Error("expected the built-in prompt with an empty glossary")
+	}
+	hash := opts.InputHash("text")
+	opts.Glossary = &Glossary{Terms: []GlossaryTerm{{Term: "deep work", Note: "focused work", Avoid: []string{"focused labor"}}, {Term: "flow"}}}
+	prompt := opts.SystemPrompt()
+	for _, want := range []string{systemPrompt, "\n- deep work: focused work (not \"focused labor\")\n", "\n- flow\n"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("expected %q in %q", want, prompt)
+		}
+	}
+	if opts.InputHash("text") == hash {
+		t.Error("expected the glossary to change the input hash")
+	}
+}
+
+func TestGlossaryCheck(t *testing.T) {
+	g := &Glossary{Terms: []GlossaryTerm{
+		{Term: "deep work", Avoid: []string{"focused labor"}},
+		{Term: "shallow work"},
+		{Term: "flow"},
+	}}
+	source := "Deep work and deep work again. Shallow work twice: shallow-work. Flow once."
+	problems := g.Check(source, "Focused labor is great, and so is the flow.")
+	want := []string{`uses "focused labor" instead of "deep work"`, `leaves out "shallow work"`}
+	if !slices.Equal(problems, want) {
+		t.Errorf("expected %q, got %q", want, problems)
+	}
+	if problems := g.Check(source, "Deep works and shallow works."); len(problems) != 0 {
+		t.Errorf("expected no problems, got %q", problems)
+	}
+	var none *Glossary
+	if problems := none.Check(source, ""); problems != nil {
+		t.Errorf("expected a nil glossary to check nothing, got %q", problems)
+	}
+}
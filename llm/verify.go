@@ -0,0 +1,324 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/cohesion-org/deepseek-go"
+
+	"cli-epub-parser-md-generator/render"
+)
+
+// Verification modes of the rewrites, see Verify.
+const (
+	VerifyLocal string = "local"
+	VerifyJudge string = "judge"
+)
+
+// ClaimKind is the type of fact a claim states.
+type ClaimKind string
+
+const (
+	ClaimNumber ClaimKind = "number"
+	ClaimName   ClaimKind = "name"
+	ClaimQuote  ClaimKind = "quote"
+)
+
+// Claim is a fact stated by a rewrite that can be checked against its source chapter.
+type Claim struct {
+	Kind ClaimKind `json:"kind"`
+	Text string    `json:"text"`
+	// Sentence is the sentence of the rewrite stating the claim.
+	Sentence  string `json:"sentence"`
+	Supported bool   `json:"supported"`
+	// Verdict is the explanation of the judge, when it was asked.
+	Verdict string `json:"verdict,omitempty"`
+}
+
+// FaithfulnessReport lists the claims of a rewrite and whether its source supports them.
+type FaithfulnessReport struct {
+	Claims []Claim `json:"claims"`
+}
+
+var (
+	// claimNumberRe matches numbers with their currency, percent or scale, ex: $1,200, 42%, 3.5 million.
+	claimNumberRe = regexp.MustCompile(`(?i)[$\x{20ac}\x{a3}]?\d+(?:[.,]\d+)*(?:\s?%|\s(?:percent|per cent|thousand|million|billion|trillion)\b)?`)
+	// claimNameRe matches runs of two or more capitalized words, ex: Cal Newport, University of Chicago.
+	claimNameRe  = regexp.MustCompile(`\p{Lu}[\p{L}'\x{2019}]+(?:(?:\s+(?:of|de|da|van|von|der|du|la|le|al)\s+|\s+)\p{Lu}[\p{L}'\x{2019}]+)+`)
+	claimQuoteRe = regexp.MustCompile(`[\x{201c}"]([^\x{201d}"]+)[\x{201d}"]`)
+	// claimStarters are capitalized only because they start a sentence.
+	claimStarters = map[string]bool{"A": true, "An": true, "And": true, "As": true, "At": true, "But": true, "By": true, "For": true,
+		"From": true, "If": true, "In": true, "It": true, "On": true, "So": true, "The": true, "This": true, "That": true, "These": true,
+		"Those": true, "Today": true, "We": true, "What": true, "When": true, "While": true, "Why": true, "With": true, "You": true}
+	claimScales = map[string]float64{"thousand": 1e3, "million": 1e6, "billion": 1e9, "trillion": 1e12}
+)
+
+// ExtractClaims returns the numbers, names and quotes stated by the paragraphs, list items and
+// quotes of text, a Markdown rewrite. Bare single digits are left out, they mostly count tips
+// or steps.
+func ExtractClaims(text string) []Claim {
+	var claims []Claim
+	seen := map[string]bool{}
+	add := func(kind ClaimKind, claim, sentence string) {
+		if key := string(kind) + "\x00" + strings.ToLower(claim); !seen[key] {
+			seen[key] = true
+			claims = append(claims, Claim{Kind: kind, Text: claim, Sentence: sentence})
+		}
+	}
+	for _, block := range render.ParseMarkdown(text) {
+		if block.Kind != render.BlockParagraph && block.Kind != render.BlockListItem && block.Kind != render.BlockQuote && block.Kind != render.BlockTable {
+			continue
+		}
+		for _, sentence := range splitSentences(block.PlainText()) {
+			for _, number := range claimNumberRe.FindAllString(sentence, -1) {
+				if len(number) > 1 {
+					add(ClaimNumber, number, sentence)
+				}
+			}
+			for _, m := range claimNameRe.FindAllStringIndex(sentence, -1) {
+				name := sentence[m[0]:m[1]]
+				if first, rest, _ := strings.Cut(name, " "); m[0] == 0 && claimStarters[first] {
+					name = rest
+				}
+				if strings.Contains(name, " ") {
+					add(ClaimName, name, sentence)
+				}
+			}
+			for _, m := range claimQuoteRe.FindAllStringSubmatch(sentence, -1) {
+				if len(strings.Fields(m[1])) >= 4 {
+					add(ClaimQuote, strings.TrimSpace(m[1]), sentence)
+				}
+			}
+		}
+	}
+	return claims
+}
+
+// splitSentences splits text after the periods, question and exclamation marks followed by a
+// space and a capital letter or a quote.
+func splitSentences(text string) []string {
+	var sentences []string
+	start := 0
+	runes := []rune(text)
+	for i := 0; i+2 < len(runes); i++ {
+		if strings.ContainsRune(".!?", runes[i]) && unicode.IsSpace(runes[i+1]) && (unicode.IsUpper(runes[i+2]) || strings.ContainsRune("\"\u201c", runes[i+2])) {
+			sentences = append(sentences, strings.TrimSpace(string(runes[start:i+1])))
+			start = i + 2
+		}
+	}
+	if rest := strings.TrimSpace(string(runes[start:])); len(rest) > 0 {
+		sentences = append(sentences, rest)
+	}
+	return sentences
+}
+
+// claimSource is a source chapter prepared for matching claims.
+type claimSource struct {
+	text     string // lower case words separated by single spaces
+	words    map[string]bool
+	trigrams map[string]bool
+	numbers  map[float64]bool
+}
+
+func newClaimSource(source string) *claimSource {
+	words := claimWords(source)
+	s := &claimSource{text: strings.Join(words, " "), words: map[string]bool{}, trigrams: map[string]bool{}, numbers: map[float64]bool{}}
+	for i, word := range words {
+		s.words[word] = true
+		if i+2 < len(words) {
+			s.trigrams[strings.Join(words[i:i+3], " ")] = true
+		}
+	}
+	for _, number := range claimNumberRe.FindAllString(source, -1) {
+		value, scaled := numberValues(number)
+		s.numbers[value], s.numbers[scaled] = true, true
+	}
+	return s
+}
+
+// claimWords returns the words of text in lower case, without punctuation.
+func claimWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// numberValues returns the value of a number as written, and multiplied by its scale: 3.5
+// and 3500000 for 3.5 million. Commas before three digits separate thousands, other commas
+// are decimal separators.
+func numberValues(number string) (float64, float64) {
+	digits := strings.TrimLeftFunc(strings.ToLower(number), func(r rune) bool { return !unicode.IsDigit(r) })
+	scale := 1.0
+	for word, s := range claimScales {
+		if strings.HasSuffix(digits, word) {
+			scale = s
+		}
+	}
+	end := strings.IndexFunc(digits, func(r rune) bool { return !unicode.IsDigit(r) && r != '.' && r != ',' })
+	if end >= 0 {
+		digits = digits[:end]
+	}
+	var b strings.Builder
+	for i := 0; i < len(digits); i++ {
+		c := digits[i]
+		if c == ',' {
+			rest := digits[i+1:]
+			if len(rest) >= 3 && !strings.ContainsAny(rest[:3], ".,") && (len(rest) == 3 || rest[3] == ',' || rest[3] == '.') {
+				continue
+			}
+			c = '.'
+		}
+		b.WriteByte(c)
+	}
+	value, _ := strconv.ParseFloat(b.String(), 64)
+	return value, value * scale
+}
+
+// supports tells if the source states the claim: the same number, every word of a name, or
+// most of a quote word for word.
+func (s *claimSource) supports(claim Claim) bool {
+	switch claim.Kind {
+	case ClaimNumber:
+		value, scaled := numberValues(claim.Text)
+		return s.numbers[value] || s.numbers[scaled]
+	case ClaimName:
+		for _, word := range claimWords(claim.Text) {
+			if !s.words[word] {
+				return false
+			}
+		}
+		return true
+	default:
+		words := claimWords(claim.Text)
+		if strings.Contains(" "+s.text+" ", " "+strings.Join(words, " ")+" ") {
+			return true
+		}
+		found := 0
+		for i := 0; i+2 < len(words); i++ {
+			if s.trigrams[strings.Join(words[i:i+3], " ")] {
+				found++
+			}
+		}
+		return len(words) > 2 && float64(found) >= 0.8*float64(len(words)-2)
+	}
+}
+
+// Verify checks the claims of text, the rewrite of source, by matching them in the source. With
+// judge, the claims not found are sent to the model, which may find them supported by the
+// source in other words. When the model fails, the report of the local check is returned with
+// the error.
+func Verify(ctx context.Context, client *deepseek.Client, cache *Cache, opts Options, source, text string, judge bool) (*FaithfulnessReport, error) {
+	report := &FaithfulnessReport{Claims: ExtractClaims(text)}
+	s := newClaimSource(source)
+	for i := range report.Claims {
+		report.Claims[i].Supported = s.supports(report.Claims[i])
+	}
+	if !judge || len(report.Unsupported()) == 0 {
+		return report, nil
+	}
+	return report, judgeClaims(ctx, client, cache, opts, report, source)
+}
+
+// judgeVerdicts is the answer of the judge.
+type judgeVerdicts struct {
+	Claims []struct {
+		ID        int    `json:"id"`
+		Supported bool   `json:"supported"`
+		Reason    string `json:"reason"`
+	} `json:"claims"`
+}
+
+// judgeClaims asks the model whether source supports the unsupported claims of report.
+func judgeClaims(ctx context.Context, client *deepseek.Client, cache *Cache, opts Options, report *FaithfulnessReport, source string) error {
+	var b strings.Builder
+	b.WriteString("SOURCE CHAPTER:\n" + source + "\n\nCLAIMS OF THE REWRITE:\n")
+	var asked []int
+	for i, claim := range report.Claims {
+		if !claim.Supported {
+			asked = append(asked, i)
+			fmt.Fprintf(&b, "%d. [%s] %q in: %s\n", len(asked), claim.Kind, claim.Text, claim.Sentence)
+		}
+	}
+	request := &deepseek.ChatCompletionRequest{
+		Model: opts.ModelName(),
+		Messages: []deepseek.ChatCompletionMessage{
+			{Role: deepseek.ChatMessageRoleSystem, Content: judgePrompt},
+			{Role: deepseek.ChatMessageRoleUser, Content: b.String()},
+		},
+		JSONMode: true,
+	}
+	key := cacheKey(request.Model, judgePrompt, b.String())
+	response, cached := cache.Get(key)
+	if !cached {
+		var err error
+		if response, err = client.CreateChatCompletion(ctx, request); err != nil {
+			return fmt.Errorf("error asking the judge: %w", err)
+		}
+	}
+	var verdicts judgeVerdicts
+	if err := deepseek.NewJSONExtractor(nil).ExtractJSON(response, &verdicts); err != nil {
+		return fmt.Errorf("error extracting the verdicts of the judge: %w", err)
+	}
+	if !cached {
+		if err := cache.Put(key, response); err != nil {
+			opts.message("error caching response: " + err.Error())
+		}
+	}
+	for _, verdict := range verdicts.Claims {
+		if verdict.ID < 1 || verdict.ID > len(asked) {
+			continue
+		}
+		claim := &report.Claims[asked[verdict.ID-1]]
+		claim.Supported, claim.Verdict = verdict.Supported, verdict.Reason
+	}
+	return nil
+}
+
+// Unsupported returns the claims the source does not support.
+func (r *FaithfulnessReport) Unsupported() []Claim {
+	if r == nil {
+		return nil
+	}
+	var claims []Claim
+	for _, claim := range r.Claims {
+		if !claim.Supported {
+			claims = append(claims, claim)
+		}
+	}
+	return claims
+}
+
+// Markdown renders the report of the chapter titled title: the unsupported claims first,
+// highlighted in bold in their sentence, then the supported ones.
+func (r *FaithfulnessReport) Markdown(title string) string {
+	var b strings.Builder
+	unsupported := r.Unsupported()
+	fmt.Fprintf(&b, "# Faithfulness: %s\n\n", title)
+	if len(unsupported) == 0 {
+		fmt.Fprintf(&b, "All %d claims found in the source chapter.\n", len(r.Claims))
+	} else {
+		fmt.Fprintf(&b, "%d of %d claims not found in the source chapter.\n\n## Unsupported\n\n", len(unsupported), len(r.Claims))
+		for _, claim := range unsupported {
+			fmt.Fprintf(&b, "- **%s** (%s): %s\n", claim.Text, claim.Kind, strings.Replace(claim.Sentence, claim.Text, "**"+claim.Text+"**", 1))
+			if len(claim.Verdict) > 0 {
+				fmt.Fprintf(&b, "  - judge: %s\n", claim.Verdict)
+			}
+		}
+	}
+	if supported := slices.DeleteFunc(slices.Clone(r.Claims), func(claim Claim) bool { return !claim.Supported }); len(supported) > 0 {
+		b.WriteString("\n## Supported\n\n")
+		for _, claim := range supported {
+			fmt.Fprintf(&b, "- %s (%s)", claim.Text, claim.Kind)
+			if len(claim.Verdict) > 0 {
+				fmt.Fprintf(&b, ": %s", claim.Verdict)
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
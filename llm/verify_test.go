@@ -0,0 +1,90 @@
+package llm
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cohesion-org/deepseek-go"
+
+	"cli-epub-parser-md-generator/internal/llmtest"
+)
+
+const verifySource = `Cal Newport studied 1,200 knowledge workers at the University of Chicago. He found that 24% of them never had an hour without email. "Clarity about what matters provides clarity about what does not," he wrote. Revenue grew to 3.5 million dollars.`
+
+func TestExtractClaims(t *testing.T) {
+	text := "# The 5 Rules\n\nThe Deep Work Hypothesis is simple. Cal Newport found that 42% of workers check email, across 1200 people.\n\n" +
+		"- Step 3: he said \"clarity about what matters provides clarity\" to 3,500,000 readers.\n\n```\n99 bottles\n```\n"
+	var got []string
+	for _, claim := range ExtractClaims(text) {
+		got = append(got, string(claim.Kind)+":"+claim.Text)
+	}
+	want := "name:Deep Work Hypothesis|number:42%|number:1200|name:Cal Newport|number:3,500,000|quote:clarity about what matters provides clarity"
+	if strings.Join(got, "|") != want {
+		t.Errorf("expected %s, got %s", want, strings.Join(got, "|"))
+	}
+}
+
+func TestNumberValues(t *testing.T) {
+	for number, want := range map[string][2]float64{"1,200": {1200, 1200}, "3.5 million": {3.5, 3.5e6}, "$2,500,000": {2.5e6, 2.5e6}, "42 %": {42, 42}, "3,5": {3.5, 3.5}} {
+		if value, scaled := numberValues(number); value != want[0] || scaled != want[1] {
+			t.Errorf("numberValues(%q) = %v, %v, expected %v", number, value, scaled, want)
+		}
+	}
+}
+
+func TestVerifyLocal(t *testing.T) {
+	text := "Cal Newport followed 1200 workers at the University of Chicago, and 42% of them never had an hour free. " +
+		"He wrote that \"clarity about what matters provides clarity about what does not matter\". Sales reached $3,500,000 under John Smith."
+	report, err := Verify(context.Background(), nil, nil, Options{}, verifySource, text, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var unsupported []string
+	for _, claim := range report.Unsupported() {
+		unsupported = append(unsupported, claim.Text)
+	}
+	if strings.Join(unsupported, "|") != "42%|John Smith" {
+		t.Errorf("unexpected unsupported claims %q of %+v", unsupported, report.Claims)
+	}
+	md := report.Markdown("Deep Work")
+	for _, want := range []string{"# Faithfulness: Deep Work", "2 of 7 claims not found", "- **42%** (number): Cal Newport followed 1200 workers at the University of Chicago, and **42%** of them", "\n- Cal Newport (name)\n"} {
+		if !strings.Contains(md, want) {
+			t.Errorf("expected %q in %q", want, md)
+		}
+	}
+}
+
+func TestVerifyJudge(t *testing.T) {
+	var asked string
+	client, calls := llmtest.NewFakeModelFunc(t, func(n int, messages []deepseek.ChatCompletionMessage) map[string]any {
+		asked = messages[1].Content
+		return map[string]any{"claims": []map[string]any{
+			{"id": 1, "supported": true, "reason": "the source says forty percent"},
+			{"id": 2, "supported": false, "reason": "John Smith is not in the source"},
+		}}
+	})
+	text := "About 40% of them never had an hour free, said John Smith."
+	cache := &Cache{Dir: t.TempDir()}
+	report, err := Verify(context.Background(), client, cache, Options{}, verifySource, text, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(asked, "SOURCE CHAPTER:\n"+verifySource) || !strings.Contains(asked, `1. [number] "40%" in: `) || !strings.Contains(asked, `2. [name] "John Smith" in: `) {
+		t.Errorf("unexpected judge request %q", asked)
+	}
+	if len(report.Claims) != 2 || !report.Claims[0].Supported || report.Claims[0].Verdict != "the source says forty percent" || report.Claims[1].Supported {
+		t.Errorf("unexpected verdicts %+v", report.Claims)
+	}
+	if md := report.Markdown("T"); !strings.Contains(md, "  - judge: John Smith is not in the source\n") {
+		t.Errorf("expected the reason of the judge in %q", md)
+	}
+	Verify(context.Background(), client, cache, Options{}, verifySource, text, true)
+	if calls.Load() != 1 {
+		t.Errorf("expected the verdicts to be cached, got %d requests", calls.Load())
+	}
+	// claims all found locally are not sent
+	if _, err := Verify(context.Background(), client, cache, Options{}, verifySource, "Cal Newport said so.", true); err != nil || calls.Load() != 1 {
+		t.Errorf("expected no request, got %d %v", calls.Load(), err)
+	}
+}
@@ -17,6 +17,9 @@ import (
 	"github.com/cohesion-org/deepseek-go"
 	"github.com/gocolly/colly"
 	"github.com/tiktoken-go/tokenizer"
+
+	"cli-epub-parser-md-generator/epub"
+	"cli-epub-parser-md-generator/tokens"
 )
 
 type SomeMethod struct {
@@ -154,7 +157,7 @@ func TestSaveTextMD(t *testing.T) {
 
 func TestExtractEpub(t *testing.T) {
 	t.Skip()
-	ExtractEpub("book1.epub", ".temp")
+	epub.Extract("book1.epub", ".temp")
 }
 
 func TestScanHTML(t *testing.T) {
@@ -219,7 +222,7 @@ func TestBook2(t *testing.T) {
 		Subchapters = append(Subchapters, NewSubchapter(e.Attr("data-pdf-bookmark"), e.Text))
 	})
 	bookName := "book1.epub"
-	err := ExtractEpub(bookName, ".tmp")
+	err := epub.Extract(bookName, ".tmp")
 	filePath, err := ScanHTMLFiles(".tmp")
 	if err != nil {
 		fmt.Println(err)
@@ -256,7 +259,7 @@ func TestScanHTMLFiles2(t *testing.T) {
 	t.Skip()
 	var routes []string
 	bookName := "progit.epub"
-	err := ExtractEpub(bookName, tmpDir.Path)
+	err := epub.Extract(bookName, tmpDir.Path)
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -330,13 +333,13 @@ func TestChannel(t *testing.T) {
 func TestChannelIntegration(t *testing.T) {
 	var err error
 	fullText := "lorem ipsum"
-	tokenizeChannel := make(chan EncodedResponse)
+	tokenizeChannel := make(chan int)
 	go func() {
-		val, err2 := checkTokenv2(fullText)
+		val, err2 := tokens.Count(fullText)
 		tokenizeChannel <- val
 		err = err2
 	}()
-	// tokenize, err := checkTokenv2(fullText)
+	// tokenize, err := tokens.Count(fullText)
 	if err != nil {
 		fmt.Println(err)
 	}
@@ -0,0 +1,176 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cli-epub-parser-md-generator/render"
+	"cli-epub-parser-md-generator/tokens"
+)
+
+// ChapterStatus is where a chapter is in a run.
+type ChapterStatus string
+
+const (
+	StatusPending ChapterStatus = "pending"
+	StatusRunning ChapterStatus = "running"
+	StatusDone    ChapterStatus = "done"
+	StatusFailed  ChapterStatus = "failed"
+)
+
+// manifestName is the file, inside the output folder of a book, recording the state of its runs.
+const manifestName string = ".manifest.json"
+
+// Checkpoint is the rewrite of a chapter, kept so a resumed run can write it again without
+// asking the model.
+type Checkpoint struct {
+	Title     string `json:"title"`
+	Content   string `json:"content"`
+	Narration string `json:"narration"`
+	// Summary is given to the next chapters with -previously.
+	Summary string `json:"summary,omitempty"`
+}
+
+// ManifestChapter is the state of one chapter of a book.
+type ManifestChapter struct {
+	Number    int           `json:"number"`
+	Title     string        `json:"title"`
+	Source    string        `json:"source"`
+	Status    ChapterStatus `json:"status"`
+	InputHash string        `json:"input_hash"`
+	Outputs   []string      `json:"outputs,omitempty"`
+	Usage     render.Usage  `json:"usage"`
+	Error     string        `json:"error,omitempty"`
+	// Warnings are the divergences of the rewrite from the glossary of the book.
+	Warnings []string `json:"warnings,omitempty"`
+	// Unsupported are the claims of the rewrite not found in the source chapter, with -verify.
+	Unsupported []string    `json:"unsupported,omitempty"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+	Result      *Checkpoint `json:"result,omitempty"`
+}
+
+// Manifest records the state of every chapter generated from a book, saved after each change
+// so a run that dies can be resumed with -resume.
+type Manifest struct {
+	Book      string             `json:"book"`
+	Style     string             `json:"style"`
+	Model     string             `json:"model"`
+	Formats   []string           `json:"formats"`
+	UpdatedAt time.Time          `json:"updated_at"`
+	Chapters  []*ManifestChapter `json:"chapters"`
+
+	path string
+	mu   sync.Mutex
+}
+
+// LoadManifest reads the manifest of the output folder dir, or returns an empty one.
+func LoadManifest(dir string) (*Manifest, error) {
+	m := &Manifest{path: filepath.Join(dir, manifestName)}
+	data, err := os.ReadFile(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("error parsing manifest %s: %w", m.path, err)
+	}
+	return m, nil
+}
+
+// chapter returns the entry of chapter number, adding it when missing. m.mu must be held.
+func (m *Manifest) chapter(number int) *ManifestChapter {
+	for _, ch := range m.Chapters {
+		if ch.Number == number {
+			return ch
+		}
+	}
+	ch := &ManifestChapter{Number: number, Status: StatusPending}
+	m.Chapters = append(m.Chapters, ch)
+	sort.Slice(m.Chapters, func(i, j int) bool { return m.Chapters[i].Number < m.Chapters[j].Number })
+	return ch
+}
+
+// Completed returns the generated chapter number if it was done from the same input.
+func (m *Manifest) Completed(number int, inputHash string) (render.Chapter, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, ch := range m.Chapters {
+		if ch.Number == number && ch.Status == StatusDone && ch.InputHash == inputHash && ch.Result != nil {
+			return render.Chapter{Title: ch.Result.Title, Content: ch.Result.Content, Narration: ch.Result.Narration, Summary: ch.Result.Summary, Usage: ch.Usage}, true
+		}
+	}
+	return render.Chapter{}, false
+}
+
+// Previously returns the summaries of the chapters done before chapter number, in this run or
+// earlier ones, one "Chapter 2, Title: summary" line each, in order. The closest chapters are
+// kept first, as long as the lines fit in budget tokens.
+func (m *Manifest) Previously(number, budget int) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var lines []string
+	used := 0
+	for i := len(m.Chapters) - 1; i >= 0; i-- {
+		ch := m.Chapters[i]
+		if ch.Number >= number || ch.Status != StatusDone || ch.Result == nil || len(ch.Result.Summary) == 0 {
+			continue
+		}
+		line := fmt.Sprintf("- Chapter %d, %s: %s\n", ch.Number, ch.Result.Title, strings.Join(strings.Fields(ch.Result.Summary), " "))
+		count, err := tokens.Count(line)
+		if err != nil {
+			return "", err
+		}
+		if used+count > budget {
+			break
+		}
+		used += count
+		lines = append(lines, line)
+	}
+	slices.Reverse(lines)
+	return strings.Join(lines, ""), nil
+}
+
+// Update changes the entry of chapter number and saves the manifest.
+func (m *Manifest) Update(number int, update func(ch *ManifestChapter)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ch := m.chapter(number)
+	update(ch)
+	ch.UpdatedAt = time.Now()
+	return m.save()
+}
+
+// save writes the manifest to a temporary file renamed over the previous one, so a crash
+// never leaves it half written. m.mu must be held.
+func (m *Manifest) save() error {
+	m.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return err
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+// Save writes the manifest.
+func (m *Manifest) Save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.save()
+}
@@ -0,0 +1,83 @@
+package pipeline
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cli-epub-parser-md-generator/render"
+)
+
+func TestManifest(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "book")
+	m, err := LoadManifest(dir)
+	if err != nil || len(m.Chapters) != 0 {
+		t.Fatalf("expected an empty manifest, got %+v %v", m, err)
+	}
+	m.Book = "book.epub"
+	m.Update(3, func(ch *ManifestChapter) { ch.Status, ch.InputHash = StatusFailed, "a" })
+	m.Update(1, func(ch *ManifestChapter) {
+		ch.Status, ch.InputHash, ch.Usage = StatusDone, "b", render.Usage{TotalTokens: 10}
+		ch.Result = &Checkpoint{Title: "One", Content: "# One", Narration: "One"}
+	})
+	if _, err := os.Stat(filepath.Join(dir, manifestName+".tmp")); !os.IsNotExist(err) {
+		t.Error("expected the temporary file to be renamed")
+	}
+
+	loaded, err := LoadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Book != "book.epub" || len(loaded.Chapters) != 2 || loaded.Chapters[0].Number != 1 {
+		t.Fatalf("unexpected manifest %+v", loaded)
+	}
+	if ch, ok := loaded.Completed(1, "b"); !ok || ch.Title != "One" || ch.Narration != "One" || ch.Usage.TotalTokens != 10 {
+		t.Errorf("expected chapter 1 to be completed, got %+v", ch)
+	}
+	if _, ok := loaded.Completed(1, "changed"); ok {
+		t.Error("expected a changed input to be generated again")
+	}
+	if _, ok := loaded.Completed(3, "a"); ok {
+		t.Error("expected a failed chapter to be retried")
+	}
+}
+
+func TestManifestPreviously(t *testing.T) {
+	m, _ := LoadManifest(t.TempDir())
+	for number, summary := range map[int]string{1: "Habits start small.", 2: "Cues trigger\n habits.", 4: "Later.", 5: ""} {
+		m.Update(number, func(ch *ManifestChapter) {
+			ch.Status, ch.Result = StatusDone, &Checkpoint{Title: fmt.Sprint("Title ", number), Summary: summary}
+		})
+	}
+	m.Update(3, func(ch *ManifestChapter) { ch.Status = StatusFailed })
+	previously, err := m.Previously(4, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "- Chapter 1, Title 1: Habits start small.\n- Chapter 2, Title 2: Cues trigger habits.\n"; previously != want {
+		t.Errorf("expected %q, got %q", want, previously)
+	}
+	// the closest chapters are kept when the budget is short
+	if previously, _ := m.Previously(4, 15); previously != "- Chapter 2, Title 2: Cues trigger habits.\n" {
+		t.Errorf("expected only the closest chapter, got %q", previously)
+	}
+	if previously, _ := m.Previously(1, 1000); previously != "" {
+		t.Errorf("expected no summaries before the first chapter, got %q", previously)
+	}
+}
+
+func TestWriterOnSave(t *testing.T) {
+	var saved []string
+	opts := render.Options{Dir: t.TempDir(), Book: "book", Style: render.StyleBlog, OnSave: func(path string) { saved = append(saved, path) }}
+	writers, err := render.NewAll([]string{render.FormatMarkdown, render.FormatJSON}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range writers {
+		w.Write(render.Chapter{Index: 2, Title: "Two", Content: "text"})
+	}
+	if len(saved) != 2 || saved[0] != filepath.Join(opts.Dir, "02-two.md") || saved[1] != filepath.Join(opts.Dir, "02-two.json") {
+		t.Errorf("unexpected saved files %v", saved)
+	}
+}
@@ -0,0 +1,355 @@
+// Package pipeline runs the generation of a book: it converts and rewrites the selected
+// chapters concurrently, writes them in every format and records the run in a manifest so
+// it can be resumed.
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/cohesion-org/deepseek-go"
+
+	"cli-epub-parser-md-generator/epub"
+	"cli-epub-parser-md-generator/extract"
+	"cli-epub-parser-md-generator/llm"
+	"cli-epub-parser-md-generator/render"
+	"cli-epub-parser-md-generator/tokens"
+)
+
+// ProgressEvent reports a step of a Generator, for the console or the web UI.
+type ProgressEvent struct {
+	Chapter int           `json:"chapter,omitempty"`
+	Title   string        `json:"title,omitempty"`
+	Status  ChapterStatus `json:"status,omitempty"`
+	Message string        `json:"message"`
+	Usage   render.Usage  `json:"usage"`
+	Done    int           `json:"done"`
+	Total   int           `json:"total"`
+}
+
+// Generator rewrites the selected chapters of an extracted book and writes them in every format.
+type Generator struct {
+	Book     *epub.Book
+	BookName string // the file the book was read from, ex: book.epub
+	// Chapters are numbered from 1, as by epub.Book.Chapters, Selected lists the numbers to generate.
+	Chapters    []epub.SpineItem
+	Selected    []int
+	Convert     extract.Options
+	Generate    llm.Options
+	Formats     []string
+	OutputDir   string
+	Concurrency int
+	// Resume skips the chapters done by a previous run from the same input.
+	Resume bool
+	// Glossary gives the key terms of the book in glossary.yaml of the output folder to the
+	// model with every chapter, extracting them from all the chapters when the file does not
+	// exist, and flags the rewrites diverging from it.
+	Glossary bool
+	// Previously is the budget, in tokens, of the summaries of the chapters before each chapter
+	// added to its prompt, 0 to leave them out. The chapters are then generated one at a time,
+	// in order, so each one knows what the previous ones covered.
+	Previously int
+	// Verify checks the claims of every rewrite against its source chapter and writes a
+	// NN-title.verify.md report: llm.VerifyLocal matches them in the text, llm.VerifyJudge also
+	// asks the model about the ones not found. Empty to skip the check.
+	Verify   string
+	Cache    *llm.Cache
+	Client   *deepseek.Client
+	Progress func(ProgressEvent)
+
+	mu   sync.Mutex
+	done int
+}
+
+func (r *Generator) report(e ProgressEvent) {
+	r.mu.Lock()
+	if e.Status == StatusDone || e.Status == StatusFailed {
+		r.done++
+	}
+	e.Done, e.Total = r.done, len(r.Selected)
+	r.mu.Unlock()
+	if r.Progress != nil {
+		r.Progress(e)
+	}
+}
+
+// generateJob is a chapter waiting for, then holding, its rewrite.
+type generateJob struct {
+	number    int
+	item      epub.SpineItem
+	converted extract.Chapter
+	inputHash string
+	// resumed is set when the chapter was done by a previous run and is only written again
+	resumed bool
+	chapter render.Chapter
+	// warnings are the divergences of the rewrite from the glossary
+	warnings []string
+	// faithfulness is the check of the claims of the rewrite, with Verify
+	faithfulness *llm.FaithfulnessReport
+	err          error
+}
+
+// Run converts the chapters in order, sends them to the model with up to Concurrency requests
+// at the same time, then writes them in order. Every state change is saved to the manifest of
+// the output folder. The error lists the chapters that failed.
+func (r *Generator) Run(ctx context.Context) error {
+	// saved collects the files written for the current chapter, for the manifest
+	var saved []string
+	writers, err := render.NewAll(r.Formats, render.Options{
+		Dir:   r.OutputDir,
+		Book:  strings.TrimSuffix(filepath.Base(r.BookName), filepath.Ext(r.BookName)),
+		Style: r.Generate.Style,
+		WPM:   r.Generate.WPM,
+		OnSave: func(path string) {
+			saved = append(saved, path)
+			r.report(ProgressEvent{Message: "saved at: " + path})
+		},
+	})
+	if err != nil {
+		return err
+	}
+	manifest, err := LoadManifest(r.OutputDir)
+	if err != nil {
+		return err
+	}
+	manifest.Book, manifest.Style, manifest.Model, manifest.Formats = filepath.Base(r.BookName), r.Generate.Style, r.Generate.ModelName(), r.Formats
+	if err := manifest.Save(); err != nil {
+		return fmt.Errorf("error saving manifest: %w", err)
+	}
+	var jobs []generateJob
+	var messages []string
+	for _, number := range r.Selected {
+		item := r.Chapters[number-1]
+		opts := r.Convert
+		opts.Chapter, opts.RootDir = number, r.Book.Dir
+		converted, err := extract.ConvertFile(r.Book.Path(item.Href), opts)
+		if err != nil {
+			return err
+		}
+		for _, warning := range converted.Warnings {
+			r.report(ProgressEvent{Chapter: number, Title: item.Title, Message: fmt.Sprintf("chapter %d: %s", number, warning)})
+		}
+		if len(converted.Text) == 0 {
+			r.report(ProgressEvent{Chapter: number, Title: item.Title, Message: fmt.Sprintf("skipping empty chapter %d", number)})
+			continue
+		}
+		count, err := tokens.Count(converted.Text)
+		if err != nil {
+			return err
+		}
+		message := fmt.Sprintf("%d: %s, token length is: %d", number, item.Title, count)
+		if opts.Cleaner != nil {
+			rawOpts := opts
+			rawOpts.Cleaner = nil
+			rawOpts.AssetsDir = ""
+			if raw, err := extract.ConvertFile(r.Book.Path(item.Href), rawOpts); err == nil {
+				if rawCount, err := tokens.Count(raw.Text); err == nil {
+					message += fmt.Sprintf(", cleaning saved %d tokens (%d -> %d)", rawCount-count, rawCount, count)
+				}
+			}
+		}
+		jobs = append(jobs, generateJob{number: number, item: item, converted: converted})
+		messages = append(messages, message)
+	}
+	generate := r.Generate
+	generate.Recap = r.Previously > 0
+	workers := r.Concurrency
+	if r.Previously > 0 && workers > 1 {
+		workers = 1
+		r.report(ProgressEvent{Message: "generating the chapters one at a time, so each one gets the summaries of the previous ones"})
+	}
+	if r.Glossary {
+		if generate.Glossary, err = r.loadGlossary(); err != nil {
+			return err
+		}
+	}
+	for i := range jobs {
+		job, message := &jobs[i], messages[i]
+		number, item := job.number, job.item
+		job.inputHash = generate.InputHash(job.converted.Text)
+		if r.Resume {
+			if job.chapter, job.resumed = manifest.Completed(number, job.inputHash); job.resumed {
+				message = fmt.Sprintf("chapter %d already generated, skipping", number)
+			}
+		}
+		status := StatusPending
+		if !job.resumed {
+			err = manifest.Update(number, func(ch *ManifestChapter) {
+				ch.Title, ch.Source, ch.InputHash = item.Title, item.Href, job.inputHash
+				ch.Status, ch.Error, ch.Result, ch.Warnings, ch.Unsupported = StatusPending, "", nil, nil, nil
+			})
+			if err != nil {
+				return fmt.Errorf("error saving manifest: %w", err)
+			}
+		} else {
+			status = StatusDone
+		}
+		r.report(ProgressEvent{Chapter: number, Title: item.Title, Status: status, Message: message})
+	}
+	runJobs(jobs, workers, func(job *generateJob) {
+		if job.resumed {
+			return
+		}
+		if err := ctx.Err(); err != nil {
+			job.err = err
+		} else {
+			r.report(ProgressEvent{Chapter: job.number, Title: job.item.Title, Status: StatusRunning, Message: "Processing... " + job.item.Title})
+			if err := manifest.Update(job.number, func(ch *ManifestChapter) { ch.Status = StatusRunning }); err != nil {
+				r.report(ProgressEvent{Chapter: job.number, Message: "error saving manifest: " + err.Error()})
+			}
+			opts := generate
+			opts.OnMessage = func(message string) {
+				r.report(ProgressEvent{Chapter: job.number, Title: job.item.Title, Message: fmt.Sprintf("chapter %d: %s", job.number, message)})
+			}
+			if r.Previously > 0 {
+				// the summaries are left out of the input hash: they change when earlier chapters are
+				// generated again, which should not invalidate the chapters done after them
+				opts.Previously, job.err = manifest.Previously(job.number, r.Previously)
+			}
+			if job.err == nil {
+				job.chapter, job.err = llm.Generate(ctx, r.Client, r.Cache, opts, job.converted.Text)
+			}
+			if job.err == nil {
+				job.warnings = generate.Glossary.Check(job.converted.Text, job.chapter.Content)
+			}
+			if job.err == nil && len(r.Verify) > 0 {
+				var err error
+				job.faithfulness, err = llm.Verify(ctx, r.Client, r.Cache, opts, job.converted.Text, job.chapter.Narration, r.Verify == llm.VerifyJudge)
+				if err != nil {
+					r.report(ProgressEvent{Chapter: job.number, Title: job.item.Title, Message: fmt.Sprintf("chapter %d: %v, the faithfulness report only has the local check", job.number, err)})
+				}
+			}
+		}
+		err := manifest.Update(job.number, func(ch *ManifestChapter) {
+			if job.err != nil {
+				ch.Status, ch.Error = StatusFailed, job.err.Error()
+				return
+			}
+			ch.Status, ch.Usage, ch.Warnings = StatusDone, job.chapter.Usage, job.warnings
+			for _, claim := range job.faithfulness.Unsupported() {
+				ch.Unsupported = append(ch.Unsupported, claim.Text)
+			}
+			ch.Result = &Checkpoint{Title: job.chapter.Title, Content: job.chapter.Content, Narration: job.chapter.Narration, Summary: job.chapter.Summary}
+		})
+		if err != nil {
+			r.report(ProgressEvent{Chapter: job.number, Message: "error saving manifest: " + err.Error()})
+		}
+		if job.err != nil {
+			r.report(ProgressEvent{Chapter: job.number, Title: job.item.Title, Status: StatusFailed, Message: fmt.Sprintf("chapter %d: %v", job.number, job.err)})
+		} else {
+			r.report(ProgressEvent{Chapter: job.number, Title: job.item.Title, Status: StatusDone, Usage: job.chapter.Usage, Message: fmt.Sprintf("chapter %d: %s", job.number, job.chapter.Title)})
+		}
+		if len(job.warnings) > 0 {
+			r.report(ProgressEvent{Chapter: job.number, Title: job.item.Title, Message: fmt.Sprintf("chapter %d diverges from the glossary: %s", job.number, strings.Join(job.warnings, "; "))})
+		}
+	})
+	var failed []string
+	for _, job := range jobs {
+		if job.err != nil {
+			failed = append(failed, fmt.Sprint(job.number))
+			continue
+		}
+		chapter := job.chapter
+		chapter.Book = filepath.Base(r.BookName)
+		chapter.Chapter = filepath.Base(job.item.Href)
+		chapter.Index = job.number
+		chapter.Content += extract.FiguresMarkdown(job.converted.Figures)
+		saved = nil
+		for _, w := range writers {
+			if err := w.Write(chapter); err != nil {
+				return err
+			}
+		}
+		if job.faithfulness != nil {
+			path, err := r.writeFaithfulness(job, chapter)
+			if err != nil {
+				return err
+			}
+			saved = append(saved, path)
+		}
+		if err := manifest.Update(job.number, func(ch *ManifestChapter) { ch.Outputs = saved }); err != nil {
+			return fmt.Errorf("error saving manifest: %w", err)
+		}
+	}
+	for _, w := range writers {
+		if err := w.Close(); err != nil {
+			return err
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("chapters %s failed, run again with -resume to retry them", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// loadGlossary reads the glossary of the output folder, or extracts it from all the chapters of
+// the book, not only the selected ones, and saves it for the next runs and for the user to edit.
+func (r *Generator) loadGlossary() (*llm.Glossary, error) {
+	path := filepath.Join(r.OutputDir, llm.GlossaryName)
+	glossary, err := llm.LoadGlossary(path)
+	if err == nil {
+		r.report(ProgressEvent{Message: fmt.Sprintf("using the %d terms of the glossary %s", len(glossary.Terms), path)})
+		return glossary, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	var chapters []string
+	for i, item := range r.Chapters {
+		opts := r.Convert
+		opts.Chapter, opts.RootDir, opts.AssetsDir = i+1, r.Book.Dir, ""
+		converted, err := extract.ConvertFile(r.Book.Path(item.Href), opts)
+		if err != nil {
+			return nil, err
+		}
+		chapters = append(chapters, converted.Markdown)
+	}
+	glossary = llm.ExtractGlossary(chapters)
+	if err := glossary.Save(path); err != nil {
+		return nil, fmt.Errorf("error saving glossary: %w", err)
+	}
+	r.report(ProgressEvent{Message: fmt.Sprintf("extracted %d key terms to the glossary %s, edit it to change the terms given to the model", len(glossary.Terms), path)})
+	return glossary, nil
+}
+
+// writeFaithfulness writes the faithfulness report of the chapter of job next to its outputs,
+// and returns its path.
+func (r *Generator) writeFaithfulness(job generateJob, chapter render.Chapter) (string, error) {
+	path := filepath.Join(r.OutputDir, chapter.FileName()+".verify.md")
+	if err := os.WriteFile(path, []byte(job.faithfulness.Markdown(chapter.Title)), 0644); err != nil {
+		return "", fmt.Errorf("error writing faithfulness report: %w", err)
+	}
+	message := fmt.Sprintf("chapter %d: all %d claims found in the source", job.number, len(job.faithfulness.Claims))
+	if unsupported := len(job.faithfulness.Unsupported()); unsupported > 0 {
+		message = fmt.Sprintf("chapter %d: %d of %d claims not found in the source, see %s", job.number, unsupported, len(job.faithfulness.Claims), path)
+	}
+	r.report(ProgressEvent{Chapter: job.number, Title: job.item.Title, Message: message})
+	return path, nil
+}
+
+// runJobs calls run for every job with at most workers calls at the same time.
+func runJobs(jobs []generateJob, workers int, run func(job *generateJob)) {
+	sem := make(chan struct{}, max(workers, 1))
+	var wg sync.WaitGroup
+	for i := range jobs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(job *generateJob) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			// a panic in a worker would end the process without the deferred cleanup of main
+			defer func() {
+				if r := recover(); r != nil {
+					job.err = fmt.Errorf("panic: %v", r)
+				}
+			}()
+			run(job)
+		}(&jobs[i])
+	}
+	wg.Wait()
+}
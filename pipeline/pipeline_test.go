@@ -0,0 +1,252 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cohesion-org/deepseek-go"
+
+	"cli-epub-parser-md-generator/epub"
+	"cli-epub-parser-md-generator/internal/epubtest"
+	"cli-epub-parser-md-generator/internal/llmtest"
+	"cli-epub-parser-md-generator/llm"
+	"cli-epub-parser-md-generator/render"
+)
+
+func TestGenerateRun(t *testing.T) {
+	dir := t.TempDir()
+	epub.Extract(epubtest.Write(t, epubtest.Files), dir)
+	book, err := epub.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, calls := llmtest.NewFakeModel(t)
+	out := filepath.Join(t.TempDir(), "test-book")
+	newRun := func(resume bool) *Generator {
+		return &Generator{
+			Book:      book,
+			BookName:  "test-book.epub",
+			Chapters:  book.Chapters(false),
+			Selected:  []int{2, 3},
+			Generate:  llm.Options{Style: render.StyleBlog, WPM: render.DefaultWordsPerMinute},
+			Formats:   []string{render.FormatMarkdown, render.FormatEPUB},
+			OutputDir: out,
+			Resume:    resume,
+			Client:    client,
+		}
+	}
+	var events []ProgressEvent
+	run := newRun(false)
+	run.Progress = func(e ProgressEvent) { events = append(events, e) }
+	if err := run.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected 2 requests, got %d", calls.Load())
+	}
+	if last := events[len(events)-1]; last.Done != 2 || last.Total != 2 {
+		t.Errorf("unexpected progress %+v", last)
+	}
+	manifest, err := LoadManifest(out)
+	if err != nil || len(manifest.Chapters) != 2 || manifest.Chapters[0].Status != StatusDone || len(manifest.Chapters[0].Outputs) != 1 {
+		t.Fatalf("unexpected manifest %+v %v", manifest, err)
+	}
+	if _, err := os.Stat(manifest.Chapters[0].Outputs[0]); err != nil {
+		t.Error(err)
+	}
+
+	os.Remove(filepath.Join(out, "test-book-companion.epub"))
+	if err := newRun(true).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected the resumed run to skip the model, got %d requests", calls.Load())
+	}
+	if _, err := os.Stat(filepath.Join(out, "test-book-companion.epub")); err != nil {
+		t.Error("expected the resumed chapters to be written again")
+	}
+}
+
+func TestGenerateManifestError(t *testing.T) {
+	dir := t.TempDir()
+	epub.Extract(epubtest.Write(t, epubtest.Files), dir)
+	book, err := epub.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, _ := llmtest.NewFakeModel(t)
+	out := filepath.Join(t.TempDir(), "test-book")
+	failures := 0
+	run := &Generator{
+		Book:      book,
+		BookName:  "test-book.epub",
+		Chapters:  book.Chapters(false),
+		Selected:  []int{2},
+		Generate:  llm.Options{Style: render.StyleBlog, WPM: render.DefaultWordsPerMinute},
+		Formats:   []string{render.FormatMarkdown},
+		OutputDir: out,
+		Client:    client,
+		Progress: func(e ProgressEvent) {
+			if e.Status == StatusRunning {
+				// the manifest can't be saved from the start of the request on
+				os.Mkdir(filepath.Join(out, manifestName+".tmp"), 0755)
+			}
+			if strings.HasPrefix(e.Message, "error saving manifest") {
+				failures++
+			}
+		},
+	}
+	if err := run.Run(context.Background()); err == nil {
+		t.Error("expected an error saving the outputs to the manifest")
+	}
+	// the running status and the result of the chapter are lost
+	if failures != 2 {
+		t.Errorf("expected 2 errors saving the manifest to be reported, got %d", failures)
+	}
+}
+
+func TestGenerateGlossary(t *testing.T) {
+	dir := t.TempDir()
+	files := maps.Clone(epubtest.Files)
+	// the terms of the chapters left out of the first run are extracted too
+	files["OEBPS/text/ch02.xhtml"] = `<html><body><h1>Two</h1><p><i>Deep work</i> beats shallow work. Deep work is rare. Do deep work.</p></body></html>`
+	epub.Extract(epubtest.Write(t, files), dir)
+	book, err := epub.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, _ := llmtest.NewFakeModel(t)
+	out := filepath.Join(t.TempDir(), "test-book")
+	newRun := func() *Generator {
+		return &Generator{
+			Book:      book,
+			BookName:  "test-book.epub",
+			Chapters:  book.Chapters(false),
+			Selected:  []int{2},
+			Generate:  llm.Options{Style: render.StyleBlog, WPM: render.DefaultWordsPerMinute},
+			Formats:   []string{render.FormatMarkdown},
+			OutputDir: out,
+			Glossary:  true,
+			Client:    client,
+		}
+	}
+	if err := newRun().Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(out, llm.GlossaryName)
+	if glossary, err := llm.LoadGlossary(path); err != nil || len(glossary.Terms) != 1 || glossary.Terms[0].Term != "Deep work" {
+		t.Fatalf("expected the glossary to be extracted from every chapter, got %+v %v", glossary, err)
+	}
+	// the fake model always answers "Rewritten text."
+	glossary := &llm.Glossary{Terms: []llm.GlossaryTerm{{Term: "chapter text", Avoid: []string{"rewritten text"}}}}
+	if err := glossary.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	run := newRun()
+	run.Progress = func(e ProgressEvent) { messages = append(messages, e.Message) }
+	if err := run.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := LoadManifest(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{`uses "rewritten text" instead of "chapter text"`}; !slices.Equal(manifest.Chapters[0].Warnings, want) {
+		t.Errorf("expected warnings %q, got %q", want, manifest.Chapters[0].Warnings)
+	}
+	if !slices.ContainsFunc(messages, func(m string) bool { return strings.Contains(m, "diverges from the glossary") }) {
+		t.Errorf("expected the warnings to be reported, got %q", messages)
+	}
+	if !slices.Contains(messages, "saved at: "+filepath.Join(out, "02-post-2.md")) {
+		t.Errorf("expected the saved files to be reported, got %q", messages)
+	}
+}
+
+func TestGeneratePreviously(t *testing.T) {
+	dir := t.TempDir()
+	epub.Extract(epubtest.Write(t, epubtest.Files), dir)
+	book, err := epub.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var prompts []string
+	client, _ := llmtest.NewFakeModelFunc(t, func(n int, messages []deepseek.ChatCompletionMessage) map[string]any {
+		prompts = append(prompts, messages[0].Content)
+		return map[string]any{"title": fmt.Sprintf("Post %d", n), "content": "Rewritten text.", "summary": fmt.Sprintf("Summary %d.", n)}
+	})
+	out := filepath.Join(t.TempDir(), "test-book")
+	run := &Generator{
+		Book:        book,
+		BookName:    "test-book.epub",
+		Chapters:    book.Chapters(false),
+		Selected:    []int{2, 3},
+		Generate:    llm.Options{Style: render.StyleBlog, WPM: render.DefaultWordsPerMinute},
+		Formats:     []string{render.FormatMarkdown},
+		OutputDir:   out,
+		Concurrency: 4,
+		Previously:  500,
+		Client:      client,
+	}
+	if err := run.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(prompts) != 2 || !strings.Contains(prompts[0], `"summary"`) || strings.Contains(prompts[0], "Previously:") {
+		t.Fatalf("expected the first prompt to ask for a summary only, got %q", prompts)
+	}
+	if want := "Previously:\n- Chapter 2, Post 1: Summary 1.\n"; !strings.Contains(prompts[1], want) {
+		t.Errorf("expected %q in the second prompt %q", want, prompts[1])
+	}
+	manifest, err := LoadManifest(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary := manifest.Chapters[1].Result.Summary; summary != "Summary 2." {
+		t.Errorf("expected the summary in the manifest, got %q", summary)
+	}
+}
+
+func TestGenerateVerify(t *testing.T) {
+	dir := t.TempDir()
+	epub.Extract(epubtest.Write(t, epubtest.Files), dir)
+	book, err := epub.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, _ := llmtest.NewFakeModelFunc(t, func(n int, messages []deepseek.ChatCompletionMessage) map[string]any {
+		return map[string]any{"title": "Post", "content": "The first chapter brings 42% more text."}
+	})
+	out := filepath.Join(t.TempDir(), "test-book")
+	run := &Generator{
+		Book:      book,
+		BookName:  "test-book.epub",
+		Chapters:  book.Chapters(false),
+		Selected:  []int{2},
+		Generate:  llm.Options{Style: render.StyleBlog, WPM: render.DefaultWordsPerMinute},
+		Formats:   []string{render.FormatMarkdown},
+		OutputDir: out,
+		Verify:    llm.VerifyLocal,
+		Client:    client,
+	}
+	if err := run.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := LoadManifest(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := manifest.Chapters[0]
+	if !slices.Equal(ch.Unsupported, []string{"42%"}) || len(ch.Outputs) != 2 || ch.Outputs[1] != filepath.Join(out, "02-post.verify.md") {
+		t.Fatalf("unexpected manifest chapter %+v", ch)
+	}
+	report, err := os.ReadFile(ch.Outputs[1])
+	if err != nil || !strings.Contains(string(report), "- **42%** (number): The first chapter brings **42%** more text.") {
+		t.Errorf("unexpected report %q %v", report, err)
+	}
+}
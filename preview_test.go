@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBookFileServer(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, ".env"), []byte("DEEPSEEK_API_KEY=secret"), 0644)
+	dir := filepath.Join(root, "book")
+	os.MkdirAll(filepath.Join(dir, "OEBPS", "fonts"), 0755)
+	for name, content := range map[string]string{"OEBPS/ch01.xhtml": "<html/>", "OEBPS/style.css": "p {}", "OEBPS/fonts/serif.woff2": "font"} {
+		os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	}
+	server := httptest.NewServer(http.StripPrefix("/book/", bookFileServer(os.DirFS(dir))))
+	defer server.Close()
+
+	for name, contentType := range map[string]string{"OEBPS/ch01.xhtml": "application/xhtml+xml", "OEBPS/style.css": "text/css; charset=utf-8", "OEBPS/fonts/serif.woff2": "font/woff2"} {
+		resp, err := http.Get(server.URL + "/book/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != contentType || resp.Header.Get("Content-Security-Policy") != "sandbox" {
+			t.Errorf("%s: unexpected response %d %q", name, resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+	}
+	for _, path := range []string{"/book/../.env", "/book/..%2f.env", "/book/%2e%2e/.env"} {
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		req.URL.Opaque = path
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := make([]byte, 64)
+		n, _ := resp.Body.Read(body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK || strings.Contains(string(body[:n]), "secret") {
+			t.Errorf("%s: expected the file outside of the book to be refused, got %d", path, resp.StatusCode)
+		}
+	}
+	resp, err := http.Post(server.URL+"/book/OEBPS/ch01.xhtml", "text/plain", strings.NewReader("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected the book to be read-only, got %d", resp.StatusCode)
+	}
+}
+
+func TestListen(t *testing.T) {
+	taken, err := listen("127.0.0.1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	port := taken.Addr().(*net.TCPAddr).Port
+	listener, err := listen("127.0.0.1", port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if addr := listener.Addr().(*net.TCPAddr); addr.Port == port || !addr.IP.IsLoopback() {
+		t.Errorf("expected another port on the loopback address, got %s", addr)
+	}
+	if !isLoopback("localhost") || !isLoopback("::1") || isLoopback("0.0.0.0") || isLoopback("") {
+		t.Error("unexpected isLoopback")
+	}
+}
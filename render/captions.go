@@ -0,0 +1,170 @@
+package render
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	ExportTeleprompter string = "teleprompter"
+	ExportSRT          string = "srt"
+	ExportVTT          string = "vtt"
+)
+
+const (
+	teleprompterLineWidth int = 32
+	captionLineWidth      int = 42
+	captionMaxLines       int = 2
+	minCueDuration            = time.Second
+)
+
+// Cue is a single subtitle shown between Start and End.
+type Cue struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+var sentenceEndRe = regexp.MustCompile(`([.!?…]["'”’)]*)\s+`)
+
+// splitSentences splits text after sentence punctuation, keeping the punctuation.
+func splitSentences(text string) []string {
+	var sentences []string
+	text = sentenceEndRe.ReplaceAllString(strings.TrimSpace(text), "$1\n")
+	for _, sentence := range strings.Split(text, "\n") {
+		if sentence = strings.TrimSpace(sentence); len(sentence) > 0 {
+			sentences = append(sentences, sentence)
+		}
+	}
+	return sentences
+}
+
+// wrapWords greedily wraps text into lines of at most width characters.
+// A single word longer than width gets a line of its own.
+func wrapWords(text string, width int) []string {
+	var lines []string
+	var line string
+	for _, word := range strings.Fields(text) {
+		if len(line) > 0 && len(line)+1+len(word) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if len(line) > 0 {
+			line += " "
+		}
+		line += word
+	}
+	if len(line) > 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// spokenParagraphs returns the text that is read aloud, one entry per paragraph.
+// Headings, rules, code blocks and tables are not part of the narration.
+func spokenParagraphs(content string) []string {
+	var paragraphs []string
+	for _, block := range ParseMarkdown(content) {
+		switch block.Kind {
+		case BlockParagraph, BlockListItem, BlockQuote:
+			if text := strings.TrimSpace(block.PlainText()); len(text) > 0 {
+				paragraphs = append(paragraphs, text)
+			}
+		}
+	}
+	return paragraphs
+}
+
+// teleprompterText renders content as short lines with one sentence per line group,
+// section titles in capitals and a pause marker between paragraphs.
+func teleprompterText(content string) string {
+	var b strings.Builder
+	for _, block := range ParseMarkdown(content) {
+		switch block.Kind {
+		case BlockHeading:
+			b.WriteString("\n" + strings.ToUpper(strings.TrimSpace(block.PlainText())) + "\n\n")
+		case BlockParagraph, BlockListItem, BlockQuote:
+			for _, sentence := range splitSentences(block.PlainText()) {
+				b.WriteString(strings.Join(wrapWords(sentence, teleprompterLineWidth), "\n") + "\n")
+			}
+			b.WriteString("\n[pause]\n\n")
+		}
+	}
+	return strings.TrimSpace(b.String()) + "\n"
+}
+
+// buildCues splits the narration into caption sized cues timed at wpm words per minute.
+func buildCues(content string, wpm int) []Cue {
+	var cues []Cue
+	var start time.Duration
+	maxChars := captionLineWidth * captionMaxLines
+	for _, paragraph := range spokenParagraphs(content) {
+		for _, sentence := range splitSentences(paragraph) {
+			var chunk []string
+			emit := func() {
+				if len(chunk) == 0 {
+					return
+				}
+				text := strings.Join(chunk, " ")
+				d := estimateDuration(text, wpm)
+				if d < minCueDuration {
+					d = minCueDuration
+				}
+				cues = append(cues, Cue{Start: start, End: start + d, Text: strings.Join(wrapWords(text, captionLineWidth), "\n")})
+				start += d
+				chunk = nil
+			}
+			for _, word := range strings.Fields(sentence) {
+				if len(strings.Join(append(chunk, word), " ")) > maxChars {
+					emit()
+				}
+				chunk = append(chunk, word)
+			}
+			emit()
+		}
+	}
+	return cues
+}
+
+func formatCueTime(d time.Duration, sep string) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+// toSRT renders cues as a SubRip file.
+func toSRT(cues []Cue) string {
+	var b strings.Builder
+	for i, cue := range cues {
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", i+1, formatCueTime(cue.Start, ","), formatCueTime(cue.End, ","), cue.Text)
+	}
+	return b.String()
+}
+
+// toVTT renders cues as a WebVTT file.
+func toVTT(cues []Cue) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n")
+	for i, cue := range cues {
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", i+1, formatCueTime(cue.Start, "."), formatCueTime(cue.End, "."), cue.Text)
+	}
+	return b.String()
+}
+
+// ParseExports validates the comma separated -export flag.
+func ParseExports(value string) ([]string, error) {
+	var exports []string
+	for _, export := range strings.Split(value, ",") {
+		export = strings.TrimSpace(strings.ToLower(export))
+		switch export {
+		case "":
+			continue
+		case ExportTeleprompter, ExportSRT, ExportVTT:
+			exports = append(exports, export)
+		default:
+			return nil, fmt.Errorf("unknown export %q, expected %s, %s or %s", export, ExportTeleprompter, ExportSRT, ExportVTT)
+		}
+	}
+	return exports, nil
+}
@@ -0,0 +1,59 @@
+package render
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitSentences(t *testing.T) {
+	got := splitSentences("Hello there. How are you? \"Fine!\" she said.")
+	want := []string{"Hello there.", "How are you?", "\"Fine!\"", "she said."}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestTeleprompterText(t *testing.T) {
+	out := teleprompterText("## Intro\n\nThis is a sentence that is long enough to wrap around. Short one.\n\nNext paragraph.")
+	if !strings.HasPrefix(out, "INTRO\n\n") {
+		t.Errorf("expected heading first, got %q", out)
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if len(line) > teleprompterLineWidth {
+			t.Errorf("line too long: %q", line)
+		}
+	}
+	if strings.Count(out, "[pause]") != 2 {
+		t.Errorf("expected a pause per paragraph, got %q", out)
+	}
+}
+
+func TestBuildCuesSRTAndVTT(t *testing.T) {
+	content := "# Title\n\n" + strings.Repeat("word ", 20) + "end.\n\n```\nnot spoken\n```\n"
+	cues := buildCues(content, 60)
+	if len(cues) != 2 {
+		t.Fatalf("expected 2 cues, got %d: %+v", len(cues), cues)
+	}
+	if cues[0].Start != 0 || cues[1].Start != cues[0].End || cues[1].End != 21*time.Second {
+		t.Errorf("unexpected timings %+v", cues)
+	}
+	srt := toSRT(cues)
+	if !strings.HasPrefix(srt, "1\n00:00:00,000 --> 00:00:") {
+		t.Errorf("unexpected srt:\n%s", srt)
+	}
+	vtt := toVTT(cues)
+	if !strings.HasPrefix(vtt, "WEBVTT\n\n1\n00:00:00.000 --> ") || strings.Contains(vtt, "not spoken") {
+		t.Errorf("unexpected vtt:\n%s", vtt)
+	}
+}
+
+func TestParseExports(t *testing.T) {
+	exports, err := ParseExports("srt, VTT,teleprompter")
+	if err != nil || len(exports) != 3 {
+		t.Errorf("unexpected result %v %v", exports, err)
+	}
+	if _, err := ParseExports("docx"); err == nil {
+		t.Error("expected an error for an unknown export")
+	}
+}
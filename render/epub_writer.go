@@ -0,0 +1,217 @@
+package render
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const epubContainerXML string = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+var epubTemplates = template.Must(template.New("epub").Funcs(template.FuncMap{
+	"xml": escapeXML,
+	"inc": func(i int) int { return i + 1 },
+}).Parse(`
+{{define "opf"}}<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="book-id">urn:uuid:{{.ID}}</dc:identifier>
+    <dc:title>{{xml .Title}}</dc:title>
+    <dc:language>en</dc:language>
+    <meta property="dcterms:modified">{{.Modified}}</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+{{range .Chapters}}    <item id="{{.ID}}" href="{{.Href}}" media-type="application/xhtml+xml"/>
+{{end}}{{range $i, $a := .Assets}}    <item id="asset-{{inc $i}}" href="{{xml $a.Href}}" media-type="{{$a.MediaType}}"/>
+{{end}}  </manifest>
+  <spine toc="ncx">
+{{range .Chapters}}    <itemref idref="{{.ID}}"/>
+{{end}}  </spine>
+</package>
+{{end}}
+{{define "nav"}}<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="en" xml:lang="en">
+<head><title>{{xml .Title}}</title></head>
+<body>
+  <nav epub:type="toc" id="toc">
+    <h1>{{xml .Title}}</h1>
+    <ol>
+{{range .Chapters}}      <li><a href="{{.Href}}">{{xml .Title}}</a></li>
+{{end}}    </ol>
+  </nav>
+</body>
+</html>
+{{end}}
+{{define "ncx"}}<?xml version="1.0" encoding="UTF-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+  <head><meta name="dtb:uid" content="urn:uuid:{{.ID}}"/></head>
+  <docTitle><text>{{xml .Title}}</text></docTitle>
+  <navMap>
+{{range $i, $ch := .Chapters}}    <navPoint id="nav-{{$ch.ID}}" playOrder="{{inc $i}}"><navLabel><text>{{xml $ch.Title}}</text></navLabel><content src="{{$ch.Href}}"/></navPoint>
+{{end}}  </navMap>
+</ncx>
+{{end}}
+{{define "chapter"}}<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" lang="en" xml:lang="en">
+<head><title>{{xml .Title}}</title></head>
+<body>
+{{.Body}}</body>
+</html>
+{{end}}`))
+
+// epubFile is a file of the package rendered from one of epubTemplates.
+type epubFile struct {
+	name     string
+	template string
+	data     any
+}
+
+// epubAsset is an image referenced by a chapter, packaged next to the chapters.
+type epubAsset struct {
+	Href      string
+	MediaType string
+}
+
+type epubChapter struct {
+	ID    string
+	Href  string
+	Title string
+	Body  string
+}
+
+// epubWriter packages every chapter of the run into a companion EPUB 3 book,
+// with an EPUB 2 NCX for older readers.
+type epubWriter struct {
+	opts     Options
+	chapters []epubChapter
+	assets   []epubAsset
+}
+
+var imageMediaTypes = map[string]string{
+	".gif":  "image/gif",
+	".jpeg": "image/jpeg",
+	".jpg":  "image/jpeg",
+	".png":  "image/png",
+	".svg":  "image/svg+xml",
+	".webp": "image/webp",
+}
+
+func (w *epubWriter) Write(ch Chapter) error {
+	body, _ := MarkdownToHTML(ch.Content)
+	for _, block := range ParseMarkdown(ch.Content) {
+		for _, inline := range block.Inlines {
+			if inline.Kind == InlineImage {
+				w.addAsset(inline.URL)
+			}
+		}
+	}
+	n := len(w.chapters) + 1
+	w.chapters = append(w.chapters, epubChapter{
+		ID:    fmt.Sprintf("chapter-%03d", n),
+		Href:  fmt.Sprintf("chapter-%03d.xhtml", n),
+		Title: ch.Title,
+		Body:  body,
+	})
+	return nil
+}
+
+// addAsset records an image exported to the output folder, remote and missing images are left out.
+func (w *epubWriter) addAsset(href string) {
+	mediaType, ok := imageMediaTypes[strings.ToLower(path.Ext(href))]
+	if !ok || strings.Contains(href, "://") || strings.HasPrefix(path.Clean(href), "..") {
+		return
+	}
+	for _, a := range w.assets {
+		if a.Href == href {
+			return
+		}
+	}
+	if _, err := os.Stat(filepath.Join(w.opts.Dir, filepath.FromSlash(href))); err != nil {
+		return
+	}
+	w.assets = append(w.assets, epubAsset{Href: href, MediaType: mediaType})
+}
+
+func (w *epubWriter) Close() error {
+	if len(w.chapters) == 0 {
+		return nil
+	}
+	data := struct {
+		ID       string
+		Title    string
+		Modified string
+		Chapters []epubChapter
+		Assets   []epubAsset
+	}{newUUID(), w.opts.Book + " (companion)", time.Now().UTC().Format("2006-01-02T15:04:05Z"), w.chapters, w.assets}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	// the mimetype entry must come first and be stored without compression
+	mimetype, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	mimetype.Write([]byte("application/epub+zip"))
+	files := []epubFile{
+		{"OEBPS/content.opf", "opf", data},
+		{"OEBPS/nav.xhtml", "nav", data},
+		{"OEBPS/toc.ncx", "ncx", data},
+	}
+	for _, ch := range w.chapters {
+		files = append(files, epubFile{"OEBPS/" + ch.Href, "chapter", ch})
+	}
+	f, err := zw.Create("META-INF/container.xml")
+	if err != nil {
+		return err
+	}
+	f.Write([]byte(epubContainerXML))
+	for _, file := range files {
+		f, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if err := epubTemplates.ExecuteTemplate(f, file.template, file.data); err != nil {
+			return err
+		}
+	}
+	for _, a := range w.assets {
+		content, err := os.ReadFile(filepath.Join(w.opts.Dir, filepath.FromSlash(a.Href)))
+		if err != nil {
+			return err
+		}
+		f, err := zw.Create("OEBPS/" + a.Href)
+		if err != nil {
+			return err
+		}
+		f.Write(content)
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return w.opts.save(Slugify(w.opts.Book)+"-companion.epub", buf.String())
+}
+
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
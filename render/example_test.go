@@ -0,0 +1,16 @@
+package render_test
+
+import (
+	"fmt"
+
+	"cli-epub-parser-md-generator/render"
+)
+
+func ExampleParseMarkdown() {
+	for _, block := range render.ParseMarkdown("# Loops\n\nA **for** loop [repeats](https://go.dev).") {
+		fmt.Println(block.PlainText())
+	}
+	// Output:
+	// Loops
+	// A for loop repeats.
+}
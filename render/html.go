@@ -0,0 +1,216 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"html/template"
+	"net/url"
+	"strings"
+)
+
+// TOCEntry is a heading of a rendered chapter, linked by its anchor id.
+type TOCEntry struct {
+	Level int
+	Title string
+	ID    string
+}
+
+// safeURL returns raw when it is relative or an http, https or mailto URL, "#" otherwise: the
+// Markdown comes from the model and its links must not run script, ex: javascript:alert(1).
+func safeURL(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "#"
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https", "mailto":
+		return raw
+	}
+	return "#"
+}
+
+func inlinesToHTML(inlines []MarkdownInline) string {
+	var b strings.Builder
+	for _, inline := range inlines {
+		text := html.EscapeString(inline.Text)
+		switch inline.Kind {
+		case InlineStrong:
+			fmt.Fprintf(&b, "<strong>%s</strong>", text)
+		case InlineEmphasis:
+			fmt.Fprintf(&b, "<em>%s</em>", text)
+		case InlineCode:
+			fmt.Fprintf(&b, "<code>%s</code>", text)
+		case InlineLink:
+			fmt.Fprintf(&b, `<a href="%s">%s</a>`, html.EscapeString(safeURL(inline.URL)), text)
+		case InlineImage:
+			fmt.Fprintf(&b, `<img src="%s" alt="%s"/>`, html.EscapeString(safeURL(inline.URL)), text)
+		default:
+			b.WriteString(text)
+		}
+	}
+	return b.String()
+}
+
+// MarkdownToHTML renders Markdown as an HTML fragment and returns the headings for a table
+// of contents. The output is also well formed XHTML, so the EPUB writer can reuse it.
+func MarkdownToHTML(content string) (string, []TOCEntry) {
+	var b strings.Builder
+	var toc []TOCEntry
+	ids := map[string]int{}
+	inList := false
+	for _, block := range ParseMarkdown(content) {
+		if inList && block.Kind != BlockListItem {
+			b.WriteString("</ul>\n")
+			inList = false
+		}
+		switch block.Kind {
+		case BlockHeading:
+			title := block.PlainText()
+			id := Slugify(title)
+			if n := ids[id]; n > 0 {
+				id = fmt.Sprintf("%s-%d", id, n)
+			}
+			ids[Slugify(title)]++
+			toc = append(toc, TOCEntry{Level: block.Level, Title: title, ID: id})
+			fmt.Fprintf(&b, "<h%d id=\"%s\">%s</h%d>\n", block.Level, id, inlinesToHTML(block.Inlines), block.Level)
+		case BlockParagraph:
+			fmt.Fprintf(&b, "<p>%s</p>\n", inlinesToHTML(block.Inlines))
+		case BlockListItem:
+			if !inList {
+				b.WriteString("<ul>\n")
+				inList = true
+			}
+			fmt.Fprintf(&b, "<li>%s</li>\n", inlinesToHTML(block.Inlines))
+		case BlockQuote:
+			fmt.Fprintf(&b, "<blockquote><p>%s</p></blockquote>\n", inlinesToHTML(block.Inlines))
+		case BlockCode:
+			fmt.Fprintf(&b, "<pre><code>%s</code></pre>\n", html.EscapeString(block.Text))
+		case BlockRule:
+			b.WriteString("<hr/>\n")
+		case BlockTable:
+			b.WriteString("<table>\n")
+			for i, row := range block.Rows {
+				cell := "td"
+				if i == 0 {
+					cell = "th"
+				}
+				b.WriteString("<tr>")
+				for _, inlines := range row {
+					fmt.Fprintf(&b, "<%s>%s</%s>", cell, inlinesToHTML(inlines), cell)
+				}
+				b.WriteString("</tr>\n")
+			}
+			b.WriteString("</table>\n")
+		}
+	}
+	if inList {
+		b.WriteString("</ul>\n")
+	}
+	return b.String(), toc
+}
+
+var htmlChapterTemplate = template.Must(template.New("chapter").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { max-width: 42em; margin: 2em auto; padding: 0 1em; font-family: Georgia, serif; line-height: 1.6; color: #222; }
+nav.toc { border: 1px solid #ddd; padding: .5em 1em; margin-bottom: 2em; }
+nav.toc ul { list-style: none; padding-left: 1em; margin: 0; }
+pre { background: #f5f5f5; padding: 1em; overflow-x: auto; }
+blockquote { border-left: 3px solid #ccc; margin-left: 0; padding-left: 1em; color: #555; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ddd; padding: .3em .6em; }
+img { max-width: 100%; }
+</style>
+</head>
+<body>
+{{if .TOC}}<nav class="toc">
+<strong>Contents</strong>
+<ul>
+{{range .TOC}}<li style="margin-left: {{.Indent}}em"><a href="#{{.ID}}">{{.Title}}</a></li>
+{{end}}</ul>
+</nav>
+{{end}}<article>
+{{.Body}}</article>
+</body>
+</html>
+`))
+
+var htmlIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Book}}</title>
+<style>body { max-width: 42em; margin: 2em auto; padding: 0 1em; font-family: Georgia, serif; line-height: 1.6; }</style>
+</head>
+<body>
+<h1>{{.Book}}</h1>
+<ol>
+{{range .Chapters}}<li><a href="{{.Href}}">{{.Title}}</a></li>
+{{end}}</ol>
+</body>
+</html>
+`))
+
+type htmlTOCItem struct {
+	Title  string
+	ID     string
+	Indent int
+}
+
+type htmlIndexItem struct {
+	Title string
+	Href  string
+}
+
+// htmlWriter writes every chapter as a standalone HTML page with a table of contents,
+// and an index.html linking the chapters of the run.
+type htmlWriter struct {
+	opts     Options
+	chapters []htmlIndexItem
+}
+
+func (w *htmlWriter) Write(ch Chapter) error {
+	body, headings := MarkdownToHTML(ch.Content)
+	var toc []htmlTOCItem
+	if len(headings) > 1 {
+		top := headings[0].Level
+		for _, h := range headings {
+			if h.Level < top {
+				top = h.Level
+			}
+		}
+		for _, h := range headings {
+			toc = append(toc, htmlTOCItem{Title: h.Title, ID: h.ID, Indent: h.Level - top})
+		}
+	}
+	var buf bytes.Buffer
+	err := htmlChapterTemplate.Execute(&buf, struct {
+		Title string
+		TOC   []htmlTOCItem
+		Body  template.HTML
+	}{ch.Title, toc, template.HTML(body)})
+	if err != nil {
+		return err
+	}
+	w.chapters = append(w.chapters, htmlIndexItem{Title: ch.Title, Href: ch.FileName() + ".html"})
+	return w.opts.save(ch.FileName()+".html", buf.String())
+}
+
+func (w *htmlWriter) Close() error {
+	if len(w.chapters) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	err := htmlIndexTemplate.Execute(&buf, struct {
+		Book     string
+		Chapters []htmlIndexItem
+	}{w.opts.Book, w.chapters})
+	if err != nil {
+		return err
+	}
+	return w.opts.save("index.html", buf.String())
+}
@@ -0,0 +1,215 @@
+package render
+
+import (
+	"regexp"
+	"strings"
+)
+
+// MarkdownBlockKind is the type of a top level Markdown block.
+type MarkdownBlockKind uint
+
+const (
+	BlockParagraph MarkdownBlockKind = iota
+	BlockHeading
+	BlockListItem
+	BlockQuote
+	BlockCode
+	BlockRule
+	BlockTable
+)
+
+// MarkdownInlineKind is the type of a span inside a block.
+type MarkdownInlineKind uint
+
+const (
+	InlineText MarkdownInlineKind = iota
+	InlineStrong
+	InlineEmphasis
+	InlineCode
+	InlineLink
+	InlineImage
+)
+
+// MarkdownInline is a run of text with a single style. Nested styles are flattened.
+type MarkdownInline struct {
+	Kind MarkdownInlineKind
+	Text string
+	URL  string
+}
+
+// MarkdownBlock is a heading, paragraph, list item, quote, code block, rule or table.
+// Code blocks keep their raw text in Text, tables their cells in Rows, the header row first,
+// every other block is split into Inlines.
+type MarkdownBlock struct {
+	Kind    MarkdownBlockKind
+	Level   int
+	Text    string
+	Inlines []MarkdownInline
+	Rows    [][][]MarkdownInline
+}
+
+// PlainText returns the block text without any Markdown markup. The cells of a table are
+// separated by " | ", its rows by new lines.
+func (b MarkdownBlock) PlainText() string {
+	switch b.Kind {
+	case BlockCode:
+		return b.Text
+	case BlockTable:
+		var rows []string
+		for _, row := range b.Rows {
+			var cells []string
+			for _, cell := range row {
+				cells = append(cells, inlinesText(cell))
+			}
+			rows = append(rows, strings.Join(cells, " | "))
+		}
+		return strings.Join(rows, "\n")
+	}
+	return inlinesText(b.Inlines)
+}
+
+func inlinesText(inlines []MarkdownInline) string {
+	var sb strings.Builder
+	for _, inline := range inlines {
+		sb.WriteString(inline.Text)
+	}
+	return sb.String()
+}
+
+var (
+	headingRe  = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	listItemRe = regexp.MustCompile(`^\s*(?:[-*+]|\d+[.)])\s+(.*)$`)
+	ruleRe     = regexp.MustCompile(`^\s*(?:(?:-\s*){3,}|(?:\*\s*){3,}|(?:_\s*){3,})$`)
+	// tableDelimiterRe is the line under the header row of a table, ex: | --- | :-: |
+	tableDelimiterRe = regexp.MustCompile(`^\|(?:\s*:?-+:?\s*\|)*\s*:?-+:?\s*\|?$`)
+)
+
+// ParseMarkdown splits Markdown text into blocks. It understands the subset of
+// Markdown the models return: ATX headings, paragraphs, lists, quotes, fenced
+// code, rules, tables with a header row and the common inline styles.
+func ParseMarkdown(src string) []MarkdownBlock {
+	var blocks []MarkdownBlock
+	var paragraph []string
+	flush := func() {
+		if len(paragraph) == 0 {
+			return
+		}
+		blocks = append(blocks, MarkdownBlock{Kind: BlockParagraph, Inlines: parseInlines(strings.Join(paragraph, " "))})
+		paragraph = nil
+	}
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			flush()
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			flush()
+			fence := trimmed[:3]
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), fence); i++ {
+				code = append(code, lines[i])
+			}
+			blocks = append(blocks, MarkdownBlock{Kind: BlockCode, Text: strings.Join(code, "\n")})
+		case headingRe.MatchString(trimmed):
+			flush()
+			m := headingRe.FindStringSubmatch(trimmed)
+			blocks = append(blocks, MarkdownBlock{Kind: BlockHeading, Level: len(m[1]), Inlines: parseInlines(m[2])})
+		case ruleRe.MatchString(trimmed):
+			flush()
+			blocks = append(blocks, MarkdownBlock{Kind: BlockRule})
+		case listItemRe.MatchString(line):
+			flush()
+			m := listItemRe.FindStringSubmatch(line)
+			blocks = append(blocks, MarkdownBlock{Kind: BlockListItem, Inlines: parseInlines(m[1])})
+		case strings.HasPrefix(trimmed, ">"):
+			flush()
+			var quote []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				quote = append(quote, strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")))
+			}
+			i--
+			blocks = append(blocks, MarkdownBlock{Kind: BlockQuote, Inlines: parseInlines(strings.Join(quote, " "))})
+		case strings.HasPrefix(trimmed, "|") && i+1 < len(lines) && tableDelimiterRe.MatchString(strings.TrimSpace(lines[i+1])):
+			flush()
+			rows := [][][]MarkdownInline{tableCells(trimmed)}
+			for i += 2; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), "|"); i++ {
+				rows = append(rows, tableCells(strings.TrimSpace(lines[i])))
+			}
+			i--
+			blocks = append(blocks, MarkdownBlock{Kind: BlockTable, Rows: rows})
+		default:
+			paragraph = append(paragraph, trimmed)
+		}
+	}
+	flush()
+	return blocks
+}
+
+// tableCells splits a table row into its cells, at the pipes that are not escaped.
+func tableCells(row string) [][]MarkdownInline {
+	row = strings.TrimPrefix(row, "|")
+	if strings.HasSuffix(row, "|") && !strings.HasSuffix(row, `\|`) {
+		row = row[:len(row)-1]
+	}
+	var cells [][]MarkdownInline
+	var cell strings.Builder
+	for i := 0; i < len(row); i++ {
+		switch {
+		case row[i] == '\\' && i+1 < len(row) && row[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case row[i] == '|':
+			cells = append(cells, parseInlines(strings.TrimSpace(cell.String())))
+			cell.Reset()
+		default:
+			cell.WriteByte(row[i])
+		}
+	}
+	return append(cells, parseInlines(strings.TrimSpace(cell.String())))
+}
+
+var inlineRe = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]*)[^)]*\)|\[([^\]]+)\]\(([^)\s]*)[^)]*\)|` + "`([^`]+)`" + `|\*\*(.+?)\*\*|__(.+?)__|\*([^*\s][^*]*?)\*|\b_([^_\s][^_]*?)_\b|\\([\\` + "`" + `*_\[\]])`)
+
+func parseInlines(text string) []MarkdownInline {
+	var inlines []MarkdownInline
+	last := 0
+	for _, m := range inlineRe.FindAllStringSubmatchIndex(text, -1) {
+		if m[0] > last {
+			inlines = append(inlines, MarkdownInline{Kind: InlineText, Text: text[last:m[0]]})
+		}
+		group := func(n int) string { return text[m[2*n]:m[2*n+1]] }
+		switch {
+		case m[2] >= 0:
+			inlines = append(inlines, MarkdownInline{Kind: InlineImage, Text: group(1), URL: group(2)})
+		case m[6] >= 0:
+			inlines = append(inlines, MarkdownInline{Kind: InlineLink, Text: group(3), URL: group(4)})
+		case m[10] >= 0:
+			inlines = append(inlines, MarkdownInline{Kind: InlineCode, Text: group(5)})
+		case m[12] >= 0:
+			inlines = append(inlines, MarkdownInline{Kind: InlineStrong, Text: stripInlineMarkup(group(6))})
+		case m[14] >= 0:
+			inlines = append(inlines, MarkdownInline{Kind: InlineStrong, Text: stripInlineMarkup(group(7))})
+		case m[16] >= 0:
+			inlines = append(inlines, MarkdownInline{Kind: InlineEmphasis, Text: stripInlineMarkup(group(8))})
+		case m[18] >= 0:
+			inlines = append(inlines, MarkdownInline{Kind: InlineEmphasis, Text: stripInlineMarkup(group(9))})
+		case m[20] >= 0:
+			inlines = append(inlines, MarkdownInline{Kind: InlineText, Text: group(10)})
+		}
+		last = m[1]
+	}
+	if last < len(text) {
+		inlines = append(inlines, MarkdownInline{Kind: InlineText, Text: text[last:]})
+	}
+	return inlines
+}
+
+func stripInlineMarkup(text string) string {
+	var sb strings.Builder
+	for _, inline := range parseInlines(text) {
+		sb.WriteString(inline.Text)
+	}
+	return sb.String()
+}
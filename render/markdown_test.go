@@ -0,0 +1,55 @@
+package render
+
+import "testing"
+
+func TestParseMarkdown(t *testing.T) {
+	src := "# Title\n\nFirst line\nsecond **bold** and _em_ with snake_case.\n\n- item one\n- item `two`\n\n> quoted\n\n```\ncode here\n```\n\n---\n"
+	blocks := ParseMarkdown(src)
+	kinds := []MarkdownBlockKind{BlockHeading, BlockParagraph, BlockListItem, BlockListItem, BlockQuote, BlockCode, BlockRule}
+	if len(blocks) != len(kinds) {
+		t.Fatalf("expected %d blocks, got %d: %+v", len(kinds), len(blocks), blocks)
+	}
+	for i, kind := range kinds {
+		if blocks[i].Kind != kind {
+			t.Errorf("block %d: expected kind %d, got %d", i, kind, blocks[i].Kind)
+		}
+	}
+	if got := blocks[1].PlainText(); got != "First line second bold and em with snake_case." {
+		t.Errorf("unexpected plain text %q", got)
+	}
+	var strong bool
+	for _, inline := range blocks[1].Inlines {
+		if inline.Kind == InlineStrong && inline.Text == "bold" {
+			strong = true
+		}
+	}
+	if !strong {
+		t.Errorf("expected a strong inline in %+v", blocks[1].Inlines)
+	}
+	if blocks[5].Text != "code here" {
+		t.Errorf("unexpected code block %q", blocks[5].Text)
+	}
+}
+
+func TestParseMarkdownTable(t *testing.T) {
+	blocks := ParseMarkdown("Before\n\n| Year | Sales |\n| --- | ---: |\n| 2020 | **12** |\n| a \\| b |  |\nAfter\n\n| not | a table |\n")
+	kinds := []MarkdownBlockKind{BlockParagraph, BlockTable, BlockParagraph, BlockParagraph}
+	if len(blocks) != len(kinds) {
+		t.Fatalf("expected %d blocks, got %d: %+v", len(kinds), len(blocks), blocks)
+	}
+	for i, kind := range kinds {
+		if blocks[i].Kind != kind {
+			t.Errorf("block %d: expected kind %d, got %d", i, kind, blocks[i].Kind)
+		}
+	}
+	if got, want := blocks[1].PlainText(), "Year | Sales\n2020 | 12\na | b | "; got != want {
+		t.Errorf("expected the cells %q, got %q", want, got)
+	}
+}
+
+func TestParseInlinesLinkAndImage(t *testing.T) {
+	inlines := parseInlines("see [docs](http://x.y) and ![a chart](assets/c.png)")
+	if len(inlines) != 4 || inlines[1].Kind != InlineLink || inlines[3].Kind != InlineImage || inlines[3].URL != "assets/c.png" {
+		t.Errorf("unexpected inlines %+v", inlines)
+	}
+}
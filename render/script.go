@@ -0,0 +1,148 @@
+package render
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	StyleBlog          string = "blog"
+	StyleYouTubeScript string = "youtube-script"
+)
+
+const DefaultWordsPerMinute int = 150
+
+// ScriptSegment is one numbered part of the body of a narration script.
+type ScriptSegment struct {
+	Title string `json:"title"`
+	Text  string `json:"text"`
+}
+
+// YouTubeScript is the structured response requested with -format youtube-script.
+type YouTubeScript struct {
+	Title    string          `json:"title"`
+	Hook     string          `json:"hook"`
+	Intro    string          `json:"intro"`
+	Segments []ScriptSegment `json:"segments"`
+	Outro    string          `json:"outro"`
+}
+
+// ScriptPart is a spoken part of the script with its estimated timing.
+type ScriptPart struct {
+	Number   int // segment number, 0 for hook, intro and outro
+	Title    string
+	Text     string
+	Start    time.Duration
+	Duration time.Duration
+}
+
+// Heading is the title of the part as shown in the Markdown script.
+func (p ScriptPart) Heading() string {
+	if p.Number > 0 {
+		return fmt.Sprintf("%d. %s", p.Number, p.Title)
+	}
+	return p.Title
+}
+
+func countWords(text string) int {
+	return len(strings.Fields(text))
+}
+
+// estimateDuration returns how long text takes to read aloud at wpm words per minute.
+func estimateDuration(text string, wpm int) time.Duration {
+	seconds := float64(countWords(text)) * 60 / float64(wpmOrDefault(wpm))
+	return time.Duration(math.Round(seconds)) * time.Second
+}
+
+// FormatTimestamp formats d the way YouTube expects chapter timestamps, ex: 01:42 or 1:02:03.
+func FormatTimestamp(d time.Duration) string {
+	total := int(d.Round(time.Second) / time.Second)
+	h, m, s := total/3600, (total%3600)/60, total%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%02d:%02d", m, s)
+}
+
+// Parts lays out the hook, intro, segments and outro one after another with their timings.
+func (s YouTubeScript) Parts(wpm int) []ScriptPart {
+	var parts []ScriptPart
+	var start time.Duration
+	add := func(number int, title, text string) {
+		if len(strings.TrimSpace(text)) == 0 {
+			return
+		}
+		d := estimateDuration(text, wpm)
+		parts = append(parts, ScriptPart{Number: number, Title: title, Text: text, Start: start, Duration: d})
+		start += d
+	}
+	add(0, "Hook", s.Hook)
+	add(0, "Intro", s.Intro)
+	for i, segment := range s.Segments {
+		add(i+1, segment.Title, segment.Text)
+	}
+	add(0, "Outro", s.Outro)
+	return parts
+}
+
+// Runtime is the estimated total spoken length of the script.
+func (s YouTubeScript) Runtime(wpm int) time.Duration {
+	var total time.Duration
+	for _, part := range s.Parts(wpm) {
+		total += part.Duration
+	}
+	return total
+}
+
+// Description builds the YouTube description block with chapter timestamps.
+// YouTube requires the first chapter to start at 00:00, so the hook is folded into the intro.
+func (s YouTubeScript) Description(wpm int) string {
+	var b strings.Builder
+	b.WriteString(s.Title + "\n\n")
+	b.WriteString("Chapters:\n")
+	first := true
+	for _, part := range s.Parts(wpm) {
+		if part.Number == 0 && part.Title == "Hook" {
+			continue
+		}
+		start := part.Start
+		if first {
+			start, first = 0, false
+		}
+		fmt.Fprintf(&b, "%s %s\n", FormatTimestamp(start), part.Title)
+	}
+	return b.String()
+}
+
+// Markdown renders the script with per-part timings, the total runtime and the description block.
+func (s YouTubeScript) Markdown(wpm int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", s.Title)
+	fmt.Fprintf(&b, "**Estimated runtime:** %s (at %d words per minute)\n\n", FormatTimestamp(s.Runtime(wpm)), wpmOrDefault(wpm))
+	for _, part := range s.Parts(wpm) {
+		fmt.Fprintf(&b, "## %s\n\n", part.Heading())
+		fmt.Fprintf(&b, "_%s – %s (%s)_\n\n", FormatTimestamp(part.Start), FormatTimestamp(part.Start+part.Duration), FormatTimestamp(part.Duration))
+		b.WriteString(strings.TrimSpace(part.Text) + "\n\n")
+	}
+	b.WriteString("## YouTube description\n\n")
+	b.WriteString("```\n" + s.Description(wpm) + "```\n")
+	return b.String()
+}
+
+// Narration renders only what is read aloud, one section per part, without timings.
+func (s YouTubeScript) Narration() string {
+	var b strings.Builder
+	for _, part := range s.Parts(0) {
+		fmt.Fprintf(&b, "## %s\n\n%s\n\n", part.Heading(), strings.TrimSpace(part.Text))
+	}
+	return b.String()
+}
+
+func wpmOrDefault(wpm int) int {
+	if wpm <= 0 {
+		return DefaultWordsPerMinute
+	}
+	return wpm
+}
@@ -0,0 +1,57 @@
+package render
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEstimateDuration(t *testing.T) {
+	text := strings.Repeat("word ", 150)
+	if d := estimateDuration(text, 150); d != time.Minute {
+		t.Errorf("expected 1m, got %v", d)
+	}
+	if d := estimateDuration(text, 0); d != time.Minute {
+		t.Errorf("expected default wpm to give 1m, got %v", d)
+	}
+	if d := estimateDuration(text, 300); d != 30*time.Second {
+		t.Errorf("expected 30s, got %v", d)
+	}
+}
+
+func TestFormatTimestamp(t *testing.T) {
+	cases := map[time.Duration]string{
+		0:                 "00:00",
+		102 * time.Second: "01:42",
+		time.Hour + 2*time.Minute + 3*time.Second: "1:02:03",
+	}
+	for d, want := range cases {
+		if got := FormatTimestamp(d); got != want {
+			t.Errorf("formatTimestamp(%v) = %s, want %s", d, got, want)
+		}
+	}
+}
+
+func TestYouTubeScriptDescription(t *testing.T) {
+	script := YouTubeScript{
+		Title: "Deep Work",
+		Hook:  strings.Repeat("word ", 25),
+		Intro: strings.Repeat("word ", 50),
+		Segments: []ScriptSegment{
+			{Title: "Why focus matters", Text: strings.Repeat("word ", 150)},
+			{Title: "10 rules", Text: strings.Repeat("word ", 75)},
+		},
+		Outro: strings.Repeat("word ", 25),
+	}
+	if runtime := script.Runtime(150); runtime != 130*time.Second {
+		t.Errorf("expected runtime 2m10s, got %v", runtime)
+	}
+	want := "Deep Work\n\nChapters:\n00:00 Intro\n00:30 Why focus matters\n01:30 10 rules\n02:00 Outro\n"
+	if got := script.Description(150); got != want {
+		t.Errorf("unexpected description:\n%s", got)
+	}
+	md := script.Markdown(150)
+	if !strings.Contains(md, "## 2. 10 rules") || !strings.Contains(md, "**Estimated runtime:** 02:10") {
+		t.Errorf("unexpected markdown:\n%s", md)
+	}
+}
@@ -0,0 +1,106 @@
+package render
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const FormatSSML string = "ssml"
+
+const (
+	ssmlHeadingBreak   string = "1s"
+	ssmlParagraphBreak string = "600ms"
+)
+
+// SSMLSegment is one section of the narration, split at level 1 and 2 headings.
+type SSMLSegment struct {
+	Title string
+	SSML  string
+}
+
+var sayAsRe = regexp.MustCompile(`\b(\d{4}-\d{2}-\d{2})\b|\b(\d{1,2}/\d{1,2}/\d{4})\b|\b(\d+(?:st|nd|rd|th))\b|(\d+(?:[.,]\d+)*)`)
+
+func escapeXML(text string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(text))
+	return b.String()
+}
+
+// ssmlText escapes text and wraps numbers and dates in <say-as> so the engine reads them correctly.
+func ssmlText(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range sayAsRe.FindAllStringSubmatchIndex(text, -1) {
+		b.WriteString(escapeXML(text[last:m[0]]))
+		value := escapeXML(text[m[0]:m[1]])
+		switch {
+		case m[2] >= 0:
+			fmt.Fprintf(&b, `<say-as interpret-as="date" format="ymd">%s</say-as>`, value)
+		case m[4] >= 0:
+			fmt.Fprintf(&b, `<say-as interpret-as="date" format="mdy">%s</say-as>`, value)
+		case m[6] >= 0:
+			fmt.Fprintf(&b, `<say-as interpret-as="ordinal">%s</say-as>`, value)
+		default:
+			fmt.Fprintf(&b, `<say-as interpret-as="cardinal">%s</say-as>`, value)
+		}
+		last = m[1]
+	}
+	b.WriteString(escapeXML(text[last:]))
+	return b.String()
+}
+
+func ssmlInlines(inlines []MarkdownInline) string {
+	var b strings.Builder
+	for _, inline := range inlines {
+		switch inline.Kind {
+		case InlineStrong:
+			fmt.Fprintf(&b, `<emphasis level="strong">%s</emphasis>`, ssmlText(inline.Text))
+		case InlineEmphasis:
+			fmt.Fprintf(&b, `<emphasis level="moderate">%s</emphasis>`, ssmlText(inline.Text))
+		case InlineImage:
+			// images are not read aloud
+		default:
+			b.WriteString(ssmlText(inline.Text))
+		}
+	}
+	return b.String()
+}
+
+func wrapSpeak(body string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<speak version="1.1" xmlns="http://www.w3.org/2001/10/synthesis" xml:lang="en-US">` + "\n" + body + "</speak>\n"
+}
+
+// markdownToSSML converts Markdown into one SSML document per segment. Segments start at
+// level 1 and 2 headings. When speakHeadings is false, headings only mark segment
+// boundaries, which suits scripts whose headings are labels such as "Hook" or "Outro".
+func markdownToSSML(content string, speakHeadings bool) []SSMLSegment {
+	var segments []SSMLSegment
+	var title string
+	var body strings.Builder
+	flush := func() {
+		if body.Len() > 0 {
+			segments = append(segments, SSMLSegment{Title: title, SSML: wrapSpeak(body.String())})
+		}
+		body.Reset()
+	}
+	for _, block := range ParseMarkdown(content) {
+		switch block.Kind {
+		case BlockHeading:
+			if block.Level <= 2 {
+				flush()
+				title = block.PlainText()
+			}
+			if speakHeadings {
+				fmt.Fprintf(&body, "<p>%s</p>\n<break time=\"%s\"/>\n", ssmlInlines(block.Inlines), ssmlHeadingBreak)
+			}
+		case BlockParagraph, BlockListItem, BlockQuote:
+			fmt.Fprintf(&body, "<p>%s</p>\n<break time=\"%s\"/>\n", ssmlInlines(block.Inlines), ssmlParagraphBreak)
+		}
+	}
+	flush()
+	return segments
+}
@@ -0,0 +1,44 @@
+package render
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestSSMLText(t *testing.T) {
+	got := ssmlText("On 2024-01-15, 3 of 1,200 people came 2nd & left.")
+	for _, want := range []string{
+		`<say-as interpret-as="date" format="ymd">2024-01-15</say-as>`,
+		`<say-as interpret-as="cardinal">3</say-as>`,
+		`<say-as interpret-as="cardinal">1,200</say-as>`,
+		`<say-as interpret-as="ordinal">2nd</say-as>`,
+		`&amp;`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %s in %s", want, got)
+		}
+	}
+}
+
+func TestMarkdownToSSML(t *testing.T) {
+	content := "Opening line.\n\n## Part one\n\nSome **bold** claim.\n\n### Detail\n\nMore text.\n\n## Part two\n\nThe end."
+	segments := markdownToSSML(content, true)
+	if len(segments) != 3 {
+		t.Fatalf("expected 3 segments, got %d", len(segments))
+	}
+	if segments[1].Title != "Part one" || !strings.Contains(segments[1].SSML, `<emphasis level="strong">bold</emphasis>`) || !strings.Contains(segments[1].SSML, "Detail") {
+		t.Errorf("unexpected segment %+v", segments[1])
+	}
+	for _, segment := range segments {
+		var doc struct{ XMLName xml.Name }
+		if err := xml.Unmarshal([]byte(segment.SSML), &doc); err != nil || doc.XMLName.Local != "speak" {
+			t.Errorf("invalid ssml %v:\n%s", err, segment.SSML)
+		}
+	}
+	for _, segment := range markdownToSSML(content, false) {
+		if strings.Contains(segment.SSML, "Part one") {
+			t.Errorf("headings should not be spoken: %s", segment.SSML)
+		}
+	}
+}
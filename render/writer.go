@@ -0,0 +1,224 @@
+// Package render writes generated chapters to disk as Markdown, HTML, JSON, EPUB and SSML,
+// with teleprompter, SRT and VTT exports of the youtube-script style.
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	FormatMarkdown string = "md"
+	FormatHTML     string = "html"
+	FormatJSON     string = "json"
+	FormatEPUB     string = "epub"
+)
+
+// Usage is the token usage reported by the model for one chapter.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Chapter is the rewrite of one chapter of a book, ready to be written out.
+type Chapter struct {
+	Book    string `json:"book"`
+	Chapter string `json:"chapter"`
+	Index   int    `json:"-"`
+	Title   string `json:"title"`
+	Content string `json:"content"`
+	// Narration is the spoken part of Content, for the speech and caption writers.
+	Narration string `json:"-"`
+	// Summary tells what the chapter covered, for the prompts of the next chapters.
+	Summary string `json:"summary,omitempty"`
+	Usage   Usage  `json:"usage"`
+}
+
+// FileName is the base name, without extension, of every file written for the chapter.
+func (ch Chapter) FileName() string {
+	return fmt.Sprintf("%02d-%s", ch.Index, Slugify(ch.Title))
+}
+
+// Writer writes generated chapters in one output format.
+type Writer interface {
+	// Write is called once for every generated chapter, in reading order.
+	Write(ch Chapter) error
+	// Close is called after the last chapter. Writers that package the whole book do it here.
+	Close() error
+}
+
+// Options are shared by every writer of a run.
+type Options struct {
+	Dir   string
+	Book  string
+	Style string
+	WPM   int
+	// OnSave, when set, is called with the path of every file written.
+	OnSave func(path string)
+}
+
+// save writes text to filename inside the output folder and reports it to OnSave.
+func (opts Options) save(filename, text string) error {
+	if err := SaveFile(opts.Dir, filename, text); err != nil {
+		return err
+	}
+	if opts.OnSave != nil {
+		opts.OnSave(filepath.Join(opts.Dir, filename))
+	}
+	return nil
+}
+
+// writerNames lists every format accepted by -format and -export.
+var writerNames = []string{FormatMarkdown, FormatHTML, FormatJSON, FormatEPUB, FormatSSML, ExportTeleprompter, ExportSRT, ExportVTT}
+
+func New(name string, opts Options) (Writer, error) {
+	switch name {
+	case FormatMarkdown:
+		return &markdownWriter{opts: opts}, nil
+	case FormatHTML:
+		return &htmlWriter{opts: opts}, nil
+	case FormatJSON:
+		return &jsonWriter{opts: opts}, nil
+	case FormatEPUB:
+		return &epubWriter{opts: opts}, nil
+	case FormatSSML:
+		return &ssmlWriter{opts: opts}, nil
+	case ExportTeleprompter, ExportSRT, ExportVTT:
+		return &captionWriter{opts: opts, kind: name}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q, expected one of %s", name, strings.Join(writerNames, ", "))
+}
+
+// NewAll creates a writer for each name, in order.
+func NewAll(names []string, opts Options) ([]Writer, error) {
+	var writers []Writer
+	for _, name := range names {
+		w, err := New(name, opts)
+		if err != nil {
+			return nil, err
+		}
+		writers = append(writers, w)
+	}
+	return writers, nil
+}
+
+// ParseFormat splits the -format flag into the writing style (blog or youtube-script)
+// and the output formats. Markdown is written when no output format is given.
+func ParseFormat(value string) (string, []string, error) {
+	style := StyleBlog
+	var formats []string
+	seen := map[string]bool{}
+	for _, f := range strings.Split(value, ",") {
+		f = strings.TrimSpace(strings.ToLower(f))
+		switch {
+		case f == "":
+			continue
+		case f == StyleBlog || f == StyleYouTubeScript:
+			style = f
+		case seen[f]:
+			continue
+		default:
+			if _, err := New(f, Options{}); err != nil {
+				return "", nil, fmt.Errorf("unknown format %q, expected %s, %s or one of %s", f, StyleBlog, StyleYouTubeScript, strings.Join(writerNames, ", "))
+			}
+			seen[f] = true
+			formats = append(formats, f)
+		}
+	}
+	if len(formats) == 0 {
+		formats = []string{FormatMarkdown}
+	}
+	return style, formats, nil
+}
+
+var slugRe = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Slugify turns a title into a safe file name, ex: "How to: Be Healthy!" -> "how-to-be-healthy".
+func Slugify(title string) string {
+	slug := strings.Trim(slugRe.ReplaceAllString(strings.ToLower(title), "-"), "-")
+	if len(slug) > 80 {
+		slug = strings.TrimRight(slug[:80], "-")
+	}
+	if len(slug) == 0 {
+		slug = "untitled"
+	}
+	return slug
+}
+
+// BookDir is the folder under root all files generated from a book are written to, ex: output/book.
+func BookDir(root, book string) string {
+	return filepath.Join(root, Slugify(strings.TrimSuffix(filepath.Base(book), filepath.Ext(book))))
+}
+
+// SaveFile writes text to filename inside dir, creating dir when needed.
+func SaveFile(dir, filename, text string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, filename), []byte(text), 0644)
+}
+
+type markdownWriter struct {
+	opts Options
+}
+
+func (w *markdownWriter) Write(ch Chapter) error {
+	return w.opts.save(ch.FileName()+".md", ch.Content)
+}
+
+func (w *markdownWriter) Close() error { return nil }
+
+type jsonWriter struct {
+	opts Options
+}
+
+func (w *jsonWriter) Write(ch Chapter) error {
+	data, err := json.MarshalIndent(ch, "", "  ")
+	if err != nil {
+		return err
+	}
+	return w.opts.save(ch.FileName()+".json", string(data))
+}
+
+func (w *jsonWriter) Close() error { return nil }
+
+type ssmlWriter struct {
+	opts Options
+}
+
+// Write saves every segment of the narration to its own numbered .ssml file.
+// Blog headings are read aloud, script headings are labels such as "Hook" and are not.
+func (w *ssmlWriter) Write(ch Chapter) error {
+	for i, segment := range markdownToSSML(ch.Narration, w.opts.Style == StyleBlog) {
+		err := w.opts.save(fmt.Sprintf("%s.%02d.ssml", ch.FileName(), i+1), segment.SSML)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *ssmlWriter) Close() error { return nil }
+
+type captionWriter struct {
+	opts Options
+	kind string
+}
+
+func (w *captionWriter) Write(ch Chapter) error {
+	switch w.kind {
+	case ExportTeleprompter:
+		return w.opts.save(ch.FileName()+".teleprompter.txt", teleprompterText(ch.Narration))
+	case ExportSRT:
+		return w.opts.save(ch.FileName()+".srt", toSRT(buildCues(ch.Narration, w.opts.WPM)))
+	default:
+		return w.opts.save(ch.FileName()+".vtt", toVTT(buildCues(ch.Narration, w.opts.WPM)))
+	}
+}
+
+func (w *captionWriter) Close() error { return nil }
@@ -0,0 +1,106 @@
+package render
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseFormat(t *testing.T) {
+	style, formats, err := ParseFormat("youtube-script,ssml,html,html")
+	if err != nil || style != StyleYouTubeScript || strings.Join(formats, ",") != "ssml,html" {
+		t.Errorf("unexpected result %s %v %v", style, formats, err)
+	}
+	style, formats, err = ParseFormat("")
+	if err != nil || style != StyleBlog || strings.Join(formats, ",") != FormatMarkdown {
+		t.Errorf("expected blog style with markdown, got %s %v %v", style, formats, err)
+	}
+	if _, _, err := ParseFormat("pdf"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	if got := Slugify("How to: Be Healthy!"); got != "how-to-be-healthy" {
+		t.Errorf("got %q", got)
+	}
+	if got := Slugify("???"); got != "untitled" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestMarkdownToHTML(t *testing.T) {
+	body, toc := MarkdownToHTML("# Intro\n\nA <b> & **c**\n\n- one\n- two\n\n## Intro\n")
+	for _, want := range []string{`<h1 id="intro">Intro</h1>`, "A &lt;b&gt; &amp; <strong>c</strong>", "<ul>\n<li>one</li>\n<li>two</li>\n</ul>", `<h2 id="intro-1">`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in\n%s", want, body)
+		}
+	}
+	if len(toc) != 2 || toc[1].ID != "intro-1" {
+		t.Errorf("unexpected toc %+v", toc)
+	}
+}
+
+func TestMarkdownToHTMLTable(t *testing.T) {
+	body, _ := MarkdownToHTML("| Year | Sales |\n| --- | --- |\n| 2020 | <12> |\n")
+	if want := "<table>\n<tr><th>Year</th><th>Sales</th></tr>\n<tr><td>2020</td><td>&lt;12&gt;</td></tr>\n</table>\n"; body != want {
+		t.Errorf("expected %q, got %q", want, body)
+	}
+}
+
+func TestMarkdownToHTMLLinks(t *testing.T) {
+	body, _ := MarkdownToHTML("[a](https://go.dev) [b](../ch02.html#x) [c](mailto:me@example.com) [d](javascript:alert%281%29) [e](JavaScript:void) ![f](data:image/svg+xml,x)")
+	for _, want := range []string{`href="https://go.dev"`, `href="../ch02.html#x"`, `href="mailto:me@example.com"`, `<a href="#">d</a>`, `<a href="#">e</a>`, `<img src="#" alt="f"/>`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in\n%s", want, body)
+		}
+	}
+	if strings.Contains(strings.ToLower(body), "javascript:") {
+		t.Errorf("expected no script URL in\n%s", body)
+	}
+}
+
+func TestWriters(t *testing.T) {
+	dir := t.TempDir()
+	writers, err := NewAll([]string{FormatMarkdown, FormatHTML, FormatJSON, FormatEPUB}, Options{Dir: dir, Book: "book1", Style: StyleBlog})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chapter := Chapter{Book: "book1.epub", Chapter: "ch01.xhtml", Index: 1, Title: "Deep Work", Content: "## Focus\n\nText.", Usage: Usage{TotalTokens: 42}}
+	for _, w := range writers {
+		if err := w.Write(chapter); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"01-deep-work.md", "01-deep-work.html", "index.html", "01-deep-work.json", "book1-companion.epub"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "01-deep-work.json"))
+	var decoded map[string]any
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded["book"] != "book1.epub" || decoded["usage"] == nil {
+		t.Errorf("unexpected json %s", data)
+	}
+	reader, err := zip.OpenReader(filepath.Join(dir, "book1-companion.epub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if reader.File[0].Name != "mimetype" || reader.File[0].Method != zip.Store {
+		t.Errorf("mimetype must be the first, stored entry")
+	}
+	var names []string
+	for _, f := range reader.File {
+		names = append(names, f.Name)
+	}
+	if !strings.Contains(strings.Join(names, ","), "OEBPS/chapter-001.xhtml") {
+		t.Errorf("unexpected entries %v", names)
+	}
+}
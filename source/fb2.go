@@ -0,0 +1,343 @@
+package source
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cli-epub-parser-md-generator/epub"
+	"cli-epub-parser-md-generator/internal/htmlutil"
+)
+
+// fb2Node is an element of a FictionBook document, or a run of text when name is empty.
+type fb2Node struct {
+	name     string
+	attrs    []xml.Attr
+	text     string
+	children []*fb2Node
+}
+
+// attr returns the value of the attribute with the local name key, ex: href for l:href.
+func (n *fb2Node) attr(key string) string {
+	for _, a := range n.attrs {
+		if a.Name.Local == key {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// child returns the first child element named name, or nil.
+func (n *fb2Node) child(name string) *fb2Node {
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// content returns the text of n and its descendants with its spaces collapsed.
+func (n *fb2Node) content() string {
+	var b strings.Builder
+	var walk func(*fb2Node)
+	walk = func(n *fb2Node) {
+		b.WriteString(n.text)
+		for _, c := range n.children {
+			walk(c)
+			if c.name == "p" || c.name == "v" {
+				b.WriteString(" ")
+			}
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+func parseFB2(data []byte) (*fb2Node, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	// the data is UTF-8 now, whatever the XML declaration says
+	decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	root := &fb2Node{}
+	stack := []*fb2Node{root}
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		parent := stack[len(stack)-1]
+		switch t := token.(type) {
+		case xml.StartElement:
+			n := &fb2Node{name: t.Name.Local, attrs: t.Attr}
+			parent.children = append(parent.children, n)
+			stack = append(stack, n)
+		case xml.EndElement:
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			parent.children = append(parent.children, &fb2Node{text: htmlutil.Normalize(string(t))})
+		}
+	}
+	if fb := root.child("FictionBook"); fb != nil {
+		return fb, nil
+	}
+	return nil, fmt.Errorf("not a FictionBook document")
+}
+
+// FB2 reads FictionBook 2 files: every top level section of the main body is a chapter, with
+// its nested sections as subheadings, the notes body is a non-linear document the note links
+// point to, and the binaries are written as images.
+type FB2 struct{}
+
+func (FB2) Read(path, dir string) (*epub.Book, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data, _, err = htmlutil.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s: %w", path, err)
+	}
+	fb, err := parseFB2(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	b := newBuilder(dir, fb2Metadata(fb))
+	if b.book.Metadata.Title == "" {
+		b.book.Metadata.Title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if err := writeFB2Binaries(b, fb); err != nil {
+		return nil, err
+	}
+	var main, notes *fb2Node
+	for _, body := range fb.children {
+		if body.name != "body" {
+			continue
+		}
+		switch {
+		case body.attr("name") == "notes" || body.attr("name") == "comments":
+			if notes == nil {
+				notes = body
+			}
+		case main == nil:
+			main = body
+		}
+	}
+	if main == nil {
+		return nil, fmt.Errorf("no body in %s", path)
+	}
+	c := &fb2Converter{notesHref: "notes.xhtml"}
+	var front strings.Builder
+	var sections []*fb2Node
+	for _, child := range main.children {
+		switch child.name {
+		case "section":
+			sections = append(sections, child)
+		case "title":
+			// the title of the book, already in the metadata
+		default:
+			c.write(&front, child, 1)
+		}
+	}
+	if len(sections) == 0 {
+		// a body without sections is a single chapter
+		sections, front = []*fb2Node{{name: "section", children: main.children}}, strings.Builder{}
+	}
+	if len(strings.TrimSpace(front.String())) > 0 {
+		if err := b.add("front.xhtml", b.book.Metadata.Title, front.String(), false); err != nil {
+			return nil, err
+		}
+	}
+	for i, section := range sections {
+		href := fmt.Sprintf("chapter-%03d.xhtml", i+1)
+		var body strings.Builder
+		c.children(&body, section, 1)
+		title := ""
+		if t := section.child("title"); t != nil {
+			title = t.content()
+		}
+		if err := b.add(href, title, body.String(), true); err != nil {
+			return nil, err
+		}
+	}
+	if notes != nil {
+		var body strings.Builder
+		for _, child := range notes.children {
+			if child.name == "section" {
+				fmt.Fprintf(&body, `<aside epub:type="footnote" id="%s">`, html.EscapeString(child.attr("id")))
+				for _, p := range child.children {
+					if p.name != "title" {
+						c.write(&body, p, 2)
+					}
+				}
+				body.WriteString("</aside>\n")
+			}
+		}
+		if err := b.add(c.notesHref, "Notes", body.String(), false); err != nil {
+			return nil, err
+		}
+	}
+	return b.finish()
+}
+
+func fb2Metadata(fb *fb2Node) epub.Metadata {
+	var metadata epub.Metadata
+	description := fb.child("description")
+	if description == nil {
+		return metadata
+	}
+	if info := description.child("title-info"); info != nil {
+		for _, c := range info.children {
+			switch c.name {
+			case "book-title":
+				metadata.Title = c.content()
+			case "author":
+				var names []string
+				for _, part := range []string{"first-name", "middle-name", "last-name"} {
+					if n := c.child(part); n != nil && len(n.content()) > 0 {
+						names = append(names, n.content())
+					}
+				}
+				if len(names) == 0 && c.child("nickname") != nil {
+					names = append(names, c.child("nickname").content())
+				}
+				if len(names) > 0 {
+					metadata.Creators = append(metadata.Creators, strings.Join(names, " "))
+				}
+			case "lang":
+				metadata.Language = c.content()
+			case "date":
+				metadata.Date = c.content()
+			case "annotation":
+				metadata.Description = c.content()
+			}
+		}
+	}
+	if publish := description.child("publish-info"); publish != nil {
+		for _, c := range publish.children {
+			switch c.name {
+			case "publisher":
+				metadata.Publisher = c.content()
+			case "isbn":
+				metadata.Identifier = "urn:isbn:" + c.content()
+			}
+		}
+	}
+	if document := description.child("document-info"); document != nil && metadata.Identifier == "" {
+		if id := document.child("id"); id != nil {
+			metadata.Identifier = id.content()
+		}
+	}
+	return metadata
+}
+
+// writeFB2Binaries writes the base64 binaries, the images of the book, to images/<id>.
+func writeFB2Binaries(b *builder, fb *fb2Node) error {
+	for _, binary := range fb.children {
+		id := binary.attr("id")
+		if binary.name != "binary" || len(id) == 0 || !filepath.IsLocal(id) {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(binary.content()), ""))
+		if err != nil {
+			return fmt.Errorf("error decoding binary %s: %w", id, err)
+		}
+		href := "images/" + id
+		if err := os.MkdirAll(filepath.Dir(b.book.Path(href)), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(b.book.Path(href), data, 0644); err != nil {
+			return err
+		}
+		b.addFile(href, binary.attr("content-type"))
+	}
+	return nil
+}
+
+// fb2Converter writes FictionBook elements as XHTML.
+type fb2Converter struct {
+	notesHref string
+}
+
+// fb2Tags are the FictionBook elements written as an XHTML element of another name.
+var fb2Tags = map[string]string{
+	"emphasis":      "em",
+	"strikethrough": "del",
+	"epigraph":      "blockquote",
+	"cite":          "blockquote",
+	"annotation":    "blockquote",
+	"text-author":   "p",
+	"poem":          "div",
+	"stanza":        "p",
+}
+
+// fb2Same are the FictionBook elements written as the XHTML element of the same name. Other
+// elements are replaced by their content.
+var fb2Same = map[string]bool{"p": true, "strong": true, "sub": true, "sup": true, "code": true, "table": true, "tr": true, "td": true, "th": true}
+
+func (c *fb2Converter) write(b *strings.Builder, n *fb2Node, level int) {
+	if len(n.name) == 0 {
+		b.WriteString(html.EscapeString(n.text))
+		return
+	}
+	switch n.name {
+	case "section":
+		c.children(b, n, level+1)
+	case "title", "subtitle":
+		heading := min(level, 6)
+		if n.name == "subtitle" {
+			heading = min(level+1, 6)
+		}
+		fmt.Fprintf(b, "<h%d>%s</h%d>\n", heading, html.EscapeString(n.content()), heading)
+	case "v":
+		c.children(b, n, level)
+		b.WriteString("<br/>")
+	case "empty-line":
+		b.WriteString("<br/>")
+	case "image":
+		alt := n.attr("alt")
+		if src, ok := strings.CutPrefix(n.attr("href"), "#"); ok {
+			fmt.Fprintf(b, `<img src="images/%s" alt="%s"/>`, html.EscapeString(src), html.EscapeString(alt))
+		}
+	case "a":
+		href := n.attr("href")
+		if id, ok := strings.CutPrefix(href, "#"); ok && n.attr("type") == "note" {
+			fmt.Fprintf(b, `<a epub:type="noteref" href="%s#%s">`, c.notesHref, html.EscapeString(id))
+		} else {
+			fmt.Fprintf(b, `<a href="%s">`, html.EscapeString(href))
+		}
+		c.children(b, n, level)
+		b.WriteString("</a>")
+	default:
+		tag := fb2Tags[n.name]
+		if len(tag) == 0 && fb2Same[n.name] {
+			tag = n.name
+		}
+		if len(tag) == 0 {
+			c.children(b, n, level)
+			return
+		}
+		fmt.Fprintf(b, "<%s>", tag)
+		c.children(b, n, level)
+		fmt.Fprintf(b, "</%s>", tag)
+	}
+}
+
+func (c *fb2Converter) children(b *strings.Builder, n *fb2Node, level int) {
+	for _, child := range n.children {
+		c.write(b, child, level)
+	}
+}
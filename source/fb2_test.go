@@ -0,0 +1,52 @@
+package source
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const fb2Book = `<?xml version="1.0" encoding="windows-1251"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0" xmlns:l="http://www.w3.org/1999/xlink">
+<description>
+<title-info><genre>prose</genre><author><first-name>Ivan</first-name><last-name>Petrov</last-name></author>
+<book-title>` + "\xcf\xf0\xe8\xec\xe5\xf0" + `</book-title><annotation><p>A short book.</p></annotation><lang>ru</lang></title-info>
+<publish-info><publisher>Press</publisher><isbn>978-0</isbn></publish-info>
+</description>
+<body>
+<title><p>Example</p></title>
+<epigraph><p>Words before.</p><text-author>Someone</text-author></epigraph>
+<section><title><p>Chapter 1</p><p>The Start</p></title>
+<p>Some <emphasis>text</emphasis> with a note<a l:href="#n1" type="note">[1]</a>.</p>
+<image l:href="#cover.png"/>
+<section><title><p>Part A</p></title><p>Nested.</p></section>
+<poem><stanza><v>Line one</v><v>Line two</v></stanza></poem>
+</section>
+<section><title><p>Chapter 2</p></title><p>More <strong>text</strong>.</p></section>
+</body>
+<body name="notes"><section id="n1"><title><p>1</p></title><p>The note.</p></section></body>
+<binary id="cover.png" content-type="image/png">cG5n</binary>
+</FictionBook>`
+
+func TestFB2(t *testing.T) {
+	book := readFile(t, "book.fb2", fb2Book)
+	if book.Metadata.Title != "Пример" || book.Metadata.Creators[0] != "Ivan Petrov" || book.Metadata.Language != "ru" || book.Metadata.Identifier != "urn:isbn:978-0" {
+		t.Errorf("unexpected metadata %+v", book.Metadata)
+	}
+	if titles := chapterTitles(book, false); !slices.Equal(titles, []string{"Chapter 1 The Start", "Chapter 2"}) {
+		t.Errorf("unexpected chapters %q", titles)
+	}
+	if titles := chapterTitles(book, true); len(titles) != 4 || titles[0] != "Пример" || titles[3] != "Notes" {
+		t.Errorf("expected the epigraph and notes as non-linear documents, got %q", titles)
+	}
+	md := chapterMarkdown(t, book, 0)
+	for _, want := range []string{"# Chapter 1 The Start", "Some *text* with a note[^1].", "![](images-cover.png)", "## Part A", "Line one", "[^1]: The note."} {
+		if !strings.Contains(md, want) {
+			t.Errorf("expected %q in %q", want, md)
+		}
+	}
+	if data, err := os.ReadFile(book.Path("images/cover.png")); err != nil || string(data) != "png" {
+		t.Errorf("expected the binary to be written, got %q %v", data, err)
+	}
+}
@@ -0,0 +1,185 @@
+package source
+
+import (
+	"cmp"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"cli-epub-parser-md-generator/epub"
+	"cli-epub-parser-md-generator/internal/htmlutil"
+)
+
+// htmlDirManifest lists the documents of a folder in reading order, one path per line.
+const htmlDirManifest = "manifest.txt"
+
+// HTMLDir reads a folder of HTML files, such as a saved website. The documents are read in the
+// order of manifest.txt, one path per line, else of the links of index.html, whose link texts
+// make the table of contents, else by name with numbers in natural order (ch2 before ch10).
+// The folder is copied, so it is left untouched.
+type HTMLDir struct{}
+
+func (HTMLDir) Read(root, dir string) (*epub.Book, error) {
+	if err := os.CopyFS(dir, os.DirFS(root)); err != nil {
+		return nil, fmt.Errorf("error copying %s: %w", root, err)
+	}
+	var documents []string
+	b := newBuilder(dir, epub.Metadata{Title: filepath.Base(filepath.Clean(root))})
+	err := filepath.WalkDir(dir, func(filename string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		href, _ := filepath.Rel(dir, filename)
+		href = filepath.ToSlash(href)
+		switch mediaType := mime.TypeByExtension(path.Ext(href)); {
+		case isHTML(href):
+			documents = append(documents, href)
+		case strings.HasPrefix(mediaType, "image/"):
+			b.addFile(href, mediaType)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(documents, naturalCompare)
+	order, err := readHTMLDirManifest(dir, documents)
+	if err != nil {
+		return nil, err
+	}
+	for _, index := range []string{"index.html", "index.htm", "index.xhtml"} {
+		if len(order) > 0 || !slices.Contains(documents, index) {
+			continue
+		}
+		doc, err := htmlutil.ParseFile(filepath.Join(dir, index))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: %w", index, err)
+		}
+		order, b.book.TOC = indexLinks(doc, index, documents)
+		if title := findText(doc, "title"); len(title) > 0 {
+			b.book.Metadata.Title = title
+		}
+		if len(order) == 0 {
+			order = slices.DeleteFunc(slices.Clone(documents), func(href string) bool { return href == index })
+		}
+	}
+	if len(order) == 0 {
+		order = documents
+	}
+	for _, href := range order {
+		b.addFile(href, "application/xhtml+xml")
+		b.book.Spine = append(b.book.Spine, epub.SpineItem{ManifestItem: b.book.Manifest[href], Linear: true})
+	}
+	return b.finish()
+}
+
+func isHTML(href string) bool {
+	switch strings.ToLower(path.Ext(href)) {
+	case ".html", ".htm", ".xhtml":
+		return true
+	}
+	return false
+}
+
+// readHTMLDirManifest returns the documents listed in manifest.txt, or nil without one.
+func readHTMLDirManifest(dir string, documents []string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, htmlDirManifest))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var order []string
+	for i, line := range strings.Split(string(data), "\n") {
+		href := strings.TrimSpace(line)
+		if len(href) == 0 || strings.HasPrefix(href, "#") {
+			continue
+		}
+		href = path.Clean(filepath.ToSlash(href))
+		if !slices.Contains(documents, href) {
+			return nil, fmt.Errorf("%s:%d: no HTML document %q in the folder", htmlDirManifest, i+1, href)
+		}
+		order = append(order, href)
+	}
+	return order, nil
+}
+
+// indexLinks returns the documents index links to, in order and once each, and the table of
+// contents made of the links.
+func indexLinks(doc *html.Node, index string, documents []string) ([]string, []epub.TOCItem) {
+	var order []string
+	var toc []epub.TOCItem
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "a" {
+			ref, err := url.Parse(htmlutil.Attr(n, "href"))
+			if err == nil && !ref.IsAbs() && len(ref.Path) > 0 {
+				href := path.Join(path.Dir(index), ref.Path)
+				if href != index && slices.Contains(documents, href) {
+					if !slices.Contains(order, href) {
+						order = append(order, href)
+					}
+					if title := strings.Join(strings.Fields(htmlutil.TextContent(n)), " "); len(title) > 0 {
+						toc = append(toc, epub.TOCItem{Title: title, Href: href, Level: 1})
+					}
+				}
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+	return order, toc
+}
+
+func findText(n *html.Node, tag string) string {
+	if n.Type == html.ElementNode && n.Data == tag {
+		return strings.Join(strings.Fields(htmlutil.TextContent(n)), " ")
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if text := findText(child, tag); len(text) > 0 {
+			return text
+		}
+	}
+	return ""
+}
+
+// naturalCompare compares paths with their runs of digits as numbers, so ch2 sorts before ch10.
+func naturalCompare(a, b string) int {
+	for len(a) > 0 && len(b) > 0 {
+		da, db := leadingDigits(a), leadingDigits(b)
+		if len(da) > 0 && len(db) > 0 {
+			na, _ := strconv.Atoi(da)
+			nb, _ := strconv.Atoi(db)
+			if c := cmp.Compare(na, nb); c != 0 {
+				return c
+			}
+			a, b = a[len(da):], b[len(db):]
+			continue
+		}
+		if a[0] != b[0] {
+			return cmp.Compare(a[0], b[0])
+		}
+		a, b = a[1:], b[1:]
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+func leadingDigits(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
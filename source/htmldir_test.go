@@ -0,0 +1,75 @@
+package source
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func writeHTMLDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "site")
+	for name, content := range files {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(filename), 0755)
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+var htmlDirFiles = map[string]string{
+	"ch10.html":     "<html><body><h1>Ten</h1><p>Last.</p></body></html>",
+	"ch2.html":      "<html><body><h1>Two</h1><p>Second.</p></body></html>",
+	"ch1.html":      "<html><head><title>One</title></head><body><p>First.</p></body></html>",
+	"img/photo.jpg": "jpg",
+}
+
+func TestHTMLDirByName(t *testing.T) {
+	dir := writeHTMLDir(t, htmlDirFiles)
+	book, err := Open(dir, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if titles := chapterTitles(book, false); !slices.Equal(titles, []string{"One", "Two", "Ten"}) {
+		t.Errorf("expected the files in natural order, got %q", titles)
+	}
+	if book.Metadata.Title != "site" || book.Manifest["img/photo.jpg"].MediaType != "image/jpeg" {
+		t.Errorf("unexpected book %+v", book)
+	}
+}
+
+func TestHTMLDirIndex(t *testing.T) {
+	files := map[string]string{"index.html": `<html><head><title>The Site</title></head><body>
+<a href="ch10.html">The end</a> <a href="ch1.html#top">The start</a> <a href="https://example.com/">elsewhere</a></body></html>`}
+	for name, content := range htmlDirFiles {
+		files[name] = content
+	}
+	book, err := Open(writeHTMLDir(t, files), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if titles := chapterTitles(book, false); !slices.Equal(titles, []string{"The end", "The start"}) || book.Metadata.Title != "The Site" {
+		t.Errorf("expected the order of the index links, got %q", titles)
+	}
+}
+
+func TestHTMLDirManifest(t *testing.T) {
+	files := map[string]string{"manifest.txt": "# reading order\nch2.html\n\nch1.html\n"}
+	for name, content := range htmlDirFiles {
+		files[name] = content
+	}
+	book, err := Open(writeHTMLDir(t, files), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if titles := chapterTitles(book, false); !slices.Equal(titles, []string{"Two", "One"}) {
+		t.Errorf("expected the order of the manifest, got %q", titles)
+	}
+	files["manifest.txt"] = "missing.html\n"
+	if _, err := Open(writeHTMLDir(t, files), t.TempDir()); err == nil {
+		t.Error("expected an error for a missing document in the manifest")
+	}
+}
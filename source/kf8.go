@@ -0,0 +1,340 @@
+package source
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"cli-epub-parser-md-generator/epub"
+)
+
+var (
+	kf8EmbedRe = regexp.MustCompile(`kindle:embed:([0-9A-Va-v]{4})(\?mime=[^"')\s]*)?`)
+	kf8FlowRe  = regexp.MustCompile(`kindle:flow:([0-9A-Va-v]{4})(\?mime=[^"')\s]*)?`)
+	kf8PosRe   = regexp.MustCompile(`kindle:pos:fid:([0-9A-Va-v]{4}):off:([0-9A-Va-v]{10})`)
+)
+
+// kf8Part is a document of a KF8 book, assembled from its skeleton and fragments.
+type kf8Part struct {
+	href       string
+	start, end int // the range of the skeleton and fragments in the text
+	content    []byte
+}
+
+// readKF8 rebuilds the XHTML parts of a KF8 book: the first flow of the text holds every
+// skeleton, the outline of a part, followed by the fragments inserted into it. The other flows,
+// stylesheets and SVG images, are written to styles/, and the kindle: links resolved.
+func readKF8(b *builder, h *mobiHeader, records [][]byte, text []byte, images map[int]string) error {
+	flows, err := readFDST(h, records, text)
+	if err != nil {
+		return err
+	}
+	skeletons, _, err := readIndex(records, h.skeleton)
+	if err != nil {
+		return fmt.Errorf("error reading the skeleton index: %w", err)
+	}
+	fragments, _, err := readIndex(records, h.fragment)
+	if err != nil {
+		return fmt.Errorf("error reading the fragment index: %w", err)
+	}
+	flowHrefs := map[int]string{}
+	for i, flow := range flows[1:] {
+		ext, mediaType := ".css", "text/css"
+		if bytes.Contains(flow[:min(len(flow), 512)], []byte("<svg")) {
+			ext, mediaType = ".svg", "image/svg+xml"
+		}
+		if len(flowHrefs) == 0 {
+			if err := os.MkdirAll(b.book.Path("styles"), 0755); err != nil {
+				return err
+			}
+		}
+		href := fmt.Sprintf("styles/flow%03d%s", i+1, ext)
+		if err := os.WriteFile(b.book.Path(href), flow, 0644); err != nil {
+			return err
+		}
+		b.addFile(href, mediaType)
+		flowHrefs[i+1] = href
+	}
+	markup := flows[0]
+	var parts []kf8Part
+	// the part of every fragment, the targets of kindle:pos links
+	var fragmentParts []int
+	for i, skeleton := range skeletons {
+		count, start, length := skeleton.value(1, 0), skeleton.value(6, 0), skeleton.value(6, 1)
+		if start < 0 || length < 0 || start+length > len(markup) {
+			return fmt.Errorf("skeleton %d out of the text", i)
+		}
+		content := slices.Clone(markup[start : start+length])
+		next := start + length
+		for range count {
+			if len(fragmentParts) >= len(fragments) {
+				return fmt.Errorf("missing fragments for skeleton %d", i)
+			}
+			fragment := fragments[len(fragmentParts)]
+			insert, err := strconv.Atoi(fragment.key)
+			if err != nil {
+				return fmt.Errorf("invalid fragment position %q", fragment.key)
+			}
+			insert -= start
+			length := fragment.value(6, 1)
+			if insert < 0 || insert > len(content) || length < 0 || next+length > len(markup) {
+				return fmt.Errorf("fragment %d out of skeleton %d", len(fragmentParts), i)
+			}
+			content = slices.Insert(content, insert, markup[next:next+length]...)
+			next += length
+			fragmentParts = append(fragmentParts, i)
+		}
+		parts = append(parts, kf8Part{href: fmt.Sprintf("part%04d.xhtml", i), start: start, end: next, content: content})
+	}
+	if len(parts) == 0 {
+		// no skeleton index: the text is a single document
+		parts = []kf8Part{{href: "part0000.xhtml", end: len(markup), content: markup}}
+	}
+	resolve := func(re *regexp.Regexp, hrefs func(n int) string) func([]byte) []byte {
+		return func(link []byte) []byte {
+			n, _ := strconv.ParseInt(string(re.FindSubmatch(link)[1]), 32, 0)
+			return []byte(hrefs(int(n)))
+		}
+	}
+	for _, part := range parts {
+		content := kf8EmbedRe.ReplaceAllFunc(part.content, resolve(kf8EmbedRe, func(n int) string { return images[n] }))
+		content = kf8FlowRe.ReplaceAllFunc(content, resolve(kf8FlowRe, func(n int) string { return flowHrefs[n] }))
+		content = kf8PosRe.ReplaceAllFunc(content, resolve(kf8PosRe, func(n int) string {
+			if n < len(fragmentParts) {
+				return parts[fragmentParts[n]].href
+			}
+			return ""
+		}))
+		if err := os.WriteFile(b.book.Path(part.href), []byte(h.decode(content)), 0644); err != nil {
+			return err
+		}
+		b.addFile(part.href, "application/xhtml+xml")
+		b.book.Spine = append(b.book.Spine, epub.SpineItem{ManifestItem: b.book.Manifest[part.href], Linear: true})
+	}
+	toc, err := readNCX(h, records)
+	if err != nil {
+		return err
+	}
+	for _, entry := range toc {
+		href := ""
+		if entry.fid >= 0 && entry.fid < len(fragmentParts) {
+			href = parts[fragmentParts[entry.fid]].href
+		} else {
+			for _, part := range parts {
+				if entry.pos >= part.start && entry.pos < part.end {
+					href = part.href
+				}
+			}
+		}
+		if len(href) > 0 && len(entry.label) > 0 {
+			b.book.TOC = append(b.book.TOC, epub.TOCItem{Title: entry.label, Href: href, Level: entry.level})
+		}
+	}
+	return nil
+}
+
+// readFDST splits the text into its flows with the FDST record, or returns it as a single flow.
+func readFDST(h *mobiHeader, records [][]byte, text []byte) ([][]byte, error) {
+	if h.fdst < 0 || h.fdst >= len(records) {
+		return [][]byte{text}, nil
+	}
+	r := records[h.fdst]
+	if len(r) < 12 || string(r[:4]) != "FDST" {
+		return nil, fmt.Errorf("invalid FDST record")
+	}
+	start, count := int(binary.BigEndian.Uint32(r[4:])), int(binary.BigEndian.Uint32(r[8:]))
+	if start+count*8 > len(r) {
+		return nil, fmt.Errorf("truncated FDST record")
+	}
+	var flows [][]byte
+	for i := range count {
+		from, to := int(binary.BigEndian.Uint32(r[start+i*8:])), int(binary.BigEndian.Uint32(r[start+i*8+4:]))
+		if from > to || to > len(text) {
+			return nil, fmt.Errorf("flow %d out of the text", i)
+		}
+		flows = append(flows, text[from:to])
+	}
+	if len(flows) == 0 {
+		flows = [][]byte{text}
+	}
+	return flows, nil
+}
+
+// indexEntry is an entry of an INDX index, with its key and its values by tag.
+type indexEntry struct {
+	key  string
+	tags map[int][]int
+}
+
+// value returns the i-th value of tag, or 0.
+func (e indexEntry) value(tag, i int) int {
+	if values := e.tags[tag]; i < len(values) {
+		return values[i]
+	}
+	return 0
+}
+
+// indexTag is a tag definition of the TAGX section of an index.
+type indexTag struct {
+	tag, values, mask int
+	end               bool
+}
+
+// readIndex reads the entries of the index whose header is the record at, and the strings of its
+// CNCX records by offset.
+func readIndex(records [][]byte, at int) ([]indexEntry, map[int][]byte, error) {
+	if at < 0 || at >= len(records) {
+		return nil, nil, nil
+	}
+	header := records[at]
+	if len(header) < 184 || string(header[:4]) != "INDX" {
+		return nil, nil, fmt.Errorf("invalid INDX record")
+	}
+	count, cncxCount := int(binary.BigEndian.Uint32(header[24:])), int(binary.BigEndian.Uint32(header[52:]))
+	if at+count+cncxCount >= len(records) {
+		return nil, nil, fmt.Errorf("the INDX record lists %d index and %d CNCX records, more than the book has", count, cncxCount)
+	}
+	tagx := int(binary.BigEndian.Uint32(header[180:]))
+	if tagx+12 > len(header) || string(header[tagx:tagx+4]) != "TAGX" {
+		return nil, nil, fmt.Errorf("missing TAGX section")
+	}
+	tagxEnd, controlBytes := tagx+int(binary.BigEndian.Uint32(header[tagx+4:])), int(binary.BigEndian.Uint32(header[tagx+8:]))
+	if tagxEnd > len(header) {
+		return nil, nil, fmt.Errorf("truncated TAGX section")
+	}
+	var tags []indexTag
+	for i := tagx + 12; i+4 <= tagxEnd; i += 4 {
+		tags = append(tags, indexTag{tag: int(header[i]), values: int(header[i+1]), mask: int(header[i+2]), end: header[i+3] == 1})
+	}
+	cncx := map[int][]byte{}
+	for i := range cncxCount {
+		n := at + count + 1 + i
+		if n >= len(records) {
+			break
+		}
+		for pos, r := 0, records[n]; pos < len(r); {
+			length, size := readVarint(r[pos:])
+			if size == 0 || length < 0 || pos+size+length > len(r) {
+				break
+			}
+			if length > 0 {
+				cncx[i*0x10000+pos] = r[pos+size : pos+size+length]
+			}
+			pos += size + length
+		}
+	}
+	var entries []indexEntry
+	for n := at + 1; n <= at+count && n < len(records); n++ {
+		r := records[n]
+		if len(r) < 28 || string(r[:4]) != "INDX" {
+			return nil, nil, fmt.Errorf("invalid INDX record %d", n)
+		}
+		idxt, entryCount := int(binary.BigEndian.Uint32(r[20:])), int(binary.BigEndian.Uint32(r[24:]))
+		if idxt+4+entryCount*2 > len(r) {
+			return nil, nil, fmt.Errorf("truncated IDXT section in record %d", n)
+		}
+		for i := range entryCount {
+			start, end := int(binary.BigEndian.Uint16(r[idxt+4+i*2:])), idxt
+			if i+1 < entryCount {
+				end = int(binary.BigEndian.Uint16(r[idxt+4+(i+1)*2:]))
+			}
+			if start >= end || end > len(r) || start+1+int(r[start]) > end {
+				return nil, nil, fmt.Errorf("invalid index entry %d in record %d", i, n)
+			}
+			keyEnd := start + 1 + int(r[start])
+			entries = append(entries, indexEntry{key: string(r[start+1 : keyEnd]), tags: readTagMap(tags, controlBytes, r[keyEnd:end])})
+		}
+	}
+	return entries, cncx, nil
+}
+
+// readTagMap reads the values of an index entry: control bytes telling which tags are present
+// and how many values they have, followed by the values as variable width integers.
+func readTagMap(tags []indexTag, controlBytes int, data []byte) map[int][]int {
+	if controlBytes > len(data) {
+		return nil
+	}
+	control, data := data[:controlBytes], data[controlBytes:]
+	type present struct {
+		tag, count, size, values int
+	}
+	var found []present
+	for _, t := range tags {
+		if t.end {
+			if len(control) > 0 {
+				control = control[1:]
+			}
+			continue
+		}
+		if len(control) == 0 {
+			break
+		}
+		value := int(control[0]) & t.mask
+		if value == 0 {
+			continue
+		}
+		p := present{tag: t.tag, values: t.values}
+		switch {
+		case value == t.mask && bits.OnesCount(uint(t.mask)) > 1:
+			// the size in bytes of the values follows
+			size, n := readVarint(data)
+			if n == 0 {
+				return nil
+			}
+			data = data[n:]
+			p.size = size
+		case value == t.mask:
+			p.count = 1
+		default:
+			p.count = value >> bits.TrailingZeros(uint(t.mask))
+		}
+		found = append(found, p)
+	}
+	tagMap := map[int][]int{}
+	for _, p := range found {
+		var values []int
+		if p.size > 0 {
+			for consumed := 0; consumed < p.size && len(data) > 0; {
+				value, n := readVarint(data)
+				if n == 0 {
+					break
+				}
+				data, consumed = data[n:], consumed+n
+				values = append(values, value)
+			}
+		} else {
+			for range p.count * p.values {
+				value, n := readVarint(data)
+				if n == 0 {
+					break
+				}
+				data = data[n:]
+				values = append(values, value)
+			}
+		}
+		tagMap[p.tag] = values
+	}
+	return tagMap
+}
+
+// readVarint reads a forward variable width integer, 7 bits per byte ending with the byte with
+// its high bit set, and returns it with its size. The size is 0 when the integer is not ended
+// within maxVarintSize bytes.
+func readVarint(data []byte) (int, int) {
+	value := 0
+	for i, c := range data[:min(len(data), maxVarintSize)] {
+		value = value<<7 | int(c&0x7f)
+		if c&0x80 != 0 {
+			return value, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// maxVarintSize bounds the variable width integers of the indexes, which fit in 28 bits.
+const maxVarintSize int = 4
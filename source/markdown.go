@@ -0,0 +1,176 @@
+package source
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"cli-epub-parser-md-generator/epub"
+	"cli-epub-parser-md-generator/internal/htmlutil"
+	"cli-epub-parser-md-generator/render"
+)
+
+var (
+	markdownHeadingRe = regexp.MustCompile(`^(#{1,6})\s+(.+?)\s*#*\s*$`)
+	markdownFenceRe   = regexp.MustCompile("^\\s*(```|~~~)")
+)
+
+// markdownFrontMatter is the YAML front matter of a manuscript, between --- lines at the top.
+type markdownFrontMatter struct {
+	Title       string   `yaml:"title"`
+	Author      string   `yaml:"author"`
+	Authors     []string `yaml:"authors"`
+	Language    string   `yaml:"language"`
+	Date        string   `yaml:"date"`
+	Description string   `yaml:"description"`
+}
+
+// Markdown reads Markdown manuscripts, split into chapters at the top level headings. When
+// there is a single top level heading, it is the title of the book and chapters start at the
+// next level. Metadata is read from a YAML front matter with title, author and language, and
+// the local images are copied with the chapters.
+type Markdown struct{}
+
+func (Markdown) Read(path, dir string) (*epub.Book, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data, _, err = htmlutil.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s: %w", path, err)
+	}
+	text := htmlutil.Normalize(strings.ReplaceAll(string(data), "\r\n", "\n"))
+	var metadata epub.Metadata
+	if rest, ok := strings.CutPrefix(text, "---\n"); ok {
+		if front, body, ok := strings.Cut(rest, "\n---\n"); ok {
+			var matter markdownFrontMatter
+			if err := yaml.Unmarshal([]byte(front), &matter); err != nil {
+				return nil, fmt.Errorf("error parsing the front matter of %s: %w", path, err)
+			}
+			metadata.Title = matter.Title
+			metadata.Creators = matter.Authors
+			if len(matter.Author) > 0 {
+				metadata.Creators = append([]string{matter.Author}, metadata.Creators...)
+			}
+			metadata.Language, metadata.Date, metadata.Description = matter.Language, matter.Date, matter.Description
+			text = body
+		}
+	}
+	sections, title := splitMarkdown(text)
+	metadata.Title = cmp.Or(metadata.Title, title, strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+	b := newBuilder(dir, metadata)
+	for i, section := range sections {
+		body, _ := render.MarkdownToHTML(section.text)
+		if err := copyMarkdownImages(filepath.Dir(path), dir, section.text); err != nil {
+			return nil, err
+		}
+		if err := b.add(fmt.Sprintf("chapter-%03d.xhtml", i+1), cmp.Or(section.title, metadata.Title), body, true); err != nil {
+			return nil, err
+		}
+	}
+	return b.finish()
+}
+
+// markdownSection is a chapter of a manuscript, with its heading line in text.
+type markdownSection struct {
+	title string
+	text  string
+}
+
+// splitMarkdown splits a manuscript at its chapter headings, ignoring the lines of code blocks,
+// and returns the sections and the title of the book when it is the single top level heading.
+func splitMarkdown(text string) ([]markdownSection, string) {
+	lines := strings.Split(text, "\n")
+	levels := make([]int, len(lines))
+	counts := map[int]int{}
+	inFence := false
+	for i, line := range lines {
+		if markdownFenceRe.MatchString(line) {
+			inFence = !inFence
+			continue
+		}
+		if m := markdownHeadingRe.FindStringSubmatch(line); m != nil && !inFence {
+			levels[i] = len(m[1])
+			counts[levels[i]]++
+		}
+	}
+	top := 0
+	for level := 6; level >= 1; level-- {
+		if counts[level] > 0 {
+			top = level
+		}
+	}
+	title := ""
+	if top > 0 && counts[top] == 1 && counts[top+1] > 0 {
+		for i, level := range levels {
+			if level == top {
+				title = render.ParseMarkdown(lines[i])[0].PlainText()
+				lines[i], levels[i] = "", 0
+			}
+		}
+		top++
+	}
+	var sections []markdownSection
+	current := markdownSection{}
+	for i, line := range lines {
+		if levels[i] == top && top > 0 {
+			if len(strings.TrimSpace(current.text)) > 0 {
+				sections = append(sections, current)
+			}
+			current = markdownSection{title: render.ParseMarkdown(line)[0].PlainText()}
+		}
+		current.text += line + "\n"
+	}
+	if len(strings.TrimSpace(current.text)) > 0 {
+		sections = append(sections, current)
+	}
+	return sections, title
+}
+
+// copyMarkdownImages copies the images a section links to with a relative path from the folder
+// of the manuscript to the book folder, where the chapters are written.
+func copyMarkdownImages(from, to, text string) error {
+	for _, block := range render.ParseMarkdown(text) {
+		for _, inline := range block.Inlines {
+			if inline.Kind != render.InlineImage {
+				continue
+			}
+			ref, err := url.Parse(inline.URL)
+			if err != nil || ref.IsAbs() || len(ref.Path) == 0 || !filepath.IsLocal(filepath.FromSlash(ref.Path)) {
+				continue
+			}
+			if err := copyFile(filepath.Join(from, filepath.FromSlash(ref.Path)), filepath.Join(to, filepath.FromSlash(ref.Path))); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,51 @@
+package source
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMarkdown(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "img"), 0755)
+	os.WriteFile(filepath.Join(dir, "img", "map.png"), []byte("png"), 0644)
+	manuscript := "---\ntitle: The Manuscript\nauthor: Ann Writer\nlanguage: en\n---\n" +
+		"Some words before the first chapter.\n\n" +
+		"# One\n\nThe **first** chapter.\n\n![A map](img/map.png)\n\n```\n# not a heading\n```\n\n## A section\n\nMore.\n\n" +
+		"# Two\n\nThe second chapter.\n"
+	filename := filepath.Join(dir, "book.md")
+	os.WriteFile(filename, []byte(manuscript), 0644)
+	out := t.TempDir()
+	book, err := Open(filename, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Metadata.Title != "The Manuscript" || book.Metadata.Creators[0] != "Ann Writer" || book.Metadata.Language != "en" {
+		t.Errorf("unexpected metadata %+v", book.Metadata)
+	}
+	if titles := chapterTitles(book, false); !slices.Equal(titles, []string{"The Manuscript", "One", "Two"}) {
+		t.Errorf("unexpected chapters %q", titles)
+	}
+	md := chapterMarkdown(t, book, 1)
+	for _, want := range []string{"# One", "The **first** chapter.", "![A map](img-map.png)", "# not a heading", "## A section"} {
+		if !strings.Contains(md, want) {
+			t.Errorf("expected %q in %q", want, md)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(out, "img", "map.png")); err != nil {
+		t.Errorf("expected the image to be copied: %v", err)
+	}
+}
+
+func TestMarkdownTitleHeading(t *testing.T) {
+	book := readFile(t, "notes.md", "# My Book\n\n## First\n\nText.\n\n## Second\n\nText.\n")
+	if book.Metadata.Title != "My Book" {
+		t.Errorf("expected the single top heading as title, got %q", book.Metadata.Title)
+	}
+	if titles := chapterTitles(book, false); !slices.Equal(titles, []string{"First", "Second"}) {
+		t.Errorf("unexpected chapters %q", titles)
+	}
+}
@@ -0,0 +1,498 @@
+package source
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+
+	"cli-epub-parser-md-generator/epub"
+	"cli-epub-parser-md-generator/internal/htmlutil"
+)
+
+// mobiNull is the value of the record indexes of a MOBI header that are not set.
+const mobiNull = 0xffffffff
+
+// MOBI compression schemes.
+const (
+	mobiUncompressed = 1
+	mobiPalmDOC      = 2
+	mobiHuffCDIC     = 17480
+)
+
+var (
+	mobiPagebreakRe = regexp.MustCompile(`(?i)<mbp:pagebreak\s*/?>`)
+	mobiFileposRe   = regexp.MustCompile(`(?i)\bfilepos\s*=\s*["']?0*([0-9]+)["']?`)
+	mobiRecindexRe  = regexp.MustCompile(`(?i)\brecindex\s*=\s*["']?0*([0-9]+)["']?`)
+	mobiBodyStartRe = regexp.MustCompile(`(?is)^.*?<body[^>]*>`)
+	mobiBodyEndRe   = regexp.MustCompile(`(?is)</body>.*$`)
+	mobiTagRe       = regexp.MustCompile(`<[^>]*>`)
+	mobiAnchorRe    = regexp.MustCompile(`<a id="(filepos[0-9]+)"></a>`)
+)
+
+// MOBI reads Kindle books without DRM, MOBI, AZW and AZW3: the records of the PalmDB container
+// are decompressed, PalmDOC or HUFF/CDIC, into the HTML of the book. KF8 books (AZW3, and the
+// KF8 part of joint MOBI files) are rebuilt into their XHTML parts from the skeleton and fragment
+// indexes; older MOBI books are split at their page breaks. The table of contents is read from
+// the NCX index and the images from the resource records.
+type MOBI struct{}
+
+func (MOBI) Read(path, dir string) (*epub.Book, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	records, err := readPalmDB(data)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	header, err := readMOBIHeader(records, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	if boundary, ok := header.exthInt(121); ok && boundary > 0 && int(boundary) < len(records) {
+		// a joint file: the KF8 version of the book follows the MOBI 6 one
+		kf8, err := readMOBIHeader(records, int(boundary))
+		if err != nil {
+			return nil, fmt.Errorf("error reading the KF8 part of %s: %w", path, err)
+		}
+		if kf8.exth == nil {
+			kf8.exth = header.exth
+		}
+		header = kf8
+	}
+	text, err := header.text(records)
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing %s: %w", path, err)
+	}
+	b := newBuilder(dir, header.metadata())
+	if b.book.Metadata.Title == "" {
+		b.book.Metadata.Title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	images, err := writeMOBIImages(b, records, header.firstImage)
+	if err != nil {
+		return nil, err
+	}
+	if header.version >= 8 {
+		err = readKF8(b, header, records, text, images)
+	} else {
+		err = readMOBI6(b, header, records, text, images)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	return b.finish()
+}
+
+// readPalmDB splits a PalmDB file into its records.
+func readPalmDB(data []byte) ([][]byte, error) {
+	if len(data) < 78 {
+		return nil, fmt.Errorf("not a PalmDB file")
+	}
+	switch kind := string(data[60:68]); kind {
+	case "BOOKMOBI", "TEXtREAd":
+	default:
+		return nil, fmt.Errorf("not a MOBI book, type %q", kind)
+	}
+	count := int(binary.BigEndian.Uint16(data[76:]))
+	if 78+count*8 > len(data) {
+		return nil, fmt.Errorf("truncated record list")
+	}
+	records := make([][]byte, count)
+	for i := range count {
+		start, end := int(binary.BigEndian.Uint32(data[78+i*8:])), len(data)
+		if i+1 < count {
+			end = int(binary.BigEndian.Uint32(data[78+(i+1)*8:]))
+		}
+		if start > end || end > len(data) {
+			return nil, fmt.Errorf("invalid offset of record %d", i)
+		}
+		records[i] = data[start:end]
+	}
+	return records, nil
+}
+
+// mobiHeader is the header of a MOBI book, in its first record. Record indexes are absolute,
+// -1 when not set: those of a KF8 header are relative to its first record, base.
+type mobiHeader struct {
+	base        int
+	compression uint16
+	textLength  int
+	textRecords int
+	encoding    uint32
+	version     uint32
+	extraFlags  uint16
+	firstImage  int
+	huff        int
+	huffCount   int
+	fdst        int
+	skeleton    int
+	fragment    int
+	ncx         int
+	title       []byte
+	exth        map[uint32][][]byte
+}
+
+// readMOBIHeader reads the header in the record at base. It returns epub.ErrDRMProtected for
+// encrypted books.
+func readMOBIHeader(records [][]byte, base int) (*mobiHeader, error) {
+	if base >= len(records) || len(records[base]) < 16 {
+		return nil, fmt.Errorf("no MOBI header")
+	}
+	r := records[base]
+	h := &mobiHeader{
+		base:        base,
+		compression: binary.BigEndian.Uint16(r[0:]),
+		textLength:  int(binary.BigEndian.Uint32(r[4:])),
+		textRecords: int(binary.BigEndian.Uint16(r[8:])),
+		encoding:    1252,
+		firstImage:  -1, huff: -1, fdst: -1, skeleton: -1, fragment: -1, ncx: -1,
+	}
+	if encryption := binary.BigEndian.Uint16(r[12:]); encryption != 0 {
+		return nil, fmt.Errorf("%w (MOBI encryption type %d)", epub.ErrDRMProtected, encryption)
+	}
+	if len(r) < 24 || string(r[16:20]) != "MOBI" {
+		// a plain PalmDOC book
+		return h, nil
+	}
+	end := min(16+int(binary.BigEndian.Uint32(r[20:])), len(r))
+	field := func(offset int) uint32 {
+		if offset+4 > end {
+			return mobiNull
+		}
+		return binary.BigEndian.Uint32(r[offset:])
+	}
+	index := func(offset int) int {
+		if v := field(offset); v != mobiNull {
+			return base + int(v)
+		}
+		return -1
+	}
+	h.encoding = field(0x1c)
+	h.version = field(0x68)
+	if h.version == mobiNull {
+		h.version = 0
+	}
+	h.firstImage = index(0x6c)
+	h.huff, h.huffCount = index(0x70), int(field(0x74))
+	if h.huffCount == mobiNull {
+		h.huffCount = 0
+	}
+	if end >= 0xf4 {
+		h.extraFlags = binary.BigEndian.Uint16(r[0xf2:])
+	}
+	h.ncx = index(0xf4)
+	if h.version >= 8 {
+		h.fdst, h.fragment, h.skeleton = index(0xc0), index(0xf8), index(0xfc)
+	}
+	if offset, length := field(0x54), field(0x58); offset != mobiNull && int(offset)+int(length) <= len(r) {
+		h.title = r[offset : offset+length]
+	}
+	if field(0x80)&0x40 != 0 {
+		h.exth = readEXTH(r[end:])
+	}
+	return h, nil
+}
+
+// readEXTH reads the metadata records of the EXTH header, by type.
+func readEXTH(data []byte) map[uint32][][]byte {
+	if len(data) < 12 || string(data[:4]) != "EXTH" {
+		return nil
+	}
+	exth := map[uint32][][]byte{}
+	count := int(binary.BigEndian.Uint32(data[8:]))
+	for i, pos := 0, 12; i < count && pos+8 <= len(data); i++ {
+		kind, length := binary.BigEndian.Uint32(data[pos:]), int(binary.BigEndian.Uint32(data[pos+4:]))
+		if length < 8 || pos+length > len(data) {
+			break
+		}
+		exth[kind] = append(exth[kind], data[pos+8:pos+length])
+		pos += length
+	}
+	return exth
+}
+
+func (h *mobiHeader) exthInt(kind uint32) (uint32, bool) {
+	if values := h.exth[kind]; len(values) > 0 && len(values[0]) == 4 {
+		return binary.BigEndian.Uint32(values[0]), true
+	}
+	return 0, false
+}
+
+// decode converts text in the encoding of the book, Windows-1252 or UTF-8, to normalized UTF-8.
+func (h *mobiHeader) decode(data []byte) string {
+	if h.encoding == 1252 && !utf8.Valid(data) {
+		data, _ = charmap.Windows1252.NewDecoder().Bytes(data)
+	}
+	return htmlutil.Normalize(string(bytes.ToValidUTF8(data, []byte("\ufffd"))))
+}
+
+func (h *mobiHeader) metadata() epub.Metadata {
+	value := func(kind uint32) string {
+		if values := h.exth[kind]; len(values) > 0 {
+			return strings.TrimSpace(h.decode(values[0]))
+		}
+		return ""
+	}
+	metadata := epub.Metadata{
+		Title:       cmp.Or(value(503), strings.TrimSpace(h.decode(h.title))),
+		Publisher:   value(101),
+		Description: value(103),
+		Date:        value(106),
+		Language:    value(524),
+	}
+	for _, author := range h.exth[100] {
+		if name := strings.TrimSpace(h.decode(author)); len(name) > 0 {
+			metadata.Creators = append(metadata.Creators, name)
+		}
+	}
+	if isbn := value(104); len(isbn) > 0 {
+		metadata.Identifier = "urn:isbn:" + isbn
+	} else if asin := value(113); len(asin) > 0 {
+		metadata.Identifier = "urn:asin:" + asin
+	}
+	return metadata
+}
+
+// text decompresses the text records of the book.
+func (h *mobiHeader) text(records [][]byte) ([]byte, error) {
+	var decompress func([]byte) ([]byte, error)
+	switch h.compression {
+	case mobiUncompressed:
+		decompress = func(data []byte) ([]byte, error) { return data, nil }
+	case mobiPalmDOC:
+		decompress = decompressPalmDOC
+	case mobiHuffCDIC:
+		if h.huff < 0 || h.huff+h.huffCount > len(records) {
+			return nil, fmt.Errorf("missing HUFF/CDIC records")
+		}
+		huff, err := newHuffCDIC(records[h.huff : h.huff+h.huffCount])
+		if err != nil {
+			return nil, err
+		}
+		decompress = func(data []byte) ([]byte, error) { return huff.unpack(data, 0) }
+	default:
+		return nil, fmt.Errorf("unsupported compression %d", h.compression)
+	}
+	var text []byte
+	for i := h.base + 1; i <= h.base+h.textRecords && i < len(records); i++ {
+		record := records[i]
+		data, err := decompress(record[:len(record)-trailingSize(record, h.extraFlags)])
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", i, err)
+		}
+		text = append(text, data...)
+	}
+	if h.textLength > 0 && len(text) > h.textLength {
+		text = text[:h.textLength]
+	}
+	return text, nil
+}
+
+// trailingSize returns the size of the entries appended to a text record, such as the trailing
+// bytes of a multibyte character, as told by the extra data flags of the header.
+func trailingSize(record []byte, flags uint16) int {
+	size := 0
+	for bits := flags >> 1; bits != 0; bits >>= 1 {
+		if bits&1 == 0 {
+			continue
+		}
+		// the size of the entry is a variable width integer read backward from its end
+		value, shift := 0, 0
+		for end := len(record) - size; end > 0 && shift < 28; {
+			end--
+			value |= int(record[end]&0x7f) << shift
+			shift += 7
+			if record[end]&0x80 != 0 {
+				break
+			}
+		}
+		size += value
+	}
+	if flags&1 != 0 && size < len(record) {
+		size += int(record[len(record)-size-1]&0x03) + 1
+	}
+	return min(size, len(record))
+}
+
+// writeMOBIImages writes the images of the resource records from first to images/imageNNNNN
+// and returns their hrefs by number, from 1, as recindex attributes and kindle:embed links
+// refer to them.
+func writeMOBIImages(b *builder, records [][]byte, first int) (map[int]string, error) {
+	images := map[int]string{}
+	if first < 0 {
+		return images, nil
+	}
+	for i := first; i < len(records); i++ {
+		ext, mediaType := imageType(records[i])
+		if len(ext) == 0 {
+			continue
+		}
+		if len(images) == 0 {
+			if err := os.MkdirAll(b.book.Path("images"), 0755); err != nil {
+				return nil, err
+			}
+		}
+		href := fmt.Sprintf("images/image%05d%s", i-first+1, ext)
+		if err := os.WriteFile(b.book.Path(href), records[i], 0644); err != nil {
+			return nil, err
+		}
+		b.addFile(href, mediaType)
+		images[i-first+1] = href
+	}
+	return images, nil
+}
+
+// imageType returns the extension and media type of an image from its signature, or "".
+func imageType(data []byte) (string, string) {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		return ".jpg", "image/jpeg"
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return ".png", "image/png"
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return ".gif", "image/gif"
+	case bytes.HasPrefix(data, []byte("BM")) && len(data) > 14 && int(binary.LittleEndian.Uint32(data[2:])) == len(data):
+		return ".bmp", "image/bmp"
+	}
+	return "", ""
+}
+
+// readMOBI6 splits the HTML of a MOBI 6 book into documents at its page breaks. The filepos
+// links, byte offsets in the text, are pointed to anchors inserted at their targets.
+func readMOBI6(b *builder, h *mobiHeader, records [][]byte, text []byte, images map[int]string) error {
+	toc, err := readNCX(h, records)
+	if err != nil {
+		return err
+	}
+	targets := map[int]bool{}
+	for _, m := range mobiFileposRe.FindAllSubmatch(text, -1) {
+		if pos, err := strconv.Atoi(string(m[1])); err == nil && pos >= 0 && pos < len(text) {
+			targets[pos] = true
+		}
+	}
+	for _, entry := range toc {
+		if entry.pos >= 0 && entry.pos < len(text) {
+			targets[entry.pos] = true
+		}
+	}
+	text = insertAnchors(text, targets)
+	chunks := mobiPagebreakRe.Split(string(text), -1)
+	chunks[0] = stripKeepingAnchors(chunks[0], mobiBodyStartRe)
+	chunks[len(chunks)-1] = stripKeepingAnchors(chunks[len(chunks)-1], mobiBodyEndRe)
+	// the document of every anchor, to resolve the links
+	documents := map[string]string{}
+	var hrefs []string
+	for i, chunk := range chunks {
+		href := fmt.Sprintf("part%04d.html", i+1)
+		hrefs = append(hrefs, href)
+		for _, m := range mobiAnchorRe.FindAllStringSubmatch(chunk, -1) {
+			documents[m[1]] = href
+		}
+	}
+	for i, chunk := range chunks {
+		chunk = mobiFileposRe.ReplaceAllStringFunc(chunk, func(attr string) string {
+			id := "filepos" + mobiFileposRe.FindStringSubmatch(attr)[1]
+			return fmt.Sprintf(`href="%s#%s"`, documents[id], id)
+		})
+		chunk = mobiRecindexRe.ReplaceAllStringFunc(chunk, func(attr string) string {
+			n, _ := strconv.Atoi(mobiRecindexRe.FindStringSubmatch(attr)[1])
+			return fmt.Sprintf(`src="%s"`, images[n])
+		})
+		if len(strings.TrimSpace(mobiTagRe.ReplaceAllString(chunk, ""))) == 0 && !strings.Contains(chunk, "<img") {
+			continue
+		}
+		if err := b.add(hrefs[i], "", h.decode([]byte(chunk)), true); err != nil {
+			return err
+		}
+	}
+	for _, entry := range toc {
+		id := fmt.Sprintf("filepos%d", entry.pos)
+		if href, ok := documents[id]; ok && len(entry.label) > 0 {
+			b.book.TOC = append(b.book.TOC, epub.TOCItem{Title: entry.label, Href: href + "#" + id, Level: entry.level})
+		}
+	}
+	return nil
+}
+
+// stripKeepingAnchors removes the text matched by re from chunk, except the inserted anchors.
+func stripKeepingAnchors(chunk string, re *regexp.Regexp) string {
+	return re.ReplaceAllStringFunc(chunk, func(text string) string {
+		return strings.Join(mobiAnchorRe.FindAllString(text, -1), "")
+	})
+}
+
+// insertAnchors inserts an empty anchor with the id filepos<offset> at every target offset,
+// after the tag the offset falls in if any.
+func insertAnchors(text []byte, targets map[int]bool) []byte {
+	offsets := make([]int, 0, len(targets))
+	for pos := range targets {
+		offsets = append(offsets, pos)
+	}
+	slices.Sort(offsets)
+	var out []byte
+	last := 0
+	for _, pos := range offsets {
+		at := pos
+		if open := bytes.LastIndexByte(text[:at], '<'); open >= 0 && open >= bytes.LastIndexByte(text[:at], '>') {
+			if end := bytes.IndexByte(text[at:], '>'); end >= 0 {
+				at += end + 1
+			}
+		}
+		if loc := mobiPagebreakRe.FindIndex(text[at:]); loc != nil && loc[0] == 0 {
+			// the target is the start of the next document
+			at += loc[1]
+		}
+		at = max(at, last)
+		out = append(out, text[last:at]...)
+		out = fmt.Appendf(out, `<a id="filepos%d"></a>`, pos)
+		last = at
+	}
+	return append(out, text[last:]...)
+}
+
+// ncxEntry is an entry of the table of contents of a MOBI book.
+type ncxEntry struct {
+	label string
+	level int
+	pos   int // offset in the text
+	fid   int // KF8 fragment, -1 if not set
+}
+
+// readNCX reads the NCX index of the book, its table of contents.
+func readNCX(h *mobiHeader, records [][]byte) ([]ncxEntry, error) {
+	if h.ncx < 0 {
+		return nil, nil
+	}
+	entries, cncx, err := readIndex(records, h.ncx)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the NCX index: %w", err)
+	}
+	var toc []ncxEntry
+	for _, e := range entries {
+		entry := ncxEntry{level: 1, pos: -1, fid: -1}
+		if v := e.tags[1]; len(v) > 0 {
+			entry.pos = v[0]
+		}
+		if v := e.tags[3]; len(v) > 0 {
+			entry.label = strings.Join(strings.Fields(h.decode(cncx[v[0]])), " ")
+		}
+		if v := e.tags[4]; len(v) > 0 {
+			entry.level = v[0] + 1
+		}
+		if v := e.tags[6]; len(v) > 0 {
+			entry.fid = v[0]
+		}
+		toc = append(toc, entry)
+	}
+	return toc, nil
+}
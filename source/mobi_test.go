@@ -0,0 +1,313 @@
+package source
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"cli-epub-parser-md-generator/epub"
+)
+
+const testPNG = "\x89PNG\r\n\x1a\nimage"
+
+// palmDB builds a BOOKMOBI PalmDB file with records.
+func palmDB(records ...[]byte) []byte {
+	data := make([]byte, 78, 78+len(records)*8)
+	copy(data, "test")
+	copy(data[60:], "BOOKMOBI")
+	binary.BigEndian.PutUint16(data[76:], uint16(len(records)))
+	offset := 78 + len(records)*8
+	for i, r := range records {
+		data = binary.BigEndian.AppendUint32(data, uint32(offset))
+		data = binary.BigEndian.AppendUint32(data, uint32(i))
+		offset += len(r)
+	}
+	for _, r := range records {
+		data = append(data, r...)
+	}
+	return data
+}
+
+// mobiRecord0 builds the first record of a MOBI book: the PalmDOC header, a MOBI header with
+// fields set by offset in the record, record indexes unset otherwise, and EXTH records.
+func mobiRecord0(compression, encryption, textLength, textRecords int, fields map[int]uint32, exth map[uint32]string) []byte {
+	r := make([]byte, 0x108)
+	binary.BigEndian.PutUint16(r[0:], uint16(compression))
+	binary.BigEndian.PutUint32(r[4:], uint32(textLength))
+	binary.BigEndian.PutUint16(r[8:], uint16(textRecords))
+	binary.BigEndian.PutUint16(r[12:], uint16(encryption))
+	copy(r[16:], "MOBI")
+	binary.BigEndian.PutUint32(r[20:], 0x108-16)
+	binary.BigEndian.PutUint32(r[0x1c:], 65001)
+	for _, offset := range []int{0x6c, 0xc0, 0xf4, 0xf8, 0xfc} {
+		binary.BigEndian.PutUint32(r[offset:], mobiNull)
+	}
+	binary.BigEndian.PutUint32(r[0x80:], 0x40)
+	for offset, value := range fields {
+		binary.BigEndian.PutUint32(r[offset:], value)
+	}
+	var records []byte
+	for kind, value := range exth {
+		records = binary.BigEndian.AppendUint32(records, kind)
+		records = binary.BigEndian.AppendUint32(records, uint32(8+len(value)))
+		records = append(records, value...)
+	}
+	r = append(r, "EXTH"...)
+	r = binary.BigEndian.AppendUint32(r, uint32(12+len(records)))
+	r = binary.BigEndian.AppendUint32(r, uint32(len(exth)))
+	return append(r, records...)
+}
+
+// appendVarint appends v as a forward variable width integer.
+func appendVarint(data []byte, v int) []byte {
+	var groups []byte
+	for {
+		groups = append([]byte{byte(v & 0x7f)}, groups...)
+		if v >>= 7; v == 0 {
+			break
+		}
+	}
+	groups[len(groups)-1] |= 0x80
+	return append(data, groups...)
+}
+
+// testIndexEntry is an entry of an index built by mobiIndex.
+type testIndexEntry struct {
+	key    string
+	values map[int][]int
+}
+
+// mobiIndex builds the records of an index: its header, one record of entries and a CNCX record
+// of labels, whose offsets are returned. tags are the tags and their number of values.
+func mobiIndex(tags [][2]int, entries []testIndexEntry, labels ...string) ([][]byte, []int) {
+	var cncx []byte
+	var offsets []int
+	for _, label := range labels {
+		offsets = append(offsets, len(cncx))
+		cncx = append(appendVarint(cncx, len(label)), label...)
+	}
+	tagx := []byte("TAGX")
+	tagx = binary.BigEndian.AppendUint32(tagx, uint32(12+4*len(tags)+4))
+	tagx = binary.BigEndian.AppendUint32(tagx, 1)
+	for i, tag := range tags {
+		tagx = append(tagx, byte(tag[0]), byte(tag[1]), 1<<i, 0)
+	}
+	tagx = append(tagx, 0, 0, 0, 1)
+	header := make([]byte, 192)
+	copy(header, "INDX")
+	binary.BigEndian.PutUint32(header[24:], 1)
+	binary.BigEndian.PutUint32(header[52:], 1)
+	binary.BigEndian.PutUint32(header[180:], 192)
+	header = append(header, tagx...)
+	record := make([]byte, 192)
+	copy(record, "INDX")
+	var positions []int
+	for _, entry := range entries {
+		positions = append(positions, len(record))
+		record = append(append(record, byte(len(entry.key))), entry.key...)
+		control := byte(0)
+		for i, tag := range tags {
+			if _, ok := entry.values[tag[0]]; ok {
+				control |= 1 << i
+			}
+		}
+		record = append(record, control)
+		for _, tag := range tags {
+			for _, v := range entry.values[tag[0]] {
+				record = appendVarint(record, v)
+			}
+		}
+	}
+	binary.BigEndian.PutUint32(record[20:], uint32(len(record)))
+	binary.BigEndian.PutUint32(record[24:], uint32(len(entries)))
+	record = append(record, "IDXT"...)
+	for _, pos := range positions {
+		record = binary.BigEndian.AppendUint16(record, uint16(pos))
+	}
+	return [][]byte{header, record, cncx}, offsets
+}
+
+// palmDOCLiterals compresses data with PalmDOC literals only.
+func palmDOCLiterals(data []byte) []byte {
+	var out []byte
+	for _, c := range data {
+		if c == 0 || c >= 0x09 && c <= 0x7f {
+			out = append(out, c)
+		} else {
+			out = append(out, 1, c)
+		}
+	}
+	return out
+}
+
+// writeMOBI writes the records as a book named name and reads it.
+func writeMOBI(t *testing.T, name string, records ...[]byte) (*epub.Book, error) {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filename, palmDB(records...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return Open(filename, t.TempDir())
+}
+
+// testMOBI6 returns the records of a MOBI 6 book, and the offset of its second chapter.
+func testMOBI6() ([][]byte, int) {
+	text := []byte("<html><head><guide></guide></head><body><h1>Chapter One</h1><p>Caf\xe9 text, see <a filepos=0000000000>the note</a>.</p>" +
+		`<img recindex="00001"/><mbp:pagebreak/><h1>Chapter Two</h1><p>The note.</p></body></html>`)
+	second := bytes.Index(text, []byte("<h1>Chapter Two"))
+	copy(text[bytes.Index(text, []byte("0000000000")):], fmt.Sprintf("%010d", second))
+	ncx, labels := mobiIndex([][2]int{{1, 1}, {3, 1}, {4, 1}}, nil, "Chapter One", "Chapter Two")
+	ncx, _ = mobiIndex([][2]int{{1, 1}, {3, 1}, {4, 1}}, []testIndexEntry{
+		{"000", map[int][]int{1: {0}, 3: {labels[0]}, 4: {0}}},
+		{"001", map[int][]int{1: {second}, 3: {labels[1]}, 4: {0}}},
+	}, "Chapter One", "Chapter Two")
+	// two text records with a multibyte byte and a trailing entry of 3 bytes
+	var textRecords [][]byte
+	for _, part := range [][]byte{text[:60], text[60:]} {
+		textRecords = append(textRecords, append(palmDOCLiterals(part), 0x00, 'x', 'y', 0x83))
+	}
+	record0 := mobiRecord0(mobiPalmDOC, 0, len(text), 2, map[int]uint32{0x1c: 1252, 0x6c: 3, 0xf0: 0x0003, 0xf4: 4},
+		map[uint32]string{100: "Jane Doe", 503: "Test Book", 524: "en", 104: "978-1"})
+	return [][]byte{record0, textRecords[0], textRecords[1], []byte(testPNG), ncx[0], ncx[1], ncx[2]}, second
+}
+
+func TestMOBI6(t *testing.T) {
+	records, second := testMOBI6()
+	book, err := writeMOBI(t, "book.mobi", records...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Metadata.Title != "Test Book" || !slices.Equal(book.Metadata.Creators, []string{"Jane Doe"}) || book.Metadata.Language != "en" || book.Metadata.Identifier != "urn:isbn:978-1" {
+		t.Errorf("unexpected metadata %+v", book.Metadata)
+	}
+	if titles := chapterTitles(book, false); !slices.Equal(titles, []string{"Chapter One", "Chapter Two"}) {
+		t.Errorf("unexpected chapters %q", titles)
+	}
+	if len(book.TOC) != 2 || book.TOC[1].Href != fmt.Sprintf("part0002.html#filepos%d", second) {
+		t.Errorf("unexpected table of contents %+v", book.TOC)
+	}
+	md := chapterMarkdown(t, book, 0)
+	for _, want := range []string{"# Chapter One", "Café text", "the note", "images-image00001.png"} {
+		if !strings.Contains(md, want) {
+			t.Errorf("expected %q in %q", want, md)
+		}
+	}
+	if md := chapterMarkdown(t, book, 1); !strings.Contains(md, "The note.") || strings.Contains(md, "xy") {
+		t.Errorf("unexpected second chapter %q", md)
+	}
+	if data, err := os.ReadFile(book.Path("images/image00001.png")); err != nil || string(data) != testPNG {
+		t.Errorf("expected the image to be written, got %q %v", data, err)
+	}
+}
+
+// testKF8 returns the records of a KF8 book with two parts, an image and a stylesheet.
+func testKF8() [][]byte {
+	skeleton := "<html><head><title>T</title></head><body></body></html>"
+	first := `<h1>First</h1><p>One <img src="kindle:embed:0001?mime=image/png"/></p>`
+	second := `<h1>Second</h1><p><a href="kindle:pos:fid:0000:off:0000000000">Back</a> <link href="kindle:flow:0001?mime=text/css"/></p>`
+	markup := skeleton + first + skeleton + second
+	css := "p { margin: 0 }"
+	text := markup + css
+	insert := strings.Index(skeleton, "</body>")
+	skeletons, _ := mobiIndex([][2]int{{1, 1}, {6, 2}}, []testIndexEntry{
+		{"SKEL0000000", map[int][]int{1: {1}, 6: {0, len(skeleton)}}},
+		{"SKEL0000001", map[int][]int{1: {1}, 6: {len(skeleton + first), len(skeleton)}}},
+	})
+	fragments, _ := mobiIndex([][2]int{{6, 2}}, []testIndexEntry{
+		{fmt.Sprint(insert), map[int][]int{6: {0, len(first)}}},
+		{fmt.Sprint(len(skeleton+first) + insert), map[int][]int{6: {0, len(second)}}},
+	})
+	ncx, labels := mobiIndex(nil, nil, "First", "Second")
+	ncx, _ = mobiIndex([][2]int{{3, 1}, {4, 1}, {6, 2}}, []testIndexEntry{
+		{"000", map[int][]int{3: {labels[0]}, 4: {0}, 6: {0, 0}}},
+		{"001", map[int][]int{3: {labels[1]}, 4: {0}, 6: {1, 0}}},
+	}, "First", "Second")
+	fdst := []byte("FDST")
+	for _, v := range []int{12, 2, 0, len(markup), len(markup), len(text)} {
+		fdst = binary.BigEndian.AppendUint32(fdst, uint32(v))
+	}
+	record0 := mobiRecord0(mobiUncompressed, 0, len(text), 1, map[int]uint32{0x68: 8, 0x6c: 2, 0xc0: 3, 0xfc: 4, 0xf8: 7, 0xf4: 10},
+		map[uint32]string{503: "KF8 Book"})
+	records := [][]byte{record0, []byte(text), []byte(testPNG), fdst}
+	return append(append(append(records, skeletons...), fragments...), ncx...)
+}
+
+func TestKF8(t *testing.T) {
+	css := "p { margin: 0 }"
+	book, err := writeMOBI(t, "book.azw3", testKF8()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if titles := chapterTitles(book, false); !slices.Equal(titles, []string{"First", "Second"}) {
+		t.Errorf("unexpected chapters %q", titles)
+	}
+	part, _ := os.ReadFile(book.Path("part0000.xhtml"))
+	if want := `<body><h1>First</h1><p>One <img src="images/image00001.png"/></p></body>`; !strings.Contains(string(part), want) {
+		t.Errorf("expected %q in %q", want, part)
+	}
+	part, _ = os.ReadFile(book.Path("part0001.xhtml"))
+	for _, want := range []string{`href="part0000.xhtml"`, `href="styles/flow001.css"`} {
+		if !strings.Contains(string(part), want) {
+			t.Errorf("expected %q in %q", want, part)
+		}
+	}
+	if data, err := os.ReadFile(book.Path("styles/flow001.css")); err != nil || string(data) != css {
+		t.Errorf("expected the stylesheet to be written, got %q %v", data, err)
+	}
+}
+
+func TestMOBIErrors(t *testing.T) {
+	_, err := writeMOBI(t, "book.mobi", mobiRecord0(mobiPalmDOC, 2, 0, 0, nil, nil))
+	if !errors.Is(err, epub.ErrDRMProtected) {
+		t.Errorf("expected ErrDRMProtected, got %v", err)
+	}
+	filename := filepath.Join(t.TempDir(), "book.azw3")
+	if err := os.WriteFile(filename, []byte("not a book"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(filename, t.TempDir()); err == nil || !strings.Contains(err.Error(), "not a PalmDB file") {
+		t.Errorf("expected a PalmDB error, got %v", err)
+	}
+}
+
+// openMutated opens count copies of data with a few random bytes changed each, which must fail
+// or succeed without panicking.
+func openMutated(t *testing.T, name string, data []byte, count int) {
+	t.Helper()
+	random := rand.New(rand.NewPCG(1, 2))
+	dir := t.TempDir()
+	for i := range count {
+		mutated := bytes.Clone(data)
+		for range 1 + random.IntN(4) {
+			mutated[random.IntN(len(mutated))] = byte(random.IntN(256))
+		}
+		filename := filepath.Join(dir, fmt.Sprintf("%d-%s", i, name))
+		if err := os.WriteFile(filename, mutated, 0644); err != nil {
+			t.Fatal(err)
+		}
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Fatalf("mutation %d of %s panics: %v", i, name, r)
+				}
+			}()
+			Open(filename, filepath.Join(dir, fmt.Sprint(i)))
+		}()
+	}
+}
+
+func TestMOBIMalformed(t *testing.T) {
+	records, _ := testMOBI6()
+	openMutated(t, "book.mobi", palmDB(records...), 1000)
+	openMutated(t, "book.azw3", palmDB(testKF8()...), 1000)
+	if value, size := readVarint([]byte{0x7f, 0x7f, 0x7f, 0x7f, 0x7f, 0xff}); size != 0 || value != 0 {
+		t.Errorf("expected a varint longer than 4 bytes to be refused, got %d %d", value, size)
+	}
+}
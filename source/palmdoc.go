@@ -0,0 +1,165 @@
+package source
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// decompressPalmDOC expands a text record compressed with the LZ77 variant of PalmDOC.
+func decompressPalmDOC(data []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data)*2)
+	for i := 0; i < len(data); {
+		c := data[i]
+		i++
+		switch {
+		case c == 0 || c >= 0x09 && c <= 0x7f:
+			out = append(out, c)
+		case c <= 0x08:
+			// the next c bytes are literals
+			if i+int(c) > len(data) {
+				return nil, fmt.Errorf("truncated PalmDOC literal")
+			}
+			out = append(out, data[i:i+int(c)]...)
+			i += int(c)
+		case c <= 0xbf:
+			// a distance and length pair on 14 bits: copy from the output
+			if i >= len(data) {
+				return nil, fmt.Errorf("truncated PalmDOC pair")
+			}
+			pair := (int(c)<<8 | int(data[i])) & 0x3fff
+			i++
+			distance, length := pair>>3, pair&0x07+3
+			if distance == 0 || distance > len(out) {
+				return nil, fmt.Errorf("invalid PalmDOC distance %d", distance)
+			}
+			for range length {
+				out = append(out, out[len(out)-distance])
+			}
+		default:
+			// a space followed by a character
+			out = append(out, ' ', c^0x80)
+		}
+	}
+	return out, nil
+}
+
+// huffCDIC decompresses the text records of MOBI files compressed with the HUFF/CDIC scheme:
+// a Huffman code table and dictionaries of phrases, themselves possibly compressed.
+type huffCDIC struct {
+	dict1      [256]huffCode
+	minCode    [33]uint64
+	maxCode    [33]uint64
+	dictionary []huffPhrase
+}
+
+type huffCode struct {
+	length   int
+	terminal bool
+	max      uint64
+}
+
+type huffPhrase struct {
+	data     []byte
+	expanded bool
+}
+
+func newHuffCDIC(records [][]byte) (*huffCDIC, error) {
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no HUFF record")
+	}
+	huff := records[0]
+	if len(huff) < 16 || !bytes.HasPrefix(huff, []byte("HUFF\x00\x00\x00\x18")) {
+		return nil, fmt.Errorf("invalid HUFF record")
+	}
+	h := &huffCDIC{}
+	off1, off2 := int(binary.BigEndian.Uint32(huff[8:])), int(binary.BigEndian.Uint32(huff[12:]))
+	if off1+256*4 > len(huff) || off2+64*4 > len(huff) {
+		return nil, fmt.Errorf("invalid HUFF record")
+	}
+	for i := range h.dict1 {
+		v := binary.BigEndian.Uint32(huff[off1+i*4:])
+		length := int(v & 0x1f)
+		if length == 0 {
+			return nil, fmt.Errorf("invalid HUFF code length")
+		}
+		h.dict1[i] = huffCode{length: length, terminal: v&0x80 != 0, max: (uint64(v>>8)+1)<<(32-length) - 1}
+	}
+	for length := 1; length <= 32; length++ {
+		lo := uint64(binary.BigEndian.Uint32(huff[off2+(length-1)*8:]))
+		hi := uint64(binary.BigEndian.Uint32(huff[off2+(length-1)*8+4:]))
+		h.minCode[length] = lo << (32 - length)
+		h.maxCode[length] = (hi+1)<<(32-length) - 1
+	}
+	for _, cdic := range records[1:] {
+		if len(cdic) < 16 || !bytes.HasPrefix(cdic, []byte("CDIC\x00\x00\x00\x10")) {
+			return nil, fmt.Errorf("invalid CDIC record")
+		}
+		phrases, bits := int(binary.BigEndian.Uint32(cdic[8:])), binary.BigEndian.Uint32(cdic[12:])
+		n := min(1<<bits, phrases-len(h.dictionary))
+		for i := range n {
+			if 16+i*2+2 > len(cdic) {
+				return nil, fmt.Errorf("truncated CDIC record")
+			}
+			off := int(binary.BigEndian.Uint16(cdic[16+i*2:]))
+			if 18+off > len(cdic) {
+				return nil, fmt.Errorf("truncated CDIC record")
+			}
+			length := binary.BigEndian.Uint16(cdic[16+off:])
+			end := 18 + off + int(length&0x7fff)
+			if end > len(cdic) {
+				return nil, fmt.Errorf("truncated CDIC record")
+			}
+			h.dictionary = append(h.dictionary, huffPhrase{data: cdic[18+off : end], expanded: length&0x8000 != 0})
+		}
+	}
+	return h, nil
+}
+
+// unpack decompresses a record, expanding the phrases of the dictionary as they are used.
+func (h *huffCDIC) unpack(data []byte, depth int) ([]byte, error) {
+	if depth > 32 {
+		return nil, fmt.Errorf("HUFF/CDIC phrases nested too deep")
+	}
+	var out []byte
+	bitsLeft := len(data) * 8
+	padded := append(bytes.Clone(data), make([]byte, 8)...)
+	pos, n := 0, 32
+	x := binary.BigEndian.Uint64(padded)
+	for {
+		if n <= 0 {
+			pos += 4
+			x = binary.BigEndian.Uint64(padded[pos:])
+			n += 32
+		}
+		code := x >> n & 0xffffffff
+		entry := h.dict1[code>>24]
+		length, max := entry.length, entry.max
+		if !entry.terminal {
+			for length < 32 && code < h.minCode[length] {
+				length++
+			}
+			max = h.maxCode[length]
+		}
+		n -= length
+		bitsLeft -= length
+		if bitsLeft < 0 {
+			break
+		}
+		r := int((max - code) >> (32 - length))
+		if r < 0 || r >= len(h.dictionary) {
+			return nil, fmt.Errorf("invalid HUFF/CDIC phrase %d", r)
+		}
+		phrase := h.dictionary[r]
+		if !phrase.expanded {
+			expanded, err := h.unpack(phrase.data, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			phrase = huffPhrase{data: expanded, expanded: true}
+			h.dictionary[r] = phrase
+		}
+		out = append(out, phrase.data...)
+	}
+	return out, nil
+}
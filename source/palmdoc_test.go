@@ -0,0 +1,62 @@
+package source
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestDecompressPalmDOC(t *testing.T) {
+	// literals, a pair copying 4 bytes from 2 back, a space and a character, a run of 2 literals
+	data := []byte{'a', 'b', 0x80, 0x11, 'c' ^ 0x80, 0x02, 0xc3, 0xa9}
+	out, err := decompressPalmDOC(data)
+	if err != nil || string(out) != "ababab cé" {
+		t.Errorf("expected %q, got %q %v", "ababab cé", out, err)
+	}
+	if _, err := decompressPalmDOC([]byte{0x80, 0x11}); err == nil {
+		t.Error("expected an error for a distance before the start")
+	}
+	if _, err := decompressPalmDOC([]byte{0x03, 'a'}); err == nil {
+		t.Error("expected an error for a truncated literal")
+	}
+}
+
+// huffRecords builds a HUFF record of 8-bit codes where byte b is phrase 255-b, and a CDIC
+// record where every phrase is its byte, except the phrase of byte 1, compressed as "Hi".
+func huffRecords() [][]byte {
+	huff := make([]byte, 24+256*4+64*4)
+	copy(huff, "HUFF\x00\x00\x00\x18")
+	binary.BigEndian.PutUint32(huff[8:], 24)
+	binary.BigEndian.PutUint32(huff[12:], 24+256*4)
+	for i := range 256 {
+		binary.BigEndian.PutUint32(huff[24+i*4:], 255<<8|0x80|8)
+	}
+	cdic := []byte("CDIC\x00\x00\x00\x10")
+	cdic = binary.BigEndian.AppendUint32(cdic, 256)
+	cdic = binary.BigEndian.AppendUint32(cdic, 8)
+	var offsets, phrases []byte
+	for r := range 256 {
+		offsets = binary.BigEndian.AppendUint16(offsets, uint16(256*2+len(phrases)))
+		if b := byte(255 - r); b == 1 {
+			phrases = binary.BigEndian.AppendUint16(phrases, 2)
+			phrases = append(phrases, 'H', 'i')
+		} else {
+			phrases = binary.BigEndian.AppendUint16(phrases, 0x8000|1)
+			phrases = append(phrases, b)
+		}
+	}
+	return [][]byte{huff, append(append(cdic, offsets...), phrases...)}
+}
+
+func TestHuffCDIC(t *testing.T) {
+	huff, err := newHuffCDIC(huffRecords())
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := huff.unpack([]byte{1, ' ', 't', 'h', 'e', 'r', 'e'}, 0)
+	if err != nil || string(out) != "Hi there" {
+		t.Errorf("expected %q, got %q %v", "Hi there", out, err)
+	}
+	if _, err := newHuffCDIC([][]byte{[]byte("CDIC")}); err == nil {
+		t.Error("expected an error without a HUFF record")
+	}
+}
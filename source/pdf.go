@@ -0,0 +1,602 @@
+package source
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"cli-epub-parser-md-generator/epub"
+	"cli-epub-parser-md-generator/internal/htmlutil"
+)
+
+var (
+	// pdfPageNumberRe matches page numbers: 12, xiv, Page 3, 3 of 10.
+	pdfPageNumberRe = regexp.MustCompile(`(?i)^(page\s+)?([0-9]{1,4}|[ivxlcdm]{1,7})(\s*(of|/)\s*[0-9]{1,4})?$`)
+	pdfLigatures    = strings.NewReplacer("\ufb00", "ff", "\ufb01", "fi", "\ufb02", "fl", "\ufb03", "ffi", "\ufb04", "ffl")
+)
+
+// PDF reads the text of PDF files, page by page in the order of their content. The lines are
+// joined into paragraphs, with words hyphenated at the end of lines put back together, and the
+// running headers, footers and page numbers are left out. Chapters start at the top level
+// entries of the outline (the bookmarks), or at the next level when a single entry holds the
+// title; the levels below are sections. Without an outline, chapters start at the large headings
+// such as "Chapter 4", else the text is split into parts like plain text books.
+type PDF struct{}
+
+func (PDF) Read(path, dir string) (*epub.Book, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := openPDF(data)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	pages, pageIndex := f.pages()
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("no pages in %s", path)
+	}
+	fonts := map[pdfRef]*pdfFont{}
+	lines := make([][]pdfLine, len(pages))
+	for i, page := range pages {
+		lines[i] = pdfLines(f.pageRuns(page.dict, page.resources, fonts))
+	}
+	removeMargins(lines, pages)
+	blocks, body := pdfParagraphs(lines)
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("no text in %s, it may be a scanned PDF", path)
+	}
+	b := newBuilder(dir, f.metadata())
+	if b.book.Metadata.Title == "" {
+		b.book.Metadata.Title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if sections := pdfSections(blocks, f.outline(pageIndex)); len(sections) > 0 {
+		err = writePDFSections(b, blocks, sections)
+	} else {
+		err = writeTextChapters(b, splitPDF(blocks, body))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b.finish()
+}
+
+// pdfPage is a page with the resources and media box it inherits from the page tree.
+type pdfPage struct {
+	dict      pdfDict
+	resources pdfDict
+	box       [4]float64
+}
+
+// pages returns the pages in order, and their index by object number.
+func (f *pdfFile) pages() ([]pdfPage, map[int]int) {
+	var pages []pdfPage
+	index := map[int]int{}
+	seen := map[pdfRef]bool{}
+	var walk func(node any, resources pdfDict, box [4]float64)
+	walk = func(node any, resources pdfDict, box [4]float64) {
+		ref, isRef := node.(pdfRef)
+		if isRef {
+			if seen[ref] {
+				return
+			}
+			seen[ref] = true
+		}
+		dict := f.dict(node)
+		if r, ok := f.resolve(dict["Resources"]).(pdfDict); ok {
+			resources = r
+		}
+		if b := f.array(dict["MediaBox"]); len(b) == 4 {
+			for i := range box {
+				box[i], _ = f.number(b[i])
+			}
+		}
+		if kids, ok := f.resolve(dict["Kids"]).(pdfArray); ok && dict["Type"] != pdfName("Page") {
+			for _, kid := range kids {
+				walk(kid, resources, box)
+			}
+			return
+		}
+		if dict["Type"] != pdfName("Page") && dict["Contents"] == nil {
+			return
+		}
+		if isRef {
+			index[ref.num] = len(pages)
+		}
+		pages = append(pages, pdfPage{dict: dict, resources: resources, box: box})
+	}
+	walk(f.dict(f.trailer["Root"])["Pages"], pdfDict{}, [4]float64{0, 0, 612, 792})
+	return pages, index
+}
+
+// pdfText decodes a text string of the document: UTF-16 with a byte order mark, else
+// PDFDocEncoding, close to Windows-1252.
+func pdfText(v any) string {
+	s, _ := v.(pdfString)
+	var text string
+	switch {
+	case strings.HasPrefix(string(s), "\xfe\xff"):
+		text = utf16Text([]byte(s[2:]))
+	case strings.HasPrefix(string(s), "\xef\xbb\xbf"):
+		text = string(s[3:])
+	default:
+		var b strings.Builder
+		for _, c := range []byte(s) {
+			if c < 0x80 {
+				b.WriteByte(c)
+			} else {
+				b.WriteString(pdfWinAnsiEncoding[c])
+			}
+		}
+		text = b.String()
+	}
+	return strings.Join(strings.Fields(htmlutil.Normalize(text)), " ")
+}
+
+// metadata reads the document information dictionary and the language of the catalog.
+func (f *pdfFile) metadata() epub.Metadata {
+	info := f.dict(f.trailer["Info"])
+	value := func(key pdfName) string {
+		return pdfText(f.resolve(info[key]))
+	}
+	metadata := epub.Metadata{
+		Title:       value("Title"),
+		Description: value("Subject"),
+		Language:    pdfText(f.resolve(f.dict(f.trailer["Root"])["Lang"])),
+	}
+	if author := value("Author"); len(author) > 0 {
+		metadata.Creators = []string{author}
+	}
+	// dates are D:YYYYMMDDHHmmSS
+	if date := strings.TrimPrefix(value("CreationDate"), "D:"); len(date) >= 8 {
+		metadata.Date = date[:4] + "-" + date[4:6] + "-" + date[6:8]
+	} else if len(date) >= 4 {
+		metadata.Date = date[:4]
+	}
+	return metadata
+}
+
+// pdfOutlineEntry is a bookmark of the outline, at level 1 for the top level.
+type pdfOutlineEntry struct {
+	title string
+	level int
+	page  int
+}
+
+// outline returns the entries of the outline that point to a page, in order.
+func (f *pdfFile) outline(pageIndex map[int]int) []pdfOutlineEntry {
+	var entries []pdfOutlineEntry
+	seen := map[pdfRef]bool{}
+	var walk func(item any, level int)
+	walk = func(item any, level int) {
+		for item != nil && len(entries) < 10000 {
+			ref, ok := item.(pdfRef)
+			if !ok || seen[ref] {
+				return
+			}
+			seen[ref] = true
+			dict := f.dict(ref)
+			title := pdfText(f.resolve(dict["Title"]))
+			if page := f.destinationPage(dict, pageIndex); page >= 0 && len(title) > 0 {
+				entries = append(entries, pdfOutlineEntry{title: title, level: level, page: page})
+			}
+			walk(dict["First"], level+1)
+			item = dict["Next"]
+		}
+	}
+	walk(f.dict(f.dict(f.trailer["Root"])["Outlines"])["First"], 1)
+	return entries
+}
+
+// destinationPage returns the index of the page an outline entry goes to, or -1.
+func (f *pdfFile) destinationPage(dict pdfDict, pageIndex map[int]int) int {
+	dest := dict["Dest"]
+	if action := f.dict(dict["A"]); dest == nil && action["S"] == pdfName("GoTo") {
+		dest = action["D"]
+	}
+	for range 4 {
+		switch d := f.resolve(dest).(type) {
+		case pdfName:
+			dest = f.namedDestination(string(d))
+		case pdfString:
+			dest = f.namedDestination(string(d))
+		case pdfDict:
+			dest = d["D"]
+		case pdfArray:
+			if len(d) == 0 {
+				return -1
+			}
+			if ref, ok := d[0].(pdfRef); ok {
+				if page, ok := pageIndex[ref.num]; ok {
+					return page
+				}
+			}
+			return -1
+		default:
+			return -1
+		}
+	}
+	return -1
+}
+
+// namedDestination looks a destination up in the Dests dictionary of the catalog or the Dests
+// name tree.
+func (f *pdfFile) namedDestination(name string) any {
+	root := f.dict(f.trailer["Root"])
+	if dest, ok := f.dict(root["Dests"])[pdfName(name)]; ok {
+		return dest
+	}
+	seen := map[pdfRef]bool{}
+	var search func(node any, depth int) any
+	search = func(node any, depth int) any {
+		if ref, ok := node.(pdfRef); ok {
+			if seen[ref] {
+				return nil
+			}
+			seen[ref] = true
+		}
+		dict := f.dict(node)
+		names := f.array(dict["Names"])
+		for i := 0; i+1 < len(names); i += 2 {
+			if key, _ := f.resolve(names[i]).(pdfString); string(key) == name {
+				return names[i+1]
+			}
+		}
+		for _, kid := range f.array(dict["Kids"]) {
+			if depth < 32 {
+				if dest := search(kid, depth+1); dest != nil {
+					return dest
+				}
+			}
+		}
+		return nil
+	}
+	return search(f.dict(root["Names"])["Dests"], 0)
+}
+
+// pdfLine is a line of text, from x to end on the baseline y.
+type pdfLine struct {
+	text      string
+	x, y, end float64
+	size      float64
+}
+
+// pdfLines joins the runs of a page on the same baseline into lines, with a space between the
+// runs apart by more than a fifth of the font size.
+func pdfLines(runs []pdfRun) []pdfLine {
+	var lines []pdfLine
+	for _, run := range runs {
+		text := pdfLigatures.Replace(run.text)
+		size := max(run.size, 1)
+		if n := len(lines); n > 0 && math.Abs(lines[n-1].y-run.y) < 0.5*max(lines[n-1].size, size) {
+			line := &lines[n-1]
+			if run.x-line.end > 0.2*size && !strings.HasSuffix(line.text, " ") && !strings.HasPrefix(text, " ") {
+				line.text += " "
+			}
+			line.text += text
+			line.end, line.size = max(line.end, run.end), max(line.size, size)
+			continue
+		}
+		lines = append(lines, pdfLine{text: text, x: run.x, y: run.y, end: run.end, size: size})
+	}
+	for i := range lines {
+		lines[i].text = strings.Join(strings.Fields(lines[i].text), " ")
+	}
+	return slices.DeleteFunc(lines, func(line pdfLine) bool { return len(line.text) == 0 })
+}
+
+// removeMargins removes the running headers and footers, the lines in the top and bottom tenth
+// of the pages that repeat on three pages or more, digits aside, and the page numbers.
+func removeMargins(pages [][]pdfLine, boxes []pdfPage) {
+	key := func(text string) string {
+		return strings.Map(func(r rune) rune {
+			if unicode.IsDigit(r) {
+				return '#'
+			}
+			return unicode.ToLower(r)
+		}, strings.Join(strings.Fields(text), " "))
+	}
+	inMargin := func(line pdfLine, box [4]float64) bool {
+		height := box[3] - box[1]
+		return line.y > box[3]-0.1*height || line.y < box[1]+0.1*height
+	}
+	counts := map[string]int{}
+	for i, lines := range pages {
+		seen := map[string]bool{}
+		for _, line := range lines {
+			if k := key(line.text); inMargin(line, boxes[i].box) && !seen[k] {
+				seen[k] = true
+				counts[k]++
+			}
+		}
+	}
+	for i := range pages {
+		pages[i] = slices.DeleteFunc(pages[i], func(line pdfLine) bool {
+			text := strings.Join(strings.Fields(line.text), " ")
+			return inMargin(line, boxes[i].box) && (counts[key(text)] >= 3 || pdfPageNumberRe.MatchString(text))
+		})
+	}
+}
+
+// pdfBlock is a paragraph of the text, with the page it starts on and its font size.
+type pdfBlock struct {
+	text string
+	page int
+	size float64
+}
+
+// pdfParagraphs joins the lines into paragraphs, and returns them with the font size of the
+// body text. A paragraph ends at a wider gap between lines than usual, a change of font size, an
+// indented line, or a short line ending a sentence. A paragraph that does not end a sentence
+// continues on the next page.
+func pdfParagraphs(pages [][]pdfLine) ([]pdfBlock, float64) {
+	var gaps, sizes, widths []float64
+	for _, lines := range pages {
+		for i, line := range lines {
+			sizes = append(sizes, line.size)
+			widths = append(widths, line.end-line.x)
+			if i > 0 {
+				if gap := lines[i-1].y - line.y; gap > 0 && gap < 3*line.size {
+					gaps = append(gaps, gap)
+				}
+			}
+		}
+	}
+	body := median(sizes)
+	spacing := median(gaps)
+	if spacing == 0 {
+		spacing = 1.2 * body
+	}
+	slices.Sort(widths)
+	width := 0.0
+	if len(widths) > 0 {
+		width = widths[len(widths)*4/5]
+	}
+	var blocks []pdfBlock
+	for page, lines := range pages {
+		left := math.Inf(1)
+		for _, line := range lines {
+			left = min(left, line.x)
+		}
+		for i, line := range lines {
+			n := len(blocks)
+			if i == 0 {
+				// the paragraph of the previous page may go on
+				if n > 0 && !endsSentence(blocks[n-1].text) && startsLower(line.text) && math.Abs(blocks[n-1].size-line.size) < 0.15*body {
+					blocks[n-1].text = joinLines(blocks[n-1].text, line.text)
+				} else {
+					blocks = append(blocks, pdfBlock{text: line.text, page: page, size: line.size})
+				}
+				continue
+			}
+			prev := lines[i-1]
+			gap := prev.y - line.y
+			switch {
+			case gap > 1.5*spacing, gap < -0.5*line.size,
+				math.Abs(line.size-prev.size) > 0.15*body,
+				line.x-left > 0.8*line.size && prev.x-left < 0.5*line.size,
+				prev.end-prev.x < 0.7*width && endsSentence(prev.text):
+				blocks = append(blocks, pdfBlock{text: line.text, page: page, size: line.size})
+			default:
+				blocks[n-1].text = joinLines(blocks[n-1].text, line.text)
+			}
+		}
+	}
+	for i := range blocks {
+		blocks[i].text = strings.Join(strings.Fields(htmlutil.Normalize(blocks[i].text)), " ")
+	}
+	return slices.DeleteFunc(blocks, func(block pdfBlock) bool { return len(block.text) == 0 }), body
+}
+
+// joinLines joins two lines of a paragraph, putting back together a word hyphenated at the end
+// of the first.
+func joinLines(a, b string) string {
+	for _, hyphen := range []string{"-", "\u00ad"} {
+		if word, ok := strings.CutSuffix(a, hyphen); ok && startsLower(b) {
+			if r, _ := utf8.DecodeLastRuneInString(word); unicode.IsLetter(r) {
+				return word + b
+			}
+		}
+	}
+	return a + " " + b
+}
+
+func endsSentence(text string) bool {
+	r, _ := utf8.DecodeLastRuneInString(strings.TrimSpace(text))
+	return strings.ContainsRune(".!?:;\"\u201d\u2019)", r)
+}
+
+func startsLower(text string) bool {
+	r, _ := utf8.DecodeRuneInString(text)
+	return unicode.IsLower(r)
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	return sorted[len(sorted)/2]
+}
+
+// pdfSection is an outline entry at level 1 (chapter), 2 or 3, starting at block start, whose
+// heading takes the next headingBlocks blocks when it was found in the text.
+type pdfSection struct {
+	pdfOutlineEntry
+	start, headingBlocks int
+}
+
+// pdfSections places the outline entries in the text: at the paragraph of their page that
+// matches their title, else at the start of their page. Entries pointing back are skipped.
+func pdfSections(blocks []pdfBlock, outline []pdfOutlineEntry) []pdfSection {
+	top := 1
+	counts := map[int]int{}
+	for _, entry := range outline {
+		counts[entry.level]++
+	}
+	if counts[1] == 1 && counts[2] > 0 {
+		// the single top entry is the title of the book
+		top = 2
+	}
+	var sections []pdfSection
+	cut := 0
+	for _, entry := range outline {
+		if entry.level < top || entry.level > top+2 {
+			continue
+		}
+		entry.level -= top - 1
+		if cut < len(blocks) && entry.page < blocks[cut].page {
+			continue
+		}
+		start := cut
+		for start < len(blocks) && blocks[start].page < entry.page {
+			start++
+		}
+		section := pdfSection{pdfOutlineEntry: entry, start: start}
+		for i := start; i < len(blocks) && blocks[i].page == entry.page; i++ {
+			if n := matchHeading(blocks, i, entry.title); n > 0 {
+				section.start, section.headingBlocks = i, n
+				break
+			}
+		}
+		sections = append(sections, section)
+		cut = section.start + section.headingBlocks
+	}
+	if len(sections) == 0 || sections[0].level != 1 {
+		return nil
+	}
+	return sections
+}
+
+// matchHeading returns the number of blocks from i, one or two, whose text is the title, or 0.
+func matchHeading(blocks []pdfBlock, i int, title string) int {
+	want := headingKey(title)
+	text := ""
+	for n := 1; n <= 2 && i+n <= len(blocks) && blocks[i+n-1].page == blocks[i].page; n++ {
+		text += blocks[i+n-1].text
+		if len(text) > 2*len(title)+20 || len(want) == 0 {
+			return 0
+		}
+		got := headingKey(text)
+		if got == want || strings.HasSuffix(got, want) && len(got) <= len(want)+12 || strings.HasSuffix(want, got) && 2*len(got) >= len(want) {
+			return n
+		}
+	}
+	return 0
+}
+
+// headingKey returns the letters and digits of a title in lower case, to compare titles.
+func headingKey(title string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, title)
+}
+
+// writePDFSections writes a document per chapter, with its sections as <section> elements: as
+// in the books of the scraper, they carry their title in data-pdf-bookmark. The text before the
+// first chapter is a non-linear document.
+func writePDFSections(b *builder, blocks []pdfBlock, sections []pdfSection) error {
+	if start := sections[0].start; start > 0 {
+		if err := b.add("front.xhtml", b.book.Metadata.Title, pdfBlocksHTML(blocks[:start]), false); err != nil {
+			return err
+		}
+	}
+	chapter := 0
+	for i := 0; i < len(sections); {
+		end := i + 1
+		for end < len(sections) && sections[end].level > 1 {
+			end++
+		}
+		chapter++
+		href := fmt.Sprintf("chapter-%03d.xhtml", chapter)
+		var body strings.Builder
+		var toc []epub.TOCItem
+		open := 0
+		pos := 0
+		for j, section := range sections[i:end] {
+			if j > 0 {
+				body.WriteString(pdfBlocksHTML(blocks[pos:section.start]))
+			}
+			for ; open >= section.level; open-- {
+				body.WriteString("</section>\n")
+			}
+			kind, id := "chapter", ""
+			if section.level > 1 {
+				kind, id = fmt.Sprintf("sect%d", section.level-1), fmt.Sprintf("section-%d", j)
+				toc = append(toc, epub.TOCItem{Title: section.title, Href: href + "#" + id, Level: section.level})
+				id = fmt.Sprintf(` id="%s"`, id)
+			}
+			title := html.EscapeString(section.title)
+			fmt.Fprintf(&body, "<section data-type=\"%s\" data-pdf-bookmark=\"%s\"%s>\n<h%d>%s</h%d>\n", kind, title, id, section.level, title, section.level)
+			open = section.level
+			pos = section.start + section.headingBlocks
+		}
+		next := len(blocks)
+		if end < len(sections) {
+			next = sections[end].start
+		}
+		body.WriteString(pdfBlocksHTML(blocks[pos:next]))
+		body.WriteString(strings.Repeat("</section>\n", open))
+		if err := b.add(href, sections[i].title, body.String(), true); err != nil {
+			return err
+		}
+		b.book.TOC = append(b.book.TOC, toc...)
+		i = end
+	}
+	return nil
+}
+
+func pdfBlocksHTML(blocks []pdfBlock) string {
+	var texts []string
+	for _, block := range blocks {
+		texts = append(texts, block.text)
+	}
+	return paragraphs(texts)
+}
+
+// splitPDF groups paragraphs into chapters at the large headings, or like plain text books
+// when there are fewer than two.
+func splitPDF(blocks []pdfBlock, body float64) []textChapter {
+	var chapters []textChapter
+	current := textChapter{front: true}
+	headings := 0
+	for _, block := range blocks {
+		large := block.size >= 1.15*body && len(block.text) <= 100
+		if large && (block.size >= 1.5*body || textHeadingRe.MatchString(block.text)) {
+			if len(current.title) > 0 && len(current.blocks) == 0 {
+				// a heading on two lines of different sizes
+				current.title += " " + block.text
+				continue
+			}
+			if len(current.blocks) > 0 {
+				chapters = append(chapters, current)
+			}
+			current = textChapter{title: block.text}
+			headings++
+			continue
+		}
+		current.blocks = append(current.blocks, block.text)
+	}
+	if len(current.blocks) > 0 || len(current.title) > 0 {
+		chapters = append(chapters, current)
+	}
+	if headings >= 2 {
+		return chapters
+	}
+	var texts []string
+	for _, block := range blocks {
+		texts = append(texts, block.text)
+	}
+	return splitText(texts)
+}
@@ -0,0 +1,270 @@
+package source
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"cli-epub-parser-md-generator/epub"
+)
+
+// testPDFLine is a line of text drawn by pdfContent, at size at x, y.
+type testPDFLine struct {
+	size, x, y float64
+	text       string
+}
+
+// pdfContent returns a content stream object drawing lines with font F1, compressed when flate
+// is set.
+func pdfContent(flate bool, lines ...testPDFLine) string {
+	var content strings.Builder
+	for _, line := range lines {
+		fmt.Fprintf(&content, "BT /F1 %g Tf %g %g Td (%s) Tj ET\n", line.size, line.x, line.y, line.text)
+	}
+	if !flate {
+		return fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String())
+	}
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	w.Write([]byte(content.String()))
+	w.Close()
+	return fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", compressed.Len(), compressed.String())
+}
+
+// pdfFileData returns a PDF file with objects numbered from 1 and trailer entries.
+func pdfFileData(trailer string, objects ...string) []byte {
+	var data bytes.Buffer
+	data.WriteString("%PDF-1.7\n")
+	var offsets []int
+	for i, object := range objects {
+		offsets = append(offsets, data.Len())
+		fmt.Fprintf(&data, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := data.Len()
+	fmt.Fprintf(&data, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&data, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&data, "trailer\n<< /Size %d /Root 1 0 R %s >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, trailer, xref)
+	return data.Bytes()
+}
+
+// writePDF writes a PDF file with objects numbered from 1 and trailer entries, and reads it.
+func writePDF(t *testing.T, name, trailer string, objects ...string) (*epub.Book, error) {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filename, pdfFileData(trailer, objects...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return Open(filename, t.TempDir())
+}
+
+const testPDFFont = "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>"
+
+func TestPDF(t *testing.T) {
+	header := testPDFLine{10, 72, 760, "The Test Book"}
+	book, err := writePDF(t, "book.pdf", "/Info 14 0 R",
+		"<< /Type /Catalog /Pages 2 0 R /Outlines 10 0 R /Lang (en) /Names << /Dests << /Names [(two) [4 0 R /Fit]] >> >> >>",
+		"<< /Type /Pages /Kids [3 0 R 4 0 R 5 0 R] /Count 3 /Resources << /Font << /F1 6 0 R >> >> /MediaBox [0 0 612 792] >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 7 0 R >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 8 0 R >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 9 0 R >>",
+		testPDFFont,
+		pdfContent(false, header,
+			testPDFLine{24, 72, 700, "Chapter One"},
+			testPDFLine{12, 72, 660, "It was a dark and stormy night and the rain fell in tor-"},
+			testPDFLine{12, 72, 646, "rents over the town."},
+			testPDFLine{12, 72, 632, "The second paragraph goes on for a while and then it goes"},
+			testPDFLine{12, 72, 618, "on to the next page"},
+			testPDFLine{10, 300, 30, "1"}),
+		pdfContent(true, header,
+			testPDFLine{12, 72, 700, "in the middle of a sentence."},
+			testPDFLine{24, 72, 640, "Chapter Two"},
+			testPDFLine{16, 72, 600, "Section A"},
+			testPDFLine{12, 72, 570, "Text of the section."},
+			testPDFLine{10, 300, 30, "2"}),
+		pdfContent(false, header,
+			testPDFLine{12, 72, 700, "The end of the book at the Caf\\351."},
+			testPDFLine{10, 300, 30, "Page 3 of 3"}),
+		"<< /Type /Outlines /First 11 0 R /Last 12 0 R /Count 3 >>",
+		"<< /Title (Chapter One) /Parent 10 0 R /Next 12 0 R /Dest [3 0 R /XYZ 0 792 0] >>",
+		"<< /Title <FEFF0043006800610070007400650072002000540077006F> /Parent 10 0 R /Prev 11 0 R /First 13 0 R /Last 13 0 R /A << /S /GoTo /D (two) >> >>",
+		"<< /Title (Section A) /Parent 12 0 R /Dest [4 0 R /Fit] >>",
+		"<< /Title (Test PDF) /Author (Jane Doe) /CreationDate (D:20200102120000Z) >>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Metadata.Title != "Test PDF" || !slices.Equal(book.Metadata.Creators, []string{"Jane Doe"}) || book.Metadata.Language != "en" || book.Metadata.Date != "2020-01-02" {
+		t.Errorf("unexpected metadata %+v", book.Metadata)
+	}
+	if titles := chapterTitles(book, true); !slices.Equal(titles, []string{"Chapter One", "Chapter Two"}) {
+		t.Errorf("unexpected chapters %q", titles)
+	}
+	if len(book.TOC) != 3 || book.TOC[2].Title != "Section A" || book.TOC[2].Href != "chapter-002.xhtml#section-1" || book.TOC[2].Level != 2 {
+		t.Errorf("unexpected table of contents %+v", book.TOC)
+	}
+	md := chapterMarkdown(t, book, 0)
+	for _, want := range []string{"# Chapter One", "rain fell in torrents over the town.", "goes on to the next page in the middle of a sentence."} {
+		if !strings.Contains(md, want) {
+			t.Errorf("expected %q in %q", want, md)
+		}
+	}
+	md = chapterMarkdown(t, book, 1)
+	for _, want := range []string{"# Chapter Two", "## Section A", "Text of the section.", "The end of the book at the Café."} {
+		if !strings.Contains(md, want) {
+			t.Errorf("expected %q in %q", want, md)
+		}
+	}
+	if strings.Contains(md, "The Test Book") || strings.Contains(md, "Page 3") {
+		t.Errorf("expected headers and footers to be left out of %q", md)
+	}
+}
+
+func TestPDFWithoutOutline(t *testing.T) {
+	var lines []testPDFLine
+	y := 740.0
+	for _, text := range []string{"Preface", "Chapter 1", "The first chapter.", "Chapter 2", "The second chapter."} {
+		size := 12.0
+		if strings.HasPrefix(text, "Chapter") {
+			size = 18
+		}
+		lines = append(lines, testPDFLine{size, 72, y, text})
+		y -= 30
+	}
+	book, err := writePDF(t, "My Book.pdf", "",
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >>",
+		testPDFFont,
+		pdfContent(true, lines...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Metadata.Title != "My Book" {
+		t.Errorf("expected the title from the file name, got %q", book.Metadata.Title)
+	}
+	if titles := chapterTitles(book, false); !slices.Equal(titles, []string{"Chapter 1", "Chapter 2"}) {
+		t.Errorf("unexpected chapters %q", titles)
+	}
+	if md := chapterMarkdown(t, book, 1); !strings.Contains(md, "The second chapter.") {
+		t.Errorf("unexpected second chapter %q", md)
+	}
+}
+
+func TestPDFErrors(t *testing.T) {
+	_, err := writePDF(t, "book.pdf", "/Encrypt 3 0 R",
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [] /Count 0 >>",
+		"<< /Filter /Adobe.APS /V 4 >>")
+	if !errors.Is(err, epub.ErrDRMProtected) {
+		t.Errorf("expected ErrDRMProtected, got %v", err)
+	}
+	_, err = writePDF(t, "scan.pdf", "",
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>",
+		"<< /Length 0 >>\nstream\n\nendstream")
+	if err == nil || !strings.Contains(err.Error(), "scanned") {
+		t.Errorf("expected an error for a PDF without text, got %v", err)
+	}
+	filename := filepath.Join(t.TempDir(), "book.pdf")
+	if err := os.WriteFile(filename, []byte("not a book"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(filename, t.TempDir()); err == nil {
+		t.Error("expected an error for an invalid PDF")
+	}
+}
+
+func TestPDFMalformed(t *testing.T) {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >>",
+		testPDFFont,
+		pdfContent(false, testPDFLine{18, 72, 700, "Chapter 1"}, testPDFLine{12, 72, 670, "The first chapter."}),
+	}
+	data := pdfFileData("", objects...)
+	openMutated(t, "book.pdf", data, 1000)
+	// An xref entry with a negative offset is ignored and the objects are found by scanning the file.
+	negative := bytes.Replace(data, []byte("0000000009 00000 n"), []byte("-000000001 00000 n"), 1)
+	if bytes.Equal(negative, data) {
+		t.Fatal("expected the first object at offset 9")
+	}
+	filename := filepath.Join(t.TempDir(), "book.pdf")
+	if err := os.WriteFile(filename, negative, 0644); err != nil {
+		t.Fatal(err)
+	}
+	book, err := Open(filename, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md := chapterMarkdown(t, book, 0); !strings.Contains(md, "The first chapter.") {
+		t.Errorf("unexpected chapter %q", md)
+	}
+}
+
+func TestPDFLexer(t *testing.T) {
+	l := &pdfLexer{data: []byte(`<< /Kids [1 0 R 2 0 R] /Title (a \(b\) \101\nc) /Hex <48 69> /N -1.5 /B true /Odd#20Name null >>`)}
+	object, err := l.object()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dict, ok := object.(pdfDict)
+	if !ok {
+		t.Fatalf("expected a dictionary, got %#v", object)
+	}
+	if kids := dict["Kids"]; !slices.Equal(kids.(pdfArray), pdfArray{pdfRef{1, 0}, pdfRef{2, 0}}) {
+		t.Errorf("unexpected references %#v", kids)
+	}
+	if title := dict["Title"]; title != pdfString("a (b) A\nc") {
+		t.Errorf("unexpected literal string %q", title)
+	}
+	if hex := dict["Hex"]; hex != pdfString("Hi") {
+		t.Errorf("unexpected hex string %q", hex)
+	}
+	if n := dict["N"]; n != -1.5 || dict["B"] != true {
+		t.Errorf("unexpected number or boolean %#v %#v", n, dict["B"])
+	}
+	if _, ok := dict["Odd Name"]; !ok {
+		t.Errorf("expected the escaped name, got %#v", dict)
+	}
+}
+
+func TestParseCMap(t *testing.T) {
+	cmap := `begincodespacerange <0000> <FFFF> endcodespacerange
+2 beginbfchar <0003> <0020> <0011> <FB01> endbfchar
+2 beginbfrange <0024> <0026> <0041> <0030> <0031> [<0061> <00660066>] endbfrange`
+	mapping, codespaces := parseCMap([]byte(cmap))
+	want := map[uint32]string{0x03: " ", 0x11: "ﬁ", 0x24: "A", 0x25: "B", 0x26: "C", 0x30: "a", 0x31: "ff"}
+	for code, text := range want {
+		if mapping[code] != text {
+			t.Errorf("expected %q for %#x, got %q", text, code, mapping[code])
+		}
+	}
+	if len(codespaces) != 1 {
+		t.Errorf("unexpected code spaces %+v", codespaces)
+	}
+	font := &pdfFont{composite: true, toUnicode: mapping, codespaces: codespaces}
+	var text strings.Builder
+	for _, glyph := range font.decode([]byte("\x00\x24\x00\x03\x00\x31")) {
+		text.WriteString(glyph.text)
+	}
+	if text.String() != "A ff" {
+		t.Errorf("unexpected decoded text %q", text.String())
+	}
+}
+
+func TestGlyphText(t *testing.T) {
+	for name, want := range map[string]string{"A": "A", "eacute": "é", "quoteright": "’", "uni00E9": "é", "u1F600": "\U0001f600", "f_i": "fi", "a.sc": "a", "ccedilla": "ç", "bogus": ""} {
+		if got := glyphText(name); got != want {
+			t.Errorf("glyphText(%q) = %q, expected %q", name, got, want)
+		}
+	}
+}
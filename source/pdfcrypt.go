@@ -0,0 +1,207 @@
+package source
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rc4"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"hash"
+
+	"cli-epub-parser-md-generator/epub"
+)
+
+// pdfPadding pads passwords in the standard security handler.
+var pdfPadding = []byte("\x28\xbf\x4e\x5e\x4e\x75\x8a\x41\x64\x00\x4e\x56\xff\xfa\x01\x08\x2e\x2e\x00\xb6\xd0\x68\x3e\x80\x2f\x0c\xa9\xfe\x64\x53\x69\x7a")
+
+// pdfDecryptor decrypts the strings and streams of PDF files encrypted with the standard security
+// handler and an empty user password, which only restricts what readers allow, such as printing.
+// Files that need a password to be opened are DRM protected.
+type pdfDecryptor struct {
+	key      []byte
+	method   string // V2 (RC4), AESV2 (AES-128), AESV3 (AES-256) or None
+	revision int
+}
+
+func newPDFDecryptor(encrypt, trailer pdfDict) (*pdfDecryptor, error) {
+	if filter := encrypt["Filter"]; filter != pdfName("Standard") {
+		return nil, fmt.Errorf("%w (PDF security handler %v)", epub.ErrDRMProtected, filter)
+	}
+	v, _ := encrypt["V"].(int)
+	r, _ := encrypt["R"].(int)
+	d := &pdfDecryptor{method: "V2", revision: r}
+	if v >= 4 {
+		d.method = "None"
+		cf, _ := encrypt["CF"].(pdfDict)
+		if name, ok := encrypt["StmF"].(pdfName); ok && name != "Identity" {
+			filter, _ := cf[name].(pdfDict)
+			if method, ok := filter["CFM"].(pdfName); ok {
+				d.method = string(method)
+			}
+		}
+	}
+	o, _ := encrypt["O"].(pdfString)
+	u, _ := encrypt["U"].(pdfString)
+	if r >= 5 {
+		ue, _ := encrypt["UE"].(pdfString)
+		if len(u) < 48 || len(ue) < 32 {
+			return nil, fmt.Errorf("invalid PDF encryption dictionary")
+		}
+		if !bytes.Equal(d.hash([]byte(u[32:40])), []byte(u[:32])) {
+			return nil, fmt.Errorf("%w (the PDF needs a password)", epub.ErrDRMProtected)
+		}
+		block, err := aes.NewCipher(d.hash([]byte(u[40:48])))
+		if err != nil {
+			return nil, err
+		}
+		d.key = make([]byte, 32)
+		cipher.NewCBCDecrypter(block, make([]byte, 16)).CryptBlocks(d.key, []byte(ue[:32]))
+		return d, nil
+	}
+	length := 40
+	if l, ok := encrypt["Length"].(int); ok && r >= 3 {
+		length = l
+	}
+	n := min(length/8, 16)
+	p, _ := encrypt["P"].(int)
+	var id []byte
+	if ids, ok := trailer["ID"].(pdfArray); ok && len(ids) > 0 {
+		first, _ := ids[0].(pdfString)
+		id = []byte(first)
+	}
+	h := md5.New()
+	h.Write(pdfPadding)
+	h.Write([]byte(o))
+	h.Write(binary.LittleEndian.AppendUint32(nil, uint32(p)))
+	h.Write(id)
+	if metadata, ok := encrypt["EncryptMetadata"].(bool); ok && !metadata && r >= 4 {
+		h.Write([]byte{0xff, 0xff, 0xff, 0xff})
+	}
+	key := h.Sum(nil)
+	if r >= 3 {
+		for range 50 {
+			sum := md5.Sum(key[:n])
+			key = sum[:]
+		}
+	}
+	d.key = key[:n]
+	// the empty user password opens the file if it gives the U value
+	var check []byte
+	if r == 2 {
+		check = rc4Crypt(d.key, pdfPadding)
+	} else {
+		sum := md5.Sum(append(bytes.Clone(pdfPadding), id...))
+		check = sum[:]
+		for i := range 20 {
+			xored := make([]byte, len(d.key))
+			for j := range d.key {
+				xored[j] = d.key[j] ^ byte(i)
+			}
+			check = rc4Crypt(xored, check)
+		}
+	}
+	if len(u) < len(check) || !bytes.Equal([]byte(u[:len(check)]), check) {
+		return nil, fmt.Errorf("%w (the PDF needs a password)", epub.ErrDRMProtected)
+	}
+	return d, nil
+}
+
+// hash computes the hash of revisions 5 and 6 of an empty password with salt.
+func (d *pdfDecryptor) hash(salt []byte) []byte {
+	sum := sha256.Sum256(salt)
+	k := sum[:]
+	if d.revision < 6 {
+		return k
+	}
+	for round := 0; ; round++ {
+		k1 := bytes.Repeat(k, 64)
+		block, _ := aes.NewCipher(k[:16])
+		e := make([]byte, len(k1))
+		cipher.NewCBCEncrypter(block, k[16:32]).CryptBlocks(e, k1)
+		var h hash.Hash
+		switch sumMod3(e[:16]) {
+		case 0:
+			h = sha256.New()
+		case 1:
+			h = sha512.New384()
+		default:
+			h = sha512.New()
+		}
+		h.Write(e)
+		k = h.Sum(nil)
+		if round >= 63 && int(e[len(e)-1]) <= round+1-32 {
+			return k[:32]
+		}
+	}
+}
+
+func sumMod3(data []byte) int {
+	sum := 0
+	for _, c := range data {
+		sum += int(c)
+	}
+	return sum % 3
+}
+
+func rc4Crypt(key, data []byte) []byte {
+	c, _ := rc4.NewCipher(key)
+	out := make([]byte, len(data))
+	c.XORKeyStream(out, data)
+	return out
+}
+
+// decrypt decrypts the strings and streams of object num.
+func (d *pdfDecryptor) decrypt(value any, num, gen int) any {
+	switch v := value.(type) {
+	case pdfString:
+		return pdfString(d.decryptData([]byte(v), num, gen))
+	case pdfArray:
+		for i := range v {
+			v[i] = d.decrypt(v[i], num, gen)
+		}
+	case pdfDict:
+		for key := range v {
+			v[key] = d.decrypt(v[key], num, gen)
+		}
+	case *pdfStream:
+		d.decrypt(v.dict, num, gen)
+		v.data = d.decryptData(v.data, num, gen)
+	}
+	return value
+}
+
+func (d *pdfDecryptor) decryptData(data []byte, num, gen int) []byte {
+	if d.method == "None" {
+		return data
+	}
+	key := d.key
+	if d.method != "AESV3" {
+		h := md5.New()
+		h.Write(d.key)
+		h.Write([]byte{byte(num), byte(num >> 8), byte(num >> 16), byte(gen), byte(gen >> 8)})
+		if d.method == "AESV2" {
+			h.Write([]byte("sAlT"))
+		}
+		key = h.Sum(nil)[:min(len(d.key)+5, 16)]
+	}
+	if d.method == "V2" {
+		return rc4Crypt(key, data)
+	}
+	if len(data) < 32 || len(data)%16 != 0 {
+		return nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil
+	}
+	out := make([]byte, len(data)-16)
+	cipher.NewCBCDecrypter(block, data[:16]).CryptBlocks(out, data[16:])
+	if pad := int(out[len(out)-1]); pad >= 1 && pad <= 16 {
+		out = out[:len(out)-pad]
+	}
+	return out
+}
@@ -0,0 +1,811 @@
+package source
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/ascii85"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+// The values of PDF objects are nil, bool, int, float64, pdfString, pdfName, pdfArray, pdfDict,
+// *pdfStream and pdfRef. pdfKeyword is an operator or a delimiter.
+type (
+	pdfName    string
+	pdfString  string
+	pdfKeyword string
+	pdfArray   []any
+	pdfDict    map[pdfName]any
+	pdfRef     struct{ num, gen int }
+	pdfStream  struct {
+		dict pdfDict
+		data []byte // raw, still encoded with the filters of dict
+	}
+)
+
+// pdfLexer reads the tokens and objects of PDF files and content streams.
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isPDFDelimiter(c byte) bool {
+	return bytes.IndexByte([]byte("()<>[]{}/%"), c) >= 0
+}
+
+func (l *pdfLexer) skipSpace() {
+	for l.pos < len(l.data) {
+		switch c := l.data[l.pos]; {
+		case isPDFSpace(c):
+			l.pos++
+		case c == '%':
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// token reads a number, string, name or keyword, "[", "]", "<<" and ">>" being keywords.
+func (l *pdfLexer) token() (any, error) {
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil, io.EOF
+	}
+	switch c := l.data[l.pos]; c {
+	case '(':
+		return l.literalString()
+	case '<':
+		if l.pos+1 < len(l.data) && l.data[l.pos+1] == '<' {
+			l.pos += 2
+			return pdfKeyword("<<"), nil
+		}
+		return l.hexString()
+	case '>':
+		if l.pos+1 < len(l.data) && l.data[l.pos+1] == '>' {
+			l.pos += 2
+			return pdfKeyword(">>"), nil
+		}
+		l.pos++
+		return nil, fmt.Errorf("unexpected > at %d", l.pos-1)
+	case '[', ']', '{', '}':
+		l.pos++
+		return pdfKeyword(c), nil
+	case '/':
+		l.pos++
+		var name []byte
+		for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelimiter(l.data[l.pos]) {
+			if l.data[l.pos] == '#' && l.pos+2 < len(l.data) {
+				if b, err := hex.DecodeString(string(l.data[l.pos+1 : l.pos+3])); err == nil {
+					name = append(name, b[0])
+					l.pos += 3
+					continue
+				}
+			}
+			name = append(name, l.data[l.pos])
+			l.pos++
+		}
+		return pdfName(name), nil
+	}
+	start := l.pos
+	for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelimiter(l.data[l.pos]) {
+		l.pos++
+	}
+	if l.pos == start {
+		// a stray delimiter such as ) or }
+		l.pos++
+		return pdfKeyword(l.data[start:l.pos]), nil
+	}
+	word := string(l.data[start:l.pos])
+	if c := word[0]; c == '+' || c == '-' || c == '.' || c >= '0' && c <= '9' {
+		if n, err := strconv.Atoi(word); err == nil {
+			return n, nil
+		}
+		if f, err := strconv.ParseFloat(word, 64); err == nil {
+			return f, nil
+		}
+	}
+	return pdfKeyword(word), nil
+}
+
+func (l *pdfLexer) literalString() (any, error) {
+	l.pos++
+	var s []byte
+	for depth := 1; l.pos < len(l.data); {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				return pdfString(s), nil
+			}
+		case '\\':
+			if l.pos >= len(l.data) {
+				continue
+			}
+			c = l.data[l.pos]
+			l.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				// a line continuation
+				if c == '\r' && l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					n := int(c - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						n = n*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					c = byte(n)
+				}
+			}
+		}
+		s = append(s, c)
+	}
+	return pdfString(s), nil
+}
+
+func (l *pdfLexer) hexString() (any, error) {
+	l.pos++
+	var digits []byte
+	for l.pos < len(l.data) && l.data[l.pos] != '>' {
+		if c := l.data[l.pos]; !isPDFSpace(c) {
+			digits = append(digits, c)
+		}
+		l.pos++
+	}
+	l.pos++
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	s, err := hex.DecodeString(string(digits))
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex string: %w", err)
+	}
+	return pdfString(s), nil
+}
+
+// object reads a complete object: arrays and dictionaries with their content, and references.
+// Other keywords are returned as is.
+func (l *pdfLexer) object() (any, error) {
+	token, err := l.token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := token.(type) {
+	case int:
+		// maybe the object number of a reference: n g R
+		save := l.pos
+		if gen, err := l.token(); err == nil {
+			if g, ok := gen.(int); ok {
+				if r, err := l.token(); err == nil && r == pdfKeyword("R") {
+					return pdfRef{t, g}, nil
+				}
+			}
+		}
+		l.pos = save
+		return t, nil
+	case pdfKeyword:
+		switch t {
+		case "[":
+			var array pdfArray
+			for {
+				item, err := l.object()
+				if err != nil {
+					return nil, err
+				}
+				if item == pdfKeyword("]") {
+					return array, nil
+				}
+				array = append(array, item)
+			}
+		case "<<":
+			dict := pdfDict{}
+			for {
+				key, err := l.object()
+				if err != nil {
+					return nil, err
+				}
+				if key == pdfKeyword(">>") {
+					return dict, nil
+				}
+				name, ok := key.(pdfName)
+				if !ok {
+					continue
+				}
+				value, err := l.object()
+				if err != nil {
+					return nil, err
+				}
+				if value == pdfKeyword(">>") {
+					return dict, nil
+				}
+				dict[name] = value
+			}
+		case "true", "false":
+			return t == "true", nil
+		case "null":
+			return nil, nil
+		}
+	}
+	return token, nil
+}
+
+// pdfFile is a parsed PDF file whose objects are read on demand through the cross-reference
+// table, rebuilt by scanning the file when it is missing or broken.
+type pdfFile struct {
+	data       []byte
+	offsets    map[int]int    // object number to offset in the file
+	compressed map[int][2]int // object number to object stream and index in it
+	trailer    pdfDict
+	cache      map[int]any
+	loading    map[int]bool
+	rebuilt    bool
+	decryptor  *pdfDecryptor
+	encryptNum int
+}
+
+var pdfObjectRe = regexp.MustCompile(`(?m)(?:^|[\r\n\s])(\d+)\s+(\d+)\s+obj\b`)
+
+// openPDF reads the cross-reference table and trailer of a PDF file.
+func openPDF(data []byte) (*pdfFile, error) {
+	if !bytes.Contains(data[:min(len(data), 1024)], []byte("%PDF-")) {
+		return nil, fmt.Errorf("not a PDF file")
+	}
+	f := &pdfFile{data: data, offsets: map[int]int{}, compressed: map[int][2]int{}, cache: map[int]any{}, loading: map[int]bool{}}
+	if err := f.readXref(); err != nil || f.trailer["Root"] == nil {
+		if err := f.rebuild(); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := f.dict(f.trailer["Root"])["Pages"]; !ok && !f.rebuilt {
+		if err := f.rebuild(); err != nil {
+			return nil, err
+		}
+	}
+	if encrypt := f.trailer["Encrypt"]; encrypt != nil {
+		if ref, ok := encrypt.(pdfRef); ok {
+			f.encryptNum = ref.num
+		}
+		decryptor, err := newPDFDecryptor(f.dict(encrypt), f.trailer)
+		if err != nil {
+			return nil, err
+		}
+		f.decryptor = decryptor
+		// objects read so far are encrypted
+		f.cache = map[int]any{}
+	}
+	return f, nil
+}
+
+// readXref reads the cross-reference sections from the last one, following /Prev: entries of
+// later sections win.
+func (f *pdfFile) readXref() error {
+	at := bytes.LastIndex(f.data, []byte("startxref"))
+	if at < 0 {
+		return fmt.Errorf("no startxref")
+	}
+	l := &pdfLexer{data: f.data, pos: at + len("startxref")}
+	token, err := l.token()
+	offset, ok := token.(int)
+	if err != nil || !ok {
+		return fmt.Errorf("invalid startxref")
+	}
+	seen := map[int]bool{}
+	for offset >= 0 && !seen[offset] {
+		seen[offset] = true
+		trailer, err := f.readXrefSection(offset)
+		if err != nil {
+			return err
+		}
+		if f.trailer == nil {
+			f.trailer = trailer
+		}
+		if stm, ok := trailer["XRefStm"].(int); ok && !seen[stm] {
+			// a hybrid file: the compressed objects are in an xref stream
+			seen[stm] = true
+			if _, err := f.readXrefSection(stm); err != nil {
+				return err
+			}
+		}
+		offset = -1
+		if prev, ok := trailer["Prev"].(int); ok {
+			offset = prev
+		}
+	}
+	return nil
+}
+
+func (f *pdfFile) readXrefSection(offset int) (pdfDict, error) {
+	if offset < 0 || offset >= len(f.data) {
+		return nil, fmt.Errorf("xref offset out of the file")
+	}
+	l := &pdfLexer{data: f.data, pos: offset}
+	token, err := l.token()
+	if err != nil {
+		return nil, err
+	}
+	if token != pdfKeyword("xref") {
+		num, value, err := f.parseObjectAt(offset)
+		if err != nil {
+			return nil, err
+		}
+		stream, ok := value.(*pdfStream)
+		if !ok || stream.dict["Type"] != pdfName("XRef") {
+			return nil, fmt.Errorf("invalid xref stream %d", num)
+		}
+		return stream.dict, f.readXrefStream(stream)
+	}
+	for {
+		token, err := l.object()
+		if err != nil {
+			return nil, err
+		}
+		if token == pdfKeyword("trailer") {
+			trailer, err := l.object()
+			if dict, ok := trailer.(pdfDict); ok && err == nil {
+				return dict, nil
+			}
+			return nil, fmt.Errorf("invalid trailer")
+		}
+		start, ok := token.(int)
+		count, err := l.object()
+		if !ok || err != nil {
+			return nil, fmt.Errorf("invalid xref subsection")
+		}
+		n, _ := count.(int)
+		for i := range n {
+			off, _ := l.token()
+			if _, err := l.token(); err != nil {
+				return nil, err
+			}
+			kind, _ := l.token()
+			if o, ok := off.(int); ok && kind == pdfKeyword("n") {
+				if _, done := f.offsets[start+i]; !done {
+					if _, done := f.compressed[start+i]; !done {
+						f.offsets[start+i] = o
+					}
+				}
+			}
+		}
+	}
+}
+
+func (f *pdfFile) readXrefStream(stream *pdfStream) error {
+	data, err := f.decodeStream(stream)
+	if err != nil {
+		return err
+	}
+	w, _ := stream.dict["W"].(pdfArray)
+	if len(w) != 3 {
+		return fmt.Errorf("invalid xref stream widths")
+	}
+	widths := [3]int{}
+	for i := range widths {
+		widths[i], _ = w[i].(int)
+	}
+	index, _ := stream.dict["Index"].(pdfArray)
+	if len(index) == 0 {
+		size, _ := stream.dict["Size"].(int)
+		index = pdfArray{0, size}
+	}
+	field := func(row []byte) int {
+		n := 0
+		for _, c := range row {
+			n = n<<8 | int(c)
+		}
+		return n
+	}
+	rowSize := widths[0] + widths[1] + widths[2]
+	pos := 0
+	for i := 0; i+1 < len(index); i += 2 {
+		start, _ := index[i].(int)
+		count, _ := index[i+1].(int)
+		for n := start; n < start+count && pos+rowSize <= len(data); n++ {
+			row := data[pos : pos+rowSize]
+			pos += rowSize
+			kind := 1
+			if widths[0] > 0 {
+				kind = field(row[:widths[0]])
+			}
+			f2, f3 := field(row[widths[0]:widths[0]+widths[1]]), field(row[widths[0]+widths[1]:])
+			_, done := f.offsets[n]
+			_, compressed := f.compressed[n]
+			if done || compressed {
+				continue
+			}
+			switch kind {
+			case 1:
+				f.offsets[n] = f2
+			case 2:
+				f.compressed[n] = [2]int{f2, f3}
+			}
+		}
+	}
+	return nil
+}
+
+// rebuild finds the objects by scanning the file, for files without a usable xref table.
+func (f *pdfFile) rebuild() error {
+	f.rebuilt = true
+	f.offsets, f.compressed, f.cache = map[int]int{}, map[int][2]int{}, map[int]any{}
+	for _, m := range pdfObjectRe.FindAllSubmatchIndex(f.data, -1) {
+		num, _ := strconv.Atoi(string(f.data[m[2]:m[3]]))
+		f.offsets[num] = m[2]
+	}
+	if f.trailer == nil {
+		f.trailer = pdfDict{}
+	}
+	if at := bytes.LastIndex(f.data, []byte("trailer")); at >= 0 {
+		l := &pdfLexer{data: f.data, pos: at + len("trailer")}
+		if trailer, err := l.object(); err == nil {
+			if dict, ok := trailer.(pdfDict); ok {
+				f.trailer = dict
+			}
+		}
+	}
+	for num := range f.offsets {
+		stream, ok := f.resolve(pdfRef{num, 0}).(*pdfStream)
+		if !ok {
+			continue
+		}
+		switch stream.dict["Type"] {
+		case pdfName("ObjStm"):
+			if err := f.indexObjectStream(num, stream); err != nil {
+				continue
+			}
+		case pdfName("XRef"):
+			for _, key := range []pdfName{"Root", "Info", "Encrypt", "ID"} {
+				if _, ok := f.trailer[key]; !ok && stream.dict[key] != nil {
+					f.trailer[key] = stream.dict[key]
+				}
+			}
+		}
+	}
+	if f.trailer["Root"] == nil {
+		for num := range f.offsets {
+			if f.dict(pdfRef{num, 0})["Type"] == pdfName("Catalog") {
+				f.trailer["Root"] = pdfRef{num, 0}
+			}
+		}
+	}
+	if f.trailer["Root"] == nil {
+		return fmt.Errorf("no document catalog")
+	}
+	return nil
+}
+
+// indexObjectStream lists the objects of an object stream found by rebuild.
+func (f *pdfFile) indexObjectStream(num int, stream *pdfStream) error {
+	header, _, err := f.objectStreamHeader(stream)
+	if err != nil {
+		return err
+	}
+	for i, entry := range header {
+		if _, ok := f.offsets[entry[0]]; !ok {
+			f.compressed[entry[0]] = [2]int{num, i}
+		}
+	}
+	return nil
+}
+
+// objectStreamHeader returns the object numbers and offsets of an object stream and its data.
+func (f *pdfFile) objectStreamHeader(stream *pdfStream) ([][2]int, []byte, error) {
+	data, err := f.decodeStream(stream)
+	if err != nil {
+		return nil, nil, err
+	}
+	n, _ := stream.dict["N"].(int)
+	first, _ := stream.dict["First"].(int)
+	l := &pdfLexer{data: data}
+	var header [][2]int
+	for range n {
+		num, _ := l.token()
+		offset, _ := l.token()
+		nn, ok1 := num.(int)
+		o, ok2 := offset.(int)
+		if !ok1 || !ok2 {
+			return nil, nil, fmt.Errorf("invalid object stream header")
+		}
+		header = append(header, [2]int{nn, first + o})
+	}
+	return header, data, nil
+}
+
+// parseObjectAt parses the indirect object at offset, "n g obj ... endobj", decrypting it.
+func (f *pdfFile) parseObjectAt(offset int) (int, any, error) {
+	if offset < 0 || offset >= len(f.data) {
+		return 0, nil, fmt.Errorf("object offset %d out of the file", offset)
+	}
+	l := &pdfLexer{data: f.data, pos: offset}
+	num, _ := l.token()
+	gen, _ := l.token()
+	keyword, _ := l.token()
+	n, ok1 := num.(int)
+	g, ok2 := gen.(int)
+	if !ok1 || !ok2 || keyword != pdfKeyword("obj") {
+		return 0, nil, fmt.Errorf("no object at offset %d", offset)
+	}
+	value, err := l.object()
+	if err != nil {
+		return n, nil, err
+	}
+	if dict, ok := value.(pdfDict); ok {
+		save := l.pos
+		if token, _ := l.token(); token == pdfKeyword("stream") {
+			value, err = f.readStreamData(l, dict)
+			if err != nil {
+				return n, nil, err
+			}
+		} else {
+			l.pos = save
+		}
+	}
+	if f.decryptor != nil && n != f.encryptNum {
+		if stream, ok := value.(*pdfStream); !ok || stream.dict["Type"] != pdfName("XRef") {
+			value = f.decryptor.decrypt(value, n, g)
+		}
+	}
+	return n, value, nil
+}
+
+func (f *pdfFile) readStreamData(l *pdfLexer, dict pdfDict) (*pdfStream, error) {
+	// the data starts after the end of line following the keyword
+	if l.pos < len(l.data) && l.data[l.pos] == '\r' {
+		l.pos++
+	}
+	if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+		l.pos++
+	}
+	start := l.pos
+	length, ok := f.resolve(dict["Length"]).(int)
+	if ok && length >= 0 && start+length <= len(l.data) {
+		rest := bytes.TrimLeft(l.data[start+length:min(start+length+32, len(l.data))], " \r\n\t\f\x00")
+		if bytes.HasPrefix(rest, []byte("endstream")) {
+			return &pdfStream{dict: dict, data: l.data[start : start+length]}, nil
+		}
+	}
+	// a wrong length: the data ends at endstream
+	end := bytes.Index(l.data[start:], []byte("endstream"))
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated stream")
+	}
+	data := bytes.TrimSuffix(l.data[start:start+end], []byte("\n"))
+	return &pdfStream{dict: dict, data: bytes.TrimSuffix(data, []byte("\r"))}, nil
+}
+
+// object returns the object num, nil when it does not exist.
+func (f *pdfFile) object(num int) any {
+	if value, ok := f.cache[num]; ok {
+		return value
+	}
+	if f.loading[num] {
+		return nil
+	}
+	f.loading[num] = true
+	defer delete(f.loading, num)
+	var value any
+	if offset, ok := f.offsets[num]; ok {
+		n, v, err := f.parseObjectAt(offset)
+		if (err != nil || n != num) && !f.rebuilt {
+			// a wrong offset: find the objects again
+			if f.rebuild() == nil {
+				delete(f.loading, num)
+				return f.object(num)
+			}
+		}
+		value = v
+	} else if location, ok := f.compressed[num]; ok {
+		value = f.compressedObject(location[0], location[1])
+	}
+	f.cache[num] = value
+	return value
+}
+
+func (f *pdfFile) compressedObject(streamNum, index int) any {
+	stream, ok := f.object(streamNum).(*pdfStream)
+	if !ok {
+		return nil
+	}
+	header, data, err := f.objectStreamHeader(stream)
+	if err != nil || index >= len(header) || header[index][1] > len(data) {
+		return nil
+	}
+	l := &pdfLexer{data: data, pos: header[index][1]}
+	value, err := l.object()
+	if err != nil {
+		return nil
+	}
+	return value
+}
+
+// resolve follows references.
+func (f *pdfFile) resolve(v any) any {
+	for range 32 {
+		ref, ok := v.(pdfRef)
+		if !ok {
+			return v
+		}
+		v = f.object(ref.num)
+	}
+	return nil
+}
+
+// dict resolves v to a dictionary, the dictionary of a stream, or an empty one.
+func (f *pdfFile) dict(v any) pdfDict {
+	switch v := f.resolve(v).(type) {
+	case pdfDict:
+		return v
+	case *pdfStream:
+		return v.dict
+	}
+	return pdfDict{}
+}
+
+func (f *pdfFile) array(v any) pdfArray {
+	array, _ := f.resolve(v).(pdfArray)
+	return array
+}
+
+func (f *pdfFile) number(v any) (float64, bool) {
+	switch v := f.resolve(v).(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// decodeStream returns the data of a stream decoded with its filters.
+func (f *pdfFile) decodeStream(stream *pdfStream) ([]byte, error) {
+	data := stream.data
+	var filters, params pdfArray
+	switch filter := f.resolve(stream.dict["Filter"]).(type) {
+	case pdfName:
+		filters, params = pdfArray{filter}, pdfArray{f.resolve(stream.dict["DecodeParms"])}
+	case pdfArray:
+		filters, params = filter, f.array(stream.dict["DecodeParms"])
+	}
+	for i, filter := range filters {
+		var param pdfDict
+		if i < len(params) {
+			param = f.dict(params[i])
+		}
+		var err error
+		switch name, _ := f.resolve(filter).(pdfName); name {
+		case "FlateDecode", "Fl":
+			data, err = inflate(data)
+			if err == nil {
+				data, err = f.unpredict(data, param)
+			}
+		case "ASCIIHexDecode", "AHx":
+			var digits []byte
+			for _, c := range data {
+				if c == '>' {
+					break
+				}
+				if !isPDFSpace(c) {
+					digits = append(digits, c)
+				}
+			}
+			if len(digits)%2 == 1 {
+				digits = append(digits, '0')
+			}
+			data, err = hex.DecodeString(string(digits))
+		case "ASCII85Decode", "A85":
+			data = bytes.TrimPrefix(bytes.TrimSpace(data), []byte("<~"))
+			if end := bytes.Index(data, []byte("~>")); end >= 0 {
+				data = data[:end]
+			}
+			out := make([]byte, len(data))
+			var n int
+			n, _, err = ascii85.Decode(out, data, true)
+			data = out[:n]
+		default:
+			return nil, fmt.Errorf("unsupported filter %s", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error decoding %s stream: %w", filter, err)
+		}
+	}
+	return data, nil
+}
+
+// inflate decompresses zlib data, keeping what could be read of truncated streams.
+func inflate(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	out, err := io.ReadAll(r)
+	if err != nil && len(out) == 0 {
+		return nil, err
+	}
+	return out, nil
+}
+
+// unpredict reverses the PNG predictors of Flate streams, used by xref streams.
+func (f *pdfFile) unpredict(data []byte, param pdfDict) ([]byte, error) {
+	predictor, _ := f.number(param["Predictor"])
+	if predictor < 10 {
+		return data, nil
+	}
+	columns, colors, bits := 1.0, 1.0, 8.0
+	if v, ok := f.number(param["Columns"]); ok {
+		columns = v
+	}
+	if v, ok := f.number(param["Colors"]); ok {
+		colors = v
+	}
+	if v, ok := f.number(param["BitsPerComponent"]); ok {
+		bits = v
+	}
+	bpp := max(1, int(colors*bits+7)/8)
+	rowSize := int(columns*colors*bits+7) / 8
+	var out []byte
+	prev := make([]byte, rowSize)
+	for pos := 0; pos+rowSize+1 <= len(data); pos += rowSize + 1 {
+		kind, row := data[pos], bytes.Clone(data[pos+1:pos+1+rowSize])
+		for i := range row {
+			var left, up, upLeft int
+			if i >= bpp {
+				left, upLeft = int(row[i-bpp]), int(prev[i-bpp])
+			}
+			up = int(prev[i])
+			switch kind {
+			case 1:
+				row[i] += byte(left)
+			case 2:
+				row[i] += byte(up)
+			case 3:
+				row[i] += byte((left + up) / 2)
+			case 4:
+				p := left + up - upLeft
+				pa, pb, pc := abs(p-left), abs(p-up), abs(p-upLeft)
+				switch {
+				case pa <= pb && pa <= pc:
+					row[i] += byte(left)
+				case pb <= pc:
+					row[i] += byte(up)
+				default:
+					row[i] += byte(upLeft)
+				}
+			}
+		}
+		out = append(out, row...)
+		prev = row
+	}
+	return out, nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
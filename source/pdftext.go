@@ -0,0 +1,610 @@
+package source
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/unicode/norm"
+)
+
+// pdfRun is a piece of text shown on a page, from x to end on the baseline y, in user space.
+type pdfRun struct {
+	text      string
+	x, y, end float64
+	size      float64
+}
+
+// pdfMatrix is a transformation matrix [a b c d e f].
+type pdfMatrix [6]float64
+
+var pdfIdentity = pdfMatrix{1, 0, 0, 1, 0, 0}
+
+// mul returns m × n: m applied first.
+func (m pdfMatrix) mul(n pdfMatrix) pdfMatrix {
+	return pdfMatrix{
+		m[0]*n[0] + m[1]*n[2], m[0]*n[1] + m[1]*n[3],
+		m[2]*n[0] + m[3]*n[2], m[2]*n[1] + m[3]*n[3],
+		m[4]*n[0] + m[5]*n[2] + n[4], m[4]*n[1] + m[5]*n[3] + n[5],
+	}
+}
+
+func translate(x, y float64) pdfMatrix {
+	return pdfMatrix{1, 0, 0, 1, x, y}
+}
+
+// pdfTextState is the part of the graphics state that content streams save with q and restore
+// with Q.
+type pdfTextState struct {
+	ctm                              pdfMatrix
+	font                             *pdfFont
+	size, charSpace, wordSpace, rise float64
+	scale, leading                   float64
+}
+
+// pdfGlyph is a character code decoded by a font.
+type pdfGlyph struct {
+	text  string
+	width float64 // in thousandths of the font size
+	space bool    // the single byte code 32, which word spacing applies to
+}
+
+// pdfFont decodes the strings shown with a font into text, with a ToUnicode map or the encoding
+// of a simple font, and measures them.
+type pdfFont struct {
+	composite    bool
+	codespaces   []pdfCodespace
+	toUnicode    map[uint32]string
+	encoding     [256]string
+	widths       map[uint32]float64
+	defaultWidth float64
+}
+
+type pdfCodespace struct {
+	size   int
+	lo, hi uint32
+}
+
+// pdfTextExtractor runs the content streams of a page and collects its text runs.
+type pdfTextExtractor struct {
+	file  *pdfFile
+	fonts map[pdfRef]*pdfFont
+	runs  []pdfRun
+}
+
+// pageRuns returns the text runs of a page in the order of its content streams.
+func (f *pdfFile) pageRuns(page pdfDict, resources pdfDict, fonts map[pdfRef]*pdfFont) []pdfRun {
+	var content []byte
+	switch contents := f.resolve(page["Contents"]).(type) {
+	case *pdfStream:
+		content, _ = f.decodeStream(contents)
+	case pdfArray:
+		for _, part := range contents {
+			if stream, ok := f.resolve(part).(*pdfStream); ok {
+				if data, err := f.decodeStream(stream); err == nil {
+					content = append(append(content, data...), '\n')
+				}
+			}
+		}
+	}
+	x := &pdfTextExtractor{file: f, fonts: fonts}
+	x.run(content, resources, pdfTextState{ctm: pdfIdentity, scale: 1}, 0)
+	return x.runs
+}
+
+// run interprets a content stream, following the form XObjects it draws.
+func (x *pdfTextExtractor) run(content []byte, resources pdfDict, state pdfTextState, depth int) {
+	if depth > 8 {
+		return
+	}
+	f := x.file
+	var stack []pdfTextState
+	var operands []any
+	tm, tlm := pdfIdentity, pdfIdentity
+	number := func(i int) float64 {
+		if i < len(operands) {
+			v, _ := f.number(operands[i])
+			return v
+		}
+		return 0
+	}
+	move := func(tx, ty float64) {
+		tlm = translate(tx, ty).mul(tlm)
+		tm = tlm
+	}
+	show := func(s pdfString) {
+		if state.font == nil {
+			return
+		}
+		start := pdfMatrix{state.size * state.scale, 0, 0, state.size, 0, state.rise}.mul(tm).mul(state.ctm)
+		var text strings.Builder
+		for _, glyph := range state.font.decode([]byte(s)) {
+			text.WriteString(glyph.text)
+			advance := glyph.width/1000*state.size + state.charSpace
+			if glyph.space {
+				advance += state.wordSpace
+			}
+			tm = translate(advance*state.scale, 0).mul(tm)
+		}
+		end := pdfMatrix{state.size * state.scale, 0, 0, state.size, 0, state.rise}.mul(tm).mul(state.ctm)
+		if text.Len() > 0 {
+			x.runs = append(x.runs, pdfRun{text: text.String(), x: start[4], y: start[5], end: end[4], size: math.Hypot(start[2], start[3])})
+		}
+	}
+	l := &pdfLexer{data: content}
+	for {
+		token, err := l.object()
+		if err != nil {
+			return
+		}
+		op, ok := token.(pdfKeyword)
+		if !ok {
+			operands = append(operands, token)
+			continue
+		}
+		switch op {
+		case "q":
+			stack = append(stack, state)
+		case "Q":
+			if len(stack) > 0 {
+				state, stack = stack[len(stack)-1], stack[:len(stack)-1]
+			}
+		case "cm":
+			state.ctm = pdfMatrix{number(0), number(1), number(2), number(3), number(4), number(5)}.mul(state.ctm)
+		case "BT":
+			tm, tlm = pdfIdentity, pdfIdentity
+		case "Tf":
+			if len(operands) >= 2 {
+				state.font = x.font(f.dict(resources["Font"])[pdfNameOf(operands[0])])
+				state.size = number(1)
+			}
+		case "Tc":
+			state.charSpace = number(0)
+		case "Tw":
+			state.wordSpace = number(0)
+		case "Tz":
+			state.scale = number(0) / 100
+		case "TL":
+			state.leading = number(0)
+		case "Ts":
+			state.rise = number(0)
+		case "Td":
+			move(number(0), number(1))
+		case "TD":
+			state.leading = -number(1)
+			move(number(0), number(1))
+		case "Tm":
+			tlm = pdfMatrix{number(0), number(1), number(2), number(3), number(4), number(5)}
+			tm = tlm
+		case "T*":
+			move(0, -state.leading)
+		case "Tj":
+			if len(operands) > 0 {
+				s, _ := operands[0].(pdfString)
+				show(s)
+			}
+		case "'", "\"":
+			if op == "\"" && len(operands) == 3 {
+				state.wordSpace, state.charSpace = number(0), number(1)
+			}
+			move(0, -state.leading)
+			if len(operands) > 0 {
+				s, _ := operands[len(operands)-1].(pdfString)
+				show(s)
+			}
+		case "TJ":
+			if len(operands) > 0 {
+				array, _ := operands[0].(pdfArray)
+				for _, item := range array {
+					if s, ok := item.(pdfString); ok {
+						show(s)
+					} else if n, ok := f.number(item); ok {
+						tm = translate(-n/1000*state.size*state.scale, 0).mul(tm)
+					}
+				}
+			}
+		case "Do":
+			if len(operands) > 0 {
+				xobject, ok := f.resolve(f.dict(resources["XObject"])[pdfNameOf(operands[0])]).(*pdfStream)
+				if ok && xobject.dict["Subtype"] == pdfName("Form") {
+					data, err := f.decodeStream(xobject)
+					if err == nil {
+						formResources := resources
+						if r, ok := f.resolve(xobject.dict["Resources"]).(pdfDict); ok {
+							formResources = r
+						}
+						formState := state
+						if m := f.array(xobject.dict["Matrix"]); len(m) == 6 {
+							var matrix pdfMatrix
+							for i := range matrix {
+								matrix[i], _ = f.number(m[i])
+							}
+							formState.ctm = matrix.mul(state.ctm)
+						}
+						x.run(data, formResources, formState, depth+1)
+					}
+				}
+			}
+		case "BI":
+			// an inline image: skip its data up to EI
+			if end := strings.Index(string(content[l.pos:]), "ID"); end >= 0 {
+				l.pos += end + 2
+				for l.pos+2 < len(content) && !(isPDFSpace(content[l.pos]) && content[l.pos+1] == 'E' && content[l.pos+2] == 'I' && (l.pos+3 == len(content) || isPDFSpace(content[l.pos+3]))) {
+					l.pos++
+				}
+				l.pos += 3
+			}
+		}
+		operands = operands[:0]
+	}
+}
+
+func pdfNameOf(v any) pdfName {
+	name, _ := v.(pdfName)
+	return name
+}
+
+// font returns the font of a font dictionary, loaded once per dictionary.
+func (x *pdfTextExtractor) font(v any) *pdfFont {
+	ref, ok := v.(pdfRef)
+	if !ok {
+		// a direct dictionary, not comparable
+		return x.file.loadFont(v)
+	}
+	if font, ok := x.fonts[ref]; ok {
+		return font
+	}
+	font := x.file.loadFont(ref)
+	x.fonts[ref] = font
+	return font
+}
+
+func (f *pdfFile) loadFont(v any) *pdfFont {
+	dict := f.dict(v)
+	if len(dict) == 0 {
+		return nil
+	}
+	font := &pdfFont{widths: map[uint32]float64{}, defaultWidth: 500}
+	if dict["Subtype"] == pdfName("Type0") {
+		font.composite = true
+		font.defaultWidth = 1000
+		descendants := f.array(dict["DescendantFonts"])
+		if len(descendants) > 0 {
+			descendant := f.dict(descendants[0])
+			if dw, ok := f.number(descendant["DW"]); ok {
+				font.defaultWidth = dw
+			}
+			w := f.array(descendant["W"])
+			for i := 0; i+1 < len(w); {
+				first, _ := f.number(w[i])
+				if widths, ok := f.resolve(w[i+1]).(pdfArray); ok {
+					for j, width := range widths {
+						font.widths[uint32(first)+uint32(j)], _ = f.number(width)
+					}
+					i += 2
+					continue
+				}
+				if i+2 >= len(w) {
+					break
+				}
+				last, _ := f.number(w[i+1])
+				width, _ := f.number(w[i+2])
+				for c := uint32(first); c <= uint32(last) && c-uint32(first) < 65536; c++ {
+					font.widths[c] = width
+				}
+				i += 3
+			}
+		}
+	} else {
+		scale := 1.0
+		if m := f.array(dict["FontMatrix"]); dict["Subtype"] == pdfName("Type3") && len(m) > 0 {
+			a, _ := f.number(m[0])
+			scale = a * 1000
+		}
+		first, _ := f.number(dict["FirstChar"])
+		for i, width := range f.array(dict["Widths"]) {
+			w, _ := f.number(width)
+			font.widths[uint32(first)+uint32(i)] = w * scale
+		}
+		if base, _ := f.resolve(dict["BaseFont"]).(pdfName); strings.Contains(string(base), "Courier") {
+			font.defaultWidth = 600
+		}
+		font.encoding = f.fontEncoding(dict)
+	}
+	if stream, ok := f.resolve(dict["ToUnicode"]).(*pdfStream); ok {
+		if data, err := f.decodeStream(stream); err == nil {
+			font.toUnicode, font.codespaces = parseCMap(data)
+		}
+	}
+	return font
+}
+
+// fontEncoding returns the text of the codes of a simple font: its base encoding, standard by
+// default, changed by the Differences array.
+func (f *pdfFile) fontEncoding(dict pdfDict) [256]string {
+	encoding := pdfStandardEncoding
+	var differences pdfArray
+	switch e := f.resolve(dict["Encoding"]).(type) {
+	case pdfName:
+		encoding = namedEncoding(e)
+	case pdfDict:
+		if base, ok := f.resolve(e["BaseEncoding"]).(pdfName); ok {
+			encoding = namedEncoding(base)
+		}
+		differences = f.array(e["Differences"])
+	}
+	code := 0
+	for _, item := range differences {
+		switch v := f.resolve(item).(type) {
+		case int:
+			code = v
+		case pdfName:
+			if code >= 0 && code < 256 {
+				encoding[code] = glyphText(string(v))
+			}
+			code++
+		}
+	}
+	return encoding
+}
+
+var (
+	pdfStandardEncoding = buildEncoding(nil, map[byte]string{
+		0x27: "’", 0x60: "‘", 0xa1: "¡", 0xa2: "¢", 0xa3: "£", 0xa4: "⁄", 0xa5: "¥", 0xa6: "ƒ", 0xa7: "§",
+		0xa8: "¤", 0xa9: "'", 0xaa: "“", 0xab: "«", 0xac: "‹", 0xad: "›", 0xae: "fi", 0xaf: "fl", 0xb1: "–",
+		0xb2: "†", 0xb3: "‡", 0xb4: "·", 0xb6: "¶", 0xb7: "•", 0xb8: "‚", 0xb9: "„", 0xba: "”", 0xbb: "»",
+		0xbc: "…", 0xbd: "‰", 0xbf: "¿", 0xd0: "—", 0xe1: "Æ", 0xe8: "Ł", 0xe9: "Ø", 0xea: "Œ", 0xf1: "æ",
+		0xf5: "ı", 0xf8: "ł", 0xf9: "ø", 0xfa: "œ", 0xfb: "ß",
+	})
+	pdfWinAnsiEncoding  = buildEncoding(charmap.Windows1252, nil)
+	pdfMacRomanEncoding = buildEncoding(charmap.Macintosh, nil)
+)
+
+// buildEncoding maps the printable ASCII codes to themselves and the others with decoder, or
+// with the codes of upper.
+func buildEncoding(decoder *charmap.Charmap, upper map[byte]string) [256]string {
+	var encoding [256]string
+	for c := 0x20; c < 0x7f; c++ {
+		encoding[c] = string(rune(c))
+	}
+	for c := 0x80; c < 0x100 && decoder != nil; c++ {
+		// C1 control codes are left out
+		if r := decoder.DecodeByte(byte(c)); r != utf8.RuneError && r >= 0xa0 {
+			encoding[c] = string(r)
+		}
+	}
+	for c, s := range upper {
+		encoding[c] = s
+	}
+	return encoding
+}
+
+func namedEncoding(name pdfName) [256]string {
+	switch name {
+	case "WinAnsiEncoding":
+		return pdfWinAnsiEncoding
+	case "MacRomanEncoding":
+		return pdfMacRomanEncoding
+	}
+	return pdfStandardEncoding
+}
+
+// pdfGlyphNames are the text of the glyph names of font encodings besides letters, accented
+// letters (eacute) and uniXXXX names, see glyphText.
+var pdfGlyphNames = map[string]string{
+	"space": " ", "exclam": "!", "quotedbl": "\"", "numbersign": "#", "dollar": "$", "percent": "%",
+	"ampersand": "&", "quotesingle": "'", "quoteright": "’", "quoteleft": "‘", "parenleft": "(",
+	"parenright": ")", "asterisk": "*", "plus": "+", "comma": ",", "hyphen": "-", "period": ".",
+	"slash": "/", "zero": "0", "one": "1", "two": "2", "three": "3", "four": "4", "five": "5", "six": "6",
+	"seven": "7", "eight": "8", "nine": "9", "colon": ":", "semicolon": ";", "less": "<", "equal": "=",
+	"greater": ">", "question": "?", "at": "@", "bracketleft": "[", "backslash": "\\",
+	"bracketright": "]", "asciicircum": "^", "underscore": "_", "grave": "`", "braceleft": "{",
+	"bar": "|", "braceright": "}", "asciitilde": "~", "quotedblleft": "“", "quotedblright": "”",
+	"quotesinglbase": "‚", "quotedblbase": "„", "guillemotleft": "«", "guillemotright": "»",
+	"guilsinglleft": "‹", "guilsinglright": "›", "endash": "–", "emdash": "—", "bullet": "•",
+	"ellipsis": "…", "dagger": "†", "daggerdbl": "‡", "periodcentered": "·", "section": "§",
+	"paragraph": "¶", "copyright": "©", "registered": "®", "trademark": "™", "degree": "°",
+	"minus": "−", "multiply": "×", "divide": "÷", "plusminus": "±", "exclamdown": "¡",
+	"questiondown": "¿", "cent": "¢", "sterling": "£", "yen": "¥", "Euro": "€", "florin": "ƒ",
+	"germandbls": "ß", "ae": "æ", "AE": "Æ", "oe": "œ", "OE": "Œ", "oslash": "ø", "Oslash": "Ø",
+	"lslash": "ł", "Lslash": "Ł", "dotlessi": "ı", "eth": "ð", "Eth": "Ð", "thorn": "þ", "Thorn": "Þ",
+	"fi": "fi", "fl": "fl", "ff": "ff", "ffi": "ffi", "ffl": "ffl", "nbspace": " ", "sfthyphen": "-",
+	"perthousand": "‰", "fraction": "⁄", "onehalf": "½", "onequarter": "¼", "threequarters": "¾",
+}
+
+// pdfAccents are the combining marks of the suffixes of accented glyph names.
+var pdfAccents = map[string]string{
+	"acute": "\u0301", "grave": "\u0300", "circumflex": "\u0302", "dieresis": "\u0308", "tilde": "\u0303",
+	"ring": "\u030a", "cedilla": "\u0327", "caron": "\u030c", "macron": "\u0304", "breve": "\u0306",
+	"ogonek": "\u0328", "dotaccent": "\u0307", "hungarumlaut": "\u030b",
+}
+
+// glyphText returns the text of a glyph name, "" when unknown.
+func glyphText(name string) string {
+	name, _, _ = strings.Cut(name, ".")
+	if parts := strings.Split(name, "_"); len(parts) > 1 {
+		// a ligature such as f_f_i
+		var text strings.Builder
+		for _, part := range parts {
+			text.WriteString(glyphText(part))
+		}
+		return text.String()
+	}
+	if text, ok := pdfGlyphNames[name]; ok {
+		return text
+	}
+	if len(name) == 1 {
+		return name
+	}
+	if hexDigits, ok := strings.CutPrefix(name, "uni"); ok && len(hexDigits)%4 == 0 {
+		var text strings.Builder
+		for i := 0; i < len(hexDigits); i += 4 {
+			r, err := strconv.ParseUint(hexDigits[i:i+4], 16, 32)
+			if err != nil {
+				return ""
+			}
+			text.WriteRune(rune(r))
+		}
+		return text.String()
+	}
+	if hexDigits, ok := strings.CutPrefix(name, "u"); ok && len(hexDigits) >= 4 && len(hexDigits) <= 6 {
+		if r, err := strconv.ParseUint(hexDigits, 16, 32); err == nil {
+			return string(rune(r))
+		}
+	}
+	for suffix, mark := range pdfAccents {
+		if base, ok := strings.CutSuffix(name, suffix); ok && len(base) == 1 {
+			return norm.NFC.String(base + mark)
+		}
+	}
+	return ""
+}
+
+// parseCMap reads the character codes to text mappings and the code space ranges of a ToUnicode
+// CMap.
+func parseCMap(data []byte) (map[uint32]string, []pdfCodespace) {
+	mapping := map[uint32]string{}
+	var codespaces []pdfCodespace
+	l := &pdfLexer{data: data}
+	var operands []any
+	code := func(v any) (uint32, int) {
+		s, _ := v.(pdfString)
+		n := uint32(0)
+		for i := 0; i < len(s) && i < 4; i++ {
+			n = n<<8 | uint32(s[i])
+		}
+		return n, len(s)
+	}
+	for {
+		token, err := l.object()
+		if err != nil {
+			break
+		}
+		op, ok := token.(pdfKeyword)
+		if !ok {
+			operands = append(operands, token)
+			continue
+		}
+		switch op {
+		case "endcodespacerange":
+			for i := 0; i+1 < len(operands); i += 2 {
+				lo, size := code(operands[i])
+				hi, _ := code(operands[i+1])
+				codespaces = append(codespaces, pdfCodespace{size: size, lo: lo, hi: hi})
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, _ := code(operands[i])
+				switch dst := operands[i+1].(type) {
+				case pdfString:
+					mapping[src] = utf16Text([]byte(dst))
+				case pdfName:
+					mapping[src] = glyphText(string(dst))
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				lo, _ := code(operands[i])
+				hi, _ := code(operands[i+1])
+				if hi < lo || hi-lo > 65535 {
+					continue
+				}
+				switch dst := operands[i+2].(type) {
+				case pdfString:
+					units := []byte(dst)
+					for c := lo; c <= hi; c++ {
+						mapping[c] = utf16Text(units)
+						// the next code maps to the next character
+						units = incrementLast(units)
+					}
+				case pdfArray:
+					for j, item := range dst {
+						if s, ok := item.(pdfString); ok && lo+uint32(j) <= hi {
+							mapping[lo+uint32(j)] = utf16Text([]byte(s))
+						}
+					}
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+	return mapping, codespaces
+}
+
+func incrementLast(units []byte) []byte {
+	units = append([]byte(nil), units...)
+	for i := len(units) - 1; i >= 0; i-- {
+		units[i]++
+		if units[i] != 0 {
+			break
+		}
+	}
+	return units
+}
+
+// utf16Text decodes the UTF-16BE text of a ToUnicode mapping.
+func utf16Text(data []byte) string {
+	units := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		units = append(units, uint16(data[i])<<8|uint16(data[i+1]))
+	}
+	if len(data) == 1 {
+		units = append(units, uint16(data[0]))
+	}
+	return string(utf16.Decode(units))
+}
+
+// decode splits s into character codes, one byte for simple fonts and by the code space ranges,
+// or two bytes, for composite fonts.
+func (font *pdfFont) decode(s []byte) []pdfGlyph {
+	var glyphs []pdfGlyph
+	for i := 0; i < len(s); {
+		size := 1
+		if font.composite {
+			size = 2
+		}
+		if len(font.codespaces) > 0 {
+			size = font.codeSize(s[i:])
+		}
+		size = min(size, len(s)-i)
+		code := uint32(0)
+		for _, c := range s[i : i+size] {
+			code = code<<8 | uint32(c)
+		}
+		i += size
+		glyph := pdfGlyph{space: size == 1 && code == 32}
+		if text, ok := font.toUnicode[code]; ok {
+			glyph.text = text
+		} else if !font.composite && code < 256 {
+			glyph.text = font.encoding[code]
+		}
+		glyph.width = font.defaultWidth
+		if width, ok := font.widths[code]; ok {
+			glyph.width = width
+		}
+		glyphs = append(glyphs, glyph)
+	}
+	return glyphs
+}
+
+// codeSize returns the size of the code at the start of s by the code space ranges.
+func (font *pdfFont) codeSize(s []byte) int {
+	for size := 1; size <= 4 && size <= len(s); size++ {
+		code := uint32(0)
+		for _, c := range s[:size] {
+			code = code<<8 | uint32(c)
+		}
+		for _, space := range font.codespaces {
+			if space.size == size && code >= space.lo && code <= space.hi {
+				return size
+			}
+		}
+	}
+	if font.composite {
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,128 @@
+// Package source reads books in every supported input format, EPUB, FictionBook, MOBI and AZW3,
+// PDF, a folder of HTML files, Markdown and plain text, into an epub.Book: documents in reading
+// order on disk, written as XHTML when the format has none, so that they all go through the same
+// chapter numbering, conversion and pipeline.
+package source
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"cli-epub-parser-md-generator/epub"
+)
+
+// Source reads one input format.
+type Source interface {
+	// Read reads the book at path into dir, an empty folder it may write to, and returns it.
+	Read(path, dir string) (*epub.Book, error)
+}
+
+// Formats are the sources of files by extension. Directories are read by HTMLDir.
+var Formats = map[string]Source{
+	".epub":     EPUB{},
+	".fb2":      FB2{},
+	".md":       Markdown{},
+	".markdown": Markdown{},
+	".txt":      Text{},
+	".mobi":     MOBI{},
+	".azw":      MOBI{},
+	".azw3":     MOBI{},
+	".pdf":      PDF{},
+}
+
+// For returns the source of the book at path, by its extension or HTMLDir for a directory.
+func For(path string) (Source, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return HTMLDir{}, nil
+	}
+	if source, ok := Formats[strings.ToLower(filepath.Ext(path))]; ok {
+		return source, nil
+	}
+	return nil, fmt.Errorf("unsupported book format %q, expected .epub, .fb2, .mobi, .azw3, .pdf, .md, .txt or a folder of HTML files", filepath.Base(path))
+}
+
+// Open reads the book at path into dir with its source.
+func Open(path, dir string) (*epub.Book, error) {
+	source, err := For(path)
+	if err != nil {
+		return nil, err
+	}
+	return source.Read(path, dir)
+}
+
+// EPUB reads EPUB files, see epub.Extract and epub.Open.
+type EPUB struct{}
+
+func (EPUB) Read(path, dir string) (*epub.Book, error) {
+	if err := epub.Extract(path, dir); err != nil {
+		return nil, err
+	}
+	return epub.Open(dir)
+}
+
+// builder assembles a Book from the documents it writes to the book folder.
+type builder struct {
+	book *epub.Book
+}
+
+func newBuilder(dir string, metadata epub.Metadata) *builder {
+	return &builder{book: &epub.Book{Dir: dir, Metadata: metadata, Manifest: map[string]epub.ManifestItem{}}}
+}
+
+// add writes an XHTML document with title and body to href and appends it to the spine, and
+// to the table of contents at level 1 when it is linear and has a title. Documents with an
+// .html extension are listed as HTML, for bodies that are not well-formed XML.
+func (b *builder) add(href, title, body string, linear bool) error {
+	content := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>%s</title></head>
+<body>
+%s
+</body>
+</html>
+`, html.EscapeString(title), body)
+	if err := os.WriteFile(b.book.Path(href), []byte(content), 0644); err != nil {
+		return err
+	}
+	mediaType := "application/xhtml+xml"
+	if path.Ext(href) == ".html" {
+		mediaType = "text/html"
+	}
+	b.addFile(href, mediaType)
+	item := b.book.Manifest[href]
+	b.book.Spine = append(b.book.Spine, epub.SpineItem{ManifestItem: item, Linear: linear, Title: title})
+	if linear && len(title) > 0 {
+		b.book.TOC = append(b.book.TOC, epub.TOCItem{Title: title, Href: href, Level: 1})
+	}
+	return nil
+}
+
+// addFile lists a file of the book folder in the manifest, with its href as id.
+func (b *builder) addFile(href, mediaType string) {
+	b.book.Manifest[href] = epub.ManifestItem{ID: href, Href: href, MediaType: mediaType}
+}
+
+// finish names the documents and returns the book.
+func (b *builder) finish() (*epub.Book, error) {
+	if len(b.book.Spine) == 0 {
+		return nil, fmt.Errorf("no content found in the book")
+	}
+	b.book.AssignTitles()
+	return b.book, nil
+}
+
+// paragraphs renders text as XHTML paragraphs, one per block separated by blank lines, with
+// their lines joined.
+func paragraphs(blocks []string) string {
+	var b strings.Builder
+	for _, block := range blocks {
+		if text := strings.Join(strings.Fields(block), " "); len(text) > 0 {
+			fmt.Fprintf(&b, "<p>%s</p>\n", html.EscapeString(text))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,67 @@
+package source
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cli-epub-parser-md-generator/epub"
+	"cli-epub-parser-md-generator/extract"
+	"cli-epub-parser-md-generator/internal/epubtest"
+)
+
+// readFile writes content to a file named name and reads it with its source.
+func readFile(t *testing.T, name, content string) *epub.Book {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	book, err := Open(filename, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return book
+}
+
+// chapterTitles returns the titles of the chapters of book.
+func chapterTitles(book *epub.Book, all bool) []string {
+	var titles []string
+	for _, item := range book.Chapters(all) {
+		titles = append(titles, item.Title)
+	}
+	return titles
+}
+
+// chapterMarkdown converts the chapter at index of book.
+func chapterMarkdown(t *testing.T, book *epub.Book, index int) string {
+	t.Helper()
+	converted, err := extract.ConvertFile(book.Path(book.Chapters(false)[index].Href), extract.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return converted.Markdown
+}
+
+func TestFor(t *testing.T) {
+	tests := map[string]Source{"book.epub": EPUB{}, "Book.FB2": FB2{}, "notes.md": Markdown{}, "notes.markdown": Markdown{}, "book.txt": Text{}, "book.mobi": MOBI{}, "book.AZW3": MOBI{}, "book.pdf": PDF{}, t.TempDir(): HTMLDir{}}
+	for path, want := range tests {
+		if source, err := For(path); err != nil || source != want {
+			t.Errorf("%s: expected %T, got %T %v", path, want, source, err)
+		}
+	}
+	if _, err := For("book.docx"); err == nil || !strings.Contains(err.Error(), "unsupported book format") {
+		t.Errorf("expected an unsupported format error, got %v", err)
+	}
+}
+
+func TestEPUB(t *testing.T) {
+	book, err := Open(epubtest.Write(t, epubtest.Files), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Metadata.Title != "Test Book" || len(book.Chapters(false)) != 3 {
+		t.Errorf("unexpected book %+v", book)
+	}
+}
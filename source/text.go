@@ -0,0 +1,143 @@
+package source
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"cli-epub-parser-md-generator/epub"
+	"cli-epub-parser-md-generator/internal/htmlutil"
+)
+
+// textChapterWords is the size of the parts a text without chapter headings is split into.
+const textChapterWords = 3000
+
+var (
+	// textHeadingRe matches the lines that start a chapter in plain text books, ex: CHAPTER IV,
+	// Chapter 12. The Storm, Part Two, Prologue, XIV or 7.
+	textHeadingRe = regexp.MustCompile(`(?i)^((chapter|part|book|section)\s+([0-9]+|[ivxlcdm]+|one|two|three|four|five|six|seven|eight|nine|ten|eleven|twelve|[a-z]+teen|twenty[a-z-]*)\b.*|(prologue|epilogue|introduction|preface|foreword|afterword|appendix|conclusion)\b.*|[IVXLCDM]+\.?|[0-9]{1,3}\.?)$`)
+	// gutenbergStartRe and gutenbergEndRe frame the text of Project Gutenberg books.
+	gutenbergStartRe = regexp.MustCompile(`(?m)^\*\*\* ?START OF (THE|THIS) PROJECT GUTENBERG.*$`)
+	gutenbergEndRe   = regexp.MustCompile(`(?m)^\*\*\* ?END OF (THE|THIS) PROJECT GUTENBERG.*$`)
+	gutenbergFieldRe = regexp.MustCompile(`(?m)^(Title|Author|Language|Release Date):\s*(.+?)\s*$`)
+	blankLinesRe     = regexp.MustCompile(`\n[ \t]*\n`)
+)
+
+// Text reads plain text books. Chapters start at the short lines that look like chapter headings,
+// such as "CHAPTER IV" or "Part Two", alone in their paragraph; a text without them is split
+// into parts of about 3000 words. The text before the first heading, usually a title page and
+// contents, is a non-linear document. Project Gutenberg headers and licenses are left out.
+type Text struct{}
+
+func (Text) Read(path, dir string) (*epub.Book, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data, _, err = htmlutil.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s: %w", path, err)
+	}
+	text := htmlutil.Normalize(strings.ReplaceAll(string(data), "\r\n", "\n"))
+	metadata := epub.Metadata{Title: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))}
+	if start := gutenbergStartRe.FindStringIndex(text); start != nil {
+		for _, m := range gutenbergFieldRe.FindAllStringSubmatch(text[:start[0]], -1) {
+			switch m[1] {
+			case "Title":
+				metadata.Title = m[2]
+			case "Author":
+				metadata.Creators = []string{m[2]}
+			case "Language":
+				metadata.Language = m[2]
+			case "Release Date":
+				metadata.Date = m[2]
+			}
+		}
+		text = text[start[1]:]
+	}
+	if end := gutenbergEndRe.FindStringIndex(text); end != nil {
+		text = text[:end[0]]
+	}
+	b := newBuilder(dir, metadata)
+	if err := writeTextChapters(b, splitText(blankLinesRe.Split(text, -1))); err != nil {
+		return nil, err
+	}
+	return b.finish()
+}
+
+// writeTextChapters writes chapters as documents, the text before the first heading as a
+// non-linear document named after the book.
+func writeTextChapters(b *builder, chapters []textChapter) error {
+	for i, chapter := range chapters {
+		href := fmt.Sprintf("chapter-%03d.xhtml", i)
+		body := paragraphs(chapter.blocks)
+		if len(chapter.title) > 0 {
+			body = fmt.Sprintf("<h1>%s</h1>\n%s", html.EscapeString(chapter.title), body)
+		}
+		title := chapter.title
+		if chapter.front {
+			title = b.book.Metadata.Title
+		}
+		if err := b.add(href, title, body, !chapter.front); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// textChapter is a chapter of a plain text book: its heading and paragraphs.
+type textChapter struct {
+	title  string
+	blocks []string
+	front  bool // the text before the first heading
+}
+
+// splitText groups paragraphs into chapters at the headings, dropping the headings without
+// text such as the lines of a table of contents, or into parts when there are fewer than two.
+func splitText(blocks []string) []textChapter {
+	var chapters []textChapter
+	current := textChapter{front: true}
+	headings := 0
+	for _, block := range blocks {
+		block = strings.TrimSpace(block)
+		if len(block) == 0 {
+			continue
+		}
+		if !strings.Contains(block, "\n") && len(block) <= 80 && textHeadingRe.MatchString(block) {
+			if len(current.blocks) > 0 {
+				chapters = append(chapters, current)
+			}
+			current = textChapter{title: block}
+			headings++
+			continue
+		}
+		current.blocks = append(current.blocks, block)
+	}
+	if len(current.blocks) > 0 {
+		chapters = append(chapters, current)
+	}
+	if headings >= 2 {
+		return chapters
+	}
+	var all []string
+	for _, chapter := range chapters {
+		if len(chapter.title) > 0 {
+			all = append(all, chapter.title)
+		}
+		all = append(all, chapter.blocks...)
+	}
+	var parts []textChapter
+	words := 0
+	for _, block := range all {
+		if len(parts) == 0 || words >= textChapterWords {
+			parts = append(parts, textChapter{title: fmt.Sprintf("Part %d", len(parts)+1)})
+			words = 0
+		}
+		parts[len(parts)-1].blocks = append(parts[len(parts)-1].blocks, block)
+		words += len(strings.Fields(block))
+	}
+	return parts
+}
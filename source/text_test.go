@@ -0,0 +1,70 @@
+package source
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+const gutenbergText = `The Project Gutenberg eBook of A Short Tale
+
+Title: A Short Tale
+Author: Ann Writer
+Language: English
+
+*** START OF THE PROJECT GUTENBERG EBOOK A SHORT TALE ***
+
+A SHORT TALE
+
+Contents
+
+CHAPTER I
+
+CHAPTER II
+
+CHAPTER I
+
+It was a dark night,
+and the rain fell.
+
+Nobody came.
+
+CHAPTER II
+
+The morning was bright.
+
+*** END OF THE PROJECT GUTENBERG EBOOK A SHORT TALE ***
+
+The license.
+`
+
+func TestText(t *testing.T) {
+	book := readFile(t, "tale.txt", strings.ReplaceAll(gutenbergText, "\n", "\r\n"))
+	if book.Metadata.Title != "A Short Tale" || book.Metadata.Creators[0] != "Ann Writer" {
+		t.Errorf("unexpected metadata %+v", book.Metadata)
+	}
+	if titles := chapterTitles(book, false); !slices.Equal(titles, []string{"CHAPTER I", "CHAPTER II"}) {
+		t.Errorf("unexpected chapters %q", titles)
+	}
+	if titles := chapterTitles(book, true); len(titles) != 3 || titles[0] != "A Short Tale" {
+		t.Errorf("expected the front matter as a non-linear document, got %q", titles)
+	}
+	md := chapterMarkdown(t, book, 0)
+	if !strings.Contains(md, "# CHAPTER I\n\nIt was a dark night, and the rain fell.\n\nNobody came.") {
+		t.Errorf("unexpected markdown %q", md)
+	}
+	if strings.Contains(chapterMarkdown(t, book, 1), "license") {
+		t.Error("expected the Project Gutenberg license to be left out")
+	}
+}
+
+func TestTextWithoutHeadings(t *testing.T) {
+	paragraph := strings.Repeat("word ", 1000)
+	book := readFile(t, "essay.txt", strings.Repeat(paragraph+"\n\n", 5))
+	if titles := chapterTitles(book, false); !slices.Equal(titles, []string{"Part 1", "Part 2"}) {
+		t.Errorf("expected the text split into parts, got %q", titles)
+	}
+	if book.Metadata.Title != "essay" {
+		t.Errorf("expected the file name as title, got %q", book.Metadata.Title)
+	}
+}
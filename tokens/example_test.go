@@ -0,0 +1,16 @@
+package tokens_test
+
+import (
+	"fmt"
+
+	"cli-epub-parser-md-generator/tokens"
+)
+
+func ExampleCount() {
+	n, err := tokens.Count("The quick brown fox jumps over the lazy dog.")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(n)
+	// Output: 10
+}
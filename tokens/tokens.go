@@ -0,0 +1,38 @@
+// Package tokens counts the tokens a text costs when sent to a model, with the cl100k_base
+// encoding used by the OpenAI and DeepSeek chat models.
+package tokens
+
+import (
+	"sync"
+
+	"github.com/tiktoken-go/tokenizer"
+)
+
+var (
+	codecOnce sync.Once
+	codec     tokenizer.Codec
+	codecErr  error
+)
+
+func getCodec() (tokenizer.Codec, error) {
+	codecOnce.Do(func() {
+		codec, codecErr = tokenizer.Get(tokenizer.Cl100kBase)
+	})
+	return codec, codecErr
+}
+
+// Encode returns the token ids of text.
+func Encode(text string) ([]uint, error) {
+	enc, err := getCodec()
+	if err != nil {
+		return nil, err
+	}
+	ids, _, err := enc.Encode(text)
+	return ids, err
+}
+
+// Count returns the number of tokens of text.
+func Count(text string) (int, error) {
+	ids, err := Encode(text)
+	return len(ids), err
+}
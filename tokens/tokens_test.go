@@ -0,0 +1,16 @@
+package tokens
+
+import "testing"
+
+func TestCount(t *testing.T) {
+	n, err := Count("hello world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 tokens, got %d", n)
+	}
+	if n, _ := Count(""); n != 0 {
+		t.Errorf("expected no token for an empty text, got %d", n)
+	}
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cohesion-org/deepseek-go"
+
+	"cli-epub-parser-md-generator/epub"
+	"cli-epub-parser-md-generator/extract"
+	"cli-epub-parser-md-generator/pipeline"
+	"cli-epub-parser-md-generator/render"
+)
+
+// progressHub fans the progress events of the running generation out to the SSE clients.
+// Clients connecting late get the events they missed first.
+type progressHub struct {
+	mu      sync.Mutex
+	history []pipeline.ProgressEvent
+	clients map[chan pipeline.ProgressEvent]struct{}
+}
+
+func newProgressHub() *progressHub {
+	return &progressHub{clients: map[chan pipeline.ProgressEvent]struct{}{}}
+}
+
+func (h *progressHub) publish(e pipeline.ProgressEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.history = append(h.history, e)
+	for ch := range h.clients {
+		select {
+		case ch <- e:
+		default: // a slow client misses events rather than blocking the run
+		}
+	}
+}
+
+func (h *progressHub) reset() {
+	h.mu.Lock()
+	h.history = nil
+	h.mu.Unlock()
+}
+
+// subscribe returns the events published so far and a channel of the next ones, closed by cancel.
+func (h *progressHub) subscribe() ([]pipeline.ProgressEvent, chan pipeline.ProgressEvent, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch := make(chan pipeline.ProgressEvent, 64)
+	h.clients[ch] = struct{}{}
+	history := append([]pipeline.ProgressEvent(nil), h.history...)
+	return history, ch, func() {
+		h.mu.Lock()
+		delete(h.clients, ch)
+		h.mu.Unlock()
+	}
+}
+
+// uiChapter is a chapter as listed by the web UI.
+type uiChapter struct {
+	Number int
+	Title  string
+	Href   string
+	Tokens int
+	Linear bool
+	Status pipeline.ChapterStatus
+}
+
+// bookUI serves the web UI of one extracted book: its metadata, table of contents and chapters,
+// previews of the source and generated Markdown, and generation with live progress.
+type bookUI struct {
+	book       *epub.Book
+	bookName   string
+	configFile string
+	chapters   []epub.SpineItem
+	convert    extract.Options
+	tokens     []int
+	hub        *progressHub
+	// client replaces the DeepSeek client of the runs when set, for tests
+	client *deepseek.Client
+
+	mu        sync.Mutex
+	running   bool
+	outputDir string
+}
+
+func newBookUI(book *epub.Book, bookName, configFile string, convert extract.Options) *bookUI {
+	ui := &bookUI{
+		book:       book,
+		bookName:   bookName,
+		configFile: configFile,
+		chapters:   book.Chapters(false),
+		convert:    convert,
+		hub:        newProgressHub(),
+	}
+	ui.tokens = make([]int, len(ui.chapters))
+	for i, item := range ui.chapters {
+		ui.tokens[i] = chapterTokens(book, item, i+1, convert)
+	}
+	if settings, err := requestSettings([]string{"-book", bookName, "-config", configFile}); err == nil {
+		ui.outputDir = settings.outputDir
+	}
+	return ui
+}
+
+// convertChapter converts chapter number without copying its images, which are linked where
+// /book/ serves them.
+func (ui *bookUI) convertChapter(number int) (extract.Chapter, error) {
+	opts := ui.convert
+	opts.Chapter, opts.RootDir = number, ui.book.Dir
+	opts.AssetsDir, opts.BookHref = "", "/book"
+	return extract.ConvertFile(ui.book.Path(ui.chapters[number-1].Href), opts)
+}
+
+func (ui *bookUI) routes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /{$}", ui.handleIndex)
+	mux.HandleFunc("GET /chapters/{number}", ui.handleChapter)
+	mux.HandleFunc("POST /generate", ui.handleGenerate)
+	mux.HandleFunc("GET /events", ui.handleEvents)
+	mux.Handle("/book/", http.StripPrefix("/book/", bookFileServer(os.DirFS(ui.book.Dir))))
+}
+
+func (ui *bookUI) manifest() *pipeline.Manifest {
+	ui.mu.Lock()
+	dir := ui.outputDir
+	ui.mu.Unlock()
+	m, err := pipeline.LoadManifest(dir)
+	if err != nil {
+		return &pipeline.Manifest{}
+	}
+	return m
+}
+
+func (ui *bookUI) listChapters() []uiChapter {
+	status := map[int]pipeline.ChapterStatus{}
+	for _, ch := range ui.manifest().Chapters {
+		status[ch.Number] = ch.Status
+	}
+	chapters := make([]uiChapter, len(ui.chapters))
+	for i, item := range ui.chapters {
+		chapters[i] = uiChapter{Number: i + 1, Title: item.Title, Href: item.Href, Tokens: ui.tokens[i], Linear: item.Linear, Status: status[i+1]}
+	}
+	return chapters
+}
+
+func (ui *bookUI) render(w http.ResponseWriter, name string, data any) {
+	var buf bytes.Buffer
+	if err := uiTemplates.ExecuteTemplate(&buf, name, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
+}
+
+func (ui *bookUI) handleIndex(w http.ResponseWriter, r *http.Request) {
+	var profiles []string
+	if loaded, err := loadConfig(ui.configFile, ""); err == nil {
+		profiles = loaded.profileNames()
+	}
+	ui.mu.Lock()
+	running := ui.running
+	ui.mu.Unlock()
+	ui.render(w, "index", struct {
+		Metadata epub.Metadata
+		TOC      []epub.TOCItem
+		Chapters []uiChapter
+		Profiles []string
+		Styles   []string
+		Running  bool
+	}{ui.book.Metadata, ui.book.TOC, ui.listChapters(), profiles, []string{render.StyleBlog, render.StyleYouTubeScript}, running})
+}
+
+func (ui *bookUI) handleChapter(w http.ResponseWriter, r *http.Request) {
+	number, err := strconv.Atoi(r.PathValue("number"))
+	if err != nil || number < 1 || number > len(ui.chapters) {
+		http.NotFound(w, r)
+		return
+	}
+	converted, err := ui.convertChapter(number)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var generated string
+	for _, ch := range ui.manifest().Chapters {
+		if ch.Number == number && ch.Result != nil {
+			generated = ch.Result.Content
+		}
+	}
+	next := number + 1
+	if next > len(ui.chapters) {
+		next = 0
+	}
+	source, _ := render.MarkdownToHTML(converted.Markdown)
+	rendered, _ := render.MarkdownToHTML(generated)
+	ui.render(w, "chapter", struct {
+		Chapter       uiChapter
+		Source        string
+		SourceHTML    template.HTML
+		Generated     string
+		GeneratedHTML template.HTML
+		Prev, Next    int
+	}{ui.listChapters()[number-1], converted.Markdown, template.HTML(source), generated, template.HTML(rendered), number - 1, next})
+}
+
+// crossSite tells if r was sent by a page of another site, which must not start paid
+// generations: browsers set Sec-Fetch-Site, or at least Origin, on the requests of the pages
+// they show. Requests with neither come from tools like curl and are allowed.
+func crossSite(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return false
+	case "":
+		origin := r.Header.Get("Origin")
+		if len(origin) == 0 {
+			return false
+		}
+		u, err := url.Parse(origin)
+		return err != nil || u.Host != r.Host
+	default:
+		return true
+	}
+}
+
+// handleGenerate starts a generation of the chapters selected in the form, with the profile and
+// style chosen, and answers at once; progress follows on /events.
+func (ui *bookUI) handleGenerate(w http.ResponseWriter, r *http.Request) {
+	if crossSite(r) {
+		http.Error(w, "cross-site request refused", http.StatusForbidden)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	selected := strings.Join(r.Form["chapter"], ",")
+	if len(selected) == 0 {
+		http.Error(w, "no chapter selected", http.StatusBadRequest)
+		return
+	}
+	args := []string{"-book", ui.bookName, "-chapters", selected, "-config", ui.configFile}
+	if profile := r.FormValue("profile"); len(profile) > 0 {
+		args = append(args, "-profile", profile)
+	}
+	if r.FormValue("resume") == "on" {
+		args = append(args, "-resume")
+	}
+	settings, err := requestSettings(args)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if style := r.FormValue("style"); style == render.StyleBlog || style == render.StyleYouTubeScript {
+		settings.generate.Style = style
+	}
+	numbers, err := parseChapterSelection(selected, len(ui.chapters))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if ui.client == nil && len(os.Getenv("DEEPSEEK_API_KEY")) == 0 {
+		http.Error(w, "DEEPSEEK_API_KEY is not set", http.StatusServiceUnavailable)
+		return
+	}
+	ui.mu.Lock()
+	if ui.running {
+		ui.mu.Unlock()
+		http.Error(w, "a generation is already running", http.StatusConflict)
+		return
+	}
+	ui.running = true
+	ui.outputDir = settings.outputDir
+	ui.mu.Unlock()
+
+	ui.hub.reset()
+	run := settings.newRun(ui.book, ui.chapters, numbers)
+	if ui.client != nil {
+		run.Client = ui.client
+	}
+	run.Progress = ui.hub.publish
+	go func() {
+		defer func() {
+			ui.mu.Lock()
+			ui.running = false
+			ui.mu.Unlock()
+		}()
+		message := "finished"
+		if err := run.Run(context.Background()); err != nil {
+			message = err.Error()
+		}
+		ui.hub.publish(pipeline.ProgressEvent{Message: message, Done: len(numbers), Total: len(numbers)})
+	}()
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "generating chapters %s\n", selected)
+}
+
+// handleEvents streams the progress of the generation as Server-Sent Events.
+func (ui *bookUI) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	history, events, cancel := ui.hub.subscribe()
+	defer cancel()
+	send := func(e pipeline.ProgressEvent) {
+		data, _ := json.Marshal(e)
+		fmt.Fprintf(w, "event: progress\ndata: %s\n\n", data)
+	}
+	for _, e := range history {
+		send(e)
+	}
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-events:
+			send(e)
+			flusher.Flush()
+		}
+	}
+}
+
+var uiTemplates = template.Must(template.New("ui").Funcs(template.FuncMap{
+	"indent": func(level int) string {
+		return strings.Repeat("  ", max(level-1, 0))
+	},
+}).Parse(`{{define "head"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8"/>
+<title>{{.}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 72em; line-height: 1.4; }
+table { border-collapse: collapse; width: 100%; }
+td, th { padding: .2em .6em; border-bottom: 1px solid #ddd; text-align: left; }
+td.num { text-align: right; }
+.status-done { color: green; } .status-failed { color: #b00; } .status-running { color: #b60; }
+.columns { display: flex; gap: 1.5em; } .columns > div { flex: 1; min-width: 0; }
+pre { white-space: pre-wrap; background: #f6f6f6; padding: .8em; }
+#log { height: 12em; overflow-y: auto; background: #f6f6f6; padding: .5em; font-family: monospace; font-size: .9em; }
+</style>
+</head>
+<body>
+{{end}}
+
+{{define "index"}}{{template "head" .Metadata.Title}}
+<h1>{{.Metadata.Title}}</h1>
+<p>{{range $i, $c := .Metadata.Creators}}{{if $i}}, {{end}}{{$c}}{{end}}
+{{with .Metadata.Publisher}} · {{.}}{{end}}{{with .Metadata.Date}} · {{.}}{{end}}{{with .Metadata.Language}} · {{.}}{{end}}</p>
+{{with .Metadata.Description}}<p>{{.}}</p>{{end}}
+<form id="generate" method="post" action="/generate">
+<h2>Chapters</h2>
+<table>
+<tr><th></th><th>#</th><th>Title</th><th>Tokens</th><th>Status</th></tr>
+{{range .Chapters}}<tr>
+<td><input type="checkbox" name="chapter" value="{{.Number}}"/></td>
+<td class="num">{{.Number}}</td>
+<td><a href="/chapters/{{.Number}}">{{.Title}}</a>{{if not .Linear}} <small>(non-linear)</small>{{end}} <small><a href="/book/{{.Href}}">source</a></small></td>
+<td class="num">{{.Tokens}}</td>
+<td id="status-{{.Number}}" class="status-{{.Status}}">{{.Status}}</td>
+</tr>{{end}}
+</table>
+<p>
+<label>Profile <select name="profile"><option value="">default</option>{{range .Profiles}}<option>{{.}}</option>{{end}}</select></label>
+<label>Style <select name="style"><option value="">from profile</option>{{range .Styles}}<option>{{.}}</option>{{end}}</select></label>
+<label><input type="checkbox" name="resume"/> skip chapters already done</label>
+<button type="submit"{{if .Running}} disabled{{end}}>Generate</button>
+</p>
+</form>
+<progress id="progress" value="0" max="1"></progress>
+<div id="log"></div>
+{{with .TOC}}<h2>Contents</h2>
+<ul>{{range .}}<li>{{indent .Level}}<a href="/book/{{.Href}}">{{.Title}}</a></li>{{end}}</ul>{{end}}
+<script>
+const log = document.getElementById("log");
+const progress = document.getElementById("progress");
+const form = document.getElementById("generate");
+const events = new EventSource("/events");
+events.addEventListener("progress", (msg) => {
+  const e = JSON.parse(msg.data);
+  const line = document.createElement("div");
+  line.textContent = e.message;
+  log.appendChild(line);
+  log.scrollTop = log.scrollHeight;
+  if (e.total) { progress.max = e.total; progress.value = e.done; }
+  if (e.chapter && e.status) {
+    const cell = document.getElementById("status-" + e.chapter);
+    if (cell) { cell.textContent = e.status; cell.className = "status-" + e.status; }
+  }
+  if (!e.chapter && e.done === e.total) { form.querySelector("button").disabled = false; }
+});
+form.addEventListener("submit", async (ev) => {
+  ev.preventDefault();
+  const response = await fetch("/generate", { method: "POST", body: new URLSearchParams(new FormData(form)) });
+  const text = await response.text();
+  const line = document.createElement("div");
+  line.textContent = text;
+  log.appendChild(line);
+  if (response.ok) { form.querySelector("button").disabled = true; }
+});
+</script>
+</body>
+</html>
+{{end}}
+
+{{define "chapter"}}{{template "head" .Chapter.Title}}
+<p><a href="/">Back to the book</a>
+{{if .Prev}} · <a href="/chapters/{{.Prev}}">previous</a>{{end}}
+{{if .Next}} · <a href="/chapters/{{.Next}}">next</a>{{end}}
+· <a href="/book/{{.Chapter.Href}}">original page</a></p>
+<h1>{{.Chapter.Number}}. {{.Chapter.Title}}</h1>
+<p>{{.Chapter.Tokens}} tokens{{with .Chapter.Status}} · {{.}}{{end}}</p>
+<div class="columns">
+<div><h2>Source</h2>{{.SourceHTML}}<details><summary>Markdown</summary><pre>{{.Source}}</pre></details></div>
+<div><h2>Generated</h2>{{if .Generated}}{{.GeneratedHTML}}<details><summary>Markdown</summary><pre>{{.Generated}}</pre></details>{{else}}<p>Not generated yet.</p>{{end}}</div>
+</div>
+</body>
+</html>
+{{end}}
+`))
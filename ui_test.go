@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cli-epub-parser-md-generator/epub"
+	"cli-epub-parser-md-generator/extract"
+	"cli-epub-parser-md-generator/internal/epubtest"
+	"cli-epub-parser-md-generator/internal/llmtest"
+	"cli-epub-parser-md-generator/llm"
+)
+
+func TestBookUI(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	dir := t.TempDir()
+	epub.Extract(epubtest.Write(t, epubtest.Files), dir)
+	book, err := epub.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFile := filepath.Join(t.TempDir(), "epubmd.yaml")
+	output := t.TempDir()
+	os.WriteFile(configFile, []byte("output: "+output+"\nformat: blog,md\nprofiles:\n  short:\n    wpm: 120\n"), 0644)
+	ui := newBookUI(book, "test-book.epub", configFile, extract.Options{})
+	client, calls := llmtest.NewFakeModel(t)
+	ui.client = client
+	mux := http.NewServeMux()
+	ui.routes(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	status, body := get("/")
+	if status != http.StatusOK || !strings.Contains(body, "Test Book") || !strings.Contains(body, "One: The Start") || !strings.Contains(body, `<option>short</option>`) {
+		t.Fatalf("unexpected index %d: %s", status, body)
+	}
+	// chapters are numbered like generate and list, without the non-linear notes
+	if status, _ := get("/chapters/4"); status != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing chapter, got %d", status)
+	}
+	if status, body := get("/chapters/2"); status != http.StatusOK || !strings.Contains(body, "First chapter text.") || !strings.Contains(body, "Not generated yet.") {
+		t.Errorf("unexpected chapter page %d: %s", status, body)
+	}
+	// the images of the source preview are linked where the book is served
+	if status, body := get("/chapters/3"); status != http.StatusOK || !strings.Contains(body, `<img src="/book/OEBPS/images/fig.png" alt="A figure"/>`) {
+		t.Errorf("expected the figure from the book in %d %s", status, body)
+	}
+	if status, body := get("/book/OEBPS/images/fig.png"); status != http.StatusOK || body != "png" {
+		t.Errorf("expected the image of the book, got %d %q", status, body)
+	}
+	if status, _ := get("/book/OEBPS/text/ch01.xhtml"); status != http.StatusOK {
+		t.Errorf("expected the original page, got %d", status)
+	}
+
+	for header, value := range map[string]string{"Origin": "https://example.com", "Sec-Fetch-Site": "cross-site"} {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/generate", strings.NewReader("chapter=2"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set(header, value)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("expected a cross-site request with %s to be refused, got %d", header, resp.StatusCode)
+		}
+	}
+	resp, err := http.PostForm(server.URL+"/generate", url.Values{"chapter": {"2"}, "profile": {"short"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected the generation to start, got %d", resp.StatusCode)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events", nil)
+	events, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer events.Body.Close()
+	if events.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("unexpected content type %q", events.Header.Get("Content-Type"))
+	}
+	finished := false
+	for scanner := bufio.NewScanner(events.Body); !finished && scanner.Scan(); {
+		finished = strings.HasPrefix(scanner.Text(), "data: ") && strings.Contains(scanner.Text(), `"message":"finished"`)
+	}
+	if !finished {
+		t.Fatal("expected a finished event")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 request, got %d", calls.Load())
+	}
+	if status, body := get("/chapters/2"); status != http.StatusOK || !strings.Contains(body, "Rewritten text.") || !strings.Contains(body, "done") {
+		t.Errorf("expected the generated text next to the source, got %d: %s", status, body)
+	}
+	if _, err := os.Stat(filepath.Join(output, "test-book", ".manifest.json")); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(filepath.Join(output, "test-book", llm.GlossaryName)); err == nil {
+		t.Error("expected no glossary without -glossary")
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// workspacePrefix starts the name of the temp folder of every run, under os.TempDir().
+const workspacePrefix string = "epubmd-"
+
+// ownerFile, inside a workspace, holds the pid of the process using it. It also marks the
+// folder as a workspace of this tool: clean leaves the folders without it alone.
+const ownerFile string = ".pid"
+
+// staleAfter is how old a workspace with an unreadable owner must be before clean removes it.
+const staleAfter = 24 * time.Hour
+
+// legacyTmpRe matches the .tmpNNNN folders earlier versions created in the current folder.
+var legacyTmpRe = regexp.MustCompile(`^\.tmp\d+$`)
+
+var workspaceMu sync.Mutex
+
+// newWorkspace returns a new folder inside tmpDir, the temp folder of this process, which is
+// created under os.TempDir() on first use and removed by cleanupWorkspace.
+func newWorkspace() (string, error) {
+	workspaceMu.Lock()
+	defer workspaceMu.Unlock()
+	if len(tmpDir.Path) == 0 {
+		dir, err := os.MkdirTemp(os.TempDir(), workspacePrefix+"*")
+		if err != nil {
+			return "", fmt.Errorf("error creating temp folder: %w", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, ownerFile), []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("error creating temp folder: %w", err)
+		}
+		tmpDir.Path = dir
+		tmpDir.SetRelativePath()
+	}
+	return os.MkdirTemp(tmpDir.Path, "book-")
+}
+
+// removeWorkspace removes a folder returned by newWorkspace.
+func removeWorkspace(dir string) {
+	if len(dir) > 0 {
+		os.RemoveAll(dir)
+	}
+}
+
+// cleanupWorkspace removes the temp folder of this process. It is safe to call more than once
+// and from any goroutine.
+func cleanupWorkspace() {
+	workspaceMu.Lock()
+	defer workspaceMu.Unlock()
+	if len(tmpDir.Path) > 0 {
+		os.RemoveAll(tmpDir.Path)
+		tmpDir.Path, tmpDir.RelativePath = "", nil
+	}
+}
+
+// isStaleWorkspace reports whether a workspace was left behind by a process that is gone.
+// Folders without an owner file are not workspaces of this tool.
+func isStaleWorkspace(dir string, modTime time.Time) bool {
+	data, err := os.ReadFile(filepath.Join(dir, ownerFile))
+	if err != nil {
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return time.Since(modTime) > staleAfter
+	}
+	return pid != os.Getpid() && !processRunning(pid)
+}
+
+// findStaleWorkspaces lists the workspaces in tempRoot whose process is gone, and the
+// .tmpNNNN folders left in legacyDir by earlier versions.
+func findStaleWorkspaces(tempRoot, legacyDir string) ([]string, error) {
+	var stale []string
+	entries, err := os.ReadDir(tempRoot)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", tempRoot, err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), workspacePrefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		dir := filepath.Join(tempRoot, entry.Name())
+		if isStaleWorkspace(dir, info.ModTime()) {
+			stale = append(stale, dir)
+		}
+	}
+	if len(legacyDir) == 0 {
+		return stale, nil
+	}
+	entries, err = os.ReadDir(legacyDir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", legacyDir, err)
+	}
+	for _, entry := range entries {
+		dir := filepath.Join(legacyDir, entry.Name())
+		if entry.IsDir() && legacyTmpRe.MatchString(entry.Name()) && isLegacyWorkspace(dir) {
+			stale = append(stale, dir)
+		}
+	}
+	return stale, nil
+}
+
+// isLegacyWorkspace reports whether a .tmpNNNN folder was made by an earlier version: they
+// were created empty on every run and the book was extracted into them, so they are either
+// empty or hold an EPUB with its mimetype file.
+func isLegacyWorkspace(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	if len(entries) == 0 {
+		return true
+	}
+	mimetype, err := os.ReadFile(filepath.Join(dir, "mimetype"))
+	return err == nil && strings.TrimSpace(string(mimetype)) == "application/epub+zip"
+}
+
+// deleteTempFolders removes folders left behind by previous runs.
+func deleteTempFolders(folders []string) {
+	for _, folder := range folders {
+		if _, err := os.Stat(folder); os.IsNotExist(err) {
+			fmt.Println("Folder does not exist:", folder)
+			continue
+		}
+		if err := os.RemoveAll(folder); err != nil {
+			fmt.Println("error removing", folder, err)
+			continue
+		}
+		fmt.Println("removed", folder)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWorkspace(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	t.Cleanup(cleanupWorkspace)
+	if len(tmpDir.Path) != 0 {
+		t.Fatalf("expected the temp folder to be created lazily, got %s", tmpDir.Path)
+	}
+	dir, err := newWorkspace()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := tmpDir.Path
+	if filepath.Dir(root) != os.TempDir() || filepath.Dir(dir) != root {
+		t.Errorf("expected %s inside a workspace of %s", dir, os.TempDir())
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, ownerFile)); string(data) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("expected the owner pid, got %q", data)
+	}
+	if stale, _ := findStaleWorkspaces(os.TempDir(), ""); len(stale) != 0 {
+		t.Errorf("expected the workspace of this process to be kept, got %v", stale)
+	}
+	cleanupWorkspace()
+	if _, err := os.Stat(root); !os.IsNotExist(err) || len(tmpDir.Path) != 0 {
+		t.Errorf("expected %s to be removed", root)
+	}
+}
+
+func TestFindStaleWorkspaces(t *testing.T) {
+	tempRoot, legacy := t.TempDir(), t.TempDir()
+	mkdir := func(dir, owner string) string {
+		os.MkdirAll(dir, 0755)
+		if len(owner) > 0 {
+			os.WriteFile(filepath.Join(dir, ownerFile), []byte(owner), 0644)
+		}
+		return dir
+	}
+	dead := mkdir(filepath.Join(tempRoot, workspacePrefix+"dead"), "2147483646")
+	mkdir(filepath.Join(tempRoot, workspacePrefix+"alive"), strconv.Itoa(os.Getpid()))
+	mkdir(filepath.Join(tempRoot, workspacePrefix+"recent"), "garbage")
+	old := mkdir(filepath.Join(tempRoot, workspacePrefix+"old"), "garbage")
+	// without the owner file, the folder is not a workspace of this tool
+	unowned := mkdir(filepath.Join(tempRoot, workspacePrefix+"unowned"), "")
+	past := time.Now().Add(-2 * staleAfter)
+	os.Chtimes(old, past, past)
+	os.Chtimes(unowned, past, past)
+	mkdir(filepath.Join(tempRoot, "other"), "2147483646")
+	legacyTmp := mkdir(filepath.Join(legacy, ".tmp3823640299"), "")
+	os.WriteFile(filepath.Join(legacyTmp, "mimetype"), []byte("application/epub+zip"), 0644)
+	legacyEmpty := mkdir(filepath.Join(legacy, ".tmp42"), "")
+	foreign := mkdir(filepath.Join(legacy, ".tmp7"), "")
+	os.WriteFile(filepath.Join(foreign, "notes.txt"), []byte("not a book"), 0644)
+	mkdir(filepath.Join(legacy, ".tmpfiles"), "")
+
+	stale, err := findStaleWorkspaces(tempRoot, legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{dead: true, old: true, legacyTmp: true, legacyEmpty: true}
+	if len(stale) != len(want) {
+		t.Fatalf("expected %v, got %v", want, stale)
+	}
+	for _, dir := range stale {
+		if !want[dir] {
+			t.Errorf("unexpected stale folder %s", dir)
+		}
+	}
+	deleteTempFolders(stale)
+	if _, err := os.Stat(dead); !os.IsNotExist(err) {
+		t.Error("expected the stale folders to be removed")
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import "syscall"
+
+const (
+	processQueryLimitedInformation = 0x1000
+	// stillActive is the exit code of a process that has not exited yet.
+	stillActive = 259
+)
+
+// processRunning reports whether a process with pid exists. Signals can't probe processes on
+// Windows, its exit code is read instead.
+func processRunning(pid int) bool {
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		// the process exists but belongs to another user
+		return err == syscall.ERROR_ACCESS_DENIED
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	return syscall.GetExitCodeProcess(h, &code) == nil && code == stillActive
+}